@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -16,16 +17,139 @@ type contextKey string
 
 const userContextKey = contextKey("userID")
 
-// authMiddleware verifica o token JWT do Supabase
-func authMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		jwtSecret := os.Getenv("SUPABASE_JWT_SECRET")
-		if jwtSecret == "" {
-			log.Println("ERRO FATAL: SUPABASE_JWT_SECRET não está configurado no ambiente.")
-			http.Error(w, "Configuração do servidor incompleta", http.StatusInternalServerError)
-			return
+const (
+	defaultJWTAudience     = "authenticated" // aud dos tokens de usuários logados no Supabase
+	defaultJWTLeeway       = 30 * time.Second
+	defaultJWKSRefreshEach = 10 * time.Minute
+)
+
+// jwtVerifier valida os tokens do Supabase: HS256 com SUPABASE_JWT_SECRET (projetos antigos)
+// e/ou RS256/ES256 com as chaves do JWKS (chaves assimétricas)
+type jwtVerifier struct {
+	hmacSecret []byte
+	jwks       *jwksCache
+	parser     *jwt.Parser
+}
+
+// authVerifier é configurado no main; nil faz authMiddleware responder 500
+var authVerifier *jwtVerifier
+
+// newJWTVerifierFromEnv lê a configuração de autenticação:
+//   - SUPABASE_JWT_SECRET: segredo HS256
+//   - SUPABASE_JWKS_URL ou SUPABASE_JWKS_FILE: JWKS com as chaves RS256/ES256
+//   - SUPABASE_JWKS_REFRESH: intervalo de recarga do JWKS (padrão 10m)
+//   - SUPABASE_JWT_AUDIENCE: aud exigido (padrão "authenticated"; "-" desliga a verificação)
+//   - SUPABASE_JWT_ISSUER: iss exigido (ex: https://<projeto>.supabase.co/auth/v1); vazio não verifica
+//   - SUPABASE_JWT_LEEWAY: tolerância de relógio para exp/nbf/iat (padrão 30s)
+//
+// Retorna nil (sem erro) se nem segredo nem JWKS estiverem configurados.
+func newJWTVerifierFromEnv() (*jwtVerifier, error) {
+	verifier := &jwtVerifier{}
+	validMethods := []string{}
+
+	if secret := os.Getenv("SUPABASE_JWT_SECRET"); secret != "" {
+		verifier.hmacSecret = []byte(secret)
+		validMethods = append(validMethods, jwt.SigningMethodHS256.Alg())
+	}
+
+	jwksURL := strings.TrimSpace(os.Getenv("SUPABASE_JWKS_URL"))
+	jwksFile := strings.TrimSpace(os.Getenv("SUPABASE_JWKS_FILE"))
+	if jwksURL != "" && jwksFile != "" {
+		return nil, fmt.Errorf("configure apenas um entre SUPABASE_JWKS_URL e SUPABASE_JWKS_FILE")
+	}
+	if source := jwksURL + jwksFile; source != "" {
+		refreshEvery := defaultJWKSRefreshEach
+		if raw := os.Getenv("SUPABASE_JWKS_REFRESH"); raw != "" {
+			parsed, err := time.ParseDuration(raw)
+			if err != nil || parsed <= 0 {
+				return nil, fmt.Errorf("SUPABASE_JWKS_REFRESH inválido: %q", raw)
+			}
+			refreshEvery = parsed
+		}
+		verifier.jwks = newJWKSCache(source)
+		// Falha na carga inicial não impede o servidor de subir: a recarga periódica
+		// e a recarga por kid desconhecido tentam de novo
+		if err := verifier.jwks.refresh(); err != nil {
+			log.Printf("Alerta: %v", err)
+		}
+		verifier.jwks.startRefresh(refreshEvery)
+		validMethods = append(validMethods, jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg())
+	}
+
+	if len(validMethods) == 0 {
+		return nil, nil
+	}
+
+	leeway := defaultJWTLeeway
+	if raw := os.Getenv("SUPABASE_JWT_LEEWAY"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("SUPABASE_JWT_LEEWAY inválido: %q", raw)
+		}
+		leeway = parsed
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(validMethods),
+		jwt.WithLeeway(leeway),
+		jwt.WithExpirationRequired(),
+	}
+	audience := os.Getenv("SUPABASE_JWT_AUDIENCE")
+	if audience == "" {
+		audience = defaultJWTAudience
+	}
+	if audience != "-" {
+		options = append(options, jwt.WithAudience(audience))
+	}
+	if issuer := strings.TrimSpace(os.Getenv("SUPABASE_JWT_ISSUER")); issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+	verifier.parser = jwt.NewParser(options...)
+	return verifier, nil
+}
+
+// keyFunc escolhe a chave pelo algoritmo do token: o segredo para HS256, a chave do kid para RS256/ES256
+func (v *jwtVerifier) keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if v.hmacSecret == nil {
+			return nil, fmt.Errorf("tokens HS256 não são aceitos (SUPABASE_JWT_SECRET não configurado)")
+		}
+		return v.hmacSecret, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		if v.jwks == nil {
+			return nil, fmt.Errorf("tokens %v não são aceitos (JWKS não configurado)", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, fmt.Errorf("token sem 'kid' no cabeçalho")
 		}
+		return v.jwks.key(kid)
+	default:
+		return nil, fmt.Errorf("método de assinatura inesperado: %v", token.Header["alg"])
+	}
+}
+
+// userID valida o token e retorna a claim 'sub'
+func (v *jwtVerifier) userID(tokenString string) (string, error) {
+	token, err := v.parser.Parse(tokenString, v.keyFunc)
+	if err != nil {
+		return "", err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return "", fmt.Errorf("claims inválidas")
+	}
+	userID, err := claims.GetSubject()
+	if err != nil || userID == "" {
+		return "", fmt.Errorf("claim 'sub' (userID) não encontrada ou inválida")
+	}
+	return userID, nil
+}
 
+// authMiddleware verifica o token JWT do Supabase ou a chave de API de um dispositivo (cdk_...)
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
 			http.Error(w, "Cabeçalho de autorização ausente", http.StatusUnauthorized)
@@ -39,36 +163,42 @@ func authMiddleware(next http.Handler) http.Handler {
 		}
 		tokenString := parts[1]
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Verifica o método de assinatura
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("método de assinatura inesperado: %v", token.Header["alg"])
+		// Chave de API de dispositivo (tablet do balcão, totem): age em nome do usuário da chave,
+		// limitada aos escopos dela (ver requirePermission)
+		if strings.HasPrefix(tokenString, deviceKeyTokenPrefix) {
+			device, err := authenticateDeviceKey(db, tokenString, clientIP(r))
+			if err != nil {
+				if err != errDeviceKeyInvalid {
+					log.Printf("Erro ao validar chave de dispositivo: %v", err)
+					http.Error(w, "Erro no servidor ao validar chave de dispositivo", http.StatusInternalServerError)
+					return
+				}
+				http.Error(w, "Chave de dispositivo inválida ou revogada", http.StatusUnauthorized)
+				return
 			}
-			return []byte(jwtSecret), nil
-		})
+			ctx := context.WithValue(r.Context(), userContextKey, device.UserID)
+			ctx = context.WithValue(ctx, deviceKeyContextKey, device)
+			log.Printf("Dispositivo autenticado: %s (%s), em nome do usuário %s", device.ID, device.Name, device.UserID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		if authVerifier == nil {
+			log.Println("ERRO FATAL: nem SUPABASE_JWT_SECRET nem SUPABASE_JWKS_URL/SUPABASE_JWKS_FILE estão configurados no ambiente.")
+			http.Error(w, "Configuração do servidor incompleta", http.StatusInternalServerError)
+			return
+		}
 
+		userID, err := authVerifier.userID(tokenString)
 		if err != nil {
 			log.Printf("Erro ao parsear/validar token: %v", err)
 			http.Error(w, "Token inválido ou expirado", http.StatusUnauthorized)
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			// Token é válido. Podemos extrair o ID do usuário (geralmente na claim 'sub')
-			userID, ok := claims["sub"].(string)
-			if !ok || userID == "" {
-				log.Println("Erro: Claim 'sub' (userID) não encontrada ou inválida no token.")
-				http.Error(w, "Token inválido (sem ID de usuário)", http.StatusUnauthorized)
-				return
-			}
-
-			// Adiciona o userID ao contexto da requisição para que os handlers possam usá-lo
-			ctx := context.WithValue(r.Context(), userContextKey, userID)
-			log.Printf("Usuário autenticado: %s", userID)
-			next.ServeHTTP(w, r.WithContext(ctx)) // Prossegue para o próximo handler com o contexto atualizado
-		} else {
-			log.Printf("Token JWT inválido ou claims não são MapClaims. Claims: %+v, Válido: %v", token.Claims, token.Valid)
-			http.Error(w, "Token inválido", http.StatusUnauthorized)
-		}
+		// Adiciona o userID ao contexto da requisição para que os handlers possam usá-lo
+		ctx := context.WithValue(r.Context(), userContextKey, userID)
+		log.Printf("Usuário autenticado: %s", userID)
+		next.ServeHTTP(w, r.WithContext(ctx)) // Prossegue para o próximo handler com o contexto atualizado
 	})
 }
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Role é o papel do usuário (coluna users.role), sempre normalizado por normalizeRole
+type Role string
+
+const (
+	RoleClient     Role = "client"      // Responsável: pede e paga pelos próprios alunos
+	RoleStaff      Role = "staff"       // Equipe da cantina: prepara e entrega os pedidos
+	RoleAdmin      Role = "admin"       // Administração da escola/cantina
+	RoleSuperAdmin Role = "super_admin" // Administração da plataforma
+)
+
+// roleAliases traduz os valores gravados em users.role (inclusive os legados, como "CLIENTE") para Role
+var roleAliases = map[string]Role{
+	"client":      RoleClient,
+	"cliente":     RoleClient,
+	"parent":      RoleClient,
+	"responsavel": RoleClient,
+	"staff":       RoleStaff,
+	"admin":       RoleAdmin,
+	"super_admin": RoleSuperAdmin,
+	"superadmin":  RoleSuperAdmin,
+}
+
+// normalizeRole é o único ponto de conversão de users.role para Role.
+// Valores desconhecidos viram um Role sem nenhuma permissão.
+func normalizeRole(raw string) Role {
+	key := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(raw)), "-", "_")
+	if role, ok := roleAliases[key]; ok {
+		return role
+	}
+	return Role(key)
+}
+
+// Permission é uma ação protegida; cada rota autenticada exige exatamente uma
+type Permission string
+
+const (
+	PermSelfService      Permission = "self_service"       // Perfil, pedidos, créditos e eventos do próprio usuário
+	PermOwnStudents      Permission = "own_students"       // Alunos do responsável: listagem, limites e restrições
+	PermOrderCreate      Permission = "order_create"       // Fazer pedidos para os próprios alunos
+	PermOrdersView       Permission = "orders_view"        // Ver pedidos de todos: lista, cozinha, stream, detalhes
+	PermOrdersViewAll    Permission = "orders_view_all"    // Lista de pedidos sem o filtro de pedidos em aberto
+	PermOrdersManage     Permission = "orders_manage"      // Mudar status, cancelar qualquer pedido, retirada no balcão
+	PermMenuManage       Permission = "menu_manage"        // Itens do cardápio, calendário e horários de retirada
+	PermStockManage      Permission = "stock_manage"       // Movimentações de estoque
+	PermDietaryOverrides Permission = "dietary_overrides"  // Liberações de itens para alunos com restrição alimentar
+	PermClassesManage    Permission = "classes_manage"     // Turmas
+	PermStudentsManage   Permission = "students_manage"    // Cadastro de alunos pela administração
+	PermCreditsManage    Permission = "credits_manage"     // Ajustes manuais e conciliação de créditos
+	PermDeviceKeysManage Permission = "device_keys_manage" // Emitir e revogar chaves de API de dispositivos
+)
+
+// permissionMatrix diz quais papéis têm cada permissão
+var permissionMatrix = map[Permission][]Role{
+	PermSelfService:      {RoleClient, RoleStaff, RoleAdmin, RoleSuperAdmin},
+	PermOwnStudents:      {RoleClient},
+	PermOrderCreate:      {RoleClient},
+	PermOrdersView:       {RoleStaff, RoleAdmin, RoleSuperAdmin},
+	PermOrdersViewAll:    {RoleAdmin, RoleSuperAdmin},
+	PermOrdersManage:     {RoleStaff, RoleAdmin, RoleSuperAdmin},
+	PermMenuManage:       {RoleAdmin, RoleSuperAdmin},
+	PermStockManage:      {RoleStaff, RoleAdmin, RoleSuperAdmin},
+	PermDietaryOverrides: {RoleStaff, RoleAdmin, RoleSuperAdmin},
+	PermClassesManage:    {RoleAdmin, RoleSuperAdmin},
+	PermStudentsManage:   {RoleAdmin, RoleSuperAdmin},
+	PermCreditsManage:    {RoleAdmin, RoleSuperAdmin},
+	PermDeviceKeysManage: {RoleAdmin, RoleSuperAdmin},
+}
+
+// Can diz se o papel tem a permissão
+func (role Role) Can(permission Permission) bool {
+	for _, allowed := range permissionMatrix[permission] {
+		if role == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+const profileContextKey = contextKey("userProfile")
+
+// profileFromContext retorna o perfil carregado por requireRoles/requirePermission (nil fora dessas rotas)
+func profileFromContext(ctx context.Context) *UserProfile {
+	profile, _ := ctx.Value(profileContextKey).(*UserProfile)
+	return profile
+}
+
+// hasPermission diz se quem fez a requisição tem a permissão: o papel do perfil no contexto e,
+// se a requisição veio de um dispositivo, também os escopos da chave
+func hasPermission(ctx context.Context, permission Permission) bool {
+	profile := profileFromContext(ctx)
+	if profile == nil || !profile.Role.Can(permission) {
+		return false
+	}
+	if device := deviceKeyFromContext(ctx); device != nil {
+		return device.hasScope(permission)
+	}
+	return true
+}
+
+// requireRoles deve ficar dentro de authMiddleware: carrega o perfil do usuário uma vez, guarda no contexto
+// e responde 403 se o papel não estiver entre os informados (sem papéis, qualquer usuário com perfil passa).
+// Chaves de dispositivo não passam por requireRoles, só por requirePermission (com o escopo correspondente).
+func requireRoles(appDB *sql.DB, roles ...Role) func(http.Handler) http.Handler {
+	return authorize(&postgresUserRepository{db: appDB}, "", roles)
+}
+
+// requirePermission é requireRoles com os papéis que têm a permissão na permissionMatrix;
+// para chaves de dispositivo, exige também que a permissão esteja entre os escopos da chave
+func requirePermission(appDB *sql.DB, permission Permission) func(http.Handler) http.Handler {
+	return authorize(&postgresUserRepository{db: appDB}, permission, permissionMatrix[permission])
+}
+
+// authorize carrega o perfil pelo repositório de usuários (ver requireRoles/requirePermission)
+func authorize(users UserRepository, permission Permission, roles []Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if device := deviceKeyFromContext(r.Context()); device != nil && (permission == "" || !device.hasScope(permission)) {
+				log.Printf("Chave de dispositivo %s (%s) sem o escopo para %s %s.", device.ID, device.Name, r.Method, r.URL.Path)
+				http.Error(w, "Chave de dispositivo sem permissão para esta ação.", http.StatusForbidden)
+				return
+			}
+
+			profile := profileFromContext(r.Context())
+			if profile == nil {
+				userID := r.Context().Value(userContextKey).(string)
+				var err error
+				profile, err = users.GetProfile(userID)
+				if err != nil {
+					if err == sql.ErrNoRows {
+						http.Error(w, "Perfil de usuário não encontrado.", http.StatusUnauthorized)
+					} else {
+						log.Printf("Erro ao buscar perfil do usuário %s: %v", userID, err)
+						http.Error(w, "Erro no servidor ao verificar permissões.", http.StatusInternalServerError)
+					}
+					return
+				}
+				r = r.WithContext(context.WithValue(r.Context(), profileContextKey, profile))
+			}
+
+			if len(roles) > 0 {
+				allowed := false
+				for _, role := range roles {
+					if profile.Role == role {
+						allowed = true
+						break
+					}
+				}
+				if !allowed {
+					log.Printf("Usuário %s (Papel: %s) sem permissão para %s %s.", profile.ID, profile.Role, r.Method, r.URL.Path)
+					http.Error(w, "Acesso não autorizado para esta ação.", http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// protect é o atalho usado pelos roteadores: autentica, carrega o perfil e exige a permissão
+func protect(appDB *sql.DB, permission Permission, handler http.HandlerFunc) http.Handler {
+	return authMiddleware(requirePermission(appDB, permission)(handler))
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,72 +14,153 @@ import (
 
 // Struct Class (definida acima, mas coloque aqui ou importe de um arquivo de modelos)
 type Class struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Description *string   `json:"description,omitempty"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Description  *string   `json:"description,omitempty"`
+	StudentCount int       `json:"student_count"` // Alunos vinculados por students.class_id
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
-// Payload para criar uma turma
+// Payload para criar uma turma (também usado no PUT /classes/{id}, que substitui nome e descrição)
 type CreateClassPayload struct {
 	Name        string  `json:"name"`
 	Description *string `json:"description"`
 }
 
+// ClassesPage é a resposta paginada de GET /classes
+type ClassesPage struct {
+	Classes []Class `json:"classes"`
+	Total   int     `json:"total"`
+	Limit   int     `json:"limit"`
+	Offset  int     `json:"offset"`
+}
+
+var (
+	// errClassHasStudents indica que a turma ainda tem alunos e nenhuma turma de destino foi informada
+	errClassHasStudents = errors.New("turma ainda tem alunos vinculados")
+	// errInvalidReassignTarget indica um ?reassign_to= inválido em DELETE /classes/{id}
+	errInvalidReassignTarget = errors.New("turma de destino inválida")
+)
+
+// classDeletedTransferReason é o motivo gravado no histórico de turmas dos alunos movidos por DELETE /classes/{id}?reassign_to=
+const classDeletedTransferReason = "turma removida"
+
 // classRouterHandler para /classes e /classes/{id}
-func classRouterHandler(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+func classRouterHandler(w http.ResponseWriter, r *http.Request, appDB *sql.DB, classes ClassRepository) {
 	path := r.URL.Path
-	idSegment := strings.TrimPrefix(path, "/classes/")
+	idSegment := strings.TrimPrefix(path, "/classes")
 	idSegment = strings.Trim(idSegment, "/")
 
 	log.Printf("DEBUG: classRouterHandler: Path: %s, idSegment: '%s', Method: %s", path, idSegment, r.Method)
 
 	if idSegment == "" { // Rota base: /classes/
 		switch r.Method {
+		case http.MethodGet:
+			protect(appDB, PermClassesManage, func(ww http.ResponseWriter, rr *http.Request) {
+				handleGetClasses(ww, rr, classes)
+			}).ServeHTTP(w, r)
 		case http.MethodPost:
-			// Proteger com authMiddleware e depois chamar handleCreateClass
-			authMiddleware(http.HandlerFunc(func(ww http.ResponseWriter, rr *http.Request) {
-				handleCreateClass(ww, rr, appDB)
-			})).ServeHTTP(w, r)
-		// case http.MethodGet:
-		// TODO: handleGetClasses (listar todas as turmas)
-		// http.Error(w, "GET /classes/ não implementado", http.StatusNotImplemented)
+			// Proteger com authMiddleware + PermClassesManage e depois chamar handleCreateClass
+			protect(appDB, PermClassesManage, func(ww http.ResponseWriter, rr *http.Request) {
+				handleCreateClass(ww, rr, classes)
+			}).ServeHTTP(w, r)
 		default:
 			http.Error(w, "Método não permitido para /classes/", http.StatusMethodNotAllowed)
 		}
 	} else { // Rota com ID: /classes/{id}
 		classID := idSegment
 		switch r.Method {
-		// case http.MethodGet:
-		// TODO: handleGetClassByID
-		// case http.MethodPut:
-		// TODO: handleUpdateClass
-		// case http.MethodDelete:
-		// TODO: handleDeleteClass
+		case http.MethodGet:
+			protect(appDB, PermClassesManage, func(ww http.ResponseWriter, rr *http.Request) {
+				handleGetClassByID(ww, rr, classes, classID)
+			}).ServeHTTP(w, r)
+		case http.MethodPut:
+			protect(appDB, PermClassesManage, func(ww http.ResponseWriter, rr *http.Request) {
+				handleUpdateClass(ww, rr, classes, classID)
+			}).ServeHTTP(w, r)
+		case http.MethodDelete:
+			protect(appDB, PermClassesManage, func(ww http.ResponseWriter, rr *http.Request) {
+				handleDeleteClass(ww, rr, classes, classID)
+			}).ServeHTTP(w, r)
 		default:
-			http.Error(w, fmt.Sprintf("Método para /classes/%s não implementado ou não permitido", classID), http.StatusMethodNotAllowed)
+			http.Error(w, fmt.Sprintf("Método não permitido para /classes/%s", classID), http.StatusMethodNotAllowed)
 		}
 	}
 }
 
-func handleCreateClass(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
-	// 1. Verificar papel do usuário (ADMIN ou SUPER_ADMIN)
-	userIDfromContext := r.Context().Value(userContextKey)
-	requestingUserID := userIDfromContext.(string) // Assumindo que o middleware já validou e colocou
+func handleCreateClass(w http.ResponseWriter, r *http.Request, classes ClassRepository) {
+	// 1. Papel do usuário (ADMIN ou SUPER_ADMIN) já verificado na rota (PermClassesManage)
+
+	// 2. Decodificar payload
+	var payload CreateClassPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" {
+		http.Error(w, "Nome da turma é obrigatório.", http.StatusBadRequest)
+		return
+	}
 
-	requestingUserProfile, err := fetchUserProfile(requestingUserID, appDB) // Reutiliza a função de profile_handlers.go
+	// 3. Gravar a turma
+	newClass, err := classes.Create(payload.Name, payload.Description)
 	if err != nil {
-		http.Error(w, "Erro ao verificar permissões do usuário.", http.StatusInternalServerError)
+		if errors.Is(err, errClassNameTaken) {
+			http.Error(w, "Uma turma com este nome já existe.", http.StatusConflict) // 409 Conflict
+		} else {
+			log.Printf("Erro ao inserir turma no banco: %v", err)
+			http.Error(w, "Erro ao criar turma.", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	if requestingUserProfile.Role != "admin" && requestingUserProfile.Role != "super_admin" {
-		http.Error(w, "Acesso não autorizado para criar turmas.", http.StatusForbidden)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newClass)
+}
+
+// handleGetClasses trata GET /classes?q=&limit=&offset=: turmas por nome, com a contagem de alunos
+func handleGetClasses(w http.ResponseWriter, r *http.Request, classes ClassRepository) {
+	limit, offset, err := parseLimitOffset(r, 50, 200)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// 2. Decodificar payload
+	list, total, err := classes.List(strings.TrimSpace(r.URL.Query().Get("q")), limit, offset)
+	if err != nil {
+		log.Printf("Erro: %v", err)
+		http.Error(w, "Erro ao buscar turmas.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ClassesPage{Classes: list, Total: total, Limit: limit, Offset: offset})
+}
+
+// handleGetClassByID trata GET /classes/{id}
+func handleGetClassByID(w http.ResponseWriter, r *http.Request, classes ClassRepository, classID string) {
+	class, err := classes.Get(classID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Turma não encontrada.", http.StatusNotFound)
+		} else {
+			log.Printf("Erro ao buscar turma %s: %v", classID, err)
+			http.Error(w, "Erro ao buscar turma.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(class)
+}
+
+// handleUpdateClass trata PUT /classes/{id}: substitui nome e descrição
+func handleUpdateClass(w http.ResponseWriter, r *http.Request, classes ClassRepository, classID string) {
 	var payload CreateClassPayload
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
@@ -86,50 +168,56 @@ func handleCreateClass(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
 	}
 	defer r.Body.Close()
 
-	if strings.TrimSpace(payload.Name) == "" {
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" {
 		http.Error(w, "Nome da turma é obrigatório.", http.StatusBadRequest)
 		return
 	}
 
-	// 3. Inserir no banco
-	var newClass Class
-	sqlStatement := `
-		INSERT INTO public.classes (name, description) VALUES ($1, $2)
-		RETURNING id, name, description, created_at, updated_at`
-
-	var dbDescription sql.NullString
-	if payload.Description != nil {
-		dbDescription.String = *payload.Description
-		dbDescription.Valid = true
+	class, err := classes.Update(classID, payload.Name, payload.Description)
+	if err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			http.Error(w, "Turma não encontrada.", http.StatusNotFound)
+		case errors.Is(err, errClassNameTaken):
+			http.Error(w, "Uma turma com este nome já existe.", http.StatusConflict)
+		default:
+			log.Printf("Erro ao atualizar turma %s: %v", classID, err)
+			http.Error(w, "Erro ao atualizar turma.", http.StatusInternalServerError)
+		}
+		return
 	}
 
-	var scannedDescription sql.NullString // Variável para o Scan do description
-
-	// Linha onde 'err' é atribuído pelo Scan
-	err = appDB.QueryRow(sqlStatement, payload.Name, dbDescription).Scan(
-		&newClass.ID,
-		&newClass.Name,
-		&scannedDescription, // Usa a variável para o scan
-		&newClass.CreatedAt,
-		&newClass.UpdatedAt,
-	)
-	if scannedDescription.Valid {
-		newClass.Description = &scannedDescription.String
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(class)
+}
+
+// handleDeleteClass trata DELETE /classes/{id}[?reassign_to={outraTurmaID}].
+// Recusada (409) enquanto houver alunos na turma, a menos que reassign_to diga para onde movê-los.
+func handleDeleteClass(w http.ResponseWriter, r *http.Request, classes ClassRepository, classID string) {
+	requestingUserID := r.Context().Value(userContextKey).(string)
+
+	var reassignTo *string
+	if raw := strings.TrimSpace(r.URL.Query().Get("reassign_to")); raw != "" {
+		reassignTo = &raw
 	}
 
+	moved, err := classes.Delete(classID, reassignTo, requestingUserID)
 	if err != nil {
-		// Verificar erro de constraint UNIQUE para 'name'
-		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") ||
-			strings.Contains(err.Error(), "classes_name_key") { // O nome da constraint pode variar
-			http.Error(w, "Uma turma com este nome já existe.", http.StatusConflict) // 409 Conflict
-		} else {
-			log.Printf("Erro ao inserir turma no banco: %v", err)
-			http.Error(w, "Erro ao criar turma.", http.StatusInternalServerError)
+		switch {
+		case err == sql.ErrNoRows:
+			http.Error(w, "Turma não encontrada.", http.StatusNotFound)
+		case errors.Is(err, errClassHasStudents):
+			http.Error(w, "A turma ainda tem alunos vinculados: "+err.Error()+". Informe ?reassign_to={id} para movê-los para outra turma.", http.StatusConflict)
+		case errors.Is(err, errInvalidReassignTarget):
+			http.Error(w, err.Error()+".", http.StatusBadRequest)
+		default:
+			log.Printf("Erro ao remover turma %s: %v", classID, err)
+			http.Error(w, "Erro ao remover turma.", http.StatusInternalServerError)
 		}
-		return // Importante retornar aqui se houve erro
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(newClass)
+	log.Printf("Usuário %s removeu a turma %s (%d aluno(s) movido(s)).", requestingUserID, classID, moved)
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Tipos de lançamento do extrato de créditos (coluna credit_transactions.type)
+const (
+	CreditTxOrderDebit = "ORDER_DEBIT" // Débito por pedido
+	CreditTxTopUp      = "TOPUP"       // Recarga de créditos
+	CreditTxRefund     = "REFUND"      // Estorno (ex: pedido cancelado)
+	CreditTxAdjustment = "ADJUSTMENT"  // Ajuste manual feito por admin
+	// Movimentação entre o responsável e a carteira de um aluno (ver student_wallets.go)
+	CreditTxStudentTransfer = "STUDENT_TRANSFER"
+)
+
+var validCreditTxTypes = map[string]bool{
+	CreditTxOrderDebit:      true,
+	CreditTxTopUp:           true,
+	CreditTxRefund:          true,
+	CreditTxAdjustment:      true,
+	CreditTxStudentTransfer: true,
+}
+
+// lowBalanceThreshold é o saldo abaixo do qual o responsável recebe o aviso credit.low_balance.
+// Definido em main a partir de LOW_BALANCE_THRESHOLD.
+var lowBalanceThreshold = defaultLowBalanceThreshold
+
+const defaultLowBalanceThreshold Money = 1000 // R$ 10,00
+
+// loadLowBalanceThresholdFromEnv lê LOW_BALANCE_THRESHOLD (padrão 10.00)
+func loadLowBalanceThresholdFromEnv() (Money, error) {
+	raw := strings.TrimSpace(os.Getenv("LOW_BALANCE_THRESHOLD"))
+	if raw == "" {
+		return defaultLowBalanceThreshold, nil
+	}
+	threshold, err := parseMoney(raw, true)
+	if err != nil || threshold < 0 {
+		return 0, fmt.Errorf("LOW_BALANCE_THRESHOLD inválido: %q", raw)
+	}
+	return threshold, nil
+}
+
+// errInsufficientCredits indica que o lançamento deixaria o saldo do usuário negativo
+var errInsufficientCredits = errors.New("créditos insuficientes")
+
+// CreditTransaction espelha uma linha da tabela credit_transactions (append-only)
+type CreditTransaction struct {
+	ID           string    `json:"id"`
+	UserID       string    `json:"user_id"`
+	Type         string    `json:"type"`
+	Amount       Money     `json:"amount"` // Negativo = débito, positivo = crédito
+	BalanceAfter Money     `json:"balance_after"`
+	OrderID      *string   `json:"order_id,omitempty"`
+	TopUpID      *string   `json:"topup_id,omitempty"`
+	StudentID    *string   `json:"student_id,omitempty"` // Carteira de aluno envolvida (STUDENT_TRANSFER)
+	Description  *string   `json:"description,omitempty"`
+	CreatedBy    *string   `json:"created_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CreditTransactionsPage é a resposta paginada de GET /me/credits/transactions
+type CreditTransactionsPage struct {
+	Balance      Money               `json:"balance"`
+	Transactions []CreditTransaction `json:"transactions"`
+	Total        int                 `json:"total"`
+	Limit        int                 `json:"limit"`
+	Offset       int                 `json:"offset"`
+}
+
+// CreateCreditAdjustmentPayload é o corpo de POST /credits/adjustments
+type CreateCreditAdjustmentPayload struct {
+	UserID      string `json:"user_id"`
+	Amount      Money  `json:"amount"`
+	Description string `json:"description"`
+}
+
+// CreditReconciliation compara o saldo consolidado em users.credits com a soma do extrato
+type CreditReconciliation struct {
+	UserID        string `json:"user_id"`
+	Credits       Money  `json:"credits"`
+	LedgerBalance Money  `json:"ledger_balance"`
+	Difference    Money  `json:"difference"`
+}
+
+// recordCreditTransaction aplica um lançamento ao saldo do usuário e grava a linha no extrato.
+// Deve ser chamada dentro da mesma transação que origina a movimentação (pedido, estorno, recarga...),
+// garantindo que users.credits e credit_transactions nunca fiquem divergentes.
+// Retorna errInsufficientCredits se o saldo ficaria negativo e sql.ErrNoRows se o usuário não existir.
+func recordCreditTransaction(tx *sql.Tx, entry CreditTransaction) (*CreditTransaction, error) {
+	if !validCreditTxTypes[entry.Type] {
+		return nil, fmt.Errorf("tipo de lançamento inválido: %s", entry.Type)
+	}
+	if entry.Amount == 0 {
+		return nil, fmt.Errorf("valor do lançamento não pode ser zero")
+	}
+
+	// O UPDATE trava a linha do usuário até o fim da transação, serializando lançamentos concorrentes
+	updateBalanceQuery := `
+		UPDATE public.users
+		SET credits = credits + $1, updated_at = NOW()
+		WHERE id = $2 AND credits + $1 >= 0
+		RETURNING credits;`
+	err := tx.QueryRow(updateBalanceQuery, entry.Amount, entry.UserID).Scan(&entry.BalanceAfter)
+	if err == sql.ErrNoRows {
+		var exists bool
+		if errExists := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM public.users WHERE id = $1)", entry.UserID).Scan(&exists); errExists != nil {
+			return nil, fmt.Errorf("erro ao verificar usuário %s: %w", entry.UserID, errExists)
+		}
+		if exists {
+			return nil, errInsufficientCredits
+		}
+		return nil, sql.ErrNoRows
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao atualizar saldo do usuário %s: %w", entry.UserID, err)
+	}
+
+	insertQuery := `
+		INSERT INTO public.credit_transactions (user_id, type, amount, balance_after, order_id, topup_id, student_id, description, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at;`
+	err = tx.QueryRow(insertQuery, entry.UserID, entry.Type, entry.Amount, entry.BalanceAfter,
+		entry.OrderID, entry.TopUpID, entry.StudentID, entry.Description, entry.CreatedBy).Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao gravar lançamento no extrato do usuário %s: %w", entry.UserID, err)
+	}
+
+	if err := publishCreditEvents(tx, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// publishCreditEvents publica credit.balance_changed para o dono do saldo e, se o lançamento
+// fez o saldo cruzar lowBalanceThreshold para baixo, também credit.low_balance
+func publishCreditEvents(tx *sql.Tx, entry *CreditTransaction) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"transaction_id": entry.ID,
+		"type":           entry.Type,
+		"amount":         entry.Amount,
+		"balance":        entry.BalanceAfter,
+		"order_id":       entry.OrderID,
+		"topup_id":       entry.TopUpID,
+		"student_id":     entry.StudentID,
+	})
+	if err != nil {
+		return fmt.Errorf("erro ao serializar evento de saldo: %w", err)
+	}
+	if err := publishEvent(tx, Event{Type: EventCreditBalanceChanged, UserID: &entry.UserID, Data: data}); err != nil {
+		return err
+	}
+
+	balanceBefore := entry.BalanceAfter - entry.Amount
+	if entry.BalanceAfter < lowBalanceThreshold && balanceBefore >= lowBalanceThreshold {
+		data, err := json.Marshal(map[string]interface{}{
+			"balance":   entry.BalanceAfter,
+			"threshold": lowBalanceThreshold,
+		})
+		if err != nil {
+			return fmt.Errorf("erro ao serializar evento de saldo baixo: %w", err)
+		}
+		return publishEvent(tx, Event{Type: EventCreditLowBalance, UserID: &entry.UserID, Data: data})
+	}
+	return nil
+}
+
+// creditsRouterHandler para /me/credits/... (extrato e recargas do usuário) e /credits/... (operações de admin)
+func creditsRouterHandler(w http.ResponseWriter, r *http.Request, appDB *sql.DB, keys IdempotencyRepository, provider PaymentProvider) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+
+	// Rota com ID: /me/credits/topups/{id}
+	if topUpID := strings.TrimPrefix(path, "/me/credits/topups/"); topUpID != path {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Método não permitido para /me/credits/topups/{id}. Use GET.", http.StatusMethodNotAllowed)
+			return
+		}
+		protect(appDB, PermSelfService, func(ww http.ResponseWriter, rr *http.Request) {
+			handleGetMyTopUpByID(ww, rr, appDB, topUpID)
+		}).ServeHTTP(w, r)
+		return
+	}
+
+	switch path {
+	case "/me/credits/topups":
+		switch r.Method {
+		case http.MethodGet:
+			protect(appDB, PermSelfService, func(ww http.ResponseWriter, rr *http.Request) {
+				handleGetMyTopUps(ww, rr, appDB)
+			}).ServeHTTP(w, r)
+		case http.MethodPost:
+			authMiddleware(requirePermission(appDB, PermSelfService)(idempotencyMiddleware(keys, http.HandlerFunc(func(ww http.ResponseWriter, rr *http.Request) {
+				handleCreateTopUp(ww, rr, appDB, provider)
+			})))).ServeHTTP(w, r)
+		default:
+			http.Error(w, "Método não permitido para /me/credits/topups.", http.StatusMethodNotAllowed)
+		}
+	case "/me/credits/transactions":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Método não permitido para /me/credits/transactions. Use GET.", http.StatusMethodNotAllowed)
+			return
+		}
+		protect(appDB, PermSelfService, func(ww http.ResponseWriter, rr *http.Request) {
+			handleGetMyCreditTransactions(ww, rr, appDB)
+		}).ServeHTTP(w, r)
+	case "/credits/adjustments":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Método não permitido para /credits/adjustments. Use POST.", http.StatusMethodNotAllowed)
+			return
+		}
+		protect(appDB, PermCreditsManage, func(ww http.ResponseWriter, rr *http.Request) {
+			handleCreateCreditAdjustment(ww, rr, appDB)
+		}).ServeHTTP(w, r)
+	case "/credits/reconciliation":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Método não permitido para /credits/reconciliation. Use GET.", http.StatusMethodNotAllowed)
+			return
+		}
+		protect(appDB, PermCreditsManage, func(ww http.ResponseWriter, rr *http.Request) {
+			handleGetCreditReconciliation(ww, rr, appDB)
+		}).ServeHTTP(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleGetMyCreditTransactions lista o extrato do usuário autenticado, com paginação e filtros de data/tipo
+func handleGetMyCreditTransactions(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+	userID, ok := r.Context().Value(userContextKey).(string)
+	if !ok || userID == "" {
+		http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+		return
+	}
+
+	limit, offset, err := parseLimitOffset(r, 50, 200)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conditions := []string{"user_id = $1"}
+	queryParams := []interface{}{userID}
+
+	if from != nil {
+		queryParams = append(queryParams, *from)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(queryParams)))
+	}
+	if to != nil {
+		queryParams = append(queryParams, *to)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(queryParams)))
+	}
+	if txType := strings.ToUpper(r.URL.Query().Get("type")); txType != "" {
+		if !validCreditTxTypes[txType] {
+			http.Error(w, fmt.Sprintf("Tipo de lançamento '%s' inválido.", txType), http.StatusBadRequest)
+			return
+		}
+		queryParams = append(queryParams, txType)
+		conditions = append(conditions, fmt.Sprintf("type = $%d", len(queryParams)))
+	}
+	whereClause := " WHERE " + strings.Join(conditions, " AND ")
+
+	page := CreditTransactionsPage{Transactions: []CreditTransaction{}, Limit: limit, Offset: offset}
+
+	if err := appDB.QueryRow("SELECT credits FROM public.users WHERE id = $1", userID).Scan(&page.Balance); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Perfil do usuário não encontrado", http.StatusNotFound)
+		} else {
+			log.Printf("Erro ao buscar saldo do usuário %s: %v", userID, err)
+			http.Error(w, "Erro ao buscar extrato de créditos.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := appDB.QueryRow("SELECT COUNT(*) FROM public.credit_transactions"+whereClause, queryParams...).Scan(&page.Total); err != nil {
+		log.Printf("Erro ao contar lançamentos do usuário %s: %v", userID, err)
+		http.Error(w, "Erro ao buscar extrato de créditos.", http.StatusInternalServerError)
+		return
+	}
+
+	listQuery := `
+		SELECT id, user_id, type, amount, balance_after, order_id, topup_id, student_id, description, created_by, created_at
+		FROM public.credit_transactions` + whereClause +
+		fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d;", len(queryParams)+1, len(queryParams)+2)
+
+	rows, err := appDB.Query(listQuery, append(queryParams, limit, offset)...)
+	if err != nil {
+		log.Printf("Erro ao buscar lançamentos do usuário %s: %v", userID, err)
+		http.Error(w, "Erro ao buscar extrato de créditos.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ct CreditTransaction
+		var orderID, topUpID, studentID, description, createdBy sql.NullString
+		if err := rows.Scan(&ct.ID, &ct.UserID, &ct.Type, &ct.Amount, &ct.BalanceAfter, &orderID, &topUpID, &studentID, &description, &createdBy, &ct.CreatedAt); err != nil {
+			log.Printf("Erro ao scanear lançamento do usuário %s: %v", userID, err)
+			http.Error(w, "Erro ao processar extrato de créditos.", http.StatusInternalServerError)
+			return
+		}
+		if orderID.Valid {
+			ct.OrderID = &orderID.String
+		}
+		if topUpID.Valid {
+			ct.TopUpID = &topUpID.String
+		}
+		if studentID.Valid {
+			ct.StudentID = &studentID.String
+		}
+		if description.Valid {
+			ct.Description = &description.String
+		}
+		if createdBy.Valid {
+			ct.CreatedBy = &createdBy.String
+		}
+		page.Transactions = append(page.Transactions, ct)
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Erro após iterar lançamentos do usuário %s: %v", userID, err)
+		http.Error(w, "Erro ao processar extrato de créditos.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// handleCreateCreditAdjustment permite que ADMIN/SUPER_ADMIN lancem um ajuste manual no saldo de um usuário
+func handleCreateCreditAdjustment(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+	requestingUserID := r.Context().Value(userContextKey).(string) // PermCreditsManage verificada na rota
+
+	var payload CreateCreditAdjustmentPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	payload.Description = strings.TrimSpace(payload.Description)
+	if payload.UserID == "" || payload.Amount == 0 || payload.Description == "" {
+		http.Error(w, "user_id, amount (diferente de zero) e description são obrigatórios.", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := appDB.Begin()
+	if err != nil {
+		log.Printf("Erro ao iniciar transação de ajuste de créditos: %v", err)
+		http.Error(w, "Erro no servidor ao ajustar créditos.", http.StatusInternalServerError)
+		return
+	}
+	defer rollbackTx(tx)
+
+	entry, err := recordCreditTransaction(tx, CreditTransaction{
+		UserID:      payload.UserID,
+		Type:        CreditTxAdjustment,
+		Amount:      payload.Amount,
+		Description: &payload.Description,
+		CreatedBy:   &requestingUserID,
+	})
+	if err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			http.Error(w, "Usuário não encontrado.", http.StatusNotFound)
+		case errors.Is(err, errInsufficientCredits):
+			http.Error(w, "O ajuste deixaria o saldo do usuário negativo.", http.StatusConflict)
+		default:
+			log.Printf("Erro ao lançar ajuste para usuário %s: %v", payload.UserID, err)
+			http.Error(w, "Erro no servidor ao ajustar créditos.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := commitTx(tx); err != nil {
+		log.Printf("Erro ao confirmar ajuste de créditos para usuário %s: %v", payload.UserID, err)
+		http.Error(w, "Erro no servidor ao ajustar créditos.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Usuário %s lançou ajuste de %s para o usuário %s.", requestingUserID, payload.Amount, payload.UserID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// handleGetCreditReconciliation lista usuários cujo users.credits diverge da soma do extrato.
+// Com ?user_id= retorna a conciliação de um único usuário, mesmo que não haja divergência.
+func handleGetCreditReconciliation(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+	// PermCreditsManage verificada na rota
+	query := `
+		SELECT u.id, u.credits, COALESCE(SUM(ct.amount), 0) AS ledger_balance
+		FROM public.users u
+		LEFT JOIN public.credit_transactions ct ON ct.user_id = u.id`
+	var queryParams []interface{}
+	if userID := r.URL.Query().Get("user_id"); userID != "" {
+		query += " WHERE u.id = $1 GROUP BY u.id, u.credits"
+		queryParams = append(queryParams, userID)
+	} else {
+		query += " GROUP BY u.id, u.credits HAVING u.credits <> COALESCE(SUM(ct.amount), 0)"
+	}
+	query += " ORDER BY u.id;"
+
+	rows, err := appDB.Query(query, queryParams...)
+	if err != nil {
+		log.Printf("Erro ao conciliar créditos: %v", err)
+		http.Error(w, "Erro ao conciliar créditos.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	results := []CreditReconciliation{}
+	for rows.Next() {
+		var rec CreditReconciliation
+		if err := rows.Scan(&rec.UserID, &rec.Credits, &rec.LedgerBalance); err != nil {
+			log.Printf("Erro ao scanear conciliação de créditos: %v", err)
+			http.Error(w, "Erro ao conciliar créditos.", http.StatusInternalServerError)
+			return
+		}
+		rec.Difference = rec.Credits - rec.LedgerBalance
+		results = append(results, rec)
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Erro após iterar conciliação de créditos: %v", err)
+		http.Error(w, "Erro ao conciliar créditos.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	deviceKeyTokenPrefix   = "cdk_"      // Chaves de dispositivo: Authorization: Bearer cdk_...
+	deviceKeyRandomBytes   = 32          // Entropia da chave (sha256 puro basta para guardar o hash)
+	deviceKeyDisplayLength = 12          // Caracteres guardados em key_prefix para identificar a chave
+	deviceKeyLastUsedEvery = time.Minute // last_used_at é gravado no máximo uma vez por intervalo
+	deviceKeyContextKey    = contextKey("deviceKey")
+)
+
+var errDeviceKeyInvalid = errors.New("chave de dispositivo inválida ou revogada")
+
+// DeviceAPIKey espelha uma linha de device_api_keys (sem o hash)
+type DeviceAPIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	KeyPrefix  string     `json:"key_prefix"`
+	UserID     string     `json:"user_id"` // Usuário em nome de quem o dispositivo age
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP *string    `json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy  *string    `json:"revoked_by,omitempty"`
+}
+
+// CreatedDeviceAPIKey é a resposta de POST /device-keys: a única vez em que a chave é mostrada
+type CreatedDeviceAPIKey struct {
+	DeviceAPIKey
+	Key string `json:"key"`
+}
+
+// CreateDeviceAPIKeyPayload é o corpo de POST /device-keys. Sem user_id, o dispositivo age em nome de quem o criou.
+type CreateDeviceAPIKeyPayload struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	UserID *string  `json:"user_id,omitempty"`
+}
+
+const deviceKeyColumns = `id, name, key_prefix, user_id, scopes, created_by, created_at, last_used_at, last_used_ip, revoked_at, revoked_by`
+
+func scanDeviceKey(row interface{ Scan(...interface{}) error }) (*DeviceAPIKey, error) {
+	var key DeviceAPIKey
+	var lastUsedAt, revokedAt sql.NullTime
+	var lastUsedIP, revokedBy sql.NullString
+	err := row.Scan(&key.ID, &key.Name, &key.KeyPrefix, &key.UserID, pq.Array(&key.Scopes), &key.CreatedBy, &key.CreatedAt,
+		&lastUsedAt, &lastUsedIP, &revokedAt, &revokedBy)
+	if err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if lastUsedIP.Valid {
+		key.LastUsedIP = &lastUsedIP.String
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	if revokedBy.Valid {
+		key.RevokedBy = &revokedBy.String
+	}
+	return &key, nil
+}
+
+// hasScope diz se a chave foi emitida com a permissão
+func (key *DeviceAPIKey) hasScope(permission Permission) bool {
+	for _, scope := range key.Scopes {
+		if Permission(scope) == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceKeyFromContext retorna a chave de dispositivo que autenticou a requisição (nil para JWT)
+func deviceKeyFromContext(ctx context.Context) *DeviceAPIKey {
+	key, _ := ctx.Value(deviceKeyContextKey).(*DeviceAPIKey)
+	return key
+}
+
+func hashDeviceKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateDeviceKey() (string, error) {
+	random := make([]byte, deviceKeyRandomBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("erro ao gerar chave de dispositivo: %w", err)
+	}
+	return deviceKeyTokenPrefix + base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// clientIP é o IP de origem para last_used_ip (primeiro X-Forwarded-For atrás do proxy, senão RemoteAddr)
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(first)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// authenticateDeviceKey busca a chave ativa pelo hash e registra o uso
+func authenticateDeviceKey(appDB *sql.DB, rawKey, ip string) (*DeviceAPIKey, error) {
+	key, err := scanDeviceKey(appDB.QueryRow(
+		"SELECT "+deviceKeyColumns+" FROM public.device_api_keys WHERE key_hash = $1 AND revoked_at IS NULL",
+		hashDeviceKey(rawKey)))
+	if err == sql.ErrNoRows {
+		return nil, errDeviceKeyInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar chave de dispositivo: %w", err)
+	}
+
+	if key.LastUsedAt == nil || time.Since(*key.LastUsedAt) >= deviceKeyLastUsedEvery || (key.LastUsedIP != nil && *key.LastUsedIP != ip) {
+		_, err = appDB.Exec("UPDATE public.device_api_keys SET last_used_at = NOW(), last_used_ip = $2 WHERE id = $1", key.ID, ip)
+		if err != nil {
+			log.Printf("Alerta: não foi possível registrar o uso da chave de dispositivo %s: %v", key.ID, err)
+		}
+	}
+	return key, nil
+}
+
+// validateDeviceKeyScopes confere que os escopos são permissões conhecidas, que o usuário em nome de quem
+// o dispositivo age tem cada uma delas, e que nenhuma delas é a gestão de chaves (dispositivo não emite chaves)
+func validateDeviceKeyScopes(scopes []string, actingRole Role) ([]string, string) {
+	if len(scopes) == 0 {
+		return nil, "Informe ao menos um escopo em 'scopes'."
+	}
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		permission := Permission(scope)
+		if _, known := permissionMatrix[permission]; !known || permission == PermDeviceKeysManage {
+			return nil, fmt.Sprintf("Escopo '%s' inválido.", scope)
+		}
+		if !actingRole.Can(permission) {
+			return nil, fmt.Sprintf("O usuário da chave (papel %s) não tem a permissão '%s'.", actingRole, scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	return normalized, ""
+}
+
+// deviceKeysRouterHandler para /device-keys e /device-keys/{id} (apenas administração)
+func deviceKeysRouterHandler(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+	keyID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/device-keys"), "/")
+
+	switch {
+	case keyID == "" && r.Method == http.MethodGet:
+		protect(appDB, PermDeviceKeysManage, func(ww http.ResponseWriter, rr *http.Request) {
+			handleGetDeviceKeys(ww, rr, appDB)
+		}).ServeHTTP(w, r)
+	case keyID == "" && r.Method == http.MethodPost:
+		protect(appDB, PermDeviceKeysManage, func(ww http.ResponseWriter, rr *http.Request) {
+			handleCreateDeviceKey(ww, rr, appDB)
+		}).ServeHTTP(w, r)
+	case keyID != "" && r.Method == http.MethodDelete:
+		protect(appDB, PermDeviceKeysManage, func(ww http.ResponseWriter, rr *http.Request) {
+			handleRevokeDeviceKey(ww, rr, appDB, keyID)
+		}).ServeHTTP(w, r)
+	default:
+		http.Error(w, "Rota ou método não permitido para /device-keys", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleGetDeviceKeys trata GET /device-keys: todas as chaves, inclusive revogadas (?active=true só as ativas)
+func handleGetDeviceKeys(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+	query := "SELECT " + deviceKeyColumns + " FROM public.device_api_keys"
+	if r.URL.Query().Get("active") == "true" {
+		query += " WHERE revoked_at IS NULL"
+	}
+	query += " ORDER BY created_at DESC"
+
+	rows, err := appDB.Query(query)
+	if err != nil {
+		log.Printf("Erro ao buscar chaves de dispositivo: %v", err)
+		http.Error(w, "Erro ao buscar chaves de dispositivo.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	keys := []DeviceAPIKey{}
+	for rows.Next() {
+		key, err := scanDeviceKey(rows)
+		if err != nil {
+			log.Printf("Erro ao scanear chave de dispositivo: %v", err)
+			http.Error(w, "Erro ao buscar chaves de dispositivo.", http.StatusInternalServerError)
+			return
+		}
+		keys = append(keys, *key)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Erro após iterar chaves de dispositivo: %v", err)
+		http.Error(w, "Erro ao buscar chaves de dispositivo.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// handleCreateDeviceKey trata POST /device-keys: emite a chave e a devolve uma única vez
+func handleCreateDeviceKey(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+	requestingUserProfile := profileFromContext(r.Context())
+
+	var payload CreateDeviceAPIKeyPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" {
+		http.Error(w, "Nome do dispositivo é obrigatório.", http.StatusBadRequest)
+		return
+	}
+
+	actingProfile := requestingUserProfile
+	if payload.UserID != nil && *payload.UserID != requestingUserProfile.ID {
+		var err error
+		actingProfile, err = fetchUserProfile(*payload.UserID, appDB)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Usuário informado em 'user_id' não encontrado.", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Erro ao buscar perfil do usuário %s para chave de dispositivo: %v", *payload.UserID, err)
+			http.Error(w, "Erro no servidor ao criar chave de dispositivo.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	scopes, msg := validateDeviceKeyScopes(payload.Scopes, actingProfile.Role)
+	if msg != "" {
+		http.Error(w, msg, http.StatusUnprocessableEntity)
+		return
+	}
+
+	rawKey, err := generateDeviceKey()
+	if err != nil {
+		log.Printf("Erro: %v", err)
+		http.Error(w, "Erro no servidor ao criar chave de dispositivo.", http.StatusInternalServerError)
+		return
+	}
+
+	key, err := scanDeviceKey(appDB.QueryRow(`
+		INSERT INTO public.device_api_keys (name, key_prefix, key_hash, user_id, scopes, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+deviceKeyColumns,
+		payload.Name, rawKey[:deviceKeyDisplayLength], hashDeviceKey(rawKey), actingProfile.ID, pq.Array(scopes), requestingUserProfile.ID))
+	if err != nil {
+		log.Printf("Erro ao inserir chave de dispositivo: %v", err)
+		http.Error(w, "Erro no servidor ao criar chave de dispositivo.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Usuário %s emitiu a chave de dispositivo %s (%s) em nome de %s com escopos %v.",
+		requestingUserProfile.ID, key.ID, key.Name, key.UserID, key.Scopes)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreatedDeviceAPIKey{DeviceAPIKey: *key, Key: rawKey})
+}
+
+// handleRevokeDeviceKey trata DELETE /device-keys/{id}: revoga a chave (a linha fica para auditoria)
+func handleRevokeDeviceKey(w http.ResponseWriter, r *http.Request, appDB *sql.DB, keyID string) {
+	requestingUserID := r.Context().Value(userContextKey).(string)
+
+	result, err := appDB.Exec(`
+		UPDATE public.device_api_keys
+		SET revoked_at = NOW(), revoked_by = $2
+		WHERE id = $1 AND revoked_at IS NULL;`, keyID, requestingUserID)
+	if err != nil {
+		log.Printf("Erro ao revogar chave de dispositivo %s: %v", keyID, err)
+		http.Error(w, "Erro no servidor ao revogar chave de dispositivo.", http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		http.Error(w, "Chave de dispositivo não encontrada ou já revogada.", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Usuário %s revogou a chave de dispositivo %s.", requestingUserID, keyID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Alérgenos reconhecidos: em menu_items.allergens indicam o que o item CONTÉM;
+// em students.allergies, o que o aluno NÃO pode consumir.
+var knownAllergens = map[string]bool{
+	"gluten":    true,
+	"lactose":   true,
+	"peanuts":   true,
+	"tree_nuts": true,
+	"eggs":      true,
+	"soy":       true,
+	"fish":      true,
+	"shellfish": true,
+	"sesame":    true,
+}
+
+// Marcações alimentares reconhecidas: em menu_items.dietary_tags indicam o que o item É;
+// em students.dietary_requirements, o que todo item pedido para o aluno PRECISA ser.
+var knownDietaryTags = map[string]bool{
+	"vegetarian":   true,
+	"vegan":        true,
+	"gluten_free":  true,
+	"lactose_free": true,
+	"sugar_free":   true,
+}
+
+// StudentRestrictionsPayload é o corpo de PUT /me/students/{id}/restrictions
+type StudentRestrictionsPayload struct {
+	Allergies           []string `json:"allergies"`
+	DietaryRequirements []string `json:"dietary_requirements"`
+}
+
+// StudentDietaryOverride espelha student_dietary_overrides: item liberado pela equipe para o aluno
+type StudentDietaryOverride struct {
+	StudentID  string    `json:"student_id"`
+	MenuItemID string    `json:"menu_item_id"`
+	ApprovedBy string    `json:"approved_by"`
+	Note       *string   `json:"note,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CreateDietaryOverridePayload é o corpo de POST /students/{id}/dietary-overrides
+type CreateDietaryOverridePayload struct {
+	MenuItemID string  `json:"menu_item_id"`
+	Note       *string `json:"note"`
+}
+
+// normalizeDietaryValues padroniza (minúsculas, sem espaços, sem repetição, ordenado) e valida contra a lista conhecida
+func normalizeDietaryValues(values []string, known map[string]bool) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, value := range values {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" || seen[value] {
+			continue
+		}
+		if !known[value] {
+			return nil, fmt.Errorf("valor desconhecido: '%s'", value)
+		}
+		seen[value] = true
+		normalized = append(normalized, value)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// parseDietaryList lê uma lista separada por vírgulas da query string (ex: ?tag=vegetarian,vegan)
+func parseDietaryList(raw string, known map[string]bool) ([]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	values, err := normalizeDietaryValues(strings.Split(raw, ","), known)
+	if err != nil {
+		return nil, fmt.Errorf("filtro inválido: %w", err)
+	}
+	return values, nil
+}
+
+// normalizeMenuItemDietaryFields valida alérgenos e marcações do payload de criação/atualização de item
+func normalizeMenuItemDietaryFields(payload CreateMenuItemPayload) ([]string, []string, error) {
+	allergens, err := normalizeDietaryValues(payload.Allergens, knownAllergens)
+	if err != nil {
+		return nil, nil, fmt.Errorf("alérgeno inválido: %w", err)
+	}
+	dietaryTags, err := normalizeDietaryValues(payload.DietaryTags, knownDietaryTags)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marcação alimentar inválida: %w", err)
+	}
+	return allergens, dietaryTags, nil
+}
+
+// checkDietaryConflicts confere os itens do pedido contra as restrições do aluno.
+// Itens liberados pela equipe em student_dietary_overrides são ignorados.
+// Retorna um motivo legível ("" se não há conflito). Deve rodar dentro da transação do pedido.
+func checkDietaryConflicts(tx *sql.Tx, studentID string, lines []orderLineCheck) (string, error) {
+	var allergies, requirements []string
+	err := tx.QueryRow("SELECT allergies, dietary_requirements FROM public.students WHERE id = $1", studentID).
+		Scan(pq.Array(&allergies), pq.Array(&requirements))
+	if err != nil {
+		return "", fmt.Errorf("erro ao buscar restrições do aluno %s: %w", studentID, err)
+	}
+	if len(allergies) == 0 && len(requirements) == 0 {
+		return "", nil
+	}
+
+	overridden := map[string]bool{}
+	rows, err := tx.Query("SELECT menu_item_id FROM public.student_dietary_overrides WHERE student_id = $1", studentID)
+	if err != nil {
+		return "", fmt.Errorf("erro ao buscar liberações do aluno %s: %w", studentID, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var menuItemID string
+		if err := rows.Scan(&menuItemID); err != nil {
+			return "", fmt.Errorf("erro ao scanear liberação do aluno %s: %w", studentID, err)
+		}
+		overridden[menuItemID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("erro após iterar liberações do aluno %s: %w", studentID, err)
+	}
+
+	for _, line := range lines {
+		if overridden[line.MenuItemID] {
+			continue
+		}
+		for _, allergen := range line.Allergens {
+			for _, allergy := range allergies {
+				if allergen == allergy {
+					return fmt.Sprintf("O item '%s' contém '%s', restrito para este aluno. A equipe da cantina pode liberar o item se for o caso.",
+						line.MenuItemName, allergen), nil
+				}
+			}
+		}
+		for _, requirement := range requirements {
+			hasTag := false
+			for _, tag := range line.DietaryTags {
+				if tag == requirement {
+					hasTag = true
+					break
+				}
+			}
+			if !hasTag {
+				return fmt.Sprintf("O item '%s' não é '%s', exigido para este aluno. A equipe da cantina pode liberar o item se for o caso.",
+					line.MenuItemName, requirement), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// handleUpdateStudentRestrictions trata PUT /me/students/{id}/restrictions (alergias e exigências alimentares)
+func handleUpdateStudentRestrictions(w http.ResponseWriter, r *http.Request, appDB *sql.DB, studentID string) {
+	userID := r.Context().Value(userContextKey).(string)
+	if _, ok := ensureStudentGuardian(w, appDB, studentID, userID, GuardianPrimary, GuardianSecondary); !ok {
+		return
+	}
+
+	var payload StudentRestrictionsPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	allergies, err := normalizeDietaryValues(payload.Allergies, knownAllergens)
+	if err != nil {
+		http.Error(w, "Alergia inválida: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	requirements, err := normalizeDietaryValues(payload.DietaryRequirements, knownDietaryTags)
+	if err != nil {
+		http.Error(w, "Exigência alimentar inválida: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = appDB.Exec("UPDATE public.students SET allergies = $1, dietary_requirements = $2, updated_at = NOW() WHERE id = $3",
+		pq.Array(allergies), pq.Array(requirements), studentID)
+	if err != nil {
+		log.Printf("Erro ao salvar restrições do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao salvar restrições do aluno.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Responsável %s atualizou restrições alimentares do aluno %s.", userID, studentID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StudentRestrictionsPayload{Allergies: allergies, DietaryRequirements: requirements})
+}
+
+// handleGetDietaryOverrides trata GET /students/{id}/dietary-overrides
+func handleGetDietaryOverrides(w http.ResponseWriter, r *http.Request, appDB *sql.DB, studentID string) {
+	rows, err := appDB.Query(`
+		SELECT student_id, menu_item_id, approved_by, note, created_at
+		FROM public.student_dietary_overrides
+		WHERE student_id = $1
+		ORDER BY created_at;`, studentID)
+	if err != nil {
+		log.Printf("Erro ao buscar liberações do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao buscar liberações.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	overrides := []StudentDietaryOverride{}
+	for rows.Next() {
+		var override StudentDietaryOverride
+		var note sql.NullString
+		if err := rows.Scan(&override.StudentID, &override.MenuItemID, &override.ApprovedBy, &note, &override.CreatedAt); err != nil {
+			log.Printf("Erro ao scanear liberação do aluno %s: %v", studentID, err)
+			http.Error(w, "Erro ao buscar liberações.", http.StatusInternalServerError)
+			return
+		}
+		if note.Valid {
+			override.Note = &note.String
+		}
+		overrides = append(overrides, override)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Erro após iterar liberações do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao buscar liberações.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(overrides)
+}
+
+// handleCreateDietaryOverride trata POST /students/{id}/dietary-overrides: a equipe libera um item para o aluno
+func handleCreateDietaryOverride(w http.ResponseWriter, r *http.Request, appDB *sql.DB, studentID string) {
+	requestingUserID := r.Context().Value(userContextKey).(string)
+
+	var payload CreateDietaryOverridePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if strings.TrimSpace(payload.MenuItemID) == "" {
+		http.Error(w, "menu_item_id é obrigatório.", http.StatusBadRequest)
+		return
+	}
+
+	var override StudentDietaryOverride
+	var note sql.NullString
+	err := appDB.QueryRow(`
+		INSERT INTO public.student_dietary_overrides (student_id, menu_item_id, approved_by, note)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (student_id, menu_item_id) DO UPDATE
+		SET approved_by = EXCLUDED.approved_by, note = EXCLUDED.note, created_at = NOW()
+		RETURNING student_id, menu_item_id, approved_by, note, created_at;`,
+		studentID, payload.MenuItemID, requestingUserID, payload.Note).
+		Scan(&override.StudentID, &override.MenuItemID, &override.ApprovedBy, &note, &override.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "foreign_key_violation" {
+			http.Error(w, "Aluno ou item do cardápio não encontrado.", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Erro ao liberar item %s para aluno %s: %v", payload.MenuItemID, studentID, err)
+		http.Error(w, "Erro ao salvar liberação.", http.StatusInternalServerError)
+		return
+	}
+	if note.Valid {
+		override.Note = &note.String
+	}
+
+	log.Printf("Usuário %s liberou o item %s para o aluno %s apesar das restrições alimentares.", requestingUserID, payload.MenuItemID, studentID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(override)
+}
+
+// handleDeleteDietaryOverride trata DELETE /students/{id}/dietary-overrides/{menuItemID}
+func handleDeleteDietaryOverride(w http.ResponseWriter, r *http.Request, appDB *sql.DB, studentID, menuItemID string) {
+	result, err := appDB.Exec("DELETE FROM public.student_dietary_overrides WHERE student_id = $1 AND menu_item_id = $2", studentID, menuItemID)
+	if err != nil {
+		log.Printf("Erro ao remover liberação do item %s para aluno %s: %v", menuItemID, studentID, err)
+		http.Error(w, "Erro ao remover liberação.", http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		http.Error(w, "Liberação não encontrada.", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
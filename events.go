@@ -0,0 +1,330 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Os eventos são publicados dentro da transação que os origina, então só são entregues se ela for confirmada:
+// commitTx os entrega ao EventHub local, que distribui aos clientes SSE conectados nesta instância, e o NOTIFY
+// leva às demais instâncias, que fazem LISTEN no canal e repassam o que recebem ao seu hub.
+const eventsChannel = "cantina_events"
+
+// Tipos de evento
+const (
+	EventOrderCreated         = "order.created"
+	EventOrderStatusChanged   = "order.status_changed"
+	EventCreditBalanceChanged = "credit.balance_changed"
+	EventCreditLowBalance     = "credit.low_balance"
+	EventStreamResync         = "stream.resync" // Eventos podem ter sido perdidos: o cliente deve recarregar o estado
+)
+
+const (
+	sseHeartbeatInterval  = 25 * time.Second
+	sseSubscriberBuffer   = 64
+	listenerPingInterval  = 90 * time.Second
+	listenerMinReconnect  = 2 * time.Second
+	listenerMaxReconnect  = time.Minute
+	maxEventPayloadLength = 7900 // NOTIFY aceita até 8000 bytes
+)
+
+// Event é o envelope de tudo que trafega pelo canal e pelos streams SSE
+type Event struct {
+	Type   string          `json:"type"`
+	UserID *string         `json:"user_id,omitempty"` // Dono do recurso (responsável), para filtrar streams por usuário
+	Data   json.RawMessage `json:"data,omitempty"`
+	Origin string          `json:"origin,omitempty"` // Instância que publicou (eventOrigin)
+}
+
+// eventOrigin identifica esta instância nos eventos que ela publica: o listener ignora os que voltam pelo
+// NOTIFY, pois commitTx já os entregou ao hub local
+var eventOrigin = newEventOrigin()
+
+func newEventOrigin() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("pid-%d", os.Getpid())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// localEventHub recebe os eventos confirmados desta instância (configurado no main.go; nil descarta)
+var localEventHub *EventHub
+
+// pendingEvents guarda os eventos publicados em cada transação ainda aberta até commitTx ou rollbackTx
+var pendingEvents = struct {
+	sync.Mutex
+	byTx map[*sql.Tx][]Event
+}{byTx: map[*sql.Tx][]Event{}}
+
+// commitTx confirma a transação e entrega ao hub local os eventos publicados nela.
+// Transações que podem publicar eventos devem usar commitTx/rollbackTx em vez de Commit/Rollback.
+func commitTx(tx *sql.Tx) error {
+	err := tx.Commit()
+	events := takePendingEvents(tx)
+	if err == nil && localEventHub != nil {
+		for _, event := range events {
+			localEventHub.Broadcast(event)
+		}
+	}
+	return err
+}
+
+// rollbackTx desfaz a transação (se ainda aberta) e descarta os eventos publicados nela
+func rollbackTx(tx *sql.Tx) error {
+	takePendingEvents(tx)
+	return tx.Rollback()
+}
+
+func takePendingEvents(tx *sql.Tx) []Event {
+	pendingEvents.Lock()
+	defer pendingEvents.Unlock()
+	events := pendingEvents.byTx[tx]
+	delete(pendingEvents.byTx, tx)
+	return events
+}
+
+// orderEventQuery monta os dados do evento de pedido a partir da linha atual (já alterada na transação).
+// $1 = id do pedido, $2 = status anterior (NULL na criação).
+const orderEventQuery = `
+	SELECT o.user_id, json_build_object(
+			'order_id', o.id,
+			'user_id', o.user_id,
+			'student_id', o.student_id,
+			'status', o.status,
+			'previous_status', $2::text,
+			'total_amount', o.total_amount,
+			'scheduled_for', to_char(o.scheduled_for, 'YYYY-MM-DD'),
+			'pickup_slot_id', o.pickup_slot_id,
+			'updated_at', o.updated_at
+		)::text
+	FROM public.orders o
+	WHERE o.id = $1`
+
+// publishOrderEvent publica order.created (previousStatus nil) ou order.status_changed na transação informada
+func publishOrderEvent(q dbQueryer, orderID string, previousStatus *string) error {
+	eventType := EventOrderStatusChanged
+	if previousStatus == nil {
+		eventType = EventOrderCreated
+	}
+	var userID, data string
+	if err := q.QueryRow(orderEventQuery, orderID, previousStatus).Scan(&userID, &data); err != nil {
+		return fmt.Errorf("erro ao montar evento %s do pedido %s: %w", eventType, orderID, err)
+	}
+	return publishEvent(q, Event{Type: eventType, UserID: &userID, Data: json.RawMessage(data)})
+}
+
+// publishEvent publica o evento com NOTIFY na transação informada e o guarda para o hub local (ver commitTx)
+func publishEvent(q dbQueryer, event Event) error {
+	event.Origin = eventOrigin
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("erro ao serializar evento %s: %w", event.Type, err)
+	}
+	if len(payload) > maxEventPayloadLength {
+		return fmt.Errorf("evento %s excede o tamanho máximo do NOTIFY (%d bytes)", event.Type, len(payload))
+	}
+	if _, err := q.Exec("SELECT pg_notify($1, $2)", eventsChannel, string(payload)); err != nil {
+		return fmt.Errorf("erro ao publicar evento %s: %w", event.Type, err)
+	}
+
+	tx, inTx := q.(*sql.Tx)
+	if !inTx { // Fora de transação o NOTIFY já foi confirmado
+		if localEventHub != nil {
+			localEventHub.Broadcast(event)
+		}
+		return nil
+	}
+	pendingEvents.Lock()
+	pendingEvents.byTx[tx] = append(pendingEvents.byTx[tx], event)
+	pendingEvents.Unlock()
+	return nil
+}
+
+// eventSubscriber é um cliente conectado ao hub, com o filtro dos eventos que lhe interessam
+type eventSubscriber struct {
+	events chan Event
+	filter func(Event) bool
+}
+
+// EventHub é o pub/sub em memória de uma instância: distribui os eventos recebidos aos assinantes locais.
+// Assinantes lentos demais (buffer cheio) são desconectados, para que reconectem e recarreguem o estado,
+// em vez de travar a distribuição para os demais.
+type EventHub struct {
+	mu          sync.Mutex
+	subscribers map[*eventSubscriber]struct{}
+}
+
+func newEventHub() *EventHub {
+	return &EventHub{subscribers: map[*eventSubscriber]struct{}{}}
+}
+
+// Subscribe registra um assinante. O canal é fechado por unsubscribe ou se o assinante ficar para trás.
+func (hub *EventHub) Subscribe(filter func(Event) bool) (<-chan Event, func()) {
+	sub := &eventSubscriber{events: make(chan Event, sseSubscriberBuffer), filter: filter}
+	hub.mu.Lock()
+	hub.subscribers[sub] = struct{}{}
+	hub.mu.Unlock()
+
+	unsubscribe := func() {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		if _, ok := hub.subscribers[sub]; ok {
+			delete(hub.subscribers, sub)
+			close(sub.events)
+		}
+	}
+	return sub.events, unsubscribe
+}
+
+// Broadcast entrega o evento a todos os assinantes cujo filtro o aceita
+func (hub *EventHub) Broadcast(event Event) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for sub := range hub.subscribers {
+		if sub.filter != nil && !sub.filter(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			log.Printf("Assinante de eventos lento desconectado (buffer de %d cheio).", sseSubscriberBuffer)
+			delete(hub.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// startEventListener faz LISTEN no canal de eventos em segundo plano e repassa ao hub os eventos das outras
+// instâncias até o processo terminar. Se o LISTEN falhar (ex: banco fora do ar no boot), tenta de novo com espera
+// crescente; enquanto isso, o hub continua recebendo os eventos desta instância (ver commitTx).
+func startEventListener(connStr string, hub *EventHub) {
+	go func() {
+		wait := listenerMinReconnect
+		for {
+			err := listenForEvents(connStr, hub)
+			log.Printf("Alerta: eventos de outras instâncias indisponíveis: %v; nova tentativa em %s", err, wait)
+			time.Sleep(wait)
+			wait = min(wait*2, listenerMaxReconnect)
+		}
+	}()
+}
+
+// listenForEvents escuta o canal até o processo terminar; só retorna se o LISTEN falhar.
+// Após uma reconexão, notificações podem ter sido perdidas, então o hub recebe um stream.resync.
+func listenForEvents(connStr string, hub *EventHub) error {
+	listener := pq.NewListener(connStr, listenerMinReconnect, listenerMaxReconnect, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Listener de eventos: %v", err)
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(eventsChannel); err != nil {
+		return fmt.Errorf("erro ao escutar o canal %s: %w", eventsChannel, err)
+	}
+	log.Printf("Escutando eventos no canal %s.", eventsChannel)
+	// Eventos de outras instâncias publicados antes do LISTEN (ex: durante as tentativas) se perderam
+	hub.Broadcast(Event{Type: EventStreamResync})
+
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case notification := <-listener.Notify:
+			if notification == nil { // Conexão restabelecida
+				hub.Broadcast(Event{Type: EventStreamResync})
+				continue
+			}
+			var event Event
+			if err := json.Unmarshal([]byte(notification.Extra), &event); err != nil {
+				log.Printf("Evento inválido no canal %s: %v", eventsChannel, err)
+				continue
+			}
+			if event.Origin == eventOrigin { // Já entregue ao hub por commitTx
+				continue
+			}
+			hub.Broadcast(event)
+		case <-ticker.C:
+			if err := listener.Ping(); err != nil {
+				log.Printf("Listener de eventos: ping falhou: %v", err)
+			}
+		}
+	}
+}
+
+// serveEventStream mantém uma resposta SSE aberta, enviando os eventos do hub aceitos pelo filtro
+// e comentários periódicos para que proxies não derrubem a conexão ociosa.
+func serveEventStream(w http.ResponseWriter, r *http.Request, hub *EventHub, filter func(Event) bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming não suportado.", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := hub.Subscribe(filter)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Desliga o buffer do nginx
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, open := <-events:
+			if !open { // Desconectado pelo hub: o cliente reconecta e recarrega o estado
+				return
+			}
+			data := event.Data
+			if len(data) == 0 {
+				data = json.RawMessage("{}")
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, strings.ReplaceAll(string(data), "\n", ""))
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// handleOrderStream trata GET /orders/stream: feed de pedidos criados e mudanças de status para a equipe
+func handleOrderStream(w http.ResponseWriter, r *http.Request, hub *EventHub) {
+	requestingUserID := r.Context().Value(userContextKey).(string)
+
+	log.Printf("Usuário %s conectado ao stream de pedidos.", requestingUserID)
+	serveEventStream(w, r, hub, func(event Event) bool {
+		return strings.HasPrefix(event.Type, "order.") || event.Type == EventStreamResync
+	})
+	log.Printf("Usuário %s desconectado do stream de pedidos.", requestingUserID)
+}
+
+// handleMyEvents trata GET /me/events: stream SSE do usuário logado com as mudanças de status dos seus pedidos,
+// as movimentações do seu saldo e os avisos de saldo baixo
+func handleMyEvents(w http.ResponseWriter, r *http.Request, hub *EventHub) {
+	userID := r.Context().Value(userContextKey).(string)
+	serveEventStream(w, r, hub, func(event Event) bool {
+		if event.Type == EventStreamResync {
+			return true
+		}
+		return event.UserID != nil && *event.UserID == userID &&
+			(strings.HasPrefix(event.Type, "order.") || strings.HasPrefix(event.Type, "credit."))
+	})
+}
@@ -0,0 +1,1068 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Testes dos handlers sobre os repositórios em memória (repository_memory.go): sem Postgres.
+// A autenticação é simulada pondo no contexto o que authMiddleware e authorize poriam.
+
+var (
+	testAdmin  = UserProfile{ID: "admin-1", Email: strPtr("admin@escola.com"), Role: RoleAdmin}
+	testStaff  = UserProfile{ID: "staff-1", Email: strPtr("cantina@escola.com"), Role: RoleStaff}
+	testParent = UserProfile{ID: "parent-1", Email: strPtr("mae@exemplo.com"), Role: RoleClient}
+	testOther  = UserProfile{ID: "parent-2", Email: strPtr("pai@exemplo.com"), Role: RoleClient}
+)
+
+func strPtr(s string) *string { return &s }
+
+func newTestRepositories(t *testing.T) (*memoryStore, *Repositories) {
+	t.Helper()
+	store := newMemoryStore()
+	for _, profile := range []UserProfile{testAdmin, testStaff, testParent, testOther} {
+		store.addUser(profile)
+	}
+	return store, newMemoryRepositories(store)
+}
+
+// newAuthedRequest monta a requisição já autenticada como 'profile'
+func newAuthedRequest(method, target, body string, profile UserProfile) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	ctx := context.WithValue(r.Context(), userContextKey, profile.ID)
+	ctx = context.WithValue(ctx, profileContextKey, &profile)
+	return r.WithContext(ctx)
+}
+
+// decodeBody decodifica a resposta JSON, falhando o teste se o status não for o esperado
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, wantStatus int, out interface{}) {
+	t.Helper()
+	if w.Code != wantStatus {
+		t.Fatalf("status = %d, esperado %d (corpo: %s)", w.Code, wantStatus, strings.TrimSpace(w.Body.String()))
+	}
+	if out != nil {
+		if err := json.NewDecoder(w.Body).Decode(out); err != nil {
+			t.Fatalf("resposta não é JSON válido: %v", err)
+		}
+	}
+}
+
+func TestMenuItemsCRUD(t *testing.T) {
+	_, repos := newTestRepositories(t)
+
+	w := httptest.NewRecorder()
+	handleCreateMenuItem(w, newAuthedRequest(http.MethodPost, "/menu-items/",
+		`{"name":"Pão de queijo","price":4.5,"allergens":["lactose"],"dietary_tags":["vegetarian"]}`, testAdmin), repos.Menu)
+	var created MenuItem
+	decodeBody(t, w, http.StatusCreated, &created)
+	if created.ID == "" || !created.IsAvailable || created.Price != 450 {
+		t.Fatalf("item criado inesperado: %+v", created)
+	}
+
+	w = httptest.NewRecorder()
+	handleCreateMenuItem(w, newAuthedRequest(http.MethodPost, "/menu-items/", `{"name":"Suco","price":6,"dietary_tags":["vegan"]}`, testAdmin), repos.Menu)
+	decodeBody(t, w, http.StatusCreated, nil)
+
+	w = httptest.NewRecorder()
+	handleCreateMenuItem(w, newAuthedRequest(http.MethodPost, "/menu-items/", `{"name":"Grátis","price":0}`, testAdmin), repos.Menu)
+	decodeBody(t, w, http.StatusBadRequest, nil)
+
+	// Frações de centavo são rejeitadas em vez de arredondadas
+	w = httptest.NewRecorder()
+	handleCreateMenuItem(w, newAuthedRequest(http.MethodPost, "/menu-items/", `{"name":"Bala","price":0.125}`, testAdmin), repos.Menu)
+	decodeBody(t, w, http.StatusBadRequest, nil)
+
+	// Filtros: marcação exigida e alérgeno excluído
+	w = httptest.NewRecorder()
+	handleGetMenuItems(w, httptest.NewRequest(http.MethodGet, "/menu-items/?tag=vegetarian", nil), repos.Menu)
+	var items []MenuItem
+	decodeBody(t, w, http.StatusOK, &items)
+	if len(items) != 1 || items[0].ID != created.ID {
+		t.Fatalf("?tag=vegetarian retornou %+v", items)
+	}
+
+	w = httptest.NewRecorder()
+	handleGetMenuItems(w, httptest.NewRequest(http.MethodGet, "/menu-items/?exclude_allergens=lactose", nil), repos.Menu)
+	decodeBody(t, w, http.StatusOK, &items)
+	if len(items) != 1 || items[0].Name != "Suco" {
+		t.Fatalf("?exclude_allergens=lactose retornou %+v", items)
+	}
+
+	w = httptest.NewRecorder()
+	handleGetMenuItems(w, httptest.NewRequest(http.MethodGet, "/menu-items/?tag=desconhecida", nil), repos.Menu)
+	decodeBody(t, w, http.StatusBadRequest, nil)
+
+	w = httptest.NewRecorder()
+	handleUpdateMenuItem(w, newAuthedRequest(http.MethodPut, "/menu-items/"+created.ID,
+		`{"name":"Pão de queijo grande","price":6,"is_available":false}`, testAdmin), repos.Menu, created.ID)
+	var updated MenuItem
+	decodeBody(t, w, http.StatusOK, &updated)
+	if updated.Name != "Pão de queijo grande" || updated.IsAvailable {
+		t.Fatalf("item atualizado inesperado: %+v", updated)
+	}
+
+	w = httptest.NewRecorder()
+	handleDeleteMenuItem(w, newAuthedRequest(http.MethodDelete, "/menu-items/"+created.ID, "", testAdmin), repos.Menu, created.ID)
+	decodeBody(t, w, http.StatusNoContent, nil)
+
+	w = httptest.NewRecorder()
+	handleGetMenuItemByID(w, httptest.NewRequest(http.MethodGet, "/menu-items/"+created.ID, nil), repos.Menu, created.ID)
+	decodeBody(t, w, http.StatusNotFound, nil)
+}
+
+func TestClassesHandlers(t *testing.T) {
+	store, repos := newTestRepositories(t)
+
+	createClass := func(body string, wantStatus int) Class {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleCreateClass(w, newAuthedRequest(http.MethodPost, "/classes", body, testAdmin), repos.Classes)
+		var class Class
+		if wantStatus == http.StatusCreated {
+			decodeBody(t, w, wantStatus, &class)
+		} else {
+			decodeBody(t, w, wantStatus, nil)
+		}
+		return class
+	}
+	classA := createClass(`{"name":"1º Ano A"}`, http.StatusCreated)
+	classB := createClass(`{"name":"1º Ano B","description":"Tarde"}`, http.StatusCreated)
+	createClass(`{"name":"2º Ano A"}`, http.StatusCreated)
+	createClass(`{"name":"1º Ano A"}`, http.StatusConflict)
+	createClass(`{"name":"  "}`, http.StatusBadRequest)
+
+	w := httptest.NewRecorder()
+	handleGetClasses(w, newAuthedRequest(http.MethodGet, "/classes?q=1º&limit=1&offset=1", "", testAdmin), repos.Classes)
+	var page ClassesPage
+	decodeBody(t, w, http.StatusOK, &page)
+	if page.Total != 2 || len(page.Classes) != 1 || page.Classes[0].ID != classB.ID {
+		t.Fatalf("página inesperada: %+v", page)
+	}
+
+	w = httptest.NewRecorder()
+	handleUpdateClass(w, newAuthedRequest(http.MethodPut, "/classes/"+classB.ID, `{"name":"2º Ano A"}`, testAdmin), repos.Classes, classB.ID)
+	decodeBody(t, w, http.StatusConflict, nil)
+
+	// Turma com aluno: só sai com reassign_to
+	ana, err := repos.Students.Create(Student{Name: "Ana", ClassID: classA.ID, ParentUserID: testParent.ID})
+	if err != nil {
+		t.Fatalf("criar aluno: %v", err)
+	}
+	w = httptest.NewRecorder()
+	handleDeleteClass(w, newAuthedRequest(http.MethodDelete, "/classes/"+classA.ID, "", testAdmin), repos.Classes, classA.ID)
+	decodeBody(t, w, http.StatusConflict, nil)
+
+	w = httptest.NewRecorder()
+	handleDeleteClass(w, newAuthedRequest(http.MethodDelete, "/classes/"+classA.ID+"?reassign_to="+classA.ID, "", testAdmin), repos.Classes, classA.ID)
+	decodeBody(t, w, http.StatusBadRequest, nil)
+
+	w = httptest.NewRecorder()
+	handleDeleteClass(w, newAuthedRequest(http.MethodDelete, "/classes/"+classA.ID+"?reassign_to="+classB.ID, "", testAdmin), repos.Classes, classA.ID)
+	decodeBody(t, w, http.StatusNoContent, nil)
+
+	w = httptest.NewRecorder()
+	handleGetClassByID(w, newAuthedRequest(http.MethodGet, "/classes/"+classB.ID, "", testAdmin), repos.Classes, classB.ID)
+	var moved Class
+	decodeBody(t, w, http.StatusOK, &moved)
+	if moved.StudentCount != 1 {
+		t.Fatalf("student_count = %d, esperado 1", moved.StudentCount)
+	}
+	if _, ok := store.classes[classA.ID]; ok {
+		t.Fatal("turma removida continua no store")
+	}
+
+	// A mudança de turma entra no histórico do aluno, como uma transferência; a turma de origem some com
+	// o ON DELETE SET NULL, e o motivo diz o que aconteceu
+	transfers, err := repos.Students.ListTransfers(ana.ID)
+	if err != nil || len(transfers) != 1 {
+		t.Fatalf("transferências = %+v, %v; esperada 1", transfers, err)
+	}
+	got := transfers[0]
+	if got.FromClassID != nil || got.ToClassID == nil || *got.ToClassID != classB.ID ||
+		got.Reason == nil || *got.Reason != classDeletedTransferReason ||
+		got.TransferredBy == nil || *got.TransferredBy != testAdmin.ID {
+		t.Fatalf("transferência inesperada: %+v", got)
+	}
+}
+
+func TestStudentLifecycle(t *testing.T) {
+	store, repos := newTestRepositories(t)
+	classA, _ := repos.Classes.Create("3º Ano A", nil)
+	classB, _ := repos.Classes.Create("3º Ano B", nil)
+
+	w := httptest.NewRecorder()
+	handleCreateStudent(w, newAuthedRequest(http.MethodPost, "/students",
+		`{"name":"Bia","class_id":"nao-existe","parent_user_id":"parent-1"}`, testAdmin), repos.Students)
+	decodeBody(t, w, http.StatusBadRequest, nil)
+
+	w = httptest.NewRecorder()
+	handleCreateStudent(w, newAuthedRequest(http.MethodPost, "/students",
+		`{"name":"Bia","class_id":"`+classA.ID+`","parent_user_id":"parent-1","allergies":["Peanuts"]}`, testAdmin), repos.Students)
+	var student Student
+	decodeBody(t, w, http.StatusCreated, &student)
+	if len(student.Allergies) != 1 || student.Allergies[0] != "peanuts" {
+		t.Fatalf("alergias não normalizadas: %v", student.Allergies)
+	}
+
+	// O responsável informado vira o principal; um segundo responsável só consulta
+	store.addGuardian(student.ID, testOther.ID, GuardianViewer)
+	w = httptest.NewRecorder()
+	handleGetMyStudents(w, newAuthedRequest(http.MethodGet, "/me/students", "", testOther), repos.Students)
+	var mine []Student
+	decodeBody(t, w, http.StatusOK, &mine)
+	if len(mine) != 1 || mine[0].GuardianRole != GuardianViewer {
+		t.Fatalf("alunos do responsável: %+v", mine)
+	}
+
+	w = httptest.NewRecorder()
+	handleTransferStudent(w, newAuthedRequest(http.MethodPost, "/students/"+student.ID+"/transfer",
+		`{"class_id":"`+classA.ID+`"}`, testAdmin), repos.Students, student.ID)
+	decodeBody(t, w, http.StatusConflict, nil)
+
+	w = httptest.NewRecorder()
+	handleTransferStudent(w, newAuthedRequest(http.MethodPost, "/students/"+student.ID+"/transfer",
+		`{"class_id":"`+classB.ID+`","reason":"Mudou de turno"}`, testAdmin), repos.Students, student.ID)
+	decodeBody(t, w, http.StatusCreated, nil)
+
+	w = httptest.NewRecorder()
+	handleGetStudentTransfers(w, newAuthedRequest(http.MethodGet, "/students/"+student.ID+"/transfers", "", testAdmin), repos.Students, student.ID)
+	var transfers []StudentClassTransfer
+	decodeBody(t, w, http.StatusOK, &transfers)
+	if len(transfers) != 1 || *transfers[0].ToClassName != "3º Ano B" || *transfers[0].TransferredBy != testAdmin.ID {
+		t.Fatalf("histórico de turmas: %+v", transfers)
+	}
+
+	// Saldo na carteira impede o arquivamento
+	store.setStudentBalance(student.ID, 1250)
+	w = httptest.NewRecorder()
+	handleArchiveStudent(w, newAuthedRequest(http.MethodDelete, "/students/"+student.ID, "", testAdmin), repos.Students, student.ID)
+	decodeBody(t, w, http.StatusConflict, nil)
+
+	store.setStudentBalance(student.ID, 0)
+	w = httptest.NewRecorder()
+	handleArchiveStudent(w, newAuthedRequest(http.MethodDelete, "/students/"+student.ID, "", testAdmin), repos.Students, student.ID)
+	decodeBody(t, w, http.StatusNoContent, nil)
+
+	w = httptest.NewRecorder()
+	handleUpdateStudent(w, newAuthedRequest(http.MethodPut, "/students/"+student.ID,
+		`{"name":"Beatriz","parent_user_id":"parent-1"}`, testAdmin), repos.Students, student.ID)
+	decodeBody(t, w, http.StatusConflict, nil)
+
+	// Arquivado some da lista padrão e das listas do responsável
+	w = httptest.NewRecorder()
+	handleGetStudents(w, newAuthedRequest(http.MethodGet, "/students", "", testAdmin), repos.Students)
+	var page StudentsPage
+	decodeBody(t, w, http.StatusOK, &page)
+	if page.Total != 0 {
+		t.Fatalf("lista padrão trouxe arquivados: %+v", page)
+	}
+
+	w = httptest.NewRecorder()
+	handleGetStudents(w, newAuthedRequest(http.MethodGet, "/students?status=archived", "", testAdmin), repos.Students)
+	decodeBody(t, w, http.StatusOK, &page)
+	if page.Total != 1 || page.Students[0].ArchivedAt == nil {
+		t.Fatalf("?status=archived: %+v", page)
+	}
+
+	w = httptest.NewRecorder()
+	handleGetStudents(w, newAuthedRequest(http.MethodGet, "/students?status=removidos", "", testAdmin), repos.Students)
+	decodeBody(t, w, http.StatusBadRequest, nil)
+
+	w = httptest.NewRecorder()
+	handleGetMyStudents(w, newAuthedRequest(http.MethodGet, "/me/students", "", testParent), repos.Students)
+	decodeBody(t, w, http.StatusOK, &mine)
+	if len(mine) != 0 {
+		t.Fatalf("aluno arquivado na lista do responsável: %+v", mine)
+	}
+}
+
+func TestUpdateStudentChangesPrimaryGuardian(t *testing.T) {
+	store, repos := newTestRepositories(t)
+	class, _ := repos.Classes.Create("4º Ano", nil)
+	student, _ := repos.Students.Create(Student{Name: "Caio", ClassID: class.ID, ParentUserID: testParent.ID})
+
+	w := httptest.NewRecorder()
+	handleUpdateStudent(w, newAuthedRequest(http.MethodPut, "/students/"+student.ID,
+		`{"name":"Caio","parent_user_id":"nao-existe"}`, testAdmin), repos.Students, student.ID)
+	decodeBody(t, w, http.StatusBadRequest, nil)
+
+	w = httptest.NewRecorder()
+	handleUpdateStudent(w, newAuthedRequest(http.MethodPut, "/students/"+student.ID,
+		`{"name":"Caio Souza","parent_user_id":"parent-2","dietary_requirements":["vegan"]}`, testAdmin), repos.Students, student.ID)
+	var updated Student
+	decodeBody(t, w, http.StatusOK, &updated)
+	if updated.ParentEmail == nil || *updated.ParentEmail != *testOther.Email || updated.ClassName == nil {
+		t.Fatalf("aluno atualizado: %+v", updated)
+	}
+	if roles := store.guardians[student.ID]; roles[testOther.ID] != GuardianPrimary || len(roles) != 1 {
+		t.Fatalf("responsáveis após troca do principal: %v", roles)
+	}
+
+	w = httptest.NewRecorder()
+	handleGetStudentByID(w, newAuthedRequest(http.MethodGet, "/students/nao-existe", "", testAdmin), repos.Students, "nao-existe")
+	decodeBody(t, w, http.StatusNotFound, nil)
+}
+
+func TestCreateOrder(t *testing.T) {
+	store, repos := newTestRepositories(t)
+	class, _ := repos.Classes.Create("4º Ano A", nil)
+	ana, _ := repos.Students.Create(Student{Name: "Ana", ClassID: class.ID, ParentUserID: testParent.ID})
+	stock := 2
+	suco, _ := repos.Menu.CreateItem(MenuItem{Name: "Suco", Price: 600, IsAvailable: true, StockQuantity: &stock})
+	pao, _ := repos.Menu.CreateItem(MenuItem{Name: "Pão de queijo", Price: 450, IsAvailable: true})
+	store.setStudentBalance(ana.ID, 500)
+	store.setCredits(testParent.ID, 1000)
+
+	create := func(profile UserProfile, body string, wantStatus int) Order {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleCreateOrder(w, newAuthedRequest(http.MethodPost, "/orders/", body, profile), repos.Orders)
+		var order Order
+		if wantStatus != http.StatusCreated {
+			decodeBody(t, w, wantStatus, nil)
+			return order
+		}
+		decodeBody(t, w, http.StatusCreated, &order)
+		return order
+	}
+	orderBody := func(menuItemID string, quantity int) string {
+		return fmt.Sprintf(`{"student_id":%q,"items":[{"menu_item_id":%q,"quantity":%d}]}`, ana.ID, menuItemID, quantity)
+	}
+	stockLeft := func() int {
+		t.Helper()
+		item, err := repos.Menu.GetItem(suco.ID)
+		if err != nil || item.StockQuantity == nil {
+			t.Fatalf("item %s: %+v, %v", suco.ID, item, err)
+		}
+		return *item.StockQuantity
+	}
+
+	// R$ 6,00 + 2 x R$ 4,50: a carteira do aluno paga R$ 5,00 e os créditos do responsável o restante
+	order := create(testParent, fmt.Sprintf(`{"student_id":%q,"items":[{"menu_item_id":%q,"quantity":1},{"menu_item_id":%q,"quantity":2}]}`,
+		ana.ID, suco.ID, pao.ID), http.StatusCreated)
+	if order.TotalAmount != 1500 || order.PaidFromStudentWallet != 500 || order.Status != OrderStatusPending ||
+		len(order.Items) != 2 || order.PickupCode == nil || order.ScheduledFor != schoolDate(time.Now()).Format(dateLayout) {
+		t.Fatalf("pedido criado inesperado: %+v", order)
+	}
+	if store.studentBalances[ana.ID] != 0 || store.credits[testParent.ID] != 0 || stockLeft() != 1 {
+		t.Fatalf("saldo do aluno %s, créditos %s, estoque %d", store.studentBalances[ana.ID], store.credits[testParent.ID], stockLeft())
+	}
+	if _, err := repos.Orders.Get(order.ID); err != nil {
+		t.Fatalf("pedido criado não foi gravado: %v", err)
+	}
+
+	// Sem saldo para pagar: nada é gravado nem baixado
+	create(testParent, orderBody(suco.ID, 1), http.StatusPaymentRequired)
+	if stockLeft() != 1 || len(store.orders) != 1 {
+		t.Fatalf("pedido sem pagamento alterou o store: estoque %d, %d pedido(s)", stockLeft(), len(store.orders))
+	}
+
+	// Quem não é responsável pelo aluno, ou só o acompanha, não pede por ele
+	create(testOther, orderBody(pao.ID, 1), http.StatusForbidden)
+	store.addGuardian(ana.ID, testOther.ID, GuardianViewer)
+	store.setCredits(testOther.ID, 1000)
+	create(testOther, orderBody(pao.ID, 1), http.StatusForbidden)
+
+	// Estoque: resta 1 suco
+	store.setCredits(testParent.ID, 5000)
+	create(testParent, orderBody(suco.ID, 2), http.StatusConflict)
+	create(testParent, orderBody("menu-item-x", 1), http.StatusBadRequest)
+	create(testParent, orderBody(suco.ID, 1), http.StatusCreated)
+	if stockLeft() != 0 || store.credits[testParent.ID] != 4400 {
+		t.Fatalf("estoque %d, créditos %s após o último pedido", stockLeft(), store.credits[testParent.ID])
+	}
+}
+
+// lostResponseKeys simula o processo caindo depois de gravar o pedido e antes de gravar a resposta da chave
+type lostResponseKeys struct{ IdempotencyRepository }
+
+func (lostResponseKeys) Complete(userID, key string, status int, body []byte, contentType string) error {
+	return errors.New("conexão perdida")
+}
+
+func TestIdempotentOrderCreation(t *testing.T) {
+	store, repos := newTestRepositories(t)
+	class, _ := repos.Classes.Create("4º Ano A", nil)
+	ana, _ := repos.Students.Create(Student{Name: "Ana", ClassID: class.ID, ParentUserID: testParent.ID})
+	pao, _ := repos.Menu.CreateItem(MenuItem{Name: "Pão de queijo", Price: 450, IsAvailable: true})
+	store.setCredits(testParent.ID, 1000)
+
+	post := func(keys IdempotencyRepository, key, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := newAuthedRequest(http.MethodPost, "/orders/", body, testParent)
+		r.Header.Set(idempotencyKeyHeader, key)
+		w := httptest.NewRecorder()
+		idempotencyMiddleware(keys, http.HandlerFunc(func(ww http.ResponseWriter, rr *http.Request) {
+			handleCreateOrder(ww, rr, repos.Orders)
+		})).ServeHTTP(w, r)
+		return w
+	}
+	orderBody := func(quantity int) string {
+		return fmt.Sprintf(`{"student_id":%q,"items":[{"menu_item_id":%q,"quantity":%d}]}`, ana.ID, pao.ID, quantity)
+	}
+
+	first := post(repos.Idempotency, "chave-1", orderBody(1))
+	firstBody := first.Body.String()
+	decodeBody(t, first, http.StatusCreated, nil)
+
+	// Reenvio com o mesmo corpo: a mesma resposta, sem outro pedido nem outro débito
+	replay := post(repos.Idempotency, "chave-1", orderBody(1))
+	decodeBody(t, replay, http.StatusCreated, nil)
+	if replay.Header().Get(idempotencyReplayedHeader) != "true" || replay.Body.String() != firstBody {
+		t.Fatalf("reenvio devolveu outra resposta: %q (cabeçalho %q), esperado %q",
+			replay.Body.String(), replay.Header().Get(idempotencyReplayedHeader), firstBody)
+	}
+	if len(store.orders) != 1 || store.credits[testParent.ID] != 550 {
+		t.Fatalf("reenvio repetiu o efeito: %d pedido(s), créditos %s", len(store.orders), store.credits[testParent.ID])
+	}
+
+	// A mesma chave com outro corpo: 409, nada é gravado
+	decodeBody(t, post(repos.Idempotency, "chave-1", orderBody(2)), http.StatusConflict, nil)
+	if len(store.orders) != 1 || store.credits[testParent.ID] != 550 {
+		t.Fatalf("chave reutilizada com outro corpo gravou: %d pedido(s), créditos %s", len(store.orders), store.credits[testParent.ID])
+	}
+
+	// Resposta de erro não é gravada: corrigido o corpo, a mesma chave executa de novo
+	decodeBody(t, post(repos.Idempotency, "chave-2", fmt.Sprintf(`{"student_id":%q,"items":[]}`, ana.ID)), http.StatusBadRequest, nil)
+	decodeBody(t, post(repos.Idempotency, "chave-2", orderBody(1)), http.StatusCreated, nil)
+	if len(store.orders) != 2 || store.credits[testParent.ID] != 100 {
+		t.Fatalf("após corrigir o corpo: %d pedido(s), créditos %s", len(store.orders), store.credits[testParent.ID])
+	}
+
+	// O pedido foi gravado mas a resposta da chave não, e o cliente reenvia depois de idempotencyStaleLock:
+	// a chave ficou COMMITTED com o pedido, então não é retomada e o responsável não é debitado de novo
+	store.setCredits(testParent.ID, 1000)
+	decodeBody(t, post(lostResponseKeys{repos.Idempotency}, "chave-3", orderBody(1)), http.StatusCreated, nil)
+	store.ageIdempotencyKey(testParent.ID, "chave-3", 2*idempotencyStaleLock)
+	decodeBody(t, post(repos.Idempotency, "chave-3", orderBody(1)), http.StatusConflict, nil)
+	if len(store.orders) != 3 || store.credits[testParent.ID] != 550 {
+		t.Fatalf("chave abandonada após o pedido foi retomada: %d pedido(s), créditos %s", len(store.orders), store.credits[testParent.ID])
+	}
+}
+
+func TestPaymentWebhook(t *testing.T) {
+	store, repos := newTestRepositories(t)
+	provider := &fakePaymentProvider{webhookSecret: "segredo"}
+	addTopUp := func(id string, amount Money) {
+		chargeID := "fake_" + id
+		store.addTopUp(CreditTopUp{ID: id, UserID: testParent.ID, Amount: amount, Status: TopUpStatusPending,
+			Provider: provider.Name(), ProviderChargeID: &chargeID})
+	}
+	addTopUp("topup-1", 2000)
+	addTopUp("topup-2", 1000)
+	store.setCredits(testParent.ID, 100)
+
+	deliver := func(body, signature string, wantStatus int) map[string]int {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/payments/webhook", strings.NewReader(body))
+		r.Header.Set(webhookSignatureHeader, signature)
+		w := httptest.NewRecorder()
+		handlePaymentWebhook(w, r, repos.TopUps, provider)
+		if wantStatus != http.StatusOK {
+			decodeBody(t, w, wantStatus, nil)
+			return nil
+		}
+		var result map[string]int
+		decodeBody(t, w, http.StatusOK, &result)
+		return result
+	}
+	sign := func(body string) string { return signWebhookBody("segredo", []byte(body)) }
+	body := `{"charge_id":"fake_topup-1","amount":20}`
+
+	// Assinatura ausente, de outro segredo ou de outro corpo: 401, nada é creditado
+	deliver(body, "", http.StatusUnauthorized)
+	deliver(body, signWebhookBody("outro", []byte(body)), http.StatusUnauthorized)
+	deliver(body, sign(`{"charge_id":"fake_topup-1","amount":2000}`), http.StatusUnauthorized)
+	if store.credits[testParent.ID] != 100 || store.topUps["topup-1"].Status != TopUpStatusPending {
+		t.Fatalf("webhook com assinatura inválida alterou o store: créditos %s, recarga %s",
+			store.credits[testParent.ID], store.topUps["topup-1"].Status)
+	}
+
+	if result := deliver(body, sign(body), http.StatusOK); result["credited"] != 1 {
+		t.Fatalf("confirmação não creditou: %v", result)
+	}
+	if store.credits[testParent.ID] != 2100 || store.topUps["topup-1"].Status != TopUpStatusPaid {
+		t.Fatalf("após a confirmação: créditos %s, recarga %s", store.credits[testParent.ID], store.topUps["topup-1"].Status)
+	}
+
+	// Reentrega do mesmo webhook: 200 (o provedor para de reenviar), sem creditar de novo
+	if result := deliver(body, sign(body), http.StatusOK); result["credited"] != 0 {
+		t.Fatalf("reentrega creditou de novo: %v", result)
+	}
+	if store.credits[testParent.ID] != 2100 {
+		t.Fatalf("reentrega alterou os créditos: %s", store.credits[testParent.ID])
+	}
+
+	// Valor pago diferente do da recarga: FAILED, sem crédito
+	mismatch := `{"charge_id":"fake_topup-2","amount":5}`
+	if result := deliver(mismatch, sign(mismatch), http.StatusOK); result["credited"] != 0 {
+		t.Fatalf("pagamento divergente creditou: %v", result)
+	}
+	if store.credits[testParent.ID] != 2100 || store.topUps["topup-2"].Status != TopUpStatusFailed {
+		t.Fatalf("após pagamento divergente: créditos %s, recarga %s", store.credits[testParent.ID], store.topUps["topup-2"].Status)
+	}
+
+	// Cobrança desconhecida: nada a creditar, e o provedor não precisa reenviar
+	unknown := `{"charge_id":"fake_topup-x","amount":20}`
+	if result := deliver(unknown, sign(unknown), http.StatusOK); result["credited"] != 0 {
+		t.Fatalf("cobrança desconhecida creditou: %v", result)
+	}
+}
+
+func TestOrderReadHandlers(t *testing.T) {
+	store, repos := newTestRepositories(t)
+	class, _ := repos.Classes.Create("1º Ano A", nil)
+	ana, _ := repos.Students.Create(Student{Name: "Ana", ClassID: class.ID, ParentUserID: testParent.ID})
+	now := time.Now()
+	code := "4821"
+	qr := pickupQRPayload("order-1", code)
+	store.addOrder(Order{ID: "order-1", UserID: testParent.ID, StudentID: &ana.ID, OrderDate: now, PickupCode: &code, PickupQRPayload: &qr,
+		TotalAmount: 900, Status: "PENDING", Items: []OrderItem{{ID: "oi-1", OrderID: "order-1", MenuItemID: "m-1", Quantity: 2, PriceAtPurchase: 450}}})
+	store.addOrder(Order{ID: "order-0", UserID: testParent.ID, StudentID: &ana.ID, OrderDate: now.Add(-time.Hour), TotalAmount: 300, Status: "COMPLETED"})
+
+	w := httptest.NewRecorder()
+	handleGetMyOrders(w, newAuthedRequest(http.MethodGet, "/me/orders", "", testParent), repos.Orders, repos.Students)
+	var mine MyOrdersPage
+	decodeBody(t, w, http.StatusOK, &mine)
+	if len(mine.Orders) != 2 || mine.Orders[0].ID != "order-1" || len(mine.Orders[0].Items) != 1 {
+		t.Fatalf("pedidos do responsável: %+v", mine.Orders)
+	}
+
+	// O dono vê o código de retirada; a equipe vê o pedido sem ele; outro responsável não vê
+	w = httptest.NewRecorder()
+	handleGetOrderByID(w, newAuthedRequest(http.MethodGet, "/orders/order-1", "", testParent), repos.Orders, "order-1")
+	var order Order
+	decodeBody(t, w, http.StatusOK, &order)
+	if order.PickupCode == nil || *order.PickupCode != code {
+		t.Fatalf("dono sem código de retirada: %+v", order)
+	}
+
+	w = httptest.NewRecorder()
+	handleGetOrderByID(w, newAuthedRequest(http.MethodGet, "/orders/order-1", "", testStaff), repos.Orders, "order-1")
+	order = Order{}
+	decodeBody(t, w, http.StatusOK, &order)
+	if order.PickupCode != nil || order.PickupQRPayload != nil {
+		t.Fatalf("equipe recebeu o código de retirada: %+v", order)
+	}
+
+	w = httptest.NewRecorder()
+	handleGetOrderByID(w, newAuthedRequest(http.MethodGet, "/orders/order-1", "", testOther), repos.Orders, "order-1")
+	decodeBody(t, w, http.StatusForbidden, nil)
+
+	w = httptest.NewRecorder()
+	handleGetOrderByID(w, newAuthedRequest(http.MethodGet, "/orders/nao-existe", "", testParent), repos.Orders, "nao-existe")
+	decodeBody(t, w, http.StatusNotFound, nil)
+}
+
+func TestOrderStatusAndCancel(t *testing.T) {
+	store, repos := newTestRepositories(t)
+	class, _ := repos.Classes.Create("2º Ano A", nil)
+	ana, _ := repos.Students.Create(Student{Name: "Ana", ClassID: class.ID, ParentUserID: testParent.ID})
+	stock := 5
+	pao, _ := repos.Menu.CreateItem(MenuItem{Name: "Pão de queijo", Price: 450, IsAvailable: true, StockQuantity: &stock})
+	store.setStudentBalance(ana.ID, 500)
+	store.setCredits(testParent.ID, 1000)
+
+	// 2 x R$ 4,50: R$ 5,00 da carteira da Ana e R$ 4,00 dos créditos do responsável
+	newOrder := func() *Order {
+		t.Helper()
+		order, err := repos.Orders.Create(testParent.ID, CreateOrderRequest{StudentID: ana.ID, Items: []OrderItemRequest{{MenuItemID: pao.ID, Quantity: 2}}})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		return order
+	}
+	update := func(profile UserProfile, orderID, body string, wantStatus int) Order {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleUpdateOrderStatus(w, newAuthedRequest(http.MethodPut, "/orders/"+orderID, body, profile), repos.Orders, orderID)
+		var order Order
+		if wantStatus != http.StatusOK {
+			decodeBody(t, w, wantStatus, nil)
+			return order
+		}
+		decodeBody(t, w, http.StatusOK, &order)
+		return order
+	}
+	cancel := func(profile UserProfile, orderID, body string, wantStatus int) Order {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleCancelOrder(w, newAuthedRequest(http.MethodPost, "/orders/"+orderID+"/cancel", body, profile), repos.Orders, orderID)
+		var order Order
+		if wantStatus != http.StatusOK {
+			decodeBody(t, w, wantStatus, nil)
+			return order
+		}
+		decodeBody(t, w, http.StatusOK, &order)
+		return order
+	}
+	history := func(profile UserProfile, orderID string, wantStatus int) []OrderStatusChange {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleGetOrderHistory(w, newAuthedRequest(http.MethodGet, "/orders/"+orderID+"/history", "", profile), repos.Orders, orderID)
+		var changes []OrderStatusChange
+		if wantStatus != http.StatusOK {
+			decodeBody(t, w, wantStatus, nil)
+			return changes
+		}
+		decodeBody(t, w, http.StatusOK, &changes)
+		return changes
+	}
+	balances := func() string {
+		item, _ := repos.Menu.GetItem(pao.ID)
+		return fmt.Sprintf("carteira %s, créditos %s, estoque %d", store.studentBalances[ana.ID], store.credits[testParent.ID], *item.StockQuantity)
+	}
+
+	order := newOrder()
+	if item, _ := repos.Menu.GetItem(pao.ID); store.studentBalances[ana.ID] != 0 || store.credits[testParent.ID] != 600 || *item.StockQuantity != 3 {
+		t.Fatalf("após o pedido: %s", balances())
+	}
+
+	if updated := update(testStaff, order.ID, `{"status":"preparing"}`, http.StatusOK); updated.Status != OrderStatusPreparing || len(updated.Items) != 1 {
+		t.Fatalf("pedido atualizado inesperado: %+v", updated)
+	}
+	update(testStaff, order.ID, `{"status":"COMPLETED"}`, http.StatusConflict) // PREPARING → COMPLETED pula READY
+	update(testStaff, order.ID, `{"status":"ENTREGUE"}`, http.StatusBadRequest)
+	update(testStaff, "nao-existe", `{"status":"READY"}`, http.StatusNotFound)
+
+	// O histórico é do dono do pedido e da equipe
+	changes := history(testParent, order.ID, http.StatusOK)
+	if len(changes) != 2 || changes[0].FromStatus != nil || changes[0].ToStatus != OrderStatusPending ||
+		*changes[1].FromStatus != OrderStatusPending || changes[1].ToStatus != OrderStatusPreparing || *changes[1].ChangedBy != testStaff.ID {
+		t.Fatalf("histórico inesperado: %+v", changes)
+	}
+	history(testStaff, order.ID, http.StatusOK)
+	history(testOther, order.ID, http.StatusForbidden)
+	history(testParent, "nao-existe", http.StatusNotFound)
+
+	// O responsável só cancela enquanto PENDING, e só os próprios pedidos; a equipe até READY
+	cancel(testParent, order.ID, "", http.StatusConflict)
+	cancel(testOther, order.ID, "", http.StatusForbidden)
+	canceled := cancel(testStaff, order.ID, `{"reason":"  Acabou o pão  "}`, http.StatusOK)
+	if canceled.Status != OrderStatusCanceled || canceled.CancelReason == nil || *canceled.CancelReason != "Acabou o pão" ||
+		canceled.CanceledBy == nil || *canceled.CanceledBy != testStaff.ID || len(canceled.Items) != 1 {
+		t.Fatalf("pedido cancelado inesperado: %+v", canceled)
+	}
+	// O estorno volta para onde o pagamento saiu, e os itens voltam ao estoque
+	if store.studentBalances[ana.ID] != 500 || store.credits[testParent.ID] != 1000 {
+		t.Fatalf("estorno errado: %s", balances())
+	}
+	if item, _ := repos.Menu.GetItem(pao.ID); *item.StockQuantity != 5 {
+		t.Fatalf("estoque não devolvido: %s", balances())
+	}
+	cancel(testStaff, order.ID, "", http.StatusConflict)
+	cancel(testStaff, "nao-existe", "", http.StatusNotFound)
+
+	// PUT com CANCELED usa o mesmo fluxo do cancelamento, com estorno
+	order = newOrder()
+	cancel(testParent, order.ID, "", http.StatusOK)
+	order = newOrder()
+	if canceled := update(testStaff, order.ID, `{"status":"CANCELED"}`, http.StatusOK); canceled.Status != OrderStatusCanceled {
+		t.Fatalf("PUT CANCELED não cancelou: %+v", canceled)
+	}
+	if store.studentBalances[ana.ID] != 500 || store.credits[testParent.ID] != 1000 {
+		t.Fatalf("estorno errado após PUT CANCELED: %s", balances())
+	}
+	if changes := history(testParent, order.ID, http.StatusOK); len(changes) != 2 || changes[1].ToStatus != OrderStatusCanceled {
+		t.Fatalf("histórico do pedido cancelado por PUT: %+v", changes)
+	}
+}
+
+func TestOrderPickupAndKitchen(t *testing.T) {
+	store, repos := newTestRepositories(t)
+	class, _ := repos.Classes.Create("3º Ano A", nil)
+	ana, _ := repos.Students.Create(Student{Name: "Ana", ClassID: class.ID, ParentUserID: testParent.ID})
+	pao, _ := repos.Menu.CreateItem(MenuItem{Name: "Pão de queijo", Price: 450, IsAvailable: true})
+	suco, _ := repos.Menu.CreateItem(MenuItem{Name: "Suco", Price: 600, IsAvailable: true})
+	store.setCredits(testParent.ID, 5000)
+
+	order, err := repos.Orders.Create(testParent.ID, CreateOrderRequest{StudentID: ana.ID,
+		Items: []OrderItemRequest{{MenuItemID: pao.ID, Quantity: 2}, {MenuItemID: suco.ID, Quantity: 1}}})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	other, err := repos.Orders.Create(testParent.ID, CreateOrderRequest{StudentID: ana.ID, Items: []OrderItemRequest{{MenuItemID: pao.ID, Quantity: 1}}})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	tomorrowCode := "TMRW23"
+	tomorrow := schoolToday().AddDate(0, 0, 1).Format(dateLayout)
+	store.addOrder(Order{ID: "order-amanha", UserID: testParent.ID, StudentID: &ana.ID, ScheduledFor: tomorrow,
+		PickupCode: &tomorrowCode, TotalAmount: 450, Status: OrderStatusReady})
+
+	kitchen := func(query string, wantStatus int) KitchenOrderList {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleGetKitchenOrders(w, newAuthedRequest(http.MethodGet, "/orders/kitchen"+query, "", testStaff), repos.Orders)
+		var list KitchenOrderList
+		if wantStatus != http.StatusOK {
+			decodeBody(t, w, wantStatus, nil)
+			return list
+		}
+		decodeBody(t, w, http.StatusOK, &list)
+		return list
+	}
+	pickup := func(body string, wantStatus int) KitchenOrder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleOrderPickup(w, newAuthedRequest(http.MethodPost, "/orders/pickup", body, testStaff), repos.Orders)
+		var picked KitchenOrder
+		if wantStatus != http.StatusOK {
+			decodeBody(t, w, wantStatus, nil)
+			return picked
+		}
+		decodeBody(t, w, http.StatusOK, &picked)
+		return picked
+	}
+
+	// Lista da cozinha de hoje, com os totais por item para a produção
+	list := kitchen("", http.StatusOK)
+	if list.Date != schoolToday().Format(dateLayout) || len(list.Orders) != 2 || list.Orders[0].ID != order.ID ||
+		list.Orders[0].StudentName == nil || *list.Orders[0].StudentName != "Ana" || list.Orders[0].PickupCode != nil {
+		t.Fatalf("lista da cozinha inesperada: %+v", list)
+	}
+	if len(list.ItemTotals) != 2 || list.ItemTotals[0].MenuItemName != "Pão de queijo" || list.ItemTotals[0].Quantity != 3 || list.ItemTotals[1].Quantity != 1 {
+		t.Fatalf("totais por item inesperados: %+v", list.ItemTotals)
+	}
+	if list := kitchen("?slot=none", http.StatusOK); len(list.Orders) != 2 || list.Slot == nil || *list.Slot != "none" {
+		t.Fatalf("pedidos imediatos: %+v", list)
+	}
+	if list := kitchen("?slot=almoco", http.StatusOK); len(list.Orders) != 0 {
+		t.Fatalf("horário sem pedidos listou: %+v", list.Orders)
+	}
+	if list := kitchen("?date="+tomorrow, http.StatusOK); len(list.Orders) != 1 || list.Orders[0].ID != "order-amanha" {
+		t.Fatalf("pedidos de amanhã: %+v", list.Orders)
+	}
+	kitchen("?date=amanha", http.StatusBadRequest)
+
+	// Só pedidos READY de hoje são retirados, pelo código digitado ou pelo QR
+	pickup(fmt.Sprintf(`{"code":%q}`, *order.PickupCode), http.StatusConflict)
+	for _, status := range []string{OrderStatusPreparing, OrderStatusReady} {
+		if _, err := repos.Orders.UpdateStatus(order.ID, status, &testStaff); err != nil {
+			t.Fatalf("UpdateStatus %s: %v", status, err)
+		}
+	}
+	pickup(`{"code":"ZZZZZZ"}`, http.StatusNotFound)
+	pickup(fmt.Sprintf(`{"qr_payload":%q}`, pickupQRPayload(other.ID, *order.PickupCode)), http.StatusNotFound)
+	pickup(`{"qr_payload":"outro-formato"}`, http.StatusBadRequest)
+	pickup(fmt.Sprintf(`{"code":%q}`, tomorrowCode), http.StatusConflict)
+
+	picked := pickup(fmt.Sprintf(`{"qr_payload":%q}`, *order.PickupQRPayload), http.StatusOK)
+	if picked.ID != order.ID || picked.Status != OrderStatusCompleted || picked.StudentName == nil || *picked.StudentName != "Ana" ||
+		picked.PickupCode != nil || len(picked.Items) != 2 {
+		t.Fatalf("pedido retirado inesperado: %+v", picked)
+	}
+	changes, _ := repos.Orders.History(order.ID)
+	if last := changes[len(changes)-1]; last.ToStatus != OrderStatusCompleted || *last.ChangedBy != testStaff.ID {
+		t.Fatalf("retirada fora do histórico: %+v", changes)
+	}
+
+	// Já retirado: o código não está mais em aberto
+	pickup(fmt.Sprintf(`{"code":%q}`, strings.ToLower(*order.PickupCode)), http.StatusNotFound)
+	pickup(fmt.Sprintf(`{"qr_payload":%q}`, *order.PickupQRPayload), http.StatusConflict)
+}
+
+func TestAdminOrderListing(t *testing.T) {
+	store, repos := newTestRepositories(t)
+	classA, _ := repos.Classes.Create("1º Ano A", nil)
+	classB, _ := repos.Classes.Create("1º Ano B", nil)
+	ana, _ := repos.Students.Create(Student{Name: "Ana", ClassID: classA.ID, ParentUserID: testParent.ID})
+	beto, _ := repos.Students.Create(Student{Name: "Beto", ClassID: classB.ID, ParentUserID: testOther.ID})
+
+	base := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	code := "1234"
+	addOrder := func(id string, student *Student, hoursAgo int, total Money, status, menuItemID string) {
+		store.addOrder(Order{ID: id, UserID: student.ParentUserID, StudentID: &student.ID, OrderDate: base.Add(-time.Duration(hoursAgo) * time.Hour),
+			ScheduledFor: base.Format(dateLayout), PickupCode: &code, TotalAmount: total, Status: status,
+			Items: []OrderItem{{ID: "oi-" + id, OrderID: id, MenuItemID: menuItemID, Quantity: 1, PriceAtPurchase: total}}})
+	}
+	addOrder("order-1", ana, 1, 575, OrderStatusPending, "m-suco")
+	addOrder("order-2", beto, 2, 1200, OrderStatusReady, "m-pao")
+	addOrder("order-3", ana, 30, 300, OrderStatusCompleted, "m-pao")
+	addOrder("order-4", beto, 50, 990, OrderStatusCanceled, "m-suco")
+	addOrder("order-5", ana, 51, 1200, OrderStatusPreparing, "m-pao")
+
+	list := func(profile UserProfile, query string, wantStatus int) OrdersPage {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleAdminGetOrders(w, newAuthedRequest(http.MethodGet, "/orders/?"+query, "", profile), repos.Orders)
+		var page OrdersPage
+		if wantStatus != http.StatusOK {
+			decodeBody(t, w, wantStatus, nil)
+			return page
+		}
+		decodeBody(t, w, http.StatusOK, &page)
+		return page
+	}
+	ids := func(orders []Order) string {
+		var out []string
+		for _, order := range orders {
+			out = append(out, order.ID)
+		}
+		return strings.Join(out, ",")
+	}
+
+	// Percorre todas as páginas pelo cursor, do pedido mais recente ao mais antigo
+	var seen []Order
+	query := "limit=2"
+	for pages := 0; ; pages++ {
+		page := list(testAdmin, query, http.StatusOK)
+		if page.Total != 5 || pages > 3 {
+			t.Fatalf("página inesperada: %+v", page)
+		}
+		seen = append(seen, page.Orders...)
+		if page.NextCursor == nil {
+			break
+		}
+		query = "limit=2&cursor=" + *page.NextCursor
+	}
+	if got := ids(seen); got != "order-1,order-2,order-3,order-4,order-5" {
+		t.Fatalf("ordem das páginas: %s", got)
+	}
+	if seen[0].PickupCode != nil || len(seen[0].Items) != 1 {
+		t.Fatalf("pedido da listagem: %+v", seen[0])
+	}
+
+	// Ordenação por valor, com desempate pelo ID também entre páginas
+	page := list(testAdmin, "sort=-total_amount&limit=1", http.StatusOK)
+	if ids(page.Orders) != "order-5" || page.NextCursor == nil {
+		t.Fatalf("sort=-total_amount: %+v", page)
+	}
+	page = list(testAdmin, "sort=-total_amount&limit=2&cursor="+*page.NextCursor, http.StatusOK)
+	if ids(page.Orders) != "order-2,order-4" {
+		t.Fatalf("sort=-total_amount, segunda página: %s", ids(page.Orders))
+	}
+	list(testAdmin, "sort=total_amount&cursor="+*page.NextCursor, http.StatusBadRequest)
+	list(testAdmin, "sort=nome", http.StatusBadRequest)
+	list(testAdmin, "cursor=lixo", http.StatusBadRequest)
+
+	filters := map[string]string{
+		"class_id=" + classA.ID:                       "order-1,order-3,order-5",
+		"student_id=" + beto.ID:                       "order-2,order-4",
+		"guardian_user_id=" + testOther.ID:            "order-2,order-4",
+		"menu_item_id=m-suco":                         "order-1,order-4",
+		"status=ready,canceled":                       "order-2,order-4",
+		"from=2026-03-01&to=2026-03-01":               "order-3",
+		"user_id=" + testParent.ID + "&to=2026-02-28": "order-5",
+	}
+	for filter, want := range filters {
+		if got := ids(list(testAdmin, filter, http.StatusOK).Orders); got != want {
+			t.Errorf("?%s = %s, esperado %s", filter, got, want)
+		}
+	}
+	list(testAdmin, "status=ENTREGUE", http.StatusBadRequest)
+
+	// A equipe só vê pedidos em aberto
+	if got := ids(list(testStaff, "", http.StatusOK).Orders); got != "order-1,order-2,order-5" {
+		t.Fatalf("pedidos em aberto para a equipe: %s", got)
+	}
+	list(testStaff, "status=COMPLETED", http.StatusForbidden)
+	list(testStaff, "status=ready,canceled", http.StatusForbidden)
+	if got := ids(list(testStaff, "status=ready", http.StatusOK).Orders); got != "order-2" {
+		t.Fatalf("?status=ready para a equipe: %s", got)
+	}
+}
+
+func TestMyOrdersHistory(t *testing.T) {
+	store, repos := newTestRepositories(t)
+	class, _ := repos.Classes.Create("2º Ano A", nil)
+	ana, _ := repos.Students.Create(Student{Name: "Ana", ClassID: class.ID, ParentUserID: testParent.ID})
+	caio, _ := repos.Students.Create(Student{Name: "Caio", ClassID: class.ID, ParentUserID: testParent.ID})
+	outro, _ := repos.Students.Create(Student{Name: "Duda", ClassID: class.ID, ParentUserID: testOther.ID})
+
+	base := time.Date(2026, 5, 15, 12, 0, 0, 0, time.UTC)
+	addOrder := func(id, userID string, student *Student, daysAgo int, total Money, status string) {
+		store.addOrder(Order{ID: id, UserID: userID, StudentID: &student.ID, OrderDate: base.AddDate(0, 0, -daysAgo),
+			ScheduledFor: base.AddDate(0, 0, -daysAgo).Format(dateLayout), TotalAmount: total, Status: status})
+	}
+	addOrder("order-1", testParent.ID, ana, 0, Money(575).Times(3), OrderStatusPending)
+	addOrder("order-2", testParent.ID, caio, 1, 1010, OrderStatusCompleted)
+	addOrder("order-3", testParent.ID, ana, 2, 30, OrderStatusCompleted)
+	addOrder("order-4", testParent.ID, caio, 3, 2000, OrderStatusCanceled)
+	addOrder("order-5", testParent.ID, ana, 40, 800, OrderStatusCompleted)
+	addOrder("order-6", testOther.ID, outro, 0, 999, OrderStatusPending)
+
+	get := func(query string, wantStatus int) MyOrdersPage {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleGetMyOrders(w, newAuthedRequest(http.MethodGet, "/me/orders?"+query, "", testParent), repos.Orders, repos.Students)
+		var page MyOrdersPage
+		if wantStatus != http.StatusOK {
+			decodeBody(t, w, wantStatus, nil)
+			return page
+		}
+		decodeBody(t, w, http.StatusOK, &page)
+		return page
+	}
+
+	// Período de maio: o pedido cancelado aparece na lista mas não soma no gasto
+	page := get("from=2026-05-01&to=2026-05-31&limit=3", http.StatusOK)
+	if page.Total != 4 || len(page.Orders) != 3 || page.NextCursor == nil || *page.From != "2026-05-01" {
+		t.Fatalf("primeira página: %+v", page)
+	}
+	if page.Orders[0].StudentName == nil || *page.Orders[0].StudentName != "Ana" {
+		t.Fatalf("pedido sem nome do aluno: %+v", page.Orders[0])
+	}
+	if page.Spending.Total != 2765 || page.Spending.OrderCount != 3 || len(page.Spending.ByStudent) != 2 {
+		t.Fatalf("gasto do período: %+v", page.Spending)
+	}
+	if byAna := page.Spending.ByStudent[0]; *byAna.StudentName != "Ana" || byAna.Total.String() != "17.55" || byAna.OrderCount != 2 {
+		t.Fatalf("gasto da Ana: %+v", byAna)
+	}
+
+	next := get("from=2026-05-01&to=2026-05-31&limit=3&cursor="+*page.NextCursor, http.StatusOK)
+	if len(next.Orders) != 1 || next.Orders[0].ID != "order-4" || next.NextCursor != nil || next.Spending.Total != 2765 {
+		t.Fatalf("segunda página: %+v", next)
+	}
+
+	// Por aluno e status; alunos de outros responsáveis nunca aparecem, e pedi-los é 404
+	page = get("student_id="+caio.ID+"&status=COMPLETED", http.StatusOK)
+	if page.Total != 1 || page.Orders[0].ID != "order-2" || page.Spending.Total != 1010 {
+		t.Fatalf("?student_id=&status=: %+v", page)
+	}
+	if got := get("", http.StatusOK); got.Total != 5 {
+		t.Fatalf("histórico completo: %+v", got)
+	}
+	get("student_id="+outro.ID, http.StatusNotFound)
+
+	get("from=2026-05-31&to=2026-05-01", http.StatusBadRequest)
+	get("status=PAGO", http.StatusBadRequest)
+}
+
+// Os responsáveis de um aluno veem os pedidos uns dos outros para ele, sem o código de retirada de quem não pediu
+func TestMyOrdersSharedBetweenGuardians(t *testing.T) {
+	store, repos := newTestRepositories(t)
+	class, _ := repos.Classes.Create("3º Ano A", nil)
+	ana, _ := repos.Students.Create(Student{Name: "Ana", ClassID: class.ID, ParentUserID: testParent.ID})
+	duda, _ := repos.Students.Create(Student{Name: "Duda", ClassID: class.ID, ParentUserID: testOther.ID})
+	store.addGuardian(ana.ID, testOther.ID, GuardianSecondary)
+
+	may := time.Date(2026, 5, 20, 12, 0, 0, 0, schoolLocation)
+	addOrder := func(id, userID string, student *Student, scheduledFor string, total Money) {
+		code := "code-" + id
+		store.addOrder(Order{ID: id, UserID: userID, StudentID: &student.ID, OrderDate: may, ScheduledFor: scheduledFor,
+			PickupCode: &code, TotalAmount: total, Status: OrderStatusPending})
+	}
+	addOrder("order-mae", testParent.ID, ana, "2026-05-20", 500)
+	// Encomenda feita em maio para ser servida em junho
+	addOrder("order-pai", testOther.ID, ana, "2026-06-02", 700)
+	addOrder("order-duda", testOther.ID, duda, "2026-05-20", 900)
+
+	get := func(profile UserProfile, query string, wantStatus int) MyOrdersPage {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleGetMyOrders(w, newAuthedRequest(http.MethodGet, "/me/orders?"+query, "", profile), repos.Orders, repos.Students)
+		var page MyOrdersPage
+		if wantStatus != http.StatusOK {
+			decodeBody(t, w, wantStatus, nil)
+			return page
+		}
+		decodeBody(t, w, http.StatusOK, &page)
+		return page
+	}
+
+	page := get(testParent, "student_id="+ana.ID, http.StatusOK)
+	if page.Total != 2 || page.Spending.Total != 1200 {
+		t.Fatalf("pedidos da Ana para a mãe: %+v", page)
+	}
+	for _, order := range page.Orders {
+		if hasCode := order.PickupCode != nil; hasCode != (order.UserID == testParent.ID) {
+			t.Fatalf("código de retirada do pedido %s para a mãe: %v", order.ID, order.PickupCode)
+		}
+	}
+	if page := get(testOther, "", http.StatusOK); page.Total != 3 || page.Spending.Total != 2100 {
+		t.Fatalf("pedidos para o pai: %+v", page)
+	}
+	get(testParent, "student_id="+duda.ID, http.StatusNotFound)
+
+	// Gasto em maio pela data do pedido e pelo dia de serviço, que é o que conta nos limites do aluno
+	page = get(testParent, "from=2026-05-01&to=2026-05-31", http.StatusOK)
+	if page.Spending.Total != 1200 || page.ScheduledSpending.Total != 500 || page.ScheduledSpending.OrderCount != 1 {
+		t.Fatalf("gasto de maio: %+v / %+v", page.Spending, page.ScheduledSpending)
+	}
+
+	// Sem o vínculo com a Ana, o pai continua vendo os pedidos que ele fez para ela, mas não os da mãe;
+	// pedidos sem aluno (aluno removido) continuam no histórico de quem pediu
+	delete(store.guardians[ana.ID], testOther.ID) // Como DELETE /me/students/{id}/guardians/{user_id}
+	store.addOrder(Order{ID: "order-sem-aluno", UserID: testOther.ID, OrderDate: may, ScheduledFor: "2026-05-20",
+		TotalAmount: 100, Status: OrderStatusCompleted})
+	page = get(testOther, "", http.StatusOK)
+	if got := orderIDs(page.Orders); page.Total != 3 || !strings.Contains(got, "order-pai") || !strings.Contains(got, "order-sem-aluno") ||
+		strings.Contains(got, "order-mae") {
+		t.Fatalf("pedidos do pai sem vínculo com a Ana: %s", got)
+	}
+	if page := get(testOther, "student_id="+ana.ID, http.StatusOK); page.Total != 1 || page.Orders[0].ID != "order-pai" {
+		t.Fatalf("pedidos do pai para a Ana sem vínculo: %+v", page)
+	}
+	get(testOther, "student_id=student-x", http.StatusNotFound)
+}
+
+func orderIDs(orders []Order) string {
+	ids := make([]string, len(orders))
+	for i, order := range orders {
+		ids[i] = order.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+// ?from=&to= são dias no fuso da escola: um pedido às 22h do último dia entra no período, e um às 22h da véspera não
+func TestDateRangeUsesSchoolTimeZone(t *testing.T) {
+	previous := schoolLocation
+	schoolLocation = time.FixedZone("BRT", -3*60*60)
+	t.Cleanup(func() { schoolLocation = previous })
+
+	store, repos := newTestRepositories(t)
+	class, _ := repos.Classes.Create("4º Ano A", nil)
+	ana, _ := repos.Students.Create(Student{Name: "Ana", ClassID: class.ID, ParentUserID: testParent.ID})
+	for id, at := range map[string]time.Time{
+		"order-last-evening":   time.Date(2026, 5, 31, 22, 0, 0, 0, schoolLocation),
+		"order-before-evening": time.Date(2026, 4, 30, 22, 0, 0, 0, schoolLocation),
+	} {
+		store.addOrder(Order{ID: id, UserID: testParent.ID, StudentID: &ana.ID, OrderDate: at,
+			ScheduledFor: at.Format(dateLayout), TotalAmount: 500, Status: OrderStatusCompleted})
+	}
+
+	w := httptest.NewRecorder()
+	handleGetMyOrders(w, newAuthedRequest(http.MethodGet, "/me/orders?from=2026-05-01&to=2026-05-31", "", testParent), repos.Orders, repos.Students)
+	var page MyOrdersPage
+	decodeBody(t, w, http.StatusOK, &page)
+	if page.Total != 1 || page.Orders[0].ID != "order-last-evening" || page.Spending.Total != 500 {
+		t.Fatalf("pedidos de maio no fuso da escola: %+v", page)
+	}
+}
+
+func TestAuthorizeLoadsProfileFromRepository(t *testing.T) {
+	_, repos := newTestRepositories(t)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if profileFromContext(r.Context()) == nil {
+			t.Error("perfil não ficou no contexto")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := authorize(repos.Users, PermClassesManage, permissionMatrix[PermClassesManage])(ok)
+
+	cases := []struct {
+		userID     string
+		wantStatus int
+	}{
+		{testAdmin.ID, http.StatusNoContent},
+		{testParent.ID, http.StatusForbidden},
+		{"desconhecido", http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/classes", nil)
+		r = r.WithContext(context.WithValue(r.Context(), userContextKey, tc.userID))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.wantStatus {
+			t.Errorf("usuário %s: status = %d, esperado %d", tc.userID, w.Code, tc.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	idempotencyKeyMaxLength   = 255
+	idempotencyKeyTTL         = 24 * time.Hour // Janela em que um reenvio devolve a resposta original
+	idempotencyStaleLock      = time.Minute    // Requisição "em andamento" há mais tempo que isso foi abandonada
+	idempotencyCleanupEvery   = time.Hour      // Intervalo da limpeza das chaves expiradas
+	idempotencyMaxBodyBytes   = 1 << 20        // Corpo máximo lido para calcular a impressão digital
+	// Status de uma chave: reservada, efeito gravado (na transação do pedido/recarga) e resposta gravada
+	idempotencyStatusInProgress = "IN_PROGRESS"
+	idempotencyStatusCommitted  = "COMMITTED"
+	idempotencyStatusDone       = "COMPLETED"
+)
+
+// idempotencyRecorder repassa a resposta ao cliente e guarda uma cópia para gravar com a chave
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+// idempotencyClaim identifica a Idempotency-Key reservada para a requisição em andamento. O handler a repassa
+// para a transação que grava o efeito (pedido, recarga), que marca a chave como COMMITTED junto com ele.
+type idempotencyClaim struct {
+	UserID string
+	Key    string
+}
+
+// idempotencyClaimContextKey guarda no contexto o *idempotencyClaim posto por idempotencyMiddleware
+const idempotencyClaimContextKey = contextKey("idempotencyClaim")
+
+// idempotencyClaimFromContext retorna a chave reservada para a requisição, ou nil se ela veio sem Idempotency-Key
+func idempotencyClaimFromContext(ctx context.Context) *idempotencyClaim {
+	claim, _ := ctx.Value(idempotencyClaimContextKey).(*idempotencyClaim)
+	return claim
+}
+
+// markIdempotencyKeyCommitted marca, na transação do efeito, que a requisição da chave foi aplicada.
+// Assim, se a gravação da resposta falhar ou o processo cair antes dela, a chave não é retomada como
+// abandonada e um reenvio não repete o efeito. Sem chave (claim nil), não faz nada.
+func markIdempotencyKeyCommitted(tx *sql.Tx, claim *idempotencyClaim) error {
+	if claim == nil {
+		return nil
+	}
+	_, err := tx.Exec(`
+		UPDATE public.idempotency_keys SET status = $3
+		WHERE user_id = $1 AND key = $2 AND status = $4;`,
+		claim.UserID, claim.Key, idempotencyStatusCommitted, idempotencyStatusInProgress)
+	if err != nil {
+		return fmt.Errorf("erro ao marcar Idempotency-Key %q como aplicada: %w", claim.Key, err)
+	}
+	return nil
+}
+
+// idempotencyMiddleware torna a rota segura para retentativas com o cabeçalho Idempotency-Key.
+// Deve envolver o handler já autenticado (a chave é por usuário). Sem o cabeçalho, nada muda.
+//   - primeira requisição com a chave: executa e, se a resposta for 2xx, grava status e corpo;
+//     respostas de erro não são gravadas (nada foi criado), então um reenvio executa de novo
+//   - reenvio com o mesmo corpo: devolve a resposta gravada, com Idempotent-Replayed: true
+//   - reenvio com outro corpo, ou enquanto a original ainda está em andamento: 409
+//
+// O handler deve passar idempotencyClaimFromContext para a transação do efeito (ver markIdempotencyKeyCommitted).
+func idempotencyMiddleware(keys IdempotencyRepository, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get(idempotencyKeyHeader))
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > idempotencyKeyMaxLength {
+			http.Error(w, "Idempotency-Key muito longa (máximo 255 caracteres).", http.StatusBadRequest)
+			return
+		}
+		userID := r.Context().Value(userContextKey).(string)
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, idempotencyMaxBodyBytes+1))
+		r.Body.Close()
+		if err != nil {
+			http.Error(w, "Erro ao ler o corpo da requisição.", http.StatusBadRequest)
+			return
+		}
+		if len(body) > idempotencyMaxBodyBytes {
+			http.Error(w, "Corpo da requisição muito grande.", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := sha256.New()
+		fingerprint.Write([]byte(r.Method + " " + strings.TrimSuffix(r.URL.Path, "/") + "\n"))
+		fingerprint.Write(body)
+		requestHash := hex.EncodeToString(fingerprint.Sum(nil))
+
+		acquired, err := keys.Acquire(userID, key, requestHash)
+		if err != nil {
+			log.Printf("Erro ao reservar Idempotency-Key %q do usuário %s: %v", key, userID, err)
+			http.Error(w, "Erro no servidor ao processar a requisição.", http.StatusInternalServerError)
+			return
+		}
+		if !acquired {
+			replayIdempotentResponse(w, keys, userID, key, requestHash)
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		claim := &idempotencyClaim{UserID: userID, Key: key}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), idempotencyClaimContextKey, claim)))
+
+		if rec.status >= 200 && rec.status < 300 {
+			err = keys.Complete(userID, key, rec.status, rec.body.Bytes(), rec.Header().Get("Content-Type"))
+		} else {
+			err = keys.Release(userID, key)
+		}
+		if err != nil {
+			log.Printf("Erro ao gravar resultado da Idempotency-Key %q do usuário %s: %v", key, userID, err)
+		}
+	})
+}
+
+// replayIdempotentResponse responde a um reenvio de uma chave já existente
+func replayIdempotentResponse(w http.ResponseWriter, keys IdempotencyRepository, userID, key, requestHash string) {
+	record, err := keys.Get(userID, key)
+	if err == sql.ErrNoRows { // A requisição original falhou e liberou a chave entre a reserva e esta leitura
+		http.Error(w, "A requisição original com esta Idempotency-Key falhou; tente novamente.", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Erro ao buscar Idempotency-Key %q do usuário %s: %v", key, userID, err)
+		http.Error(w, "Erro no servidor ao processar a requisição.", http.StatusInternalServerError)
+		return
+	}
+
+	if record.RequestHash != requestHash {
+		http.Error(w, "Idempotency-Key já utilizada com outra requisição.", http.StatusConflict)
+		return
+	}
+	switch {
+	case record.Status == idempotencyStatusCommitted:
+		// O efeito foi gravado, mas a resposta não: repetir criaria outro pedido/recarga
+		http.Error(w, "A requisição original com esta Idempotency-Key já foi aplicada, mas sua resposta não foi gravada; consulte o recurso criado.", http.StatusConflict)
+		return
+	case record.Status != idempotencyStatusDone || record.ResponseStatus == 0:
+		http.Error(w, "A requisição original com esta Idempotency-Key ainda está em processamento.", http.StatusConflict)
+		return
+	}
+
+	log.Printf("Idempotency-Key %q do usuário %s reutilizada: devolvendo a resposta original.", key, userID)
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set(idempotencyReplayedHeader, "true")
+	w.WriteHeader(record.ResponseStatus)
+	w.Write(record.ResponseBody)
+}
+
+// startIdempotencyKeyCleanup remove periodicamente as chaves expiradas
+func startIdempotencyKeyCleanup(appDB *sql.DB) {
+	go func() {
+		ticker := time.NewTicker(idempotencyCleanupEvery)
+		defer ticker.Stop()
+		for range ticker.C {
+			result, err := appDB.Exec("DELETE FROM public.idempotency_keys WHERE expires_at < NOW()")
+			if err != nil {
+				log.Printf("Erro ao limpar Idempotency-Keys expiradas: %v", err)
+				continue
+			}
+			if removed, _ := result.RowsAffected(); removed > 0 {
+				log.Printf("%d Idempotency-Key(s) expirada(s) removida(s).", removed)
+			}
+		}
+	}()
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	jwksFetchTimeout      = 10 * time.Second
+	jwksMaxBodyBytes      = 1 << 20
+	jwksMinRefreshBetween = time.Minute // Limite para recargas sob demanda (kid desconhecido)
+)
+
+// jsonWebKey é uma chave do JWKS (RFC 7517); só os campos de RSA e EC são usados
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwksCache guarda as chaves públicas do JWKS por kid, lidas de uma URL ou de um arquivo local
+type jwksCache struct {
+	source string // URL http(s) ou caminho de arquivo
+	client *http.Client
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	lastRefresh time.Time
+}
+
+func newJWKSCache(source string) *jwksCache {
+	return &jwksCache{
+		source: source,
+		client: &http.Client{Timeout: jwksFetchTimeout},
+		keys:   map[string]crypto.PublicKey{},
+	}
+}
+
+func (c *jwksCache) isRemote() bool {
+	return strings.HasPrefix(c.source, "http://") || strings.HasPrefix(c.source, "https://")
+}
+
+// refresh relê o JWKS e troca o conjunto de chaves inteiro (chaves removidas na origem deixam de valer)
+func (c *jwksCache) refresh() error {
+	c.mu.Lock()
+	c.lastRefresh = time.Now()
+	c.mu.Unlock()
+
+	var body []byte
+	var err error
+	if c.isRemote() {
+		body, err = c.fetch()
+	} else {
+		body, err = os.ReadFile(c.source)
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao ler JWKS de %s: %w", c.source, err)
+	}
+
+	keys, err := parseJWKS(body)
+	if err != nil {
+		return fmt.Errorf("JWKS inválido em %s: %w", c.source, err)
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+	log.Printf("JWKS carregado de %s: %d chave(s).", c.source, len(keys))
+	return nil
+}
+
+func (c *jwksCache) fetch() ([]byte, error) {
+	resp, err := c.client.Get(c.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status HTTP %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, jwksMaxBodyBytes))
+}
+
+// key retorna a chave do kid. Um kid desconhecido força uma recarga (rotação de chaves na origem),
+// no máximo uma vez por jwksMinRefreshBetween para que tokens forjados não martelem a origem.
+func (c *jwksCache) key(kid string) (crypto.PublicKey, error) {
+	c.mu.RLock()
+	key, ok := c.keys[kid]
+	canRefresh := time.Since(c.lastRefresh) >= jwksMinRefreshBetween
+	c.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	if canRefresh {
+		if err := c.refresh(); err != nil {
+			log.Printf("Erro ao recarregar JWKS para o kid %q: %v", kid, err)
+		}
+		c.mu.RLock()
+		key, ok = c.keys[kid]
+		c.mu.RUnlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("chave %q não encontrada no JWKS", kid)
+}
+
+// startRefresh recarrega o JWKS periodicamente
+func (c *jwksCache) startRefresh(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := c.refresh(); err != nil {
+				log.Printf("Erro ao atualizar JWKS: %v", err)
+			}
+		}
+	}()
+}
+
+// parseJWKS converte o documento {"keys": [...]} em chaves públicas por kid.
+// Chaves de outros tipos ou de uso diferente de assinatura são ignoradas.
+func parseJWKS(body []byte) (map[string]crypto.PublicKey, error) {
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		var err error
+		switch jwk.Kty {
+		case "RSA":
+			key, err = parseRSAJWK(jwk)
+		case "EC":
+			key, err = parseECJWK(jwk)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("chave %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("nenhuma chave RSA/EC de assinatura")
+	}
+	return keys, nil
+}
+
+func parseRSAJWK(jwk jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil || len(n) == 0 {
+		return nil, fmt.Errorf("módulo 'n' inválido")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, fmt.Errorf("expoente 'e' inválido")
+	}
+	exponent := 0
+	for _, b := range e {
+		exponent = exponent<<8 | int(b)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}, nil
+}
+
+func parseECJWK(jwk jsonWebKey) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	var ecdhCurve ecdh.Curve
+	switch jwk.Crv {
+	case "P-256":
+		curve, ecdhCurve = elliptic.P256(), ecdh.P256()
+	case "P-384":
+		curve, ecdhCurve = elliptic.P384(), ecdh.P384()
+	case "P-521":
+		curve, ecdhCurve = elliptic.P521(), ecdh.P521()
+	default:
+		return nil, fmt.Errorf("curva %q não suportada", jwk.Crv)
+	}
+
+	size := (curve.Params().BitSize + 7) / 8
+	x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+	y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if errX != nil || errY != nil || len(x) != size || len(y) != size {
+		return nil, fmt.Errorf("coordenadas 'x'/'y' inválidas")
+	}
+	// crypto/ecdh valida que o ponto está na curva
+	point := append(append([]byte{4}, x...), y...)
+	if _, err := ecdhCurve.NewPublicKey(point); err != nil {
+		return nil, fmt.Errorf("ponto fora da curva %s", jwk.Crv)
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// KitchenOrder é um pedido na lista da cozinha, com aluno e horário de retirada
+type KitchenOrder struct {
+	Order
+	StudentName    *string `json:"student_name,omitempty"`
+	PickupSlotCode *string `json:"pickup_slot_code,omitempty"`
+	PickupSlotName *string `json:"pickup_slot_name,omitempty"`
+}
+
+// KitchenItemTotal é a quantidade total de um item a preparar
+type KitchenItemTotal struct {
+	MenuItemID   string `json:"menu_item_id"`
+	MenuItemName string `json:"menu_item_name"`
+	Quantity     int    `json:"quantity"`
+}
+
+// KitchenOrderList é a resposta de GET /orders/kitchen
+type KitchenOrderList struct {
+	Date       string             `json:"date"`
+	Slot       *string            `json:"slot,omitempty"`
+	Orders     []KitchenOrder     `json:"orders"`
+	ItemTotals []KitchenItemTotal `json:"item_totals"`
+}
+
+// fetchKitchenOrders busca os pedidos não cancelados do dia de serviço, com aluno, horário e itens, na ordem dos
+// horários de retirada (imediatos primeiro). slotCode "" lista todos os horários; "none", só os pedidos imediatos.
+func fetchKitchenOrders(appDB *sql.DB, serviceDate, slotCode string) ([]KitchenOrder, error) {
+	conditions := []string{"o.scheduled_for = $1", "o.status <> 'CANCELED'"}
+	queryParams := []interface{}{serviceDate}
+	if slotCode == "none" {
+		conditions = append(conditions, "o.pickup_slot_id IS NULL")
+	} else if slotCode != "" {
+		conditions = append(conditions, "ps.code = $2")
+		queryParams = append(queryParams, slotCode)
+	}
+
+	rows, err := appDB.Query(`
+		SELECT o.id, o.user_id, o.student_id, o.order_date, to_char(o.scheduled_for, 'YYYY-MM-DD'), o.pickup_slot_id,
+		       o.total_amount, o.status, o.created_at, o.updated_at, s.name, ps.code, ps.name
+		FROM public.orders o
+		LEFT JOIN public.students s ON s.id = o.student_id
+		LEFT JOIN public.pickup_slots ps ON ps.id = o.pickup_slot_id
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY ps.sort_order NULLS FIRST, o.created_at;`, queryParams...)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar pedidos da cozinha para %s: %w", serviceDate, err)
+	}
+	defer rows.Close()
+
+	orders := []KitchenOrder{}
+	for rows.Next() {
+		var order KitchenOrder
+		var studentID, pickupSlotID, studentName, slotCode, slotName sql.NullString
+		if err := rows.Scan(&order.ID, &order.UserID, &studentID, &order.OrderDate, &order.ScheduledFor, &pickupSlotID,
+			&order.TotalAmount, &order.Status, &order.CreatedAt, &order.UpdatedAt, &studentName, &slotCode, &slotName); err != nil {
+			return nil, fmt.Errorf("erro ao scanear pedido da cozinha: %w", err)
+		}
+		if studentID.Valid {
+			order.StudentID = &studentID.String
+		}
+		if pickupSlotID.Valid {
+			order.PickupSlotID = &pickupSlotID.String
+		}
+		if studentName.Valid {
+			order.StudentName = &studentName.String
+		}
+		if slotCode.Valid {
+			order.PickupSlotCode = &slotCode.String
+			order.PickupSlotName = &slotName.String
+		}
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro após iterar pedidos da cozinha: %w", err)
+	}
+	rows.Close()
+
+	orderIDs := make([]string, len(orders))
+	for i, order := range orders {
+		orderIDs[i] = order.ID
+	}
+	itemsByOrder, err := fetchOrderItemsByOrderIDs(appDB, orderIDs)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar itens dos pedidos da cozinha para %s: %w", serviceDate, err)
+	}
+	for i := range orders {
+		orders[i].Items = itemsByOrder[orders[i].ID]
+	}
+	return orders, nil
+}
+
+// handleGetKitchenOrders trata GET /orders/kitchen?date=YYYY-MM-DD&slot=codigo.
+// Lista os pedidos não cancelados do dia de serviço (padrão: hoje), opcionalmente de um só horário,
+// com os totais por item para a produção. slot=none lista apenas os pedidos imediatos.
+func handleGetKitchenOrders(w http.ResponseWriter, r *http.Request, orders OrderRepository) {
+	serviceDate := schoolToday()
+	if raw := r.URL.Query().Get("date"); raw != "" {
+		parsed, err := parseServiceDate(raw)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("parâmetro 'date' inválido (esperado YYYY-MM-DD): %q", raw), http.StatusBadRequest)
+			return
+		}
+		serviceDate = parsed
+	}
+
+	result := KitchenOrderList{Date: serviceDate.Format(dateLayout), ItemTotals: []KitchenItemTotal{}}
+	slotCode := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("slot")))
+	if slotCode != "" {
+		result.Slot = &slotCode
+	}
+
+	var err error
+	if result.Orders, err = orders.KitchenOrders(result.Date, slotCode); err != nil {
+		log.Printf("Erro: %v", err)
+		http.Error(w, "Erro ao buscar pedidos da cozinha.", http.StatusInternalServerError)
+		return
+	}
+
+	totals := map[string]*KitchenItemTotal{}
+	for _, order := range result.Orders {
+		for _, item := range order.Items {
+			total, ok := totals[item.MenuItemID]
+			if !ok {
+				total = &KitchenItemTotal{MenuItemID: item.MenuItemID, MenuItemName: item.MenuItemName}
+				totals[item.MenuItemID] = total
+			}
+			total.Quantity += item.Quantity
+		}
+	}
+	for _, total := range totals {
+		result.ItemTotals = append(result.ItemTotals, *total)
+	}
+	sort.Slice(result.ItemTotals, func(i, j int) bool {
+		return result.ItemTotals[i].MenuItemName < result.ItemTotals[j].MenuItemName
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -1,6 +1,7 @@
 package main // Continua sendo o pacote principal
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -20,6 +21,14 @@ func main() {
 	}
 	defer db.Close() // Garante que a conexão seja fechada quando a função main terminar
 
+	// Subcomando de migrações (server migrate up|down [N]|status): roda e sai, sem subir o servidor
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(db, os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("Erro nas migrações: %v", err)
+		}
+		return
+	}
+
 	err = db.Ping()
 	if err != nil {
 		log.Printf("Alerta: Erro ao fazer ping no banco de dados: %v.", err)
@@ -27,27 +36,88 @@ func main() {
 		log.Println("Conexão com o banco de dados PostgreSQL estabelecida com sucesso!")
 	}
 
+	migrateOnStartup, err := loadMigrateOnStartupFromEnv()
+	if err != nil {
+		log.Fatalf("Erro ao configurar migrações: %v", err)
+	}
+	if migrateOnStartup {
+		migrations, err := loadMigrations(migrationFiles)
+		if err != nil {
+			log.Fatalf("Erro ao carregar migrações: %v", err)
+		}
+		applied, err := migrateUp(context.Background(), db, migrations)
+		if err != nil {
+			log.Fatalf("Erro ao aplicar migrações: %v", err)
+		}
+		log.Printf("Migrações: %d pendente(s) aplicada(s).", len(applied))
+	}
+
+	paymentProvider, err := newPaymentProviderFromEnv()
+	if err != nil {
+		log.Fatalf("Erro ao configurar provedor de pagamento: %v", err)
+	}
+
+	schoolLocation, err = loadSchoolLocationFromEnv()
+	if err != nil {
+		log.Fatalf("Erro ao configurar fuso horário da escola: %v", err)
+	}
+
+	lowBalanceThreshold, err = loadLowBalanceThresholdFromEnv()
+	if err != nil {
+		log.Fatalf("Erro ao configurar aviso de saldo baixo: %v", err)
+	}
+
+	authVerifier, err = newJWTVerifierFromEnv()
+	if err != nil {
+		log.Fatalf("Erro ao configurar verificação de tokens: %v", err)
+	}
+	if authVerifier == nil {
+		log.Println("Atenção: SUPABASE_JWT_SECRET e SUPABASE_JWKS_URL/SUPABASE_JWKS_FILE não configurados. Rotas autenticadas responderão 500.")
+	}
+
+	startIdempotencyKeyCleanup(db)
+	startAllowanceScheduler(db)
+	startTopUpExpiry(db)
+
+	// Eventos em tempo real (SSE): hub local, alimentado pelas transações desta instância (commitTx)
+	// e, pelo LISTEN/NOTIFY do Postgres, pelas das demais
+	eventHub := newEventHub()
+	localEventHub = eventHub
+	if connStr, errConn := dbConnString(); errConn == nil {
+		startEventListener(connStr, eventHub)
+	} else {
+		log.Printf("Alerta: eventos de outras instâncias indisponíveis: %v", errConn)
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 		log.Printf("Defaulting to port %s", port)
 	}
 
+	// Acesso a dados dos handlers de cardápio, pedidos, alunos e turmas (ver repository.go)
+	repos := newPostgresRepositories(db)
+
 	// Rota principal (Olá Mundo)
 	http.HandleFunc("/", rootHandler)
 
 	// Rota para /menu-items agora chama o handler passando a conexão 'db'
 	http.HandleFunc("/menu-items/", func(w http.ResponseWriter, r *http.Request) {
-		menuItemsRouterHandler(w, r, db) // Passamos 'db' para o handler
+		menuItemsRouterHandler(w, r, db, repos.Menu) // Passamos 'db' (autenticação) e o repositório do cardápio
+	})
+
+	// Cardápio do dia (GET /menus?date=) e calendário do cardápio (/menus/calendar)
+	http.HandleFunc("/menus", func(w http.ResponseWriter, r *http.Request) {
+		menusRouterHandler(w, r, db)
+	})
+	http.HandleFunc("/menus/", func(w http.ResponseWriter, r *http.Request) {
+		menusRouterHandler(w, r, db)
 	})
 
 	// NOVA ROTA: Obter perfil do usuário logado (protegida)
 	http.HandleFunc("/me/profile", func(w http.ResponseWriter, r *http.Request) {
-		// Primeiro, passa pelo middleware de autenticação
-		authMiddleware(http.HandlerFunc(func(ww http.ResponseWriter, rr *http.Request) {
-			// Se autenticado, chama o handler para buscar o perfil
-			handleGetMyProfile(ww, rr, db)
-		})).ServeHTTP(w, r) // Importante: ServeHTTP(w,r) original da requisição
+		// Autentica e carrega o perfil no contexto; o handler só o devolve
+		protect(db, PermSelfService, handleGetMyProfile).ServeHTTP(w, r)
 	})
 
 	// NOVO: Rota para pedidos
@@ -55,9 +125,9 @@ func main() {
 		// Roteador simples baseado no método HTTP para /orders
 		// Por enquanto, só POST para criar. GET virá depois.
 		if r.Method == http.MethodPost {
-			authMiddleware(http.HandlerFunc(func(ww http.ResponseWriter, rr *http.Request) {
-				handleCreateOrder(ww, rr, db) // Chama o handler do order_handlers.go
-			})).ServeHTTP(w, r)
+			authMiddleware(requirePermission(db, PermOrderCreate)(idempotencyMiddleware(repos.Idempotency, http.HandlerFunc(func(ww http.ResponseWriter, rr *http.Request) {
+				handleCreateOrder(ww, rr, repos.Orders) // Chama o handler do order_handlers.go
+			})))).ServeHTTP(w, r)
 		} else {
 			http.Error(w, "Método não permitido para /orders. Use POST para criar.", http.StatusMethodNotAllowed)
 		}
@@ -67,9 +137,9 @@ func main() {
 	http.HandleFunc("/me/orders", func(w http.ResponseWriter, r *http.Request) {
 		// Apenas o método GET é permitido por enquanto para esta rota
 		if r.Method == http.MethodGet {
-			authMiddleware(http.HandlerFunc(func(ww http.ResponseWriter, rr *http.Request) {
-				handleGetMyOrders(ww, rr, db) // Chama o handler do order_handlers.go
-			})).ServeHTTP(w, r)
+			protect(db, PermSelfService, func(ww http.ResponseWriter, rr *http.Request) {
+				handleGetMyOrders(ww, rr, repos.Orders, repos.Students) // Chama o handler do order_listing.go
+			}).ServeHTTP(w, r)
 		} else {
 			http.Error(w, "Método não permitido para /me/orders. Use GET.", http.StatusMethodNotAllowed)
 		}
@@ -77,20 +147,69 @@ func main() {
 
 	// ATUALIZADO: Rota para pedidos agora usa o ordersRouterHandler
 	http.HandleFunc("/orders/", func(w http.ResponseWriter, r *http.Request) { // Mantenha a barra no final
-		ordersRouterHandler(w, r, db) // Chama o roteador de pedidos do order_handlers.go
+		ordersRouterHandler(w, r, db, repos.Orders, repos.Idempotency, eventHub) // Chama o roteador de pedidos do order_handlers.go
 	})
 
 	// NOVA ROTA: Para Turmas (Classes)
+	http.HandleFunc("/classes", func(w http.ResponseWriter, r *http.Request) {
+		classRouterHandler(w, r, db, repos.Classes)
+	})
 	http.HandleFunc("/classes/", func(w http.ResponseWriter, r *http.Request) {
-		classRouterHandler(w, r, db) // Chama o roteador de turmas
+		classRouterHandler(w, r, db, repos.Classes) // Chama o roteador de turmas
 	})
 
 	// NOVA ROTA: Para Alunos (Students)
+	http.HandleFunc("/students", func(w http.ResponseWriter, r *http.Request) {
+		studentRouterHandler(w, r, db, repos.Students)
+	})
 	http.HandleFunc("/students/", func(w http.ResponseWriter, r *http.Request) { // Note a barra no final
-		studentRouterHandler(w, r, db)
+		studentRouterHandler(w, r, db, repos.Students)
 	})
 	http.HandleFunc("/me/students", func(w http.ResponseWriter, r *http.Request) { // Rota específica para "meus alunos"
-		studentRouterHandler(w, r, db) // O studentRouterHandler vai tratar o path /me/students
+		studentRouterHandler(w, r, db, repos.Students) // O studentRouterHandler vai tratar o path /me/students
+	})
+	http.HandleFunc("/me/students/", func(w http.ResponseWriter, r *http.Request) { // Sub-rotas: /me/students/{id}/limits
+		studentRouterHandler(w, r, db, repos.Students)
+	})
+
+	// Stream SSE do usuário logado: status dos seus pedidos, saldo e avisos de saldo baixo
+	http.HandleFunc("/me/events", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Método não permitido para /me/events. Use GET.", http.StatusMethodNotAllowed)
+			return
+		}
+		protect(db, PermSelfService, func(ww http.ResponseWriter, rr *http.Request) {
+			handleMyEvents(ww, rr, eventHub)
+		}).ServeHTTP(w, r)
+	})
+
+	// Extrato e recargas de créditos do usuário logado e operações de crédito de admin
+	http.HandleFunc("/me/credits/", func(w http.ResponseWriter, r *http.Request) {
+		creditsRouterHandler(w, r, db, repos.Idempotency, paymentProvider)
+	})
+	http.HandleFunc("/credits/", func(w http.ResponseWriter, r *http.Request) {
+		creditsRouterHandler(w, r, db, repos.Idempotency, paymentProvider)
+	})
+
+	// Horários de retirada das encomendas (recreio, almoço...)
+	http.HandleFunc("/pickup-slots", func(w http.ResponseWriter, r *http.Request) {
+		pickupSlotsRouterHandler(w, r, db)
+	})
+	http.HandleFunc("/pickup-slots/", func(w http.ResponseWriter, r *http.Request) {
+		pickupSlotsRouterHandler(w, r, db)
+	})
+
+	// Chaves de API dos dispositivos da cantina (tablet do balcão, totens): emissão e revogação pela administração
+	http.HandleFunc("/device-keys", func(w http.ResponseWriter, r *http.Request) {
+		deviceKeysRouterHandler(w, r, db)
+	})
+	http.HandleFunc("/device-keys/", func(w http.ResponseWriter, r *http.Request) {
+		deviceKeysRouterHandler(w, r, db)
+	})
+
+	// Webhook do provedor de pagamento (autenticado pela assinatura, não por JWT)
+	http.HandleFunc("/payments/webhook", func(w http.ResponseWriter, r *http.Request) {
+		handlePaymentWebhook(w, r, repos.TopUps, paymentProvider)
 	})
 
 	log.Printf("Servidor escutando na porta %s", port)
@@ -100,8 +219,8 @@ func main() {
 	}
 }
 
-// initDB inicializa a conexão com o banco de dados
-func initDB() error {
+// dbConnString monta a string de conexão a partir das variáveis de ambiente do banco
+func dbConnString() (string, error) {
 	// Pegar as variáveis de ambiente para a conexão com o banco
 	dbHost := os.Getenv("DB_HOST")
 	dbPort := os.Getenv("DB_PORT")
@@ -112,14 +231,22 @@ func initDB() error {
 
 	if dbHost == "" || dbPort == "" || dbUser == "" || dbPassword == "" || dbName == "" {
 		log.Println("Atenção: Variáveis de ambiente do banco de dados não estão todas configuradas.")
-		return fmt.Errorf("variáveis de ambiente do banco de dados incompletas")
+		return "", fmt.Errorf("variáveis de ambiente do banco de dados incompletas")
 	}
 	if dbSSLMode == "" {
 		dbSSLMode = "require" // Supabase geralmente requer SSL
 	}
 
-	connStr := fmt.Sprintf("postgresql://%s:%s@%s:%s/%s?sslmode=%s",
-		dbUser, dbPassword, dbHost, dbPort, dbName, dbSSLMode)
+	return fmt.Sprintf("postgresql://%s:%s@%s:%s/%s?sslmode=%s",
+		dbUser, dbPassword, dbHost, dbPort, dbName, dbSSLMode), nil
+}
+
+// initDB inicializa a conexão com o banco de dados
+func initDB() error {
+	connStr, err := dbConnString()
+	if err != nil {
+		return err
+	}
 
 	var err_db_open error
 	db, err_db_open = sql.Open("postgres", connStr)
@@ -155,7 +282,8 @@ func corsMiddleware(next http.Handler) http.Handler {
 
 		// Define quais cabeçalhos HTTP podem ser usados na requisição real
 		// É importante incluir "Authorization" (para o token JWT) e "Content-Type".
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token, Idempotency-Key")
+		w.Header().Set("Access-Control-Expose-Headers", "Idempotent-Replayed")
 
 		// w.Header().Set("Access-Control-Allow-Credentials", "true") // Descomente se precisar de cookies/sessões autenticadas
 
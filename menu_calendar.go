@@ -0,0 +1,348 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// menuCalendarItemIDsQuery seleciona os itens servidos na data $1 (YYYY-MM-DD) no horário $2 (UUID ou NULL).
+// Com $2 NULL vale qualquer horário do dia e só as exceções do dia inteiro removem o item.
+const menuCalendarItemIDsQuery = `
+	SELECT e.menu_item_id
+	FROM public.menu_calendar_entries e
+	WHERE NOT e.is_excluded
+	  AND (e.service_date = $1::date
+	       OR (e.weekday = EXTRACT(ISODOW FROM $1::date)
+	           AND (e.starts_on IS NULL OR e.starts_on <= $1::date)
+	           AND (e.ends_on IS NULL OR e.ends_on >= $1::date)))
+	  AND ($2::uuid IS NULL OR e.pickup_slot_id IS NULL OR e.pickup_slot_id = $2::uuid)
+	  AND NOT EXISTS (
+	      SELECT 1 FROM public.menu_calendar_entries x
+	      WHERE x.menu_item_id = e.menu_item_id AND x.is_excluded AND x.service_date = $1::date
+	        AND (x.pickup_slot_id IS NULL OR x.pickup_slot_id = $2::uuid))`
+
+// MenuCalendarEntry espelha uma linha de menu_calendar_entries
+type MenuCalendarEntry struct {
+	ID           string    `json:"id"`
+	MenuItemID   string    `json:"menu_item_id"`
+	MenuItemName string    `json:"menu_item_name,omitempty"`
+	Weekday      *int      `json:"weekday,omitempty"`      // ISO: 1 = segunda ... 7 = domingo
+	ServiceDate  *string   `json:"service_date,omitempty"` // YYYY-MM-DD
+	PickupSlotID *string   `json:"pickup_slot_id,omitempty"`
+	StartsOn     *string   `json:"starts_on,omitempty"`
+	EndsOn       *string   `json:"ends_on,omitempty"`
+	IsExcluded   bool      `json:"is_excluded"`
+	CreatedBy    *string   `json:"created_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CreateMenuCalendarEntryPayload é o corpo de POST /menus/calendar.
+// Informe weekday (recorrente, com vigência opcional) OU service_date (data específica).
+// is_excluded=true com service_date tira o item do cardápio naquela data (feriado, falta de insumo...).
+type CreateMenuCalendarEntryPayload struct {
+	MenuItemID  string  `json:"menu_item_id"`
+	Weekday     *int    `json:"weekday"`
+	ServiceDate *string `json:"service_date"`
+	PickupSlot  *string `json:"pickup_slot"` // Código do horário; vazio = todos os horários
+	StartsOn    *string `json:"starts_on"`
+	EndsOn      *string `json:"ends_on"`
+	IsExcluded  bool    `json:"is_excluded"`
+}
+
+// DailyMenu é a resposta de GET /menus
+type DailyMenu struct {
+	Date  string     `json:"date"`
+	Slot  *string    `json:"slot,omitempty"`
+	Items []MenuItem `json:"items"`
+}
+
+// fetchMenuItemIDsServedOn retorna o conjunto de itens no cardápio do dia (e do horário, se informado)
+func fetchMenuItemIDsServedOn(q dbQueryer, serviceDate time.Time, pickupSlotID *string) (map[string]bool, error) {
+	rows, err := q.Query(menuCalendarItemIDsQuery, serviceDate.Format(dateLayout), pickupSlotID)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar cardápio de %s: %w", serviceDate.Format(dateLayout), err)
+	}
+	defer rows.Close()
+
+	served := map[string]bool{}
+	for rows.Next() {
+		var menuItemID string
+		if err := rows.Scan(&menuItemID); err != nil {
+			return nil, fmt.Errorf("erro ao scanear cardápio de %s: %w", serviceDate.Format(dateLayout), err)
+		}
+		served[menuItemID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro após iterar cardápio de %s: %w", serviceDate.Format(dateLayout), err)
+	}
+	return served, nil
+}
+
+// menusRouterHandler para /menus, /menus/calendar e /menus/calendar/{id}
+func menusRouterHandler(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/menus"), "/")
+
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		handleGetDailyMenu(w, r, appDB) // PÚBLICO, como GET /menu-items
+	case path == "calendar" && r.Method == http.MethodGet:
+		protect(appDB, PermMenuManage, func(ww http.ResponseWriter, rr *http.Request) {
+			handleGetMenuCalendar(ww, rr, appDB)
+		}).ServeHTTP(w, r)
+	case path == "calendar" && r.Method == http.MethodPost:
+		protect(appDB, PermMenuManage, func(ww http.ResponseWriter, rr *http.Request) {
+			handleCreateMenuCalendarEntry(ww, rr, appDB)
+		}).ServeHTTP(w, r)
+	case strings.HasPrefix(path, "calendar/") && r.Method == http.MethodDelete:
+		entryID := strings.TrimPrefix(path, "calendar/")
+		protect(appDB, PermMenuManage, func(ww http.ResponseWriter, rr *http.Request) {
+			handleDeleteMenuCalendarEntry(ww, rr, appDB, entryID)
+		}).ServeHTTP(w, r)
+	default:
+		http.Error(w, "Rota ou método não permitido para /menus", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleGetDailyMenu trata GET /menus?date=YYYY-MM-DD&slot=codigo (padrão: hoje, todos os horários).
+// Aceita os mesmos filtros de GET /menu-items (?tag= e ?exclude_allergens=).
+func handleGetDailyMenu(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+	serviceDate := schoolToday()
+	if raw := r.URL.Query().Get("date"); raw != "" {
+		parsed, err := parseServiceDate(raw)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("parâmetro 'date' inválido (esperado YYYY-MM-DD): %q", raw), http.StatusBadRequest)
+			return
+		}
+		serviceDate = parsed
+	}
+	menu := DailyMenu{Date: serviceDate.Format(dateLayout), Items: []MenuItem{}}
+
+	var pickupSlotID *string
+	if slotCode := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("slot"))); slotCode != "" {
+		var slotID string
+		err := appDB.QueryRow("SELECT id FROM public.pickup_slots WHERE code = $1", slotCode).Scan(&slotID)
+		if err == sql.ErrNoRows {
+			http.Error(w, fmt.Sprintf("Horário de retirada '%s' não encontrado.", slotCode), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Erro ao buscar horário de retirada %s: %v", slotCode, err)
+			http.Error(w, "Erro ao buscar cardápio do dia.", http.StatusInternalServerError)
+			return
+		}
+		menu.Slot = &slotCode
+		pickupSlotID = &slotID
+	}
+
+	filter, err := parseMenuItemFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conditions, queryParams := filter.sqlConditions([]interface{}{menu.Date, pickupSlotID})
+	conditions = append([]string{"id IN (" + menuCalendarItemIDsQuery + ")"}, conditions...)
+	query := "SELECT " + menuItemColumns + " FROM public.menu_items WHERE " + strings.Join(conditions, " AND ") + " ORDER BY category NULLS LAST, name"
+
+	rows, err := appDB.Query(query, queryParams...)
+	if err != nil {
+		log.Printf("Erro ao buscar cardápio de %s: %v", menu.Date, err)
+		http.Error(w, "Erro ao buscar cardápio do dia.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		item, err := scanMenuItem(rows)
+		if err != nil {
+			log.Printf("Erro ao scanear item do cardápio de %s: %v", menu.Date, err)
+			http.Error(w, "Erro ao buscar cardápio do dia.", http.StatusInternalServerError)
+			return
+		}
+		menu.Items = append(menu.Items, *item)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Erro após iterar cardápio de %s: %v", menu.Date, err)
+		http.Error(w, "Erro ao buscar cardápio do dia.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(menu)
+}
+
+// handleGetMenuCalendar trata GET /menus/calendar[?menu_item_id=]: as entradas do calendário (admin)
+func handleGetMenuCalendar(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+	query := `
+		SELECT e.id, e.menu_item_id, mi.name, e.weekday, to_char(e.service_date, 'YYYY-MM-DD'), e.pickup_slot_id,
+		       to_char(e.starts_on, 'YYYY-MM-DD'), to_char(e.ends_on, 'YYYY-MM-DD'), e.is_excluded, e.created_by, e.created_at
+		FROM public.menu_calendar_entries e
+		JOIN public.menu_items mi ON mi.id = e.menu_item_id`
+	var queryParams []interface{}
+	if menuItemID := r.URL.Query().Get("menu_item_id"); menuItemID != "" {
+		query += " WHERE e.menu_item_id = $1"
+		queryParams = append(queryParams, menuItemID)
+	}
+	query += " ORDER BY e.service_date NULLS FIRST, e.weekday, mi.name"
+
+	rows, err := appDB.Query(query, queryParams...)
+	if err != nil {
+		log.Printf("Erro ao buscar calendário do cardápio: %v", err)
+		http.Error(w, "Erro ao buscar calendário do cardápio.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	entries := []MenuCalendarEntry{}
+	for rows.Next() {
+		var entry MenuCalendarEntry
+		var weekday sql.NullInt64
+		var serviceDate, pickupSlotID, startsOn, endsOn, createdBy sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.MenuItemID, &entry.MenuItemName, &weekday, &serviceDate, &pickupSlotID,
+			&startsOn, &endsOn, &entry.IsExcluded, &createdBy, &entry.CreatedAt); err != nil {
+			log.Printf("Erro ao scanear entrada do calendário do cardápio: %v", err)
+			http.Error(w, "Erro ao buscar calendário do cardápio.", http.StatusInternalServerError)
+			return
+		}
+		if weekday.Valid {
+			day := int(weekday.Int64)
+			entry.Weekday = &day
+		}
+		if serviceDate.Valid {
+			entry.ServiceDate = &serviceDate.String
+		}
+		if pickupSlotID.Valid {
+			entry.PickupSlotID = &pickupSlotID.String
+		}
+		if startsOn.Valid {
+			entry.StartsOn = &startsOn.String
+		}
+		if endsOn.Valid {
+			entry.EndsOn = &endsOn.String
+		}
+		if createdBy.Valid {
+			entry.CreatedBy = &createdBy.String
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Erro após iterar calendário do cardápio: %v", err)
+		http.Error(w, "Erro ao buscar calendário do cardápio.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// validateMenuCalendarPayload retorna uma mensagem de erro ("" se o payload é válido)
+func validateMenuCalendarPayload(payload *CreateMenuCalendarEntryPayload) string {
+	if payload.MenuItemID == "" {
+		return "O campo 'menu_item_id' é obrigatório."
+	}
+	if (payload.Weekday == nil) == (payload.ServiceDate == nil) {
+		return "Informe 'weekday' (1 = segunda ... 7 = domingo) ou 'service_date', mas não os dois."
+	}
+	if payload.Weekday != nil && (*payload.Weekday < 1 || *payload.Weekday > 7) {
+		return "weekday deve estar entre 1 (segunda) e 7 (domingo)."
+	}
+	for name, value := range map[string]*string{"service_date": payload.ServiceDate, "starts_on": payload.StartsOn, "ends_on": payload.EndsOn} {
+		if value == nil {
+			continue
+		}
+		if _, err := time.Parse(dateLayout, *value); err != nil {
+			return fmt.Sprintf("%s inválido (esperado YYYY-MM-DD): %q", name, *value)
+		}
+	}
+	if payload.ServiceDate != nil && (payload.StartsOn != nil || payload.EndsOn != nil) {
+		return "starts_on/ends_on só se aplicam a entradas por weekday."
+	}
+	if payload.StartsOn != nil && payload.EndsOn != nil && *payload.StartsOn > *payload.EndsOn {
+		return "starts_on deve ser anterior ou igual a ends_on."
+	}
+	if payload.IsExcluded && payload.ServiceDate == nil {
+		return "Exceções (is_excluded) exigem 'service_date'."
+	}
+	return ""
+}
+
+// handleCreateMenuCalendarEntry trata POST /menus/calendar (admin)
+func handleCreateMenuCalendarEntry(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+	requestingUserID := r.Context().Value(userContextKey).(string)
+
+	var payload CreateMenuCalendarEntryPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if msg := validateMenuCalendarPayload(&payload); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	entry := MenuCalendarEntry{
+		MenuItemID:  payload.MenuItemID,
+		Weekday:     payload.Weekday,
+		ServiceDate: payload.ServiceDate,
+		StartsOn:    payload.StartsOn,
+		EndsOn:      payload.EndsOn,
+		IsExcluded:  payload.IsExcluded,
+		CreatedBy:   &requestingUserID,
+	}
+	if payload.PickupSlot != nil && strings.TrimSpace(*payload.PickupSlot) != "" {
+		slotCode := strings.ToLower(strings.TrimSpace(*payload.PickupSlot))
+		var slotID string
+		err := appDB.QueryRow("SELECT id FROM public.pickup_slots WHERE code = $1", slotCode).Scan(&slotID)
+		if err == sql.ErrNoRows {
+			http.Error(w, fmt.Sprintf("Horário de retirada '%s' não encontrado.", slotCode), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Erro ao buscar horário de retirada %s: %v", slotCode, err)
+			http.Error(w, "Erro ao salvar entrada do calendário.", http.StatusInternalServerError)
+			return
+		}
+		entry.PickupSlotID = &slotID
+	}
+
+	err := appDB.QueryRow(`
+		INSERT INTO public.menu_calendar_entries
+			(menu_item_id, weekday, service_date, pickup_slot_id, starts_on, ends_on, is_excluded, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at;`,
+		entry.MenuItemID, entry.Weekday, entry.ServiceDate, entry.PickupSlotID, entry.StartsOn, entry.EndsOn, entry.IsExcluded, entry.CreatedBy,
+	).Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		if isForeignKeyViolation(err, "menu_calendar_entries_menu_item_id_fkey") {
+			http.Error(w, "Item do cardápio não encontrado.", http.StatusBadRequest)
+		} else {
+			log.Printf("Erro ao inserir entrada do calendário do item %s: %v", entry.MenuItemID, err)
+			http.Error(w, "Erro ao salvar entrada do calendário.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// handleDeleteMenuCalendarEntry trata DELETE /menus/calendar/{id} (admin)
+func handleDeleteMenuCalendarEntry(w http.ResponseWriter, r *http.Request, appDB *sql.DB, entryID string) {
+	result, err := appDB.Exec("DELETE FROM public.menu_calendar_entries WHERE id = $1", entryID)
+	if err != nil {
+		log.Printf("Erro ao remover entrada %s do calendário do cardápio: %v", entryID, err)
+		http.Error(w, "Erro ao remover entrada do calendário.", http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		http.Error(w, "Entrada do calendário não encontrada.", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -7,51 +7,112 @@ import (
 	"net/http"
 	"strings" // NOVO: Para manipular strings (vamos usar para pegar o ID da URL)
 	"time"
-	// _ "github.com/lib/pq" // Já importado no main.go ou aqui se preferir
+
+	"github.com/lib/pq" // pq.Array para as colunas TEXT[] (alérgenos e marcações)
 )
 
 // MenuItem struct (sem mudanças)
 type MenuItem struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Description *string   `json:"description,omitempty"`
-	Price       float64   `json:"price"`
-	Category    *string   `json:"category,omitempty"`
-	ImageURL    *string   `json:"image_url,omitempty"`
-	IsAvailable bool      `json:"is_available"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description *string  `json:"description,omitempty"`
+	Price       Money    `json:"price"`
+	Category    *string  `json:"category,omitempty"`
+	ImageURL    *string  `json:"image_url,omitempty"`
+	IsAvailable bool     `json:"is_available"`
+	Allergens   []string `json:"allergens"`    // O que o item contém (ex: gluten, lactose)
+	DietaryTags []string `json:"dietary_tags"` // O que o item é (ex: vegetarian, vegan)
+	// Estoque atual; nil = item sem controle de estoque (ver stock.go)
+	StockQuantity *int      `json:"stock_quantity"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// menuItemColumns são as colunas lidas por scanMenuItem, na mesma ordem
+const menuItemColumns = `id, name, description, price, category, image_url, is_available, allergens, dietary_tags, stock_quantity, created_at, updated_at`
+
+// scanMenuItem lê uma linha com as colunas de menuItemColumns, tratando os campos que podem ser nulos
+func scanMenuItem(row interface{ Scan(...interface{}) error }) (*MenuItem, error) {
+	var item MenuItem
+	var description, category, imageURL sql.NullString
+	var stockQuantity sql.NullInt64
+	err := row.Scan(&item.ID, &item.Name, &description, &item.Price, &category, &imageURL, &item.IsAvailable,
+		pq.Array(&item.Allergens), pq.Array(&item.DietaryTags), &stockQuantity, &item.CreatedAt, &item.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if description.Valid {
+		item.Description = &description.String
+	}
+	if category.Valid {
+		item.Category = &category.String
+	}
+	if imageURL.Valid {
+		item.ImageURL = &imageURL.String
+	}
+	if stockQuantity.Valid {
+		quantity := int(stockQuantity.Int64)
+		item.StockQuantity = &quantity
+	}
+	if item.Allergens == nil {
+		item.Allergens = []string{}
+	}
+	if item.DietaryTags == nil {
+		item.DietaryTags = []string{}
+	}
+	return &item, nil
 }
 
 // CreateMenuItemPayload struct (sem mudanças)
 type CreateMenuItemPayload struct {
-	Name        string  `json:"name"`
-	Description *string `json:"description"`
-	Price       float64 `json:"price"`
-	Category    *string `json:"category"`
-	ImageURL    *string `json:"image_url"`
-	IsAvailable *bool   `json:"is_available"`
+	Name        string   `json:"name"`
+	Description *string  `json:"description"`
+	Price       Money    `json:"price"`
+	Category    *string  `json:"category"`
+	ImageURL    *string  `json:"image_url"`
+	IsAvailable *bool    `json:"is_available"`
+	Allergens   []string `json:"allergens"`
+	DietaryTags []string `json:"dietary_tags"`
+	// Apenas na criação: estoque inicial. Depois, o estoque muda só por POST /menu-items/{id}/stock
+	StockQuantity *int `json:"stock_quantity"`
 }
 
 // menuItemsRouterHandler decide qual função chamar baseado no método HTTP e no PATH
 // Dentro de menu_handlers.go
 
-func menuItemsRouterHandler(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+func menuItemsRouterHandler(w http.ResponseWriter, r *http.Request, appDB *sql.DB, menu MenuRepository) {
 	log.Printf("DEBUG: menuItemsRouterHandler: Recebido path: %s, Método: %s", r.URL.Path, r.Method)
 
 	itemID := strings.TrimPrefix(r.URL.Path, "/menu-items/")
 	log.Printf("DEBUG: menuItemsRouterHandler: Calculado itemID: '%s'", itemID)
 
+	// Rota de estoque: /menu-items/{id}/stock (apenas equipe)
+	if stockItemID, resource, hasResource := strings.Cut(strings.Trim(itemID, "/"), "/"); hasResource {
+		switch {
+		case resource == "stock" && r.Method == http.MethodGet:
+			protect(appDB, PermStockManage, func(ww http.ResponseWriter, rr *http.Request) {
+				handleGetStockMovements(ww, rr, appDB, stockItemID)
+			}).ServeHTTP(w, r)
+		case resource == "stock" && r.Method == http.MethodPost:
+			protect(appDB, PermStockManage, func(ww http.ResponseWriter, rr *http.Request) {
+				handleCreateStockMovement(ww, rr, appDB, stockItemID)
+			}).ServeHTTP(w, r)
+		default:
+			http.Error(w, "Rota ou método não permitido para /menu-items/{id}/"+resource, http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
 	if itemID == "" { // Rota base: /menu-items/
 		switch r.Method {
 		case http.MethodGet:
-			handleGetMenuItems(w, r, appDB) // Listar todos - PÚBLICO
+			handleGetMenuItems(w, r, menu) // Listar todos - PÚBLICO
 		case http.MethodPost:
 			// NOVO: Aplicando o middleware de autenticação ANTES de chamar handleCreateMenuItem
-			authMiddleware(http.HandlerFunc(func(ww http.ResponseWriter, rr *http.Request) {
+			protect(appDB, PermMenuManage, func(ww http.ResponseWriter, rr *http.Request) {
 				// O userID está no contexto rr.Context().Value(userContextKey) se precisar dele aqui
-				handleCreateMenuItem(ww, rr, appDB)
-			})).ServeHTTP(w, r) // Importante: ServeHTTP(w,r) original
+				handleCreateMenuItem(ww, rr, menu)
+			}).ServeHTTP(w, r) // Importante: ServeHTTP(w,r) original
 		default:
 			http.Error(w, "Método não permitido para /menu-items/", http.StatusMethodNotAllowed)
 		}
@@ -59,63 +120,58 @@ func menuItemsRouterHandler(w http.ResponseWriter, r *http.Request, appDB *sql.D
 		// Por enquanto, vamos manter GET /{id} público e proteger PUT e DELETE
 		switch r.Method {
 		case http.MethodGet:
-			handleGetMenuItemByID(w, r, appDB, itemID) // PÚBLICO
+			handleGetMenuItemByID(w, r, menu, itemID) // PÚBLICO
 		case http.MethodPut:
 			// NOVO: Aplicando o middleware
-			authMiddleware(http.HandlerFunc(func(ww http.ResponseWriter, rr *http.Request) {
-				handleUpdateMenuItem(ww, rr, appDB, itemID)
-			})).ServeHTTP(w, r)
+			protect(appDB, PermMenuManage, func(ww http.ResponseWriter, rr *http.Request) {
+				handleUpdateMenuItem(ww, rr, menu, itemID)
+			}).ServeHTTP(w, r)
 		case http.MethodDelete:
 			// NOVO: Aplicando o middleware
-			authMiddleware(http.HandlerFunc(func(ww http.ResponseWriter, rr *http.Request) {
-				handleDeleteMenuItem(ww, rr, appDB, itemID)
-			})).ServeHTTP(w, r)
+			protect(appDB, PermMenuManage, func(ww http.ResponseWriter, rr *http.Request) {
+				handleDeleteMenuItem(ww, rr, menu, itemID)
+			}).ServeHTTP(w, r)
 		default:
 			http.Error(w, "Método não permitido para /menu-items/{id}", http.StatusMethodNotAllowed)
 		}
 	}
 }
 
-// handleGetMenuItems (sem mudanças, lista todos)
-func handleGetMenuItems(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
-	rows, err := appDB.Query("SELECT id, name, description, price, category, image_url, is_available, created_at, updated_at FROM public.menu_items ORDER BY name ASC")
+// parseMenuItemFilter lê ?tag= e ?exclude_allergens= (valores validados contra os conhecidos, ver dietary.go)
+func parseMenuItemFilter(r *http.Request) (MenuItemFilter, error) {
+	tags, err := parseDietaryList(r.URL.Query().Get("tag"), knownDietaryTags)
 	if err != nil {
-		log.Printf("Erro ao buscar itens do cardápio: %v", err)
-		http.Error(w, "Erro ao buscar dados do servidor", http.StatusInternalServerError)
-		return
+		return MenuItemFilter{}, err
 	}
-	defer rows.Close()
+	excludedAllergens, err := parseDietaryList(r.URL.Query().Get("exclude_allergens"), knownAllergens)
+	if err != nil {
+		return MenuItemFilter{}, err
+	}
+	return MenuItemFilter{Tags: tags, ExcludedAllergens: excludedAllergens}, nil
+}
 
-	menu := []MenuItem{}
-	for rows.Next() {
-		var item MenuItem
-		var description, category, imageURL sql.NullString
-		err := rows.Scan(&item.ID, &item.Name, &description, &item.Price, &category, &imageURL, &item.IsAvailable, &item.CreatedAt, &item.UpdatedAt)
-		if err != nil { /* ... tratamento de erro ... */
-			http.Error(w, "Erro processar", http.StatusInternalServerError)
-			return
-		}
-		if description.Valid {
-			item.Description = &description.String
-		}
-		if category.Valid {
-			item.Category = &category.String
-		}
-		if imageURL.Valid {
-			item.ImageURL = &imageURL.String
-		}
-		menu = append(menu, item)
+// handleGetMenuItems lista os itens do cardápio.
+// Filtros opcionais: ?tag=vegetarian,vegan (itens com TODAS as marcações) e
+// ?exclude_allergens=gluten,lactose (itens sem NENHUM dos alérgenos).
+func handleGetMenuItems(w http.ResponseWriter, r *http.Request, menu MenuRepository) {
+	filter, err := parseMenuItemFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	if err = rows.Err(); err != nil { /* ... tratamento de erro ... */
-		http.Error(w, "Erro dados", http.StatusInternalServerError)
+
+	items, err := menu.ListItems(filter)
+	if err != nil {
+		log.Printf("Erro: %v", err)
+		http.Error(w, "Erro ao buscar dados do servidor", http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(menu)
+	json.NewEncoder(w).Encode(items)
 }
 
 // handleCreateMenuItem (sem mudanças, cria um novo)
-func handleCreateMenuItem(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+func handleCreateMenuItem(w http.ResponseWriter, r *http.Request, menu MenuRepository) {
 	var payload CreateMenuItemPayload
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil { /* ... */
 		http.Error(w, "Payload inválido", http.StatusBadRequest)
@@ -134,28 +190,28 @@ func handleCreateMenuItem(w http.ResponseWriter, r *http.Request, appDB *sql.DB)
 	if payload.IsAvailable != nil {
 		isAvailable = *payload.IsAvailable
 	}
+	allergens, dietaryTags, err := normalizeMenuItemDietaryFields(payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if payload.StockQuantity != nil && *payload.StockQuantity < 0 {
+		http.Error(w, "Estoque inicial não pode ser negativo", http.StatusBadRequest)
+		return
+	}
+	if payload.StockQuantity != nil && *payload.StockQuantity == 0 {
+		isAvailable = false // Sem estoque, nasce indisponível
+	}
 
-	var newItem MenuItem
-	sqlStatement := `INSERT INTO public.menu_items (name, description, price, category, image_url, is_available)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, name, description, price, category, image_url, is_available, created_at, updated_at`
-	row := appDB.QueryRow(sqlStatement, payload.Name, payload.Description, payload.Price, payload.Category, payload.ImageURL, isAvailable)
-	var dbDesc, dbCat, dbImg sql.NullString
-	err := row.Scan(&newItem.ID, &newItem.Name, &dbDesc, &newItem.Price, &dbCat, &dbImg, &newItem.IsAvailable, &newItem.CreatedAt, &newItem.UpdatedAt)
+	newItem, err := menu.CreateItem(MenuItem{
+		Name: payload.Name, Description: payload.Description, Price: payload.Price, Category: payload.Category, ImageURL: payload.ImageURL,
+		IsAvailable: isAvailable, Allergens: allergens, DietaryTags: dietaryTags, StockQuantity: payload.StockQuantity,
+	})
 	if err != nil { /* ... */
 		log.Printf("Erro DB Insert/Scan: %v", err)
 		http.Error(w, "Erro servidor", http.StatusInternalServerError)
 		return
 	}
-	if dbDesc.Valid {
-		newItem.Description = &dbDesc.String
-	}
-	if dbCat.Valid {
-		newItem.Category = &dbCat.String
-	}
-	if dbImg.Valid {
-		newItem.ImageURL = &dbImg.String
-	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(newItem)
@@ -164,15 +220,8 @@ func handleCreateMenuItem(w http.ResponseWriter, r *http.Request, appDB *sql.DB)
 // --- NOVAS FUNÇÕES HANDLER ---
 
 // handleGetMenuItemByID busca um item específico pelo ID
-func handleGetMenuItemByID(w http.ResponseWriter, r *http.Request, appDB *sql.DB, itemID string) {
-	var item MenuItem
-	sqlStatement := `SELECT id, name, description, price, category, image_url, is_available, created_at, updated_at 
-					 FROM public.menu_items WHERE id = $1;`
-
-	row := appDB.QueryRow(sqlStatement, itemID)
-	var description, category, imageURL sql.NullString
-	err := row.Scan(&item.ID, &item.Name, &description, &item.Price, &category, &imageURL, &item.IsAvailable, &item.CreatedAt, &item.UpdatedAt)
-
+func handleGetMenuItemByID(w http.ResponseWriter, r *http.Request, menu MenuRepository, itemID string) {
+	item, err := menu.GetItem(itemID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Item do cardápio não encontrado", http.StatusNotFound)
@@ -183,22 +232,12 @@ func handleGetMenuItemByID(w http.ResponseWriter, r *http.Request, appDB *sql.DB
 		return
 	}
 
-	if description.Valid {
-		item.Description = &description.String
-	}
-	if category.Valid {
-		item.Category = &category.String
-	}
-	if imageURL.Valid {
-		item.ImageURL = &imageURL.String
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(item)
 }
 
 // handleUpdateMenuItem atualiza um item existente pelo ID
-func handleUpdateMenuItem(w http.ResponseWriter, r *http.Request, appDB *sql.DB, itemID string) {
+func handleUpdateMenuItem(w http.ResponseWriter, r *http.Request, menu MenuRepository, itemID string) {
 	var payload CreateMenuItemPayload // Reutilizando o payload de criação para os campos que podem ser atualizados
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
@@ -212,22 +251,16 @@ func handleUpdateMenuItem(w http.ResponseWriter, r *http.Request, appDB *sql.DB,
 	if payload.IsAvailable != nil {
 		isAvailable = *payload.IsAvailable
 	}
+	allergens, dietaryTags, err := normalizeMenuItemDietaryFields(payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	var updatedItem MenuItem
-	sqlStatement := `
-		UPDATE public.menu_items 
-		SET name = $1, description = $2, price = $3, category = $4, image_url = $5, is_available = $6, updated_at = NOW()
-		WHERE id = $7
-		RETURNING id, name, description, price, category, image_url, is_available, created_at, updated_at;`
-
-	row := appDB.QueryRow(sqlStatement, payload.Name, payload.Description, payload.Price, payload.Category, payload.ImageURL, isAvailable, itemID)
-
-	var description, category, imageURL sql.NullString
-	err := row.Scan(
-		&updatedItem.ID, &updatedItem.Name, &description, &updatedItem.Price, &category, &imageURL,
-		&updatedItem.IsAvailable, &updatedItem.CreatedAt, &updatedItem.UpdatedAt,
-	)
-
+	updatedItem, err := menu.UpdateItem(MenuItem{
+		ID: itemID, Name: payload.Name, Description: payload.Description, Price: payload.Price, Category: payload.Category,
+		ImageURL: payload.ImageURL, IsAvailable: isAvailable, Allergens: allergens, DietaryTags: dietaryTags,
+	})
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Item do cardápio não encontrado para atualização", http.StatusNotFound)
@@ -238,27 +271,13 @@ func handleUpdateMenuItem(w http.ResponseWriter, r *http.Request, appDB *sql.DB,
 		return
 	}
 
-	if description.Valid {
-		updatedItem.Description = &description.String
-	}
-	if category.Valid {
-		updatedItem.Category = &category.String
-	}
-	if imageURL.Valid {
-		updatedItem.ImageURL = &imageURL.String
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(updatedItem)
 }
 
 // handleDeleteMenuItem deleta um item pelo ID
-func handleDeleteMenuItem(w http.ResponseWriter, r *http.Request, appDB *sql.DB, itemID string) {
-	sqlStatement := `DELETE FROM public.menu_items WHERE id = $1 RETURNING id;`
-
-	var deletedID string
-	err := appDB.QueryRow(sqlStatement, itemID).Scan(&deletedID)
-
+func handleDeleteMenuItem(w http.ResponseWriter, r *http.Request, menu MenuRepository, itemID string) {
+	err := menu.DeleteItem(itemID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Item do cardápio não encontrado para deleção", http.StatusNotFound)
@@ -269,6 +288,6 @@ func handleDeleteMenuItem(w http.ResponseWriter, r *http.Request, appDB *sql.DB,
 		return
 	}
 
-	log.Printf("Item deletado com sucesso: %s", deletedID)
+	log.Printf("Item deletado com sucesso: %s", itemID)
 	w.WriteHeader(http.StatusNoContent) // 204 No Content é uma boa resposta para DELETE bem-sucedido
 }
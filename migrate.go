@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Migrações do esquema: arquivos migrations/NNNN_nome.up.sql (e .down.sql, para reverter), embutidos no
+// binário e aplicados em ordem. As versões aplicadas ficam em public.schema_migrations.
+//
+//	server migrate up        aplica as pendentes
+//	server migrate down [N]  reverte as N últimas (padrão 1)
+//	server migrate status    lista as migrações e quando foram aplicadas
+//
+// Com MIGRATE_ON_STARTUP=true o servidor aplica as pendentes antes de começar a atender.
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey identifica o advisory lock das migrações: instâncias subindo ao mesmo tempo
+// esperam umas pelas outras em vez de aplicar a mesma migração duas vezes
+const migrationLockKey int64 = 0x63616e74696e61 // "cantina"
+
+var migrationFileName = regexp.MustCompile(`^(\d{4})_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string // Vazio = migração irreversível
+}
+
+// MigrationStatus é uma linha de 'migrate status'
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+	Unknown   bool // Aplicada no banco, mas ausente deste binário (banco migrado por uma versão mais nova)
+}
+
+// loadMigrations lê as migrações de 'fsys' (diretório migrations/), em ordem de versão.
+// Exige versões contínuas a partir de 1 e um .up.sql para cada versão.
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("erro ao listar migrações: %w", err)
+	}
+
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("nome de migração inválido: %s (use NNNN_nome.up.sql / NNNN_nome.down.sql)", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, "migrations/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler migração %s: %w", entry.Name(), err)
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("versão %04d usada por duas migrações: %s e %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migrações fora de sequência: esperada a versão %04d, encontrada %04d", i+1, m.Version)
+		}
+		if strings.TrimSpace(m.Up) == "" {
+			return nil, fmt.Errorf("migração %04d_%s sem o arquivo .up.sql", m.Version, m.Name)
+		}
+	}
+	return migrations, nil
+}
+
+// withMigrationLock roda 'fn' numa conexão dedicada segurando o advisory lock das migrações
+// (o lock é da sessão, por isso todas as operações usam a mesma conexão)
+func withMigrationLock(ctx context.Context, appDB *sql.DB, fn func(conn *sql.Conn) error) error {
+	conn, err := appDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("erro ao obter conexão para as migrações: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("erro ao obter o lock das migrações: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey); err != nil {
+			log.Printf("Alerta: erro ao liberar o lock das migrações: %v", err)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS public.schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);`)
+	if err != nil {
+		return fmt.Errorf("erro ao criar schema_migrations: %w", err)
+	}
+	return fn(conn)
+}
+
+// appliedMigrations retorna as versões aplicadas, com o nome e a data
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]MigrationStatus, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, applied_at FROM public.schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar migrações aplicadas: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]MigrationStatus{}
+	for rows.Next() {
+		var status MigrationStatus
+		var appliedAt time.Time
+		if err := rows.Scan(&status.Version, &status.Name, &appliedAt); err != nil {
+			return nil, fmt.Errorf("erro ao scanear migração aplicada: %w", err)
+		}
+		status.AppliedAt = &appliedAt
+		applied[status.Version] = status
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro após iterar migrações aplicadas: %w", err)
+	}
+	return applied, nil
+}
+
+// runMigration aplica (up) ou reverte (down) uma migração e atualiza schema_migrations, na mesma transação
+func runMigration(ctx context.Context, conn *sql.Conn, m migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("erro ao iniciar transação da migração %04d_%s: %w", m.Version, m.Name, err)
+	}
+	defer tx.Rollback()
+
+	script, bookkeeping, args := m.Up, "INSERT INTO public.schema_migrations (version, name) VALUES ($1, $2)", []interface{}{m.Version, m.Name}
+	if !up {
+		script, bookkeeping, args = m.Down, "DELETE FROM public.schema_migrations WHERE version = $1", []interface{}{m.Version}
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("erro na migração %04d_%s: %w", m.Version, m.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		return fmt.Errorf("erro ao registrar a migração %04d_%s: %w", m.Version, m.Name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("erro ao confirmar a migração %04d_%s: %w", m.Version, m.Name, err)
+	}
+	return nil
+}
+
+// migrateUp aplica as migrações pendentes, em ordem, e retorna as que foram aplicadas
+func migrateUp(ctx context.Context, appDB *sql.DB, migrations []migration) ([]migration, error) {
+	var done []migration
+	err := withMigrationLock(ctx, appDB, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, m, true); err != nil {
+				return err
+			}
+			log.Printf("Migração %04d_%s aplicada.", m.Version, m.Name)
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// migrateDown reverte as 'steps' últimas migrações aplicadas e retorna as que foram revertidas
+func migrateDown(ctx context.Context, appDB *sql.DB, migrations []migration, steps int) ([]migration, error) {
+	byVersion := map[int]migration{}
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	var done []migration
+	err := withMigrationLock(ctx, appDB, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+		for _, version := range versions {
+			if len(done) == steps {
+				break
+			}
+			m, known := byVersion[version]
+			if !known {
+				return fmt.Errorf("migração %04d_%s não existe neste binário; reverta com a versão que a aplicou", version, applied[version].Name)
+			}
+			if strings.TrimSpace(m.Down) == "" {
+				return fmt.Errorf("migração %04d_%s não tem .down.sql e não pode ser revertida", m.Version, m.Name)
+			}
+			if err := runMigration(ctx, conn, m, false); err != nil {
+				return err
+			}
+			log.Printf("Migração %04d_%s revertida.", m.Version, m.Name)
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// migrationStatus lista as migrações do binário e as aplicadas no banco, em ordem de versão
+func migrationStatus(ctx context.Context, appDB *sql.DB, migrations []migration) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := withMigrationLock(ctx, appDB, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			status := MigrationStatus{Version: m.Version, Name: m.Name}
+			if a, ok := applied[m.Version]; ok {
+				status.AppliedAt = a.AppliedAt
+				delete(applied, m.Version)
+			}
+			statuses = append(statuses, status)
+		}
+		for _, a := range applied {
+			a.Unknown = true
+			statuses = append(statuses, a)
+		}
+		sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+		return nil
+	})
+	return statuses, err
+}
+
+// runMigrateCommand trata 'server migrate up|down [N]|status'
+func runMigrateCommand(appDB *sql.DB, args []string, out io.Writer) error {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	if len(args) == 0 {
+		return fmt.Errorf("uso: migrate up | migrate down [N] | migrate status")
+	}
+	switch args[0] {
+	case "up":
+		done, err := migrateUp(ctx, appDB, migrations)
+		fmt.Fprintf(out, "%d migração(ões) aplicada(s).\n", len(done))
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("número de migrações a reverter inválido: %s", args[1])
+			}
+		}
+		done, err := migrateDown(ctx, appDB, migrations, steps)
+		fmt.Fprintf(out, "%d migração(ões) revertida(s).\n", len(done))
+		return err
+	case "status":
+		statuses, err := migrationStatus(ctx, appDB, migrations)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSÃO\tNOME\tAPLICADA EM")
+		for _, status := range statuses {
+			appliedAt := "pendente"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			if status.Unknown {
+				appliedAt += " (desconhecida neste binário)"
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("subcomando desconhecido: %s (use up, down ou status)", args[0])
+	}
+}
+
+// loadMigrateOnStartupFromEnv lê MIGRATE_ON_STARTUP (padrão: false, as migrações rodam com 'server migrate up')
+func loadMigrateOnStartupFromEnv() (bool, error) {
+	raw := strings.TrimSpace(os.Getenv("MIGRATE_ON_STARTUP"))
+	if raw == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("MIGRATE_ON_STARTUP inválido (%q): use true ou false", raw)
+	}
+	return enabled, nil
+}
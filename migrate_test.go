@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEmbeddedMigrationsLoad(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+	if len(migrations) == 0 || migrations[0].Name != "baseline" {
+		t.Fatalf("a primeira migração deve ser a baseline, veio %+v", migrations)
+	}
+	for _, m := range migrations {
+		if strings.TrimSpace(m.Down) == "" {
+			t.Errorf("migração %04d_%s sem .down.sql", m.Version, m.Name)
+		}
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	file := func(content string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(content)} }
+
+	migrations, err := loadMigrations(fstest.MapFS{
+		"migrations/0002_add_x.up.sql":      file("ALTER TABLE t ADD COLUMN x INT;"),
+		"migrations/0001_create_t.up.sql":   file("CREATE TABLE t ();"),
+		"migrations/0001_create_t.down.sql": file("DROP TABLE t;"),
+	})
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+	if len(migrations) != 2 || migrations[0].Name != "create_t" || migrations[0].Down != "DROP TABLE t;" || migrations[1].Down != "" {
+		t.Fatalf("migrações inesperadas: %+v", migrations)
+	}
+
+	invalid := map[string]fstest.MapFS{
+		"lacuna na sequência": {
+			"migrations/0001_a.up.sql": file("SELECT 1;"),
+			"migrations/0003_c.up.sql": file("SELECT 1;"),
+		},
+		"sem .up.sql": {
+			"migrations/0001_a.down.sql": file("SELECT 1;"),
+		},
+		"versão repetida": {
+			"migrations/0001_a.up.sql": file("SELECT 1;"),
+			"migrations/0001_b.up.sql": file("SELECT 1;"),
+		},
+		"nome inválido": {
+			"migrations/1_a.sql": file("SELECT 1;"),
+		},
+	}
+	for name, fsys := range invalid {
+		if _, err := loadMigrations(fsys); err == nil {
+			t.Errorf("%s: esperado erro", name)
+		}
+	}
+}
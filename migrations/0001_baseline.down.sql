@@ -0,0 +1,8 @@
+-- Remove o esquema base INTEIRO, com os dados. Só faz sentido em bancos de desenvolvimento/teste.
+
+DROP TABLE IF EXISTS public.order_items;
+DROP TABLE IF EXISTS public.orders;
+DROP TABLE IF EXISTS public.menu_items;
+DROP TABLE IF EXISTS public.students;
+DROP TABLE IF EXISTS public.classes;
+DROP TABLE IF EXISTS public.users;
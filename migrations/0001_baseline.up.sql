@@ -0,0 +1,71 @@
+-- Esquema base: as tabelas que existiam antes das migrações versionadas (criadas à mão no Supabase).
+-- Tudo é IF NOT EXISTS: num banco já em produção esta migração só é registrada em schema_migrations.
+
+CREATE EXTENSION IF NOT EXISTS pgcrypto; -- gen_random_uuid() em versões antigas do Postgres
+
+-- Perfis dos usuários. No Supabase o id é o mesmo de auth.users (sub do JWT).
+CREATE TABLE IF NOT EXISTS public.users (
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    full_name  TEXT NULL,
+    email      TEXT NULL UNIQUE,
+    credits    NUMERIC(10,2) NOT NULL DEFAULT 0, -- Saldo consolidado (o extrato fica em credit_transactions)
+    role       TEXT NOT NULL DEFAULT 'client',   -- Ver normalizeRole (authz.go); aceita valores legados como CLIENTE
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS public.classes (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name        TEXT NOT NULL CONSTRAINT classes_name_key UNIQUE,
+    description TEXT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- parent_user_id é o responsável principal. Os nomes das FKs são usados pela API para traduzir erros.
+CREATE TABLE IF NOT EXISTS public.students (
+    id             UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name           TEXT NOT NULL,
+    class_id       UUID NULL CONSTRAINT students_class_id_fkey REFERENCES public.classes(id),
+    parent_user_id UUID NOT NULL CONSTRAINT students_parent_user_id_fkey REFERENCES public.users(id),
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS public.menu_items (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name         TEXT NOT NULL,
+    description  TEXT NULL,
+    price        NUMERIC(10,2) NOT NULL CHECK (price > 0),
+    category     TEXT NULL,
+    image_url    TEXT NULL,
+    is_available BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- student_id é anulável: pedidos anteriores ao vínculo com alunos não têm aluno
+CREATE TABLE IF NOT EXISTS public.orders (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id      UUID NOT NULL REFERENCES public.users(id),
+    student_id   UUID NULL REFERENCES public.students(id) ON DELETE SET NULL,
+    order_date   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    total_amount NUMERIC(10,2) NOT NULL CHECK (total_amount >= 0),
+    status       TEXT NOT NULL DEFAULT 'PENDING',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS orders_user_order_date_idx ON public.orders (user_id, order_date DESC);
+CREATE INDEX IF NOT EXISTS orders_student_idx ON public.orders (student_id);
+
+CREATE TABLE IF NOT EXISTS public.order_items (
+    id                UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id          UUID NOT NULL REFERENCES public.orders(id) ON DELETE CASCADE,
+    menu_item_id      UUID NOT NULL REFERENCES public.menu_items(id),
+    quantity          INTEGER NOT NULL CHECK (quantity > 0),
+    price_at_purchase NUMERIC(10,2) NOT NULL, -- Preço no momento do pedido
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS order_items_order_idx ON public.order_items (order_id);
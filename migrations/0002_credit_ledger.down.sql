@@ -0,0 +1,4 @@
+-- O saldo consolidado continua em users.credits; só o extrato é perdido.
+
+DROP TABLE IF EXISTS public.credit_transactions;
+DROP FUNCTION IF EXISTS public.credit_transactions_append_only();
@@ -0,0 +1,38 @@
+-- Extrato de créditos (ledger) append-only.
+-- Cada movimentação de saldo gera uma linha; public.users.credits passa a ser
+-- apenas o saldo consolidado, sempre atualizado na mesma transação do lançamento.
+
+CREATE TABLE IF NOT EXISTS public.credit_transactions (
+    id            UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id       UUID NOT NULL REFERENCES public.users(id),
+    type          TEXT NOT NULL CHECK (type IN ('ORDER_DEBIT', 'TOPUP', 'REFUND', 'ADJUSTMENT')),
+    amount        NUMERIC(10,2) NOT NULL CHECK (amount <> 0), -- negativo = débito, positivo = crédito
+    balance_after NUMERIC(10,2) NOT NULL,
+    order_id      UUID NULL REFERENCES public.orders(id),
+    description   TEXT NULL,
+    created_by    UUID NULL REFERENCES public.users(id),
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS credit_transactions_user_created_idx
+    ON public.credit_transactions (user_id, created_at DESC);
+
+-- Impede UPDATE/DELETE: correções são feitas com novos lançamentos do tipo ADJUSTMENT.
+CREATE OR REPLACE FUNCTION public.credit_transactions_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'credit_transactions é append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS credit_transactions_append_only ON public.credit_transactions;
+CREATE TRIGGER credit_transactions_append_only
+    BEFORE UPDATE OR DELETE ON public.credit_transactions
+    FOR EACH ROW EXECUTE FUNCTION public.credit_transactions_append_only();
+
+-- Saldo de abertura: os saldos existentes viram um ajuste inicial para que
+-- SUM(amount) do extrato bata com users.credits desde o primeiro dia.
+INSERT INTO public.credit_transactions (user_id, type, amount, balance_after, description)
+SELECT u.id, 'ADJUSTMENT', u.credits, u.credits, 'Saldo de abertura do extrato'
+FROM public.users u
+WHERE u.credits <> 0
+  AND NOT EXISTS (SELECT 1 FROM public.credit_transactions ct WHERE ct.user_id = u.id);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS public.credit_transactions_refund_order_uidx;
+
+ALTER TABLE public.orders
+    DROP COLUMN IF EXISTS canceled_at,
+    DROP COLUMN IF EXISTS canceled_by,
+    DROP COLUMN IF EXISTS cancel_reason;
@@ -0,0 +1,12 @@
+-- Cancelamento de pedidos: quem cancelou, quando e por quê.
+-- O estorno em si fica registrado em credit_transactions (type = 'REFUND', order_id = pedido).
+
+ALTER TABLE public.orders
+    ADD COLUMN IF NOT EXISTS canceled_at   TIMESTAMPTZ NULL,
+    ADD COLUMN IF NOT EXISTS canceled_by   UUID NULL REFERENCES public.users(id),
+    ADD COLUMN IF NOT EXISTS cancel_reason TEXT NULL;
+
+-- Garante no máximo um estorno por pedido, mesmo com cancelamentos concorrentes
+CREATE UNIQUE INDEX IF NOT EXISTS credit_transactions_refund_order_uidx
+    ON public.credit_transactions (order_id)
+    WHERE type = 'REFUND';
@@ -0,0 +1,3 @@
+ALTER TABLE public.orders DROP CONSTRAINT IF EXISTS orders_status_check;
+
+DROP TABLE IF EXISTS public.order_status_history;
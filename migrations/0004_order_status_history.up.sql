@@ -0,0 +1,32 @@
+-- Histórico de transições de status dos pedidos (quem mudou, de onde, para onde e quando).
+
+CREATE TABLE IF NOT EXISTS public.order_status_history (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id    UUID NOT NULL REFERENCES public.orders(id) ON DELETE CASCADE,
+    from_status TEXT NULL, -- NULL na criação do pedido
+    to_status   TEXT NOT NULL,
+    changed_by  UUID NULL REFERENCES public.users(id),
+    reason      TEXT NULL,
+    changed_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS order_status_history_order_idx
+    ON public.order_status_history (order_id, changed_at);
+
+ALTER TABLE public.orders
+    DROP CONSTRAINT IF EXISTS orders_status_check,
+    ADD CONSTRAINT orders_status_check
+        CHECK (status IN ('PENDING', 'PREPARING', 'READY', 'COMPLETED', 'CANCELED'));
+
+-- Histórico retroativo para pedidos existentes: criação e, se já avançaram, o status atual.
+-- Os passos intermediários anteriores a esta tabela não são conhecidos.
+INSERT INTO public.order_status_history (order_id, from_status, to_status, changed_by, changed_at)
+SELECT o.id, NULL, 'PENDING', o.user_id, o.created_at
+FROM public.orders o
+WHERE NOT EXISTS (SELECT 1 FROM public.order_status_history h WHERE h.order_id = o.id);
+
+INSERT INTO public.order_status_history (order_id, from_status, to_status, changed_at)
+SELECT o.id, 'PENDING', o.status, o.updated_at
+FROM public.orders o
+WHERE o.status <> 'PENDING'
+  AND NOT EXISTS (SELECT 1 FROM public.order_status_history h WHERE h.order_id = o.id AND h.from_status IS NOT NULL);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS public.credit_transactions_topup_uidx;
+ALTER TABLE public.credit_transactions DROP COLUMN IF EXISTS topup_id;
+
+DROP TABLE IF EXISTS public.credit_topups;
@@ -0,0 +1,31 @@
+-- Recargas de créditos via provedor de pagamento (PIX).
+-- A recarga nasce PENDING; o webhook do provedor a marca como PAID e lança o crédito no extrato.
+
+CREATE TABLE IF NOT EXISTS public.credit_topups (
+    id                  UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id             UUID NOT NULL REFERENCES public.users(id),
+    amount              NUMERIC(10,2) NOT NULL CHECK (amount > 0),
+    status              TEXT NOT NULL DEFAULT 'PENDING' CHECK (status IN ('PENDING', 'PAID', 'EXPIRED', 'FAILED')),
+    provider            TEXT NOT NULL,
+    provider_charge_id  TEXT NULL,
+    qr_code_payload     TEXT NULL, -- "PIX copia e cola" (BR Code)
+    expires_at          TIMESTAMPTZ NULL,
+    paid_at             TIMESTAMPTZ NULL,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at          TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS credit_topups_user_created_idx
+    ON public.credit_topups (user_id, created_at DESC);
+
+CREATE UNIQUE INDEX IF NOT EXISTS credit_topups_provider_charge_uidx
+    ON public.credit_topups (provider, provider_charge_id)
+    WHERE provider_charge_id IS NOT NULL;
+
+-- Liga o lançamento de crédito à recarga; o índice único garante crédito no máximo uma vez por recarga
+ALTER TABLE public.credit_transactions
+    ADD COLUMN IF NOT EXISTS topup_id UUID NULL REFERENCES public.credit_topups(id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS credit_transactions_topup_uidx
+    ON public.credit_transactions (topup_id)
+    WHERE topup_id IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.student_blocked_items;
+DROP TABLE IF EXISTS public.student_spending_limits;
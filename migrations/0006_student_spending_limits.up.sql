@@ -0,0 +1,25 @@
+-- Limites de gasto e itens bloqueados por aluno, definidos pelo responsável.
+
+CREATE TABLE IF NOT EXISTS public.student_spending_limits (
+    student_id   UUID PRIMARY KEY REFERENCES public.students(id) ON DELETE CASCADE,
+    daily_limit  NUMERIC(10,2) NULL CHECK (daily_limit >= 0),  -- NULL = sem limite
+    weekly_limit NUMERIC(10,2) NULL CHECK (weekly_limit >= 0), -- semana de segunda a domingo
+    updated_by   UUID NULL REFERENCES public.users(id),
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Cada linha bloqueia um item específico OU uma categoria inteira (ex: "Refrigerantes")
+CREATE TABLE IF NOT EXISTS public.student_blocked_items (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    student_id   UUID NOT NULL REFERENCES public.students(id) ON DELETE CASCADE,
+    menu_item_id UUID NULL REFERENCES public.menu_items(id) ON DELETE CASCADE,
+    category     TEXT NULL,
+    created_by   UUID NULL REFERENCES public.users(id),
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK ((menu_item_id IS NULL) <> (category IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS student_blocked_items_item_uidx
+    ON public.student_blocked_items (student_id, menu_item_id) WHERE menu_item_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS student_blocked_items_category_uidx
+    ON public.student_blocked_items (student_id, lower(category)) WHERE category IS NOT NULL;
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS public.student_dietary_overrides;
+
+ALTER TABLE public.students
+    DROP COLUMN IF EXISTS allergies,
+    DROP COLUMN IF EXISTS dietary_requirements;
+
+ALTER TABLE public.menu_items
+    DROP COLUMN IF EXISTS allergens,
+    DROP COLUMN IF EXISTS dietary_tags;
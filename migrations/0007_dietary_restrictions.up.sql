@@ -0,0 +1,23 @@
+-- Alérgenos e marcações alimentares nos itens do cardápio, restrições nos alunos
+-- e liberações explícitas feitas pela equipe.
+
+ALTER TABLE public.menu_items
+    ADD COLUMN IF NOT EXISTS allergens    TEXT[] NOT NULL DEFAULT '{}', -- o que o item CONTÉM (ex: gluten, lactose)
+    ADD COLUMN IF NOT EXISTS dietary_tags TEXT[] NOT NULL DEFAULT '{}'; -- o que o item É (ex: vegetarian, vegan)
+
+CREATE INDEX IF NOT EXISTS menu_items_allergens_idx ON public.menu_items USING GIN (allergens);
+CREATE INDEX IF NOT EXISTS menu_items_dietary_tags_idx ON public.menu_items USING GIN (dietary_tags);
+
+ALTER TABLE public.students
+    ADD COLUMN IF NOT EXISTS allergies            TEXT[] NOT NULL DEFAULT '{}', -- alérgenos que o aluno não pode consumir
+    ADD COLUMN IF NOT EXISTS dietary_requirements TEXT[] NOT NULL DEFAULT '{}'; -- marcações exigidas (ex: vegetarian)
+
+-- Item liberado pela equipe para um aluno mesmo conflitando com suas restrições
+CREATE TABLE IF NOT EXISTS public.student_dietary_overrides (
+    student_id   UUID NOT NULL REFERENCES public.students(id) ON DELETE CASCADE,
+    menu_item_id UUID NOT NULL REFERENCES public.menu_items(id) ON DELETE CASCADE,
+    approved_by  UUID NOT NULL REFERENCES public.users(id),
+    note         TEXT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (student_id, menu_item_id)
+);
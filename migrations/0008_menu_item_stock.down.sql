@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS public.stock_movements;
+
+ALTER TABLE public.menu_items DROP COLUMN IF EXISTS stock_quantity;
@@ -0,0 +1,21 @@
+-- Controle de estoque opcional por item do cardápio.
+-- stock_quantity NULL = item sem controle de estoque (comportamento anterior, só is_available).
+
+ALTER TABLE public.menu_items
+    ADD COLUMN IF NOT EXISTS stock_quantity INTEGER NULL CHECK (stock_quantity >= 0);
+
+-- Toda alteração de estoque fica registrada: pedidos, cancelamentos, reposições, perdas e contagens
+CREATE TABLE IF NOT EXISTS public.stock_movements (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    menu_item_id    UUID NOT NULL REFERENCES public.menu_items(id) ON DELETE CASCADE,
+    type            TEXT NOT NULL CHECK (type IN ('ORDER', 'CANCEL', 'RESTOCK', 'WASTE', 'COUNT')),
+    quantity_change INTEGER NOT NULL,
+    quantity_after  INTEGER NOT NULL,
+    order_id        UUID NULL REFERENCES public.orders(id) ON DELETE SET NULL,
+    note            TEXT NULL,
+    created_by      UUID NULL REFERENCES public.users(id),
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS stock_movements_item_created_idx
+    ON public.stock_movements (menu_item_id, created_at DESC);
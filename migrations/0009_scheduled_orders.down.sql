@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS public.orders_scheduled_for_slot_idx;
+
+ALTER TABLE public.orders
+    DROP COLUMN IF EXISTS scheduled_for,
+    DROP COLUMN IF EXISTS pickup_slot_id;
+
+DROP TABLE IF EXISTS public.pickup_slots;
@@ -0,0 +1,41 @@
+-- Encomendas agendadas: data de serviço e horário de retirada (recreio/almoço) por pedido.
+
+-- Horários de retirada configuráveis pela administração.
+-- O prazo (cut-off) de um pedido é: (data de serviço - cutoff_days_before dias) às cutoff_time, no fuso da escola.
+CREATE TABLE IF NOT EXISTS public.pickup_slots (
+    id                 UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    code               TEXT NOT NULL UNIQUE,
+    name               TEXT NOT NULL,
+    service_time       TIME NOT NULL,
+    cutoff_time        TIME NOT NULL,
+    cutoff_days_before INTEGER NOT NULL DEFAULT 0 CHECK (cutoff_days_before >= 0),
+    is_active          BOOLEAN NOT NULL DEFAULT TRUE,
+    sort_order         INTEGER NOT NULL DEFAULT 0,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO public.pickup_slots (code, name, service_time, cutoff_time, cutoff_days_before, sort_order) VALUES
+    ('recreio_1', 'Recreio 1', '09:30', '08:00', 0, 10),
+    ('almoco',    'Almoço',    '12:00', '10:00', 0, 20),
+    ('recreio_2', 'Recreio 2', '15:30', '13:00', 0, 30)
+ON CONFLICT (code) DO NOTHING;
+
+-- scheduled_for: dia em que o pedido será servido (pedidos imediatos usam o próprio dia).
+-- pickup_slot_id NULL = pedido imediato, sem horário de retirada.
+ALTER TABLE public.orders
+    ADD COLUMN IF NOT EXISTS scheduled_for  DATE NULL,
+    ADD COLUMN IF NOT EXISTS pickup_slot_id UUID NULL REFERENCES public.pickup_slots(id);
+
+-- Carga dos pedidos existentes: o fuso está fixo em America/Sao_Paulo, o padrão de CANTINA_TIMEZONE (ver
+-- loadSchoolLocationFromEnv). Instalações com outro CANTINA_TIMEZONE devem corrigir scheduled_for dos pedidos
+-- antigos com o próprio fuso; a migração não tem acesso à configuração da aplicação.
+UPDATE public.orders
+SET scheduled_for = (order_date AT TIME ZONE 'America/Sao_Paulo')::date
+WHERE scheduled_for IS NULL;
+
+ALTER TABLE public.orders ALTER COLUMN scheduled_for SET NOT NULL;
+
+-- Lista da cozinha: pedidos por dia de serviço e horário
+CREATE INDEX IF NOT EXISTS orders_scheduled_for_slot_idx
+    ON public.orders (scheduled_for, pickup_slot_id);
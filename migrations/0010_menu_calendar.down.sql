@@ -0,0 +1 @@
+DROP TABLE IF EXISTS public.menu_calendar_entries;
@@ -0,0 +1,30 @@
+-- Calendário do cardápio: quais itens são servidos em quais dias (recorrentes por dia da semana ou em datas
+-- específicas) e, opcionalmente, em quais horários de retirada. Um item fora do calendário não pode ser pedido.
+
+CREATE TABLE IF NOT EXISTS public.menu_calendar_entries (
+    id             UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    menu_item_id   UUID NOT NULL REFERENCES public.menu_items(id) ON DELETE CASCADE,
+    weekday        SMALLINT NULL CHECK (weekday BETWEEN 1 AND 7), -- ISO: 1 = segunda ... 7 = domingo
+    service_date   DATE NULL,                                     -- Data específica (em vez de weekday)
+    pickup_slot_id UUID NULL REFERENCES public.pickup_slots(id) ON DELETE CASCADE, -- NULL = todos os horários
+    starts_on      DATE NULL,                                     -- Vigência das entradas por weekday
+    ends_on        DATE NULL,
+    is_excluded    BOOLEAN NOT NULL DEFAULT FALSE,                -- Exceção: item NÃO servido em service_date
+    created_by     UUID NULL REFERENCES public.users(id),
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK ((weekday IS NULL) <> (service_date IS NULL)),
+    CHECK (NOT is_excluded OR service_date IS NOT NULL),
+    CHECK (starts_on IS NULL OR ends_on IS NULL OR starts_on <= ends_on)
+);
+
+CREATE INDEX IF NOT EXISTS menu_calendar_entries_date_idx ON public.menu_calendar_entries (service_date);
+CREATE INDEX IF NOT EXISTS menu_calendar_entries_weekday_idx ON public.menu_calendar_entries (weekday);
+CREATE INDEX IF NOT EXISTS menu_calendar_entries_item_idx ON public.menu_calendar_entries (menu_item_id);
+
+-- Carga inicial: para não bloquear os pedidos na virada, todo item já cadastrado passa a ser servido de segunda a sexta.
+-- Itens criados depois precisam ser incluídos no calendário pela administração.
+INSERT INTO public.menu_calendar_entries (menu_item_id, weekday)
+SELECT mi.id, wd
+FROM public.menu_items mi
+CROSS JOIN generate_series(1, 5) AS wd
+WHERE NOT EXISTS (SELECT 1 FROM public.menu_calendar_entries);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS public.orders_open_pickup_code_key;
+
+ALTER TABLE public.orders
+    DROP COLUMN IF EXISTS pickup_code,
+    DROP COLUMN IF EXISTS picked_up_at,
+    DROP COLUMN IF EXISTS picked_up_by;
@@ -0,0 +1,17 @@
+-- Código de retirada por pedido: o responsável repassa o código (ou o QR) ao aluno, e a equipe o valida
+-- no balcão antes de entregar o pedido.
+
+ALTER TABLE public.orders
+    ADD COLUMN IF NOT EXISTS pickup_code  TEXT NULL,
+    ADD COLUMN IF NOT EXISTS picked_up_at TIMESTAMPTZ NULL,
+    ADD COLUMN IF NOT EXISTS picked_up_by UUID NULL REFERENCES public.users(id);
+
+-- Pedidos em aberto que já existiam recebem um código
+UPDATE public.orders
+SET pickup_code = upper(substr(md5(random()::text || id::text), 1, 6))
+WHERE pickup_code IS NULL AND status IN ('PENDING', 'PREPARING', 'READY');
+
+-- O código identifica o pedido entre os pedidos em aberto; depois de retirado/cancelado pode ser reutilizado
+CREATE UNIQUE INDEX IF NOT EXISTS orders_open_pickup_code_key
+    ON public.orders (pickup_code)
+    WHERE status IN ('PENDING', 'PREPARING', 'READY');
@@ -0,0 +1,18 @@
+-- Idempotency-Key: guarda a impressão digital da requisição e a resposta original, para que reenvios
+-- (ex: retentativas do app em Wi-Fi instável) devolvam o mesmo resultado em vez de repetir o efeito.
+
+CREATE TABLE IF NOT EXISTS public.idempotency_keys (
+    user_id         UUID NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    key             TEXT NOT NULL,
+    request_hash    TEXT NOT NULL,                -- sha256 de método + caminho + corpo
+    status          TEXT NOT NULL DEFAULT 'IN_PROGRESS' CHECK (status IN ('IN_PROGRESS', 'COMPLETED')),
+    response_status INTEGER NULL,
+    response_body   BYTEA NULL,
+    content_type    TEXT NULL,
+    locked_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at      TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_idx ON public.idempotency_keys (expires_at);
@@ -0,0 +1,20 @@
+-- Chaves de API de dispositivos (tablet do balcão, totens): aceitas no lugar do JWT em
+-- "Authorization: Bearer cdk_...". A chave age em nome de 'user_id' (para o histórico/auditoria)
+-- e só acessa as rotas cujas permissões estão em 'scopes'. Só o hash SHA-256 da chave é guardado.
+
+CREATE TABLE IF NOT EXISTS public.device_api_keys (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name         TEXT NOT NULL,
+    key_prefix   TEXT NOT NULL,                -- Início da chave, para identificá-la nas listagens
+    key_hash     TEXT NOT NULL UNIQUE,         -- sha256 (hex) da chave completa
+    user_id      UUID NOT NULL REFERENCES public.users(id),
+    scopes       TEXT[] NOT NULL,              -- Permissões (ver authz.go), ex: {orders_view,orders_manage}
+    created_by   UUID NOT NULL REFERENCES public.users(id),
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ NULL,
+    last_used_ip TEXT NULL,
+    revoked_at   TIMESTAMPTZ NULL,
+    revoked_by   UUID NULL REFERENCES public.users(id)
+);
+
+CREATE INDEX IF NOT EXISTS device_api_keys_user_idx ON public.device_api_keys (user_id);
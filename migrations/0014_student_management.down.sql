@@ -0,0 +1,10 @@
+-- Alunos arquivados voltam a aparecer como ativos.
+
+DROP TABLE IF EXISTS public.student_class_transfers;
+
+DROP INDEX IF EXISTS public.students_active_parent_idx;
+DROP INDEX IF EXISTS public.students_class_idx;
+
+ALTER TABLE public.students
+    DROP COLUMN IF EXISTS archived_at,
+    DROP COLUMN IF EXISTS archived_by;
@@ -0,0 +1,21 @@
+-- Gestão de alunos: arquivamento (exclusão lógica, para que pedidos antigos continuem apontando para
+-- um aluno válido) e histórico de transferências de turma.
+
+ALTER TABLE public.students
+    ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ NULL,
+    ADD COLUMN IF NOT EXISTS archived_by UUID NULL REFERENCES public.users(id);
+
+CREATE INDEX IF NOT EXISTS students_class_idx ON public.students (class_id);
+CREATE INDEX IF NOT EXISTS students_active_parent_idx ON public.students (parent_user_id) WHERE archived_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS public.student_class_transfers (
+    id             UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    student_id     UUID NOT NULL REFERENCES public.students(id),
+    from_class_id  UUID NULL REFERENCES public.classes(id) ON DELETE SET NULL,
+    to_class_id    UUID NULL REFERENCES public.classes(id) ON DELETE SET NULL,
+    reason         TEXT NULL,
+    transferred_by UUID NULL REFERENCES public.users(id),
+    transferred_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS student_class_transfers_student_idx ON public.student_class_transfers (student_id, transferred_at);
@@ -0,0 +1,3 @@
+-- O responsável principal continua em students.parent_user_id; os demais responsáveis são perdidos.
+
+DROP TABLE IF EXISTS public.student_guardians;
@@ -0,0 +1,23 @@
+-- Responsáveis por aluno: pais separados, avós etc. podem pedir e pagar pelo mesmo aluno.
+--   primary:   responsável principal (= students.parent_user_id); pede, paga, define limites e convida/revoga os demais
+--   secondary: pede, paga e atualiza restrições alimentares
+--   viewer:    apenas consulta
+-- O responsável principal continua em students.parent_user_id (mantido em sincronia pela API).
+
+CREATE TABLE IF NOT EXISTS public.student_guardians (
+    student_id UUID NOT NULL REFERENCES public.students(id) ON DELETE CASCADE,
+    user_id    UUID NOT NULL REFERENCES public.users(id),
+    role       TEXT NOT NULL CHECK (role IN ('primary', 'secondary', 'viewer')),
+    invited_by UUID NULL REFERENCES public.users(id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (student_id, user_id)
+);
+
+-- Um único responsável principal por aluno
+CREATE UNIQUE INDEX IF NOT EXISTS student_guardians_one_primary_idx ON public.student_guardians (student_id) WHERE role = 'primary';
+CREATE INDEX IF NOT EXISTS student_guardians_user_idx ON public.student_guardians (user_id);
+
+-- Carga inicial: o responsável atual de cada aluno vira o principal
+INSERT INTO public.student_guardians (student_id, user_id, role)
+SELECT id, parent_user_id, 'primary' FROM public.students WHERE parent_user_id IS NOT NULL
+ON CONFLICT (student_id, user_id) DO NOTHING;
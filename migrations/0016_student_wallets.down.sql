@@ -0,0 +1,12 @@
+-- Só reverter com as carteiras zeradas: o saldo dos alunos não volta automaticamente para os responsáveis.
+-- Falha (e nada é revertido) se o extrato de créditos já tiver lançamentos STUDENT_TRANSFER.
+
+ALTER TABLE public.credit_transactions DROP COLUMN IF EXISTS student_id;
+ALTER TABLE public.credit_transactions DROP CONSTRAINT IF EXISTS credit_transactions_type_check;
+ALTER TABLE public.credit_transactions ADD CONSTRAINT credit_transactions_type_check
+    CHECK (type IN ('ORDER_DEBIT', 'TOPUP', 'REFUND', 'ADJUSTMENT'));
+
+DROP TABLE IF EXISTS public.student_wallet_transactions;
+DROP FUNCTION IF EXISTS public.student_wallet_transactions_append_only();
+DROP TABLE IF EXISTS public.student_allowances;
+DROP TABLE IF EXISTS public.student_wallets;
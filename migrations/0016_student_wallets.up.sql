@@ -0,0 +1,71 @@
+-- Carteiras por aluno (opcionais): saldo abastecido pela carteira de um responsável, por transferência
+-- manual ou mesada recorrente. Ao pedir, o aluno paga primeiro com a própria carteira e o restante sai
+-- do responsável que fez o pedido, conforme funding_policy:
+--   student_then_guardian: carteira do aluno primeiro, o que faltar sai do responsável (padrão)
+--   student_only:          só a carteira do aluno; sem saldo suficiente o pedido é recusado
+--   guardian_only:         ignora a carteira do aluno (comportamento anterior)
+
+CREATE TABLE IF NOT EXISTS public.student_wallets (
+    student_id     UUID PRIMARY KEY REFERENCES public.students(id) ON DELETE CASCADE,
+    balance        NUMERIC(10,2) NOT NULL DEFAULT 0 CHECK (balance >= 0),
+    funding_policy TEXT NOT NULL DEFAULT 'student_then_guardian'
+                   CHECK (funding_policy IN ('student_then_guardian', 'student_only', 'guardian_only')),
+    updated_by     UUID NULL REFERENCES public.users(id),
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Mesadas: todo 'weekday' (ISO: 1 = segunda ... 7 = domingo) transfere 'amount' da carteira de 'funded_by'
+CREATE TABLE IF NOT EXISTS public.student_allowances (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    student_id  UUID NOT NULL REFERENCES public.students(id) ON DELETE CASCADE,
+    funded_by   UUID NOT NULL REFERENCES public.users(id),
+    amount      NUMERIC(10,2) NOT NULL CHECK (amount > 0),
+    weekday     INTEGER NOT NULL CHECK (weekday BETWEEN 1 AND 7),
+    next_run_on DATE NOT NULL,
+    last_run_at TIMESTAMPTZ NULL,
+    last_error  TEXT NULL,            -- Ex: saldo insuficiente do responsável na última execução
+    is_active   BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS student_allowances_due_idx ON public.student_allowances (next_run_on) WHERE is_active;
+
+-- Extrato da carteira do aluno (append-only, como credit_transactions)
+CREATE TABLE IF NOT EXISTS public.student_wallet_transactions (
+    id               UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    student_id       UUID NOT NULL REFERENCES public.students(id) ON DELETE CASCADE,
+    type             TEXT NOT NULL CHECK (type IN ('TRANSFER_IN', 'TRANSFER_OUT', 'ALLOWANCE', 'ORDER_DEBIT', 'REFUND')),
+    amount           NUMERIC(10,2) NOT NULL CHECK (amount <> 0), -- negativo = débito, positivo = crédito
+    balance_after    NUMERIC(10,2) NOT NULL,
+    guardian_user_id UUID NULL REFERENCES public.users(id),      -- Contraparte de transferências e mesadas
+    order_id         UUID NULL REFERENCES public.orders(id),
+    allowance_id     UUID NULL REFERENCES public.student_allowances(id) ON DELETE SET NULL,
+    description      TEXT NULL,
+    created_by       UUID NULL REFERENCES public.users(id),
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS student_wallet_transactions_student_created_idx
+    ON public.student_wallet_transactions (student_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS student_wallet_transactions_order_idx
+    ON public.student_wallet_transactions (order_id) WHERE order_id IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION public.student_wallet_transactions_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'student_wallet_transactions é append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS student_wallet_transactions_append_only ON public.student_wallet_transactions;
+CREATE TRIGGER student_wallet_transactions_append_only
+    BEFORE UPDATE OR DELETE ON public.student_wallet_transactions
+    FOR EACH ROW EXECUTE FUNCTION public.student_wallet_transactions_append_only();
+
+-- Movimentações entre a carteira do responsável e a do aluno aparecem no extrato do responsável
+ALTER TABLE public.credit_transactions DROP CONSTRAINT IF EXISTS credit_transactions_type_check;
+ALTER TABLE public.credit_transactions ADD CONSTRAINT credit_transactions_type_check
+    CHECK (type IN ('ORDER_DEBIT', 'TOPUP', 'REFUND', 'ADJUSTMENT', 'STUDENT_TRANSFER'));
+ALTER TABLE public.credit_transactions
+    ADD COLUMN IF NOT EXISTS student_id UUID NULL REFERENCES public.students(id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS public.order_items_menu_item_idx;
+DROP INDEX IF EXISTS public.orders_order_date_id_idx;
@@ -0,0 +1,5 @@
+-- Índices da listagem de pedidos paginada por cursor (GET /orders): a ordenação padrão (order_date, id)
+-- e o filtro por item do cardápio (?menu_item_id=).
+
+CREATE INDEX IF NOT EXISTS orders_order_date_id_idx ON public.orders (order_date DESC, id DESC);
+CREATE INDEX IF NOT EXISTS order_items_menu_item_idx ON public.order_items (menu_item_id);
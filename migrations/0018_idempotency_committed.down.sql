@@ -0,0 +1,7 @@
+-- Sem a resposta gravada não há o que devolver num reenvio: as chaves COMMITTED passam a bloqueá-lo
+-- como "em processamento" até expirarem
+UPDATE public.idempotency_keys SET status = 'IN_PROGRESS', locked_at = expires_at WHERE status = 'COMMITTED';
+
+ALTER TABLE public.idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_status_check;
+ALTER TABLE public.idempotency_keys
+    ADD CONSTRAINT idempotency_keys_status_check CHECK (status IN ('IN_PROGRESS', 'COMPLETED'));
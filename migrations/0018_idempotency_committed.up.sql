@@ -0,0 +1,6 @@
+-- Idempotency-Key COMMITTED: o efeito da requisição (pedido, recarga) foi gravado na mesma transação,
+-- mas a resposta ainda não. Uma chave assim nunca é retomada como abandonada, para não repetir o efeito.
+
+ALTER TABLE public.idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_status_check;
+ALTER TABLE public.idempotency_keys
+    ADD CONSTRAINT idempotency_keys_status_check CHECK (status IN ('IN_PROGRESS', 'COMMITTED', 'COMPLETED'));
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money é um valor em reais guardado em centavos inteiros (R$ 5,75 = Money(575)).
+// Somas e multiplicações são exatas, ao contrário de float64 (0.1 * 3 dá 0.30000000000000004 em ponto flutuante).
+//
+// No JSON continua sendo um número decimal (5.75), compatível com os clientes atuais; na entrada
+// são aceitas no máximo 2 casas decimais. No banco corresponde às colunas NUMERIC(10,2).
+type Money int64
+
+// errInvalidMoney indica um valor que não pode ser representado em centavos
+var errInvalidMoney = errors.New("valor monetário inválido")
+
+// Cents retorna o valor em centavos
+func (m Money) Cents() int64 {
+	return int64(m)
+}
+
+// Times multiplica o valor por uma quantidade (ex: preço unitário x itens)
+func (m Money) Times(quantity int) Money {
+	return m * Money(quantity)
+}
+
+// String formata o valor com duas casas decimais e ponto como separador ("5.75", "-0.50")
+func (m Money) String() string {
+	sign := ""
+	cents := int64(m)
+	if cents < 0 {
+		sign = "-"
+	}
+	// uint64 evita overflow ao inverter o sinal de math.MinInt64
+	abs := uint64(cents)
+	if cents < 0 {
+		abs = -abs
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, abs/100, abs%100)
+}
+
+// parseMoney converte um decimal ("5.75", "-3", "0.5") em centavos.
+// Com exact=true, mais de 2 casas decimais é erro; senão o valor é arredondado (meio centavo para longe do zero).
+func parseMoney(raw string, exact bool) (Money, error) {
+	s := strings.TrimSpace(raw)
+	negative := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		negative = true
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	intPart, fracPart, hasDot := strings.Cut(s, ".")
+	if intPart == "" || (hasDot && fracPart == "") || !isDigits(intPart) || !isDigits(fracPart) {
+		return 0, fmt.Errorf("%w: %q", errInvalidMoney, raw)
+	}
+
+	roundUp := false
+	if len(fracPart) > 2 {
+		if exact && strings.TrimRight(fracPart[2:], "0") != "" {
+			return 0, fmt.Errorf("%w: %q tem mais de 2 casas decimais", errInvalidMoney, raw)
+		}
+		roundUp = fracPart[2] >= '5'
+		fracPart = fracPart[:2]
+	}
+	for len(fracPart) < 2 {
+		fracPart += "0"
+	}
+
+	units, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil || units > (math.MaxInt64-99)/100 {
+		return 0, fmt.Errorf("%w: %q fora do intervalo", errInvalidMoney, raw)
+	}
+	frac, _ := strconv.ParseInt(fracPart, 10, 64)
+	cents := units*100 + frac
+	if roundUp {
+		cents++
+	}
+	if negative {
+		cents = -cents
+	}
+	return Money(cents), nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalJSON grava o valor como número decimal (5.75)
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON aceita um número decimal com até 2 casas (5.75, 5, 5.7); null deixa o valor como está
+func (m *Money) UnmarshalJSON(data []byte) error {
+	raw := string(data)
+	if raw == "null" {
+		return nil
+	}
+	if strings.ContainsAny(raw, "eE") {
+		return fmt.Errorf("%w: use notação decimal (%s)", errInvalidMoney, raw)
+	}
+	parsed, err := parseMoney(raw, true)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan lê colunas NUMERIC (que o driver entrega como texto), inteiras ou de ponto flutuante.
+// Valores calculados no banco com mais de 2 casas são arredondados para o centavo.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		parsed, err := parseMoney(string(v), false)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+	case string:
+		parsed, err := parseMoney(v, false)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+	case int64:
+		*m = Money(v * 100)
+	case float64:
+		*m = Money(math.Round(v * 100))
+	case nil:
+		return fmt.Errorf("%w: NULL não pode ser lido como Money (use *Money)", errInvalidMoney)
+	default:
+		return fmt.Errorf("%w: tipo %T não suportado", errInvalidMoney, src)
+	}
+	return nil
+}
+
+// Value envia o valor ao banco como texto decimal, convertido pelo Postgres para NUMERIC
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"testing"
+	"testing/quick"
+)
+
+// maxTestCents limita os valores gerados a ±R$ 10 trilhões, longe do overflow de int64 nas somas
+const maxTestCents = 1_000_000_000_000_000
+
+func boundedMoney(raw int64) Money {
+	return Money(raw % maxTestCents)
+}
+
+func TestMoneyJSONRoundTrip(t *testing.T) {
+	property := func(raw int64) bool {
+		m := boundedMoney(raw)
+		data, err := json.Marshal(m)
+		if err != nil {
+			return false
+		}
+		var decoded Money
+		return json.Unmarshal(data, &decoded) == nil && decoded == m
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMoneyJSONIsDecimalNumber(t *testing.T) {
+	cases := map[Money]string{0: "0.00", 575: "5.75", -50: "-0.50", 100000: "1000.00", 7: "0.07"}
+	for m, want := range cases {
+		data, err := json.Marshal(m)
+		if err != nil || string(data) != want {
+			t.Errorf("json.Marshal(%d) = %s, %v; esperado %s", int64(m), data, err, want)
+		}
+		// Clientes que leem o número como float continuam recebendo o mesmo valor de antes
+		var asFloat float64
+		if err := json.Unmarshal(data, &asFloat); err != nil || Money(asFloat*100+0.5*sign(asFloat)) != m {
+			t.Errorf("%s lido como float64 = %v, %v", data, asFloat, err)
+		}
+	}
+
+	accepted := map[string]Money{"5.75": 575, "5.7": 570, "5": 500, "-3.1": -310, "0.10": 10, "2.500": 250}
+	for raw, want := range accepted {
+		var m Money
+		if err := json.Unmarshal([]byte(raw), &m); err != nil || m != want {
+			t.Errorf("json.Unmarshal(%s) = %d, %v; esperado %d", raw, int64(m), err, int64(want))
+		}
+	}
+	for _, raw := range []string{"0.125", "1e2", `"5.75"`, "5.", ".5", "abc", "99999999999999999999"} {
+		var m Money
+		if err := json.Unmarshal([]byte(raw), &m); err == nil {
+			t.Errorf("json.Unmarshal(%s) deveria falhar, veio %d", raw, int64(m))
+		}
+	}
+}
+
+func sign(f float64) float64 {
+	if f < 0 {
+		return -1
+	}
+	return 1
+}
+
+// Somar preço x quantidade em centavos é exato: o total bate com a soma feita sobre os decimais em texto,
+// qualquer que seja a ordem dos itens.
+func TestMoneyOrderTotalsAreExact(t *testing.T) {
+	property := func(seed int64) bool {
+		rng := rand.New(rand.NewSource(seed))
+		lines := 1 + rng.Intn(20)
+		prices := make([]Money, lines)
+		quantities := make([]int, lines)
+		var total Money
+		var expectedCents int64
+		for i := range prices {
+			// Preço vindo do JSON, como em POST /menu-items
+			cents := rng.Int63n(100000)
+			if err := json.Unmarshal([]byte(Money(cents).String()), &prices[i]); err != nil {
+				return false
+			}
+			quantities[i] = 1 + rng.Intn(10)
+			total += prices[i].Times(quantities[i])
+			expectedCents += cents * int64(quantities[i])
+		}
+
+		var reversed Money
+		for i := lines - 1; i >= 0; i-- {
+			reversed += prices[i].Times(quantities[i])
+		}
+		return total.Cents() == expectedCents && reversed == total
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Em float64, 0.1*3 dá 0.30000000000000004
+	total := Money(575).Times(3) + Money(10).Times(3)
+	if total.String() != "17.55" || Money(10).Times(3).String() != "0.30" {
+		t.Fatalf("total = %s, esperado 17.55", total)
+	}
+}
+
+// A divisão do pagamento entre carteira do aluno e responsável (splitOrderPayment) nunca perde centavos,
+// nunca tira da carteira mais que o saldo e respeita a política da carteira
+func TestMoneyPaymentSplitIsExact(t *testing.T) {
+	policies := []FundingPolicy{FundingStudentThenGuardian, FundingStudentOnly, FundingGuardianOnly}
+	property := func(policyIndex uint8, rawBalance, rawTotal int64) bool {
+		policy := policies[int(policyIndex)%len(policies)]
+		// Saldo e total nunca são negativos (CHECK no banco e soma de preços)
+		balance, total := boundedMoney(rawBalance), boundedMoney(rawTotal)
+		if balance < 0 {
+			balance = -balance
+		}
+		if total < 0 {
+			total = -total
+		}
+
+		fromStudent, fromGuardian, err := splitOrderPayment(policy, balance, total)
+		if policy == FundingStudentOnly && balance < total {
+			return errors.Is(err, errInsufficientStudentBalance)
+		}
+		if err != nil || fromStudent+fromGuardian != total || fromStudent < 0 || fromGuardian < 0 || fromStudent > balance {
+			return false
+		}
+		switch policy {
+		case FundingGuardianOnly:
+			return fromStudent == 0
+		case FundingStudentOnly:
+			return fromGuardian == 0
+		default:
+			// O responsável só paga o que a carteira do aluno não cobre
+			return fromGuardian == 0 || fromStudent == balance
+		}
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	property := func(raw int64) bool {
+		m := boundedMoney(raw)
+		var fromText, fromString Money
+		return fromText.Scan([]byte(m.String())) == nil && fromText == m &&
+			fromString.Scan(m.String()) == nil && fromString == m
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		src  interface{}
+		want Money
+	}{
+		{[]byte("5.75"), 575},
+		{[]byte("0"), 0},
+		{[]byte("3.3333333333"), 333}, // NUMERIC calculado no banco (ex: AVG) é arredondado para o centavo
+		{[]byte("-2.005"), -201},
+		{int64(12), 1200},
+		{5.75, 575},
+		{0.1 + 0.2, 30},
+	}
+	for _, c := range cases {
+		var m Money
+		if err := m.Scan(c.src); err != nil || m != c.want {
+			t.Errorf("Scan(%v) = %d, %v; esperado %d", c.src, int64(m), err, int64(c.want))
+		}
+	}
+
+	var m Money
+	if err := m.Scan(nil); err == nil {
+		t.Error("Scan(nil) deveria falhar")
+	}
+	value, err := Money(-1050).Value()
+	if err != nil || value != "-10.50" {
+		t.Errorf("Value() = %v, %v", value, err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CancelOrderPayload é o corpo (opcional) de POST /orders/{id}/cancel
+type CancelOrderPayload struct {
+	Reason string `json:"reason"`
+}
+
+var (
+	errOrderNotFound        = errors.New("pedido não encontrado")
+	errOrderCancelForbidden = errors.New("usuário não pode cancelar este pedido")
+	errOrderNotCancelable   = errors.New("pedido não pode mais ser cancelado")
+)
+
+// cancelOrder cancela o pedido, devolve os itens ao estoque e estorna total_amount para quem pagou,
+// tudo na mesma transação.
+// Pais (dono do pedido) só cancelam enquanto PENDING; STAFF/ADMIN/SUPER_ADMIN até READY.
+func cancelOrder(appDB *sql.DB, orderID string, actor *UserProfile, reason *string) (*Order, error) {
+	tx, err := appDB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao iniciar transação de cancelamento: %w", err)
+	}
+	defer rollbackTx(tx)
+
+	// FOR UPDATE serializa cancelamentos e mudanças de status concorrentes do mesmo pedido
+	var order Order
+	var studentID, pickupSlotID sql.NullString
+	lockQuery := `
+		SELECT id, user_id, student_id, order_date, to_char(scheduled_for, 'YYYY-MM-DD'), pickup_slot_id,
+		       total_amount, status, created_at, updated_at
+		FROM public.orders
+		WHERE id = $1
+		FOR UPDATE;`
+	err = tx.QueryRow(lockQuery, orderID).Scan(
+		&order.ID, &order.UserID, &studentID, &order.OrderDate, &order.ScheduledFor, &pickupSlotID,
+		&order.TotalAmount, &order.Status, &order.CreatedAt, &order.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, errOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar pedido %s para cancelamento: %w", orderID, err)
+	}
+	if studentID.Valid {
+		order.StudentID = &studentID.String
+	}
+	if pickupSlotID.Valid {
+		order.PickupSlotID = &pickupSlotID.String
+	}
+
+	if !actor.Role.Can(PermOrdersManage) && order.UserID != actor.ID {
+		return nil, errOrderCancelForbidden
+	}
+	if !canTransitionOrderStatus(order.Status, OrderStatusCanceled, actor.Role) {
+		return nil, errOrderNotCancelable
+	}
+	previousStatus := order.Status
+
+	var canceledAt time.Time
+	updateQuery := `
+		UPDATE public.orders
+		SET status = 'CANCELED', canceled_at = NOW(), canceled_by = $2, cancel_reason = $3, updated_at = NOW()
+		WHERE id = $1
+		RETURNING status, canceled_at, updated_at;`
+	err = tx.QueryRow(updateQuery, order.ID, actor.ID, reason).Scan(&order.Status, &canceledAt, &order.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao cancelar pedido %s: %w", order.ID, err)
+	}
+	order.CanceledAt = &canceledAt
+	order.CanceledBy = &actor.ID
+	order.CancelReason = reason
+
+	if err := recordOrderStatusChange(tx, order.ID, &previousStatus, OrderStatusCanceled, &actor.ID, reason); err != nil {
+		return nil, err
+	}
+
+	if err := restoreOrderStock(tx, order.ID, &actor.ID); err != nil {
+		return nil, err
+	}
+
+	// Estorno para onde o pagamento saiu: carteira do aluno e/ou créditos do responsável
+	if err := refundOrderPayment(tx, &order, actor.ID); err != nil {
+		return nil, err
+	}
+
+	if err := commitTx(tx); err != nil {
+		return nil, fmt.Errorf("erro ao confirmar cancelamento do pedido %s: %w", order.ID, err)
+	}
+
+	log.Printf("Pedido %s cancelado por %s (Papel: %s). Estorno de %s (usuário %s e/ou carteira do aluno).",
+		order.ID, actor.ID, actor.Role, order.TotalAmount, order.UserID)
+	return &order, nil
+}
+
+// writeCancelOrderError traduz os erros de OrderRepository.Cancel para respostas HTTP
+func writeCancelOrderError(w http.ResponseWriter, err error, orderID string) {
+	switch {
+	case errors.Is(err, errOrderNotFound):
+		http.Error(w, "Pedido não encontrado.", http.StatusNotFound)
+	case errors.Is(err, errOrderCancelForbidden):
+		http.Error(w, "Acesso não autorizado para cancelar este pedido.", http.StatusForbidden)
+	case errors.Is(err, errOrderNotCancelable):
+		http.Error(w, "Este pedido não pode mais ser cancelado no status atual.", http.StatusConflict)
+	default:
+		log.Printf("Erro ao cancelar pedido %s: %v", orderID, err)
+		http.Error(w, "Erro no servidor ao cancelar pedido.", http.StatusInternalServerError)
+	}
+}
+
+// handleCancelOrder trata POST /orders/{id}/cancel
+func handleCancelOrder(w http.ResponseWriter, r *http.Request, orders OrderRepository, orderID string) {
+	requestingUserProfile := profileFromContext(r.Context())
+
+	// O corpo é opcional: um POST sem corpo cancela sem motivo informado
+	var payload CancelOrderPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && err != io.EOF {
+		http.Error(w, "Corpo da requisição inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var reason *string
+	if trimmed := strings.TrimSpace(payload.Reason); trimmed != "" {
+		reason = &trimmed
+	}
+
+	canceledOrder, err := orders.Cancel(orderID, requestingUserProfile, reason)
+	if err != nil {
+		writeCancelOrderError(w, err, orderID)
+		return
+	}
+
+	orderItems, errItems := orders.Items(canceledOrder.ID)
+	if errItems != nil {
+		log.Printf("Alerta: Não foi possível buscar itens para o pedido cancelado %s: %v", canceledOrder.ID, errItems)
+		canceledOrder.Items = []OrderItem{}
+	} else {
+		canceledOrder.Items = orderItems
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(canceledOrder)
+}
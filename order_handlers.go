@@ -7,12 +7,14 @@ import (
 
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
 	"time"
-	// A importação do driver _ "github.com/lib/pq" já está no main.go
+
+	"github.com/lib/pq" // pq.Array para alérgenos/marcações; o registro do driver está no main.go
 )
 
 // OrderItemRequest representa um item dentro de um pedido na requisição de criação
@@ -25,9 +27,35 @@ type OrderItemRequest struct {
 type CreateOrderRequest struct {
 	Items     []OrderItemRequest `json:"items"`
 	StudentID string             `json:"student_id"` // NOVO e OBRIGATÓRIO
+	// Encomenda: dia de serviço (YYYY-MM-DD) e código do horário de retirada (ver schedule.go).
+	// Sem os dois, o pedido é imediato, para hoje.
+	ScheduledFor *string `json:"scheduled_for,omitempty"`
+	PickupSlot   *string `json:"pickup_slot,omitempty"`
+	// Idempotency-Key da requisição, marcada como aplicada na transação do pedido (nil sem o cabeçalho)
+	idempotency *idempotencyClaim
 	// Turma *string `json:"turma,omitempty"` // REMOVA ESTE CAMPO SE VOCÊ O TINHA ANTES
 }
 
+// Erros de OrderRepository.Create
+var (
+	// errStudentNotOrderable indica aluno inexistente ou arquivado, ou usuário que não pode pedir por ele
+	errStudentNotOrderable = errors.New("aluno inválido ou responsável sem permissão para pedir por ele")
+	// errUnknownMenuItem indica um menu_item_id que não existe
+	errUnknownMenuItem = errors.New("item do cardápio não encontrado")
+	// errMenuItemUnavailable indica um item marcado como indisponível
+	errMenuItemUnavailable = errors.New("item indisponível")
+)
+
+// orderRejection é um pedido recusado por regra da escola ou do responsável (agendamento, cardápio do dia,
+// restrições alimentares, limites do aluno); a mensagem é o motivo, mostrado ao responsável
+type orderRejection struct {
+	reason string
+}
+
+func (rejection *orderRejection) Error() string {
+	return rejection.reason
+}
+
 // OrderItem (para respostas e uso interno, espelha a tabela order_items)
 type OrderItem struct {
 	ID              string    `json:"id"`
@@ -35,23 +63,37 @@ type OrderItem struct {
 	MenuItemID      string    `json:"menu_item_id"`
 	MenuItemName    string    `json:"menu_item_name,omitempty"` // NOVO CAMPO
 	Quantity        int       `json:"quantity"`
-	PriceAtPurchase float64   `json:"price_at_purchase"`
+	PriceAtPurchase Money     `json:"price_at_purchase"`
 	CreatedAt       time.Time `json:"created_at"`
 	// Poderíamos adicionar 'name' do item aqui para facilitar no frontend, buscando com um JOIN
 }
 
 // Order (para respostas e uso interno, espelha a tabela orders)
 type Order struct {
-	ID          string      `json:"id"`
-	UserID      string      `json:"user_id"`
-	StudentID   *string     `json:"student_id,omitempty"` // NOVO: ID do aluno para quem é o pedido
-	OrderDate   time.Time   `json:"order_date"`
-	TotalAmount float64     `json:"total_amount"`
-	Status      string      `json:"status"`
-	CreatedAt   time.Time   `json:"created_at"`
-	UpdatedAt   time.Time   `json:"updated_at"`
-	Items       []OrderItem `json:"items,omitempty"` // Para incluir os itens do pedido na resposta
-
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	StudentID   *string   `json:"student_id,omitempty"`   // NOVO: ID do aluno para quem é o pedido
+	StudentName *string   `json:"student_name,omitempty"` // Nas leituras pelo OrderRepository
+	OrderDate   time.Time `json:"order_date"`
+	// Dia em que o pedido será servido (YYYY-MM-DD, sempre preenchido) e horário de retirada (PickupSlotID nil = pedido imediato)
+	ScheduledFor string  `json:"scheduled_for"`
+	PickupSlotID *string `json:"pickup_slot_id,omitempty"`
+	// Código de retirada e conteúdo do QR (ver pickup.go); só vão nas respostas para o responsável
+	PickupCode      *string     `json:"pickup_code,omitempty"`
+	PickupQRPayload *string     `json:"pickup_qr_payload,omitempty"`
+	TotalAmount     Money       `json:"total_amount"`
+	Status          string      `json:"status"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+	Items           []OrderItem `json:"items,omitempty"` // Para incluir os itens do pedido na resposta
+
+	// Preenchidos apenas para pedidos cancelados
+	CanceledAt   *time.Time `json:"canceled_at,omitempty"`
+	CanceledBy   *string    `json:"canceled_by,omitempty"`
+	CancelReason *string    `json:"cancel_reason,omitempty"`
+
+	// Parte do total paga pela carteira do aluno (só na resposta de criação; ver student_wallets.go)
+	PaidFromStudentWallet Money `json:"paid_from_student_wallet,omitempty"`
 }
 
 // Struct para o payload da requisição de atualização de status
@@ -62,33 +104,10 @@ type UpdateOrderStatusPayload struct {
 // (continuação do arquivo order_handlers.go, abaixo das structs)
 
 // handleUpdateOrderStatus atualiza o status de um pedido específico
-func handleUpdateOrderStatus(w http.ResponseWriter, r *http.Request, appDB *sql.DB, orderID string) {
-	// 1. Autenticação já foi feita. Pegar userID e perfil (para checar o papel).
-	userIDfromContext := r.Context().Value(userContextKey)
-	if userIDfromContext == nil {
-		http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
-		return
-	}
-	requestingUserID := userIDfromContext.(string)
-
-	requestingUserProfile, err := fetchUserProfile(requestingUserID, appDB)
-	if err != nil {
-		// Tratar erro ao buscar perfil
-		if err == sql.ErrNoRows {
-			http.Error(w, "Perfil de usuário solicitante não encontrado.", http.StatusUnauthorized)
-		} else {
-			log.Printf("Erro ao buscar perfil do usuário %s: %v", requestingUserID, err)
-			http.Error(w, "Erro no servidor ao verificar permissões.", http.StatusInternalServerError)
-		}
-		return
-	}
-
-	// 2. Autorização: Apenas STAFF, ADMIN, ou SUPER_ADMIN podem mudar status de pedidos.
-	if requestingUserProfile.Role != "staff" && requestingUserProfile.Role != "admin" && requestingUserProfile.Role != "super_admin" {
-		log.Printf("Usuário %s (Papel: %s) tentou atualizar status do pedido %s sem permissão.", requestingUserID, requestingUserProfile.Role, orderID)
-		http.Error(w, "Acesso não autorizado para esta ação.", http.StatusForbidden)
-		return
-	}
+func handleUpdateOrderStatus(w http.ResponseWriter, r *http.Request, orders OrderRepository, orderID string) {
+	// 1. Autenticação e autorização (PermOrdersManage) já foram feitas na rota; o perfil está no contexto
+	requestingUserProfile := profileFromContext(r.Context())
+	requestingUserID := requestingUserProfile.ID
 
 	// 3. Decodificar o novo status do corpo da requisição
 	var payload UpdateOrderStatusPayload
@@ -99,54 +118,48 @@ func handleUpdateOrderStatus(w http.ResponseWriter, r *http.Request, appDB *sql.
 	}
 	defer r.Body.Close()
 
-	// 4. Validar o novo status (opcional, mas recomendado)
-	//    Você pode querer uma lista de status válidos: e.g., "PREPARING", "READY", "COMPLETED", "CANCELED"
-	//    E talvez regras de transição (ex: não pode ir de COMPLETED para PREPARING).
-	//    Por simplicidade, vamos permitir qualquer string por enquanto, mas registre que isso deve ser validado.
-	newStatus := strings.TrimSpace(payload.Status)
+	// 4. Validar o novo status contra a máquina de estados (order_status.go)
+	newStatus := strings.ToUpper(strings.TrimSpace(payload.Status))
 	if newStatus == "" {
 		http.Error(w, "Novo status não pode ser vazio.", http.StatusBadRequest)
 		return
 	}
-	// Exemplo de validação de status (pode expandir)
-	validStatuses := map[string]bool{"PENDING": true, "PREPARING": true, "READY": true, "COMPLETED": true, "CANCELED": true}
-	if !validStatuses[strings.ToUpper(newStatus)] {
+	if !isValidOrderStatus(newStatus) {
 		http.Error(w, fmt.Sprintf("Status '%s' inválido.", newStatus), http.StatusBadRequest)
 		return
 	}
 
 	log.Printf("Usuário %s (Papel: %s) atualizando status do pedido %s para '%s'", requestingUserID, requestingUserProfile.Role, orderID, newStatus)
 
-	// 5. Atualizar o status no banco de dados
-	var updatedOrder Order // Para retornar o pedido atualizado completo
-	updateQuery := `
-		UPDATE public.orders 
-		SET status = $1, updated_at = NOW() 
-		WHERE id = $2
-		RETURNING id, user_id, order_date, total_amount, status, created_at, updated_at;`
-
-	err = appDB.QueryRow(updateQuery, strings.ToUpper(newStatus), orderID).Scan(
-		&updatedOrder.ID,
-		&updatedOrder.UserID,
-		&updatedOrder.OrderDate,
-		&updatedOrder.TotalAmount,
-		&updatedOrder.Status,
-		&updatedOrder.CreatedAt,
-		&updatedOrder.UpdatedAt,
-	)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
+	// 5. Cancelar exige estorno dos créditos, então usa o mesmo fluxo de POST /orders/{id}/cancel;
+	// os demais status só validam a transição e gravam o histórico
+	var updatedOrder *Order
+	var err error
+	if newStatus == OrderStatusCanceled {
+		if updatedOrder, err = orders.Cancel(orderID, requestingUserProfile, nil); err != nil {
+			writeCancelOrderError(w, err, orderID)
+			return
+		}
+	} else {
+		updatedOrder, err = orders.UpdateStatus(orderID, newStatus, requestingUserProfile)
+		var transition *orderTransitionError
+		switch {
+		case err == nil:
+		case errors.Is(err, errOrderNotFound):
 			http.Error(w, "Pedido não encontrado para atualização de status.", http.StatusNotFound)
-		} else {
+			return
+		case errors.As(err, &transition):
+			http.Error(w, fmt.Sprintf("Transição de status não permitida: %s → %s.", transition.from, transition.to), http.StatusConflict)
+			return
+		default:
 			log.Printf("Erro ao atualizar status do pedido %s: %v", orderID, err)
 			http.Error(w, "Erro no servidor ao atualizar status do pedido.", http.StatusInternalServerError)
+			return
 		}
-		return
 	}
 
 	// 6. Buscar os itens do pedido atualizado para retornar o objeto completo
-	orderItems, errItems := fetchOrderItemsByOrderID(appDB, updatedOrder.ID)
+	orderItems, errItems := orders.Items(updatedOrder.ID)
 	if errItems != nil {
 		log.Printf("Alerta: Não foi possível buscar itens para o pedido atualizado %s: %v", updatedOrder.ID, errItems)
 		updatedOrder.Items = []OrderItem{} // Retorna com itens vazios se houver erro aqui
@@ -158,12 +171,10 @@ func handleUpdateOrderStatus(w http.ResponseWriter, r *http.Request, appDB *sql.
 	json.NewEncoder(w).Encode(updatedOrder)
 }
 
-// handleCreateOrder cria um novo pedido
-func handleCreateOrder(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+// handleCreateOrder cria um novo pedido; a validação e o pagamento ficam em OrderRepository.Create
+func handleCreateOrder(w http.ResponseWriter, r *http.Request, orders OrderRepository) {
 	userIDfromContext := r.Context().Value(userContextKey).(string) // ID do pai/responsável logado
-	// (Opcional, mas bom: buscar perfil do pai para pegar o role, caso queira restringir quem pode criar pedidos)
-	//requestingUserProfile, _ := fetchUserProfile(userIDfromContext, appDB)
-	// if requestingUserProfile.Role != "CLIENTE" { ... erro ... }
+	// Quem pode criar pedidos (PermOrderCreate) é verificado na rota, por requirePermission
 
 	var reqPayload CreateOrderRequest
 	if err := json.NewDecoder(r.Body).Decode(&reqPayload); err != nil {
@@ -181,7 +192,6 @@ func handleCreateOrder(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
 		http.Error(w, "O ID do aluno (student_id) é obrigatório.", http.StatusBadRequest)
 		return
 	}
-	// ... (validação dos itens do pedido como antes) ...
 	for _, itemReq := range reqPayload.Items {
 		if itemReq.MenuItemID == "" || itemReq.Quantity <= 0 {
 			http.Error(w, "Cada item do pedido deve ter 'menu_item_id' e 'quantity' (>0) válida.", http.StatusBadRequest)
@@ -192,136 +202,37 @@ func handleCreateOrder(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
 	log.Printf("Usuário %s criando pedido para aluno %s com %d tipo(s) de item(ns).",
 		userIDfromContext, reqPayload.StudentID, len(reqPayload.Items))
 
-	// --- INÍCIO DA TRANSAÇÃO E LÓGICA ---
-	tx, err := appDB.Begin()
-	if err != nil { /* ... tratamento de erro ... */
-		http.Error(w, "Erro servidor", http.StatusInternalServerError)
+	reqPayload.idempotency = idempotencyClaimFromContext(r.Context())
+	newOrder, err := orders.Create(userIDfromContext, reqPayload)
+	var rejection *orderRejection
+	switch {
+	case err == nil:
+	case errors.As(err, &rejection):
+		log.Printf("Pedido recusado para aluno %s: %s", reqPayload.StudentID, rejection.reason)
+		http.Error(w, rejection.reason, http.StatusUnprocessableEntity)
 		return
-	}
-	defer tx.Rollback()
-
-	// ***** NOVA VALIDAÇÃO IMPORTANTE: Verificar se o student_id pertence ao parent_user_id (usuário logado) *****
-	var studentOwnerCheckID string
-	studentCheckQuery := "SELECT id FROM public.students WHERE id = $1 AND parent_user_id = $2"
-	err = tx.QueryRow(studentCheckQuery, reqPayload.StudentID, userIDfromContext).Scan(&studentOwnerCheckID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("Validação falhou: Aluno ID %s não encontrado ou não pertence ao usuário %s.", reqPayload.StudentID, userIDfromContext)
-			http.Error(w, "Aluno especificado inválido ou não pertence a este responsável.", http.StatusForbidden) // Ou 400 Bad Request
-			return                                                                                                 // Rollback será chamado pelo defer
-		}
-		log.Printf("Erro ao validar aluno %s para usuário %s: %v", reqPayload.StudentID, userIDfromContext, err)
-		http.Error(w, "Erro ao validar dados do pedido.", http.StatusInternalServerError)
-		return // Rollback
-	}
-	// Se chegou aqui, o aluno pertence ao pai.
-
-	var calculatedTotalAmount float64 = 0.0
-	var itemsForOrder []OrderItem
-	// ... (Lógica para validar itens do menu, calcular totalAmount, preparar itemsForOrder - SEM MUDANÇAS AQUI) ...
-	// Esta parte deve continuar como estava, buscando preços, verificando disponibilidade, etc.
-	for _, itemReq := range reqPayload.Items {
-		var itemName string
-		var itemPrice float64
-		var itemIsAvailable bool
-		menuItemQuery := "SELECT name, price, is_available FROM public.menu_items WHERE id = $1"
-		// IMPORTANTE: Usar tx.QueryRow aqui dentro da transação
-		errItem := tx.QueryRow(menuItemQuery, itemReq.MenuItemID).Scan(&itemName, &itemPrice, &itemIsAvailable)
-		if errItem != nil { /* ... tratamento de erro de item não encontrado ... */
-			http.Error(w, "Item menu não encontrado", http.StatusBadRequest)
-			return
-		}
-		if !itemIsAvailable { /* ... tratamento de erro de item indisponível ... */
-			http.Error(w, "Item indisponível", http.StatusBadRequest)
-			return
-		}
-		calculatedTotalAmount += itemPrice * float64(itemReq.Quantity)
-		itemsForOrder = append(itemsForOrder, OrderItem{
-			// ID do OrderItemAPIResponse será preenchido após INSERT em order_items
-			MenuItemID:      itemReq.MenuItemID,
-			MenuItemName:    itemName, // Importante popular aqui se sua struct tem
-			Quantity:        itemReq.Quantity,
-			PriceAtPurchase: itemPrice,
-		})
-	}
-
-	// Verificar créditos do usuário (pai/responsável)
-	var userCredits float64
-	// ... (Lógica para buscar userCredits do userIDfromContext - SEM MUDANÇAS AQUI) ...
-	userCreditsQuery := "SELECT credits FROM public.users WHERE id = $1"
-	errCredits := tx.QueryRow(userCreditsQuery, userIDfromContext).Scan(&userCredits)
-	if errCredits != nil { /* ... tratamento de erro ... */
-		http.Error(w, "Erro créditos", http.StatusInternalServerError)
+	case errors.Is(err, errStudentNotOrderable):
+		log.Printf("Validação falhou: Aluno ID %s não encontrado ou usuário %s não pode pedir por ele.", reqPayload.StudentID, userIDfromContext)
+		http.Error(w, "Aluno especificado inválido ou este responsável não pode fazer pedidos para ele.", http.StatusForbidden)
 		return
-	}
-
-	if userCredits < calculatedTotalAmount {
-		// ... (Lógica de créditos insuficientes - SEM MUDANÇAS AQUI) ...
+	case errors.Is(err, errUnknownMenuItem):
+		http.Error(w, "Item menu não encontrado", http.StatusBadRequest)
+		return
+	case errors.Is(err, errMenuItemUnavailable):
+		http.Error(w, "Item indisponível", http.StatusBadRequest)
+		return
+	case errors.Is(err, errInsufficientStock):
+		http.Error(w, err.Error()+".", http.StatusConflict)
+		return
+	case errors.Is(err, errInsufficientCredits):
 		http.Error(w, "Créditos insuficientes", http.StatusPaymentRequired)
 		return
-	}
-
-	// Inserir na tabela 'orders' - AGORA INCLUINDO student_id
-	var newOrder Order                  // Usando a struct Order que tem StudentID *string
-	newOrder.UserID = userIDfromContext // ID do pai
-	newOrder.TotalAmount = calculatedTotalAmount
-	newOrder.Status = "PENDING"
-	// Atribuir o student_id à struct newOrder para que seja retornado no JSON
-	// O banco espera um UUID, então reqPayload.StudentID deve ser um UUID válido
-	newOrder.StudentID = &reqPayload.StudentID
-
-	// MODIFICADO: Adicionar student_id ao INSERT e ao RETURNING
-	orderInsertQuery := `
-		INSERT INTO public.orders (user_id, student_id, total_amount, status) 
-		VALUES ($1, $2, $3, $4) 
-		RETURNING id, order_date, created_at, updated_at, student_id;` // student_id também no RETURNING
-
-	var returnedStudentID sql.NullString // Para scanear o student_id que pode ser nulo no DB, mas aqui estamos inserindo.
-	// Se student_id na tabela orders é NOT NULL, não precisamos de sql.NullString.
-	// Eu sugeri ADD COLUMN student_id UUID REFERENCES public.alunos(id) ON DELETE SET NULL;
-	// Então, ele PODE ser NULL no banco, então sql.NullString é seguro.
-	err = tx.QueryRow(orderInsertQuery, newOrder.UserID, reqPayload.StudentID, newOrder.TotalAmount, newOrder.Status).Scan(
-		&newOrder.ID, &newOrder.OrderDate, &newOrder.CreatedAt, &newOrder.UpdatedAt, &returnedStudentID)
-	if err != nil {
-		log.Printf("Erro ao inserir pedido para usuário %s, aluno %s: %v", userIDfromContext, reqPayload.StudentID, err)
-		http.Error(w, "Erro ao registrar o pedido.", http.StatusInternalServerError)
-		return // Rollback
-	}
-	if returnedStudentID.Valid { // Atribuir de volta à struct de resposta
-		newOrder.StudentID = &returnedStudentID.String
-	}
-
-	// Inserir na tabela 'order_items'
-	// ... (Lógica para inserir order_items - SEM MUDANÇAS AQUI, mas referenciando newOrder.ID) ...
-	// Certifique-se de que a struct OrderItemAPIResponse (ou a que você usa para itemsForOrder)
-	// está correta e que você preenche os campos necessários.
-	for i := range itemsForOrder {
-		itemsForOrder[i].OrderID = newOrder.ID
-		orderItemInsertQuery := `
-			INSERT INTO public.order_items (order_id, menu_item_id, quantity, price_at_purchase) 
-			VALUES ($1, $2, $3, $4) RETURNING id, created_at`
-		// No Scan abaixo, a struct currentItem (dentro do loop de itemsForOrder) é que precisaria de MenuItemName.
-		// A `itemsForOrder` já foi populada com MenuItemName. Agora só precisamos do ID e CreatedAt do BD.
-		errItemInsert := tx.QueryRow(orderItemInsertQuery, itemsForOrder[i].OrderID, itemsForOrder[i].MenuItemID, itemsForOrder[i].Quantity, itemsForOrder[i].PriceAtPurchase).Scan(
-			&itemsForOrder[i].ID, &itemsForOrder[i].CreatedAt) // Assume que sua struct OrderItemAPIResponse tem ID e CreatedAt
-		if errItemInsert != nil { /* ... tratamento de erro ... */
-			http.Error(w, "Erro itens pedido", http.StatusInternalServerError)
-			return
-		}
-	}
-	newOrder.Items = itemsForOrder
-
-	// Atualizar créditos do usuário (pai)
-	// ... (Lógica para atualizar créditos - SEM MUDANÇAS AQUI) ...
-	updateCreditsQuery := "UPDATE public.users SET credits = credits - $1 WHERE id = $2"
-	_, errUpdateCredits := tx.Exec(updateCreditsQuery, newOrder.TotalAmount, userIDfromContext)
-	if errUpdateCredits != nil { /* ... tratamento de erro ... */
-		http.Error(w, "Erro atualizar créditos", http.StatusInternalServerError)
+	case errors.Is(err, errInsufficientStudentBalance):
+		http.Error(w, "Saldo insuficiente na carteira do aluno", http.StatusPaymentRequired)
 		return
-	}
-
-	if err := tx.Commit(); err != nil { /* ... tratamento de erro ... */
-		http.Error(w, "Erro commit", http.StatusInternalServerError)
+	default:
+		log.Printf("Erro ao criar pedido (usuário %s, aluno %s): %v", userIDfromContext, reqPayload.StudentID, err)
+		http.Error(w, "Erro ao registrar o pedido.", http.StatusInternalServerError)
 		return
 	}
 
@@ -331,190 +242,8 @@ func handleCreateOrder(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
 	json.NewEncoder(w).Encode(newOrder)
 }
 
-// handleGetMyOrders busca e retorna o histórico de pedidos do usuário autenticado
-func handleGetMyOrders(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
-	userIDfromContext := r.Context().Value(userContextKey)
-	if userIDfromContext == nil {
-		http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
-		return
-	}
-	userID, ok := userIDfromContext.(string)
-	if !ok || userID == "" {
-		http.Error(w, "ID de usuário inválido no token", http.StatusUnauthorized)
-		return
-	}
-
-	log.Printf("Buscando pedidos para o usuário ID: %s", userID)
-
-	ordersQuery := `
-		SELECT id, user_id, order_date, total_amount, status, created_at, updated_at 
-		FROM public.orders 
-		WHERE user_id = $1 
-		ORDER BY order_date DESC;`
-
-	rows, err := appDB.Query(ordersQuery, userID)
-	if err != nil {
-		log.Printf("Erro ao buscar pedidos para usuário %s: %v", userID, err)
-		http.Error(w, "Erro ao buscar histórico de pedidos.", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var userOrders []Order
-
-	for rows.Next() {
-		var order Order
-		errScanOrder := rows.Scan(
-			&order.ID,
-			&order.UserID,
-			&order.OrderDate,
-			&order.TotalAmount,
-			&order.Status,
-			&order.CreatedAt,
-			&order.UpdatedAt,
-		)
-		if errScanOrder != nil {
-			log.Printf("Erro ao scanear linha do pedido para usuário %s: %v", userID, errScanOrder)
-			http.Error(w, "Erro ao processar histórico de pedidos.", http.StatusInternalServerError)
-			return
-		}
-
-		// Buscar e popular os itens do pedido
-		orderItemsDetails, errItems := fetchOrderItemsByOrderID(appDB, order.ID)
-		if errItems != nil {
-			log.Printf("Alerta: Não foi possível buscar itens para o pedido %s: %v", order.ID, errItems)
-			order.Items = []OrderItem{} // Garante que Items não seja nulo no JSON de resposta
-		} else {
-			order.Items = orderItemsDetails
-		}
-
-		userOrders = append(userOrders, order)
-	}
-	if err = rows.Err(); err != nil {
-		log.Printf("Erro após iterar pelos pedidos do usuário %s: %v", userID, err)
-		http.Error(w, "Erro ao processar dados dos pedidos.", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(userOrders)
-}
-
-// handleAdminGetOrders lista pedidos para admin/staff, com filtro opcional por status
-func handleAdminGetOrders(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
-	userIDfromContext := r.Context().Value(userContextKey)
-	if userIDfromContext == nil {
-		http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
-		return
-	}
-	requestingUserID := userIDfromContext.(string)
-
-	requestingUserProfile, err := fetchUserProfile(requestingUserID, appDB)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("Perfil não encontrado para usuário solicitante: %s", requestingUserID)
-			http.Error(w, "Usuário solicitante não encontrado ou perfil não configurado.", http.StatusUnauthorized)
-		} else {
-			log.Printf("Erro ao buscar perfil do usuário solicitante %s: %v", requestingUserID, err)
-			http.Error(w, "Erro no servidor ao verificar permissões.", http.StatusInternalServerError)
-		}
-		return
-	}
-
-	isAllowed := false
-	isPrivilegedViewer := false
-
-	// Usando os papéis que você definiu: CLIENT, STAFF, ADMIN, SUPER_ADMIN
-	if requestingUserProfile.Role == "admin" || requestingUserProfile.Role == "super_admin" {
-		isAllowed = true
-		isPrivilegedViewer = true
-	} else if requestingUserProfile.Role == "staff" {
-		isAllowed = true
-	}
-
-	if !isAllowed {
-		log.Printf("Usuário %s (Papel: %s) tentou acessar GET /orders sem permissão.", requestingUserID, requestingUserProfile.Role)
-		http.Error(w, "Acesso não autorizado para este recurso.", http.StatusForbidden)
-		return
-	}
-
-	log.Printf("Usuário %s (Papel: %s) acessando GET /orders", requestingUserID, requestingUserProfile.Role)
-
-	statusFilter := r.URL.Query().Get("status")
-	baseQuery := "SELECT id, user_id, order_date, total_amount, status, created_at, updated_at FROM public.orders"
-	var queryParams []interface{}
-	conditions := []string{}
-	paramCounter := 1
-
-	if isPrivilegedViewer {
-		if statusFilter != "" {
-			conditions = append(conditions, fmt.Sprintf("status = $%d", paramCounter))
-			queryParams = append(queryParams, statusFilter)
-			paramCounter++
-		}
-	} else if requestingUserProfile.Role == "staff" {
-		if statusFilter != "" {
-			if statusFilter == "PENDING" || statusFilter == "PREPARING" || statusFilter == "READY" {
-				conditions = append(conditions, fmt.Sprintf("status = $%d", paramCounter))
-				queryParams = append(queryParams, statusFilter)
-				paramCounter++
-			} else {
-				w.Header().Set("Content-Type", "application/json")
-				json.NewEncoder(w).Encode([]Order{})
-				return
-			}
-		} else {
-			conditions = append(conditions, fmt.Sprintf("status IN ($%d, $%d, $%d)", paramCounter, paramCounter+1, paramCounter+2))
-			queryParams = append(queryParams, "PENDING", "PREPARING", "READY")
-			paramCounter += 3
-		}
-	}
-
-	ordersQueryString := baseQuery
-	if len(conditions) > 0 {
-		ordersQueryString += " WHERE " + strings.Join(conditions, " AND ")
-	}
-	ordersQueryString += " ORDER BY order_date DESC;"
-
-	log.Printf("Executando query para /orders: %s com params: %v", ordersQueryString, queryParams)
-
-	orderRows, err := appDB.Query(ordersQueryString, queryParams...) // Renomeado para orderRows para evitar conflito
-	if err != nil {
-		log.Printf("Erro ao buscar todos os pedidos (admin/staff): %v", err)
-		http.Error(w, "Erro ao buscar lista de pedidos.", http.StatusInternalServerError)
-		return
-	}
-	defer orderRows.Close()
-
-	var allOrders []Order
-	for orderRows.Next() {
-		var order Order
-		errScan := orderRows.Scan(&order.ID, &order.UserID, &order.OrderDate, &order.TotalAmount, &order.Status, &order.CreatedAt, &order.UpdatedAt)
-		if errScan != nil {
-			log.Printf("Erro ao scanear pedido (admin/staff): %v", errScan)
-			continue
-		}
-
-		orderItemsDetails, errItems := fetchOrderItemsByOrderID(appDB, order.ID)
-		if errItems != nil {
-			log.Printf("Alerta: Não foi possível buscar itens para o pedido %s (admin view): %v", order.ID, errItems)
-			order.Items = []OrderItem{}
-		} else {
-			order.Items = orderItemsDetails
-		}
-
-		allOrders = append(allOrders, order)
-	}
-	if err = orderRows.Err(); err != nil {
-		log.Printf("Erro após iterar pelos pedidos (admin/staff): %v", err)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(allOrders)
-}
-
 // NOVO: ordersRouterHandler para lidar com rotas /orders/ e /orders/{id}
-func ordersRouterHandler(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+func ordersRouterHandler(w http.ResponseWriter, r *http.Request, appDB *sql.DB, orders OrderRepository, keys IdempotencyRepository, hub *EventHub) {
 	path := r.URL.Path
 	orderIDSegment := strings.TrimPrefix(path, "/orders/")
 	orderIDSegment = strings.Trim(orderIDSegment, "/")
@@ -525,30 +254,65 @@ func ordersRouterHandler(w http.ResponseWriter, r *http.Request, appDB *sql.DB)
 		// ... (código para GET /orders/ e POST /orders/ continua o mesmo) ...
 		switch r.Method {
 		case http.MethodGet: // Listar pedidos (admin/staff)
-			authMiddleware(http.HandlerFunc(func(ww http.ResponseWriter, rr *http.Request) {
-				handleAdminGetOrders(ww, rr, appDB)
-			})).ServeHTTP(w, r)
+			protect(appDB, PermOrdersView, func(ww http.ResponseWriter, rr *http.Request) {
+				handleAdminGetOrders(ww, rr, orders)
+			}).ServeHTTP(w, r)
 		case http.MethodPost: // Criar pedido
-			authMiddleware(http.HandlerFunc(func(ww http.ResponseWriter, rr *http.Request) {
-				handleCreateOrder(ww, rr, appDB)
-			})).ServeHTTP(w, r)
+			authMiddleware(requirePermission(appDB, PermOrderCreate)(idempotencyMiddleware(keys, http.HandlerFunc(func(ww http.ResponseWriter, rr *http.Request) {
+				handleCreateOrder(ww, rr, orders)
+			})))).ServeHTTP(w, r)
 		default:
 			http.Error(w, "Método não permitido para /orders/", http.StatusMethodNotAllowed)
 		}
+	} else if orderIDSegment == "stream" { // Rota /orders/stream: feed SSE de pedidos para a equipe
+		if r.Method != http.MethodGet {
+			http.Error(w, "Método não permitido para /orders/stream. Use GET.", http.StatusMethodNotAllowed)
+			return
+		}
+		protect(appDB, PermOrdersView, func(ww http.ResponseWriter, rr *http.Request) {
+			handleOrderStream(ww, rr, hub)
+		}).ServeHTTP(w, r)
+	} else if orderIDSegment == "pickup" { // Rota /orders/pickup: retirada no balcão com código/QR
+		if r.Method != http.MethodPost {
+			http.Error(w, "Método não permitido para /orders/pickup. Use POST.", http.StatusMethodNotAllowed)
+			return
+		}
+		protect(appDB, PermOrdersManage, func(ww http.ResponseWriter, rr *http.Request) {
+			handleOrderPickup(ww, rr, orders)
+		}).ServeHTTP(w, r)
+	} else if orderIDSegment == "kitchen" { // Rota /orders/kitchen: lista da cozinha por dia de serviço e horário
+		if r.Method != http.MethodGet {
+			http.Error(w, "Método não permitido para /orders/kitchen. Use GET.", http.StatusMethodNotAllowed)
+			return
+		}
+		protect(appDB, PermOrdersView, func(ww http.ResponseWriter, rr *http.Request) {
+			handleGetKitchenOrders(ww, rr, orders)
+		}).ServeHTTP(w, r)
+	} else if orderID, action, hasAction := strings.Cut(orderIDSegment, "/"); hasAction { // Rota /orders/{id}/{ação}
+		switch {
+		case action == "cancel" && r.Method == http.MethodPost:
+			protect(appDB, PermSelfService, func(ww http.ResponseWriter, rr *http.Request) {
+				handleCancelOrder(ww, rr, orders, orderID)
+			}).ServeHTTP(w, r)
+		case action == "history" && r.Method == http.MethodGet:
+			protect(appDB, PermSelfService, func(ww http.ResponseWriter, rr *http.Request) {
+				handleGetOrderHistory(ww, rr, orders, orderID)
+			}).ServeHTTP(w, r)
+		default:
+			http.Error(w, fmt.Sprintf("Rota ou método não permitido para /orders/%s", orderIDSegment), http.StatusMethodNotAllowed)
+		}
 	} else { // Rota com ID: /orders/{id}
 		orderID := orderIDSegment
 		switch r.Method {
 		case http.MethodGet: // <<< --- NOVA LÓGICA PARA GET /{id}
-			authMiddleware(http.HandlerFunc(func(ww http.ResponseWriter, rr *http.Request) {
-				handleGetOrderByID(ww, rr, appDB, orderID) // Passa o orderID extraído
-			})).ServeHTTP(w, r)
+			protect(appDB, PermSelfService, func(ww http.ResponseWriter, rr *http.Request) {
+				handleGetOrderByID(ww, rr, orders, orderID) // Passa o orderID extraído
+			}).ServeHTTP(w, r)
 		case http.MethodPut: // Atualizar status
-			authMiddleware(http.HandlerFunc(func(ww http.ResponseWriter, rr *http.Request) {
-				handleUpdateOrderStatus(ww, rr, appDB, orderID) // Passa o orderID extraído
-			})).ServeHTTP(w, r)
-		// case http.MethodDelete: // Futuramente para deletar/cancelar um pedido por ID
-		// 	log.Printf("Rota DELETE /orders/%s chamada (ainda não implementada)", orderID)
-		// 	http.Error(w, fmt.Sprintf("DELETE para /orders/%s ainda não implementado", orderID), http.StatusNotImplemented)
+			protect(appDB, PermOrdersManage, func(ww http.ResponseWriter, rr *http.Request) {
+				handleUpdateOrderStatus(ww, rr, orders, orderID) // Passa o orderID extraído
+			}).ServeHTTP(w, r)
+		// Cancelamento é feito via POST /orders/{id}/cancel (com estorno), não via DELETE
 		default:
 			http.Error(w, fmt.Sprintf("Método não permitido para /orders/%s", orderID), http.StatusMethodNotAllowed)
 		}
@@ -603,46 +367,48 @@ func fetchOrderItemsByOrderID(appDB *sql.DB, orderID string) ([]OrderItem, error
 	return orderItems, nil
 }
 
-// handleGetOrderByID busca um pedido específico pelo seu ID, com verificação de permissão
-func handleGetOrderByID(w http.ResponseWriter, r *http.Request, appDB *sql.DB, orderIDFromPath string) {
-	// 1. Autenticação já foi feita. Pegar userID e perfil (para checar o papel).
-	userIDfromContext := r.Context().Value(userContextKey)
-	if userIDfromContext == nil {
-		http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
-		return
+// fetchOrderItemsByOrderIDs busca de uma vez os itens de vários pedidos, agrupados por ID do pedido
+func fetchOrderItemsByOrderIDs(appDB *sql.DB, orderIDs []string) (map[string][]OrderItem, error) {
+	itemsByOrder := map[string][]OrderItem{}
+	if len(orderIDs) == 0 {
+		return itemsByOrder, nil
 	}
-	requestingUserID := userIDfromContext.(string)
 
-	requestingUserProfile, err := fetchUserProfile(requestingUserID, appDB) // Usando a função auxiliar
+	rows, err := appDB.Query(`
+		SELECT oi.id, oi.order_id, oi.menu_item_id, mi.name, oi.quantity, oi.price_at_purchase, oi.created_at
+		FROM public.order_items oi
+		JOIN public.menu_items mi ON oi.menu_item_id = mi.id
+		WHERE oi.order_id = ANY($1::uuid[])
+		ORDER BY oi.order_id, oi.created_at, oi.id;`, pq.Array(orderIDs))
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Perfil de usuário solicitante não encontrado.", http.StatusUnauthorized)
-		} else {
-			log.Printf("Erro ao buscar perfil do usuário %s: %v", requestingUserID, err)
-			http.Error(w, "Erro no servidor ao verificar permissões.", http.StatusInternalServerError)
+		return nil, fmt.Errorf("erro ao buscar itens de %d pedidos: %w", len(orderIDs), err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item OrderItem
+		if err := rows.Scan(&item.ID, &item.OrderID, &item.MenuItemID, &item.MenuItemName, &item.Quantity,
+			&item.PriceAtPurchase, &item.CreatedAt); err != nil {
+			return nil, fmt.Errorf("erro ao scanear item de pedido: %w", err)
 		}
-		return
+		itemsByOrder[item.OrderID] = append(itemsByOrder[item.OrderID], item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro após iterar itens de pedidos: %w", err)
 	}
+	return itemsByOrder, nil
+}
 
-	log.Printf("Usuário %s (Papel: %s) tentando buscar pedido com ID: %s", requestingUserID, requestingUserProfile.Role, orderIDFromPath)
+// handleGetOrderByID busca um pedido específico pelo seu ID, com verificação de permissão
+func handleGetOrderByID(w http.ResponseWriter, r *http.Request, orders OrderRepository, orderIDFromPath string) {
+	// 1. Autenticação já foi feita. O perfil (para checar o papel) está no contexto.
+	requestingUserProfile := profileFromContext(r.Context())
+	requestingUserID := requestingUserProfile.ID
 
-	// 2. Buscar o pedido pelo ID fornecido na URL
-	var order Order
-	orderQuery := `
-		SELECT id, user_id, order_date, total_amount, status, created_at, updated_at 
-		FROM public.orders 
-		WHERE id = $1;`
-
-	err = appDB.QueryRow(orderQuery, orderIDFromPath).Scan(
-		&order.ID,
-		&order.UserID,
-		&order.OrderDate,
-		&order.TotalAmount,
-		&order.Status,
-		&order.CreatedAt,
-		&order.UpdatedAt,
-	)
+	log.Printf("Usuário %s (Papel: %s) tentando buscar pedido com ID: %s", requestingUserID, requestingUserProfile.Role, orderIDFromPath)
 
+	// 2. Buscar o pedido (com os itens) pelo ID fornecido na URL
+	order, err := orders.Get(orderIDFromPath)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Pedido não encontrado.", http.StatusNotFound)
@@ -654,12 +420,7 @@ func handleGetOrderByID(w http.ResponseWriter, r *http.Request, appDB *sql.DB, o
 	}
 
 	// 3. Autorização: Verificar se o usuário pode ver este pedido específico
-	canViewOrder := false
-	if requestingUserProfile.Role == "ADMIN" || requestingUserProfile.Role == "SUPER_ADMIN" || requestingUserProfile.Role == "STAFF" {
-		canViewOrder = true
-	} else if requestingUserProfile.Role == "CLIENTE" && order.UserID == requestingUserID {
-		canViewOrder = true
-	}
+	canViewOrder := hasPermission(r.Context(), PermOrdersView) || order.UserID == requestingUserID
 
 	if !canViewOrder {
 		log.Printf("Usuário %s (Papel: %s) não autorizado a ver o pedido %s (pertence ao usuário %s).",
@@ -667,16 +428,43 @@ func handleGetOrderByID(w http.ResponseWriter, r *http.Request, appDB *sql.DB, o
 		http.Error(w, "Acesso não autorizado a este pedido.", http.StatusForbidden)
 		return
 	}
-
-	// 4. Buscar os itens do pedido
-	orderItems, errItems := fetchOrderItemsByOrderID(appDB, order.ID)
-	if errItems != nil {
-		log.Printf("Alerta: Não foi possível buscar itens para o pedido %s: %v", order.ID, errItems)
-		order.Items = []OrderItem{} // Retorna com itens vazios se houver erro aqui
-	} else {
-		order.Items = orderItems
+	if order.UserID != requestingUserID { // O código de retirada só é mostrado ao responsável
+		order.PickupCode = nil
+		order.PickupQRPayload = nil
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(order)
 }
+
+// handleGetOrderHistory trata GET /orders/{id}/history: as transições de status do pedido.
+// Visível para a equipe (staff/admin/super_admin) e para o responsável que fez o pedido.
+func handleGetOrderHistory(w http.ResponseWriter, r *http.Request, orders OrderRepository, orderID string) {
+	requestingUserProfile := profileFromContext(r.Context())
+
+	order, err := orders.Get(orderID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Pedido não encontrado.", http.StatusNotFound)
+		} else {
+			log.Printf("Erro ao buscar pedido %s para histórico: %v", orderID, err)
+			http.Error(w, "Erro no servidor ao buscar histórico do pedido.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if !hasPermission(r.Context(), PermOrdersView) && order.UserID != requestingUserProfile.ID {
+		http.Error(w, "Acesso não autorizado a este pedido.", http.StatusForbidden)
+		return
+	}
+
+	history, err := orders.History(orderID)
+	if err != nil {
+		log.Printf("Erro: %v", err)
+		http.Error(w, "Erro no servidor ao buscar histórico do pedido.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
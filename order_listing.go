@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Listagem de pedidos paginada por cursor (GET /orders). Com cursor, pedidos novos que entram durante a
+// navegação não fazem a página seguinte repetir ou pular pedidos, como aconteceria com offset.
+
+// orderSortFields são os campos aceitos em ?sort= (com "-" na frente para ordem decrescente).
+// O valor é o tipo da coluna no Postgres, usado para converter o valor do cursor na comparação.
+var orderSortFields = map[string]string{
+	"order_date":    "timestamptz",
+	"scheduled_for": "date",
+	"total_amount":  "numeric",
+}
+
+const defaultOrderSort = "-order_date"
+
+// openOrderStatuses são os únicos status que quem não tem PermOrdersViewAll vê em GET /orders: os que ainda têm
+// transição em orderStatusTransitions
+var openOrderStatuses = []string{OrderStatusPending, OrderStatusPreparing, OrderStatusReady}
+
+// OrderCursor é a posição do último pedido de uma página: valor do campo de ordenação e ID (desempate)
+type OrderCursor struct {
+	Sort  string `json:"sort"`
+	Value string `json:"value"`
+	ID    string `json:"id"`
+}
+
+// OrdersPage é a resposta paginada de GET /orders
+type OrdersPage struct {
+	Orders     []Order `json:"orders"`
+	Total      int     `json:"total"` // Pedidos que atendem aos filtros, em todas as páginas
+	Limit      int     `json:"limit"`
+	NextCursor *string `json:"next_cursor"` // nil na última página
+}
+
+// MyOrdersPage é a resposta de GET /me/orders: a página de pedidos e o gasto no período filtrado
+type MyOrdersPage struct {
+	OrdersPage
+	From *string `json:"from,omitempty"` // Período pedido em ?from=&to=, como veio
+	To   *string `json:"to,omitempty"`
+	// Gasto dos pedidos listados, feitos no período (order_date)
+	Spending OrderSpending `json:"spending"`
+	// Gasto dos pedidos servidos no período (scheduled_for), a base dos limites diário/semanal do aluno
+	// (ver checkStudentOrderRules); difere de Spending quando há encomendas para outro período
+	ScheduledSpending OrderSpending `json:"scheduled_spending"`
+}
+
+// OrderSpending soma os pedidos não cancelados que atendem aos filtros, em todas as páginas
+type OrderSpending struct {
+	Total      Money             `json:"total"`
+	OrderCount int               `json:"order_count"`
+	ByStudent  []StudentSpending `json:"by_student"`
+}
+
+// StudentSpending é o gasto com um aluno; StudentID nil agrupa pedidos sem aluno vinculado
+type StudentSpending struct {
+	StudentID   *string `json:"student_id"`
+	StudentName *string `json:"student_name,omitempty"`
+	Total       Money   `json:"total"`
+	OrderCount  int     `json:"order_count"`
+}
+
+// add acrescenta o gasto de um aluno aos totais
+func (spending *OrderSpending) add(entry StudentSpending) {
+	spending.ByStudent = append(spending.ByStudent, entry)
+	spending.Total += entry.Total
+	spending.OrderCount += entry.OrderCount
+}
+
+// splitOrderSort separa "-total_amount" em ("total_amount", true)
+func splitOrderSort(sort string) (string, bool) {
+	if sort == "" {
+		sort = defaultOrderSort
+	}
+	field := strings.TrimPrefix(sort, "-")
+	return field, field != sort
+}
+
+// orderSortValue é o valor do pedido no campo de ordenação, no formato guardado no cursor
+func orderSortValue(order Order, field string) string {
+	switch field {
+	case "scheduled_for":
+		return order.ScheduledFor
+	case "total_amount":
+		return order.TotalAmount.String()
+	default:
+		return order.OrderDate.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// encodeOrderCursor gera o token opaco devolvido em next_cursor
+func encodeOrderCursor(cursor OrderCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeOrderCursor lê o token de ?cursor=, que deve ter sido gerado com a mesma ordenação
+func decodeOrderCursor(token, sort string) (*OrderCursor, error) {
+	invalid := fmt.Errorf("parâmetro 'cursor' inválido")
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, invalid
+	}
+	var cursor OrderCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" {
+		return nil, invalid
+	}
+	if cursor.Sort != sort {
+		return nil, fmt.Errorf("o cursor foi gerado com outra ordenação (%s); refaça a busca sem cursor", cursor.Sort)
+	}
+
+	field, _ := splitOrderSort(sort)
+	switch field {
+	case "order_date":
+		_, err = time.Parse(time.RFC3339Nano, cursor.Value)
+	case "scheduled_for":
+		_, err = time.Parse(dateLayout, cursor.Value)
+	case "total_amount":
+		_, err = parseMoney(cursor.Value, true)
+	}
+	if err != nil {
+		return nil, invalid
+	}
+	return &cursor, nil
+}
+
+// parseOrderFilter lê os filtros de GET /orders e GET /me/orders: ?status= (um ou mais, separados por vírgula), ?from=&to= (data do
+// pedido), ?user_id=, ?student_id=, ?class_id=, ?guardian_user_id=, ?menu_item_id=, ?sort=, ?cursor= e ?limit=
+func parseOrderFilter(r *http.Request) (OrderFilter, error) {
+	query := r.URL.Query()
+	filter := OrderFilter{
+		UserID:         query.Get("user_id"),
+		StudentID:      query.Get("student_id"),
+		ClassID:        query.Get("class_id"),
+		GuardianUserID: query.Get("guardian_user_id"),
+		MenuItemID:     query.Get("menu_item_id"),
+		Sort:           defaultOrderSort,
+	}
+
+	var err error
+	if filter.Limit, err = parseLimit(r, 50, 200); err != nil {
+		return filter, err
+	}
+	if filter.From, filter.To, err = parseDateRange(r); err != nil {
+		return filter, err
+	}
+
+	for _, status := range strings.Split(query.Get("status"), ",") {
+		status = strings.ToUpper(strings.TrimSpace(status))
+		if status == "" {
+			continue
+		}
+		if _, known := orderStatusTransitions[status]; !known {
+			return filter, fmt.Errorf("status '%s' inválido", status)
+		}
+		filter.Statuses = append(filter.Statuses, status)
+	}
+
+	if raw := strings.TrimSpace(query.Get("sort")); raw != "" {
+		if field, _ := splitOrderSort(raw); orderSortFields[field] == "" {
+			return filter, fmt.Errorf("parâmetro 'sort' inválido: %q (use order_date, scheduled_for ou total_amount, com '-' para decrescente)", raw)
+		}
+		filter.Sort = raw
+	}
+	if raw := query.Get("cursor"); raw != "" {
+		if filter.After, err = decodeOrderCursor(raw, filter.Sort); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+// listOrdersPage busca uma página de pedidos; busca um pedido a mais que o limite para saber se há próxima página
+func listOrdersPage(orders OrderRepository, filter OrderFilter) (*OrdersPage, error) {
+	limit := filter.Limit
+	filter.Limit = limit + 1
+	list, total, err := orders.List(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &OrdersPage{Orders: list, Total: total, Limit: limit}
+	if len(list) > limit {
+		page.Orders = list[:limit]
+		last := page.Orders[limit-1]
+		field, _ := splitOrderSort(filter.Sort)
+		next := encodeOrderCursor(OrderCursor{Sort: filter.Sort, Value: orderSortValue(last, field), ID: last.ID})
+		page.NextCursor = &next
+	}
+	return page, nil
+}
+
+// handleAdminGetOrders lista pedidos para admin/staff com filtros e paginação por cursor (ver parseOrderFilter).
+// Quem não tem PermOrdersViewAll só vê pedidos em aberto (PENDING, PREPARING, READY); pedir outro status é 403.
+func handleAdminGetOrders(w http.ResponseWriter, r *http.Request, orders OrderRepository) {
+	// Rota protegida por PermOrdersView
+	requestingUserProfile := profileFromContext(r.Context())
+	isPrivilegedViewer := hasPermission(r.Context(), PermOrdersViewAll)
+
+	log.Printf("Usuário %s (Papel: %s) acessando GET /orders", requestingUserProfile.ID, requestingUserProfile.Role)
+
+	filter, err := parseOrderFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !isPrivilegedViewer {
+		if len(filter.Statuses) == 0 {
+			filter.Statuses = openOrderStatuses
+		}
+		for _, status := range filter.Statuses {
+			if len(orderStatusTransitions[status]) == 0 {
+				http.Error(w, fmt.Sprintf("Sem permissão para ver pedidos com status %s; só é possível ver pedidos em aberto (%s).",
+					status, strings.Join(openOrderStatuses, ", ")), http.StatusForbidden)
+				return
+			}
+		}
+	}
+
+	page, err := listOrdersPage(orders, filter)
+	if err != nil {
+		log.Printf("Erro ao buscar pedidos (admin/staff): %v", err)
+		http.Error(w, "Erro ao buscar lista de pedidos.", http.StatusInternalServerError)
+		return
+	}
+	// O código de retirada só vai para o responsável que fez o pedido
+	for i := range page.Orders {
+		page.Orders[i].PickupCode, page.Orders[i].PickupQRPayload = nil, nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// handleGetMyOrders trata GET /me/orders: o histórico de pedidos feitos pelo usuário autenticado e dos alunos de
+// que ele é responsável (inclusive os feitos por outros responsáveis), com os filtros ?student_id=, ?from=&to=, ?status=,
+// ?sort=, ?cursor= e ?limit= de parseOrderFilter, e o gasto no período
+func handleGetMyOrders(w http.ResponseWriter, r *http.Request, orders OrderRepository, students StudentRepository) {
+	userID, ok := r.Context().Value(userContextKey).(string)
+	if !ok || userID == "" {
+		http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+		return
+	}
+
+	filter, err := parseOrderFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.VisibleToUserID = userID
+	if filter.StudentID != "" {
+		// Além dos alunos de que é responsável, o usuário filtra pelos alunos para quem já pediu
+		// (ex: depois de perder o vínculo)
+		role, err := students.GuardianRole(filter.StudentID, userID)
+		ownOrders := 0
+		if err == nil && role == "" {
+			_, ownOrders, err = orders.List(OrderFilter{UserID: userID, StudentID: filter.StudentID, Limit: 1})
+		}
+		if err != nil {
+			log.Printf("Erro: %v", err)
+			http.Error(w, "Erro ao validar aluno.", http.StatusInternalServerError)
+			return
+		}
+		if role == "" && ownOrders == 0 {
+			http.Error(w, "Aluno não encontrado ou não pertence a este responsável.", http.StatusNotFound)
+			return
+		}
+	}
+
+	page, err := listOrdersPage(orders, filter)
+	if err != nil {
+		log.Printf("Erro ao buscar pedidos do usuário %s: %v", userID, err)
+		http.Error(w, "Erro ao buscar histórico de pedidos.", http.StatusInternalServerError)
+		return
+	}
+	// Pedidos feitos por outro responsável do aluno vêm sem o código de retirada
+	for i := range page.Orders {
+		if page.Orders[i].UserID != userID {
+			page.Orders[i].PickupCode, page.Orders[i].PickupQRPayload = nil, nil
+		}
+	}
+	spending, err := orders.Spending(filter)
+	if err != nil {
+		log.Printf("Erro ao somar gastos do usuário %s: %v", userID, err)
+		http.Error(w, "Erro ao buscar histórico de pedidos.", http.StatusInternalServerError)
+		return
+	}
+	scheduledFilter := filter
+	scheduledFilter.From, scheduledFilter.To = nil, nil
+	scheduledFilter.ScheduledFrom, scheduledFilter.ScheduledTo = filter.From, filter.To
+	scheduledSpending, err := orders.Spending(scheduledFilter)
+	if err != nil {
+		log.Printf("Erro ao somar gastos do usuário %s por dia de serviço: %v", userID, err)
+		http.Error(w, "Erro ao buscar histórico de pedidos.", http.StatusInternalServerError)
+		return
+	}
+
+	response := MyOrdersPage{OrdersPage: *page, Spending: *spending, ScheduledSpending: *scheduledSpending}
+	if raw := r.URL.Query().Get("from"); raw != "" {
+		response.From = &raw
+	}
+	if raw := r.URL.Query().Get("to"); raw != "" {
+		response.To = &raw
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
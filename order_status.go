@@ -0,0 +1,183 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Status possíveis de um pedido
+const (
+	OrderStatusPending   = "PENDING"
+	OrderStatusPreparing = "PREPARING"
+	OrderStatusReady     = "READY"
+	OrderStatusCompleted = "COMPLETED"
+	OrderStatusCanceled  = "CANCELED"
+)
+
+// orderStatusTransitions é a máquina de estados do pedido: para cada status, os próximos status permitidos.
+// COMPLETED e CANCELED são finais.
+var orderStatusTransitions = map[string]map[string]bool{
+	OrderStatusPending:   {OrderStatusPreparing: true, OrderStatusCanceled: true},
+	OrderStatusPreparing: {OrderStatusReady: true, OrderStatusCanceled: true},
+	OrderStatusReady:     {OrderStatusCompleted: true, OrderStatusCanceled: true},
+	OrderStatusCompleted: {},
+	OrderStatusCanceled:  {},
+}
+
+// Status a partir dos quais cada perfil pode cancelar um pedido
+var (
+	parentCancelableStatuses = map[string]bool{OrderStatusPending: true}
+	staffCancelableStatuses  = map[string]bool{OrderStatusPending: true, OrderStatusPreparing: true, OrderStatusReady: true}
+)
+
+// OrderStatusChange espelha uma linha de order_status_history
+type OrderStatusChange struct {
+	ID         string    `json:"id"`
+	OrderID    string    `json:"order_id"`
+	FromStatus *string   `json:"from_status"` // nil na criação do pedido
+	ToStatus   string    `json:"to_status"`
+	ChangedBy  *string   `json:"changed_by,omitempty"`
+	Reason     *string   `json:"reason,omitempty"`
+	ChangedAt  time.Time `json:"changed_at"`
+}
+
+// orderTransitionError é uma mudança de status que a máquina de estados (ou o papel do usuário) não permite
+type orderTransitionError struct {
+	from, to string
+}
+
+func (transition *orderTransitionError) Error() string {
+	return fmt.Sprintf("transição de status não permitida: %s → %s", transition.from, transition.to)
+}
+
+func isValidOrderStatus(status string) bool {
+	_, ok := orderStatusTransitions[status]
+	return ok
+}
+
+// canTransitionOrderStatus diz se um usuário com o papel informado pode mover o pedido de 'from' para 'to'.
+// Avanços na preparação (PREPARING, READY, COMPLETED) são exclusivos da equipe;
+// o cancelamento segue as regras por papel de parentCancelableStatuses/staffCancelableStatuses.
+func canTransitionOrderStatus(from, to string, role Role) bool {
+	if !orderStatusTransitions[from][to] {
+		return false
+	}
+	if to == OrderStatusCanceled {
+		if role.Can(PermOrdersManage) {
+			return staffCancelableStatuses[from]
+		}
+		return parentCancelableStatuses[from]
+	}
+	return role.Can(PermOrdersManage)
+}
+
+// updateOrderStatus trava o pedido, valida a transição para o papel de 'actor' e atualiza o status com
+// histórico, tudo na mesma transação. Cancelamentos vão por cancelOrder (estorno e estoque).
+func updateOrderStatus(appDB *sql.DB, orderID, newStatus string, actor *UserProfile) (*Order, error) {
+	tx, err := appDB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao iniciar transação para status do pedido %s: %w", orderID, err)
+	}
+	defer rollbackTx(tx)
+
+	var currentStatus string
+	err = tx.QueryRow("SELECT status FROM public.orders WHERE id = $1 FOR UPDATE", orderID).Scan(&currentStatus)
+	if err == sql.ErrNoRows {
+		return nil, errOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar status atual do pedido %s: %w", orderID, err)
+	}
+	if !canTransitionOrderStatus(currentStatus, newStatus, actor.Role) {
+		return nil, &orderTransitionError{from: currentStatus, to: newStatus}
+	}
+
+	var updatedOrder Order
+	var studentID, pickupSlotID sql.NullString
+	updateQuery := `
+		UPDATE public.orders 
+		SET status = $1, updated_at = NOW() 
+		WHERE id = $2
+		RETURNING id, user_id, student_id, order_date, to_char(scheduled_for, 'YYYY-MM-DD'), pickup_slot_id,
+		          total_amount, status, created_at, updated_at;`
+	err = tx.QueryRow(updateQuery, newStatus, orderID).Scan(
+		&updatedOrder.ID,
+		&updatedOrder.UserID,
+		&studentID,
+		&updatedOrder.OrderDate,
+		&updatedOrder.ScheduledFor,
+		&pickupSlotID,
+		&updatedOrder.TotalAmount,
+		&updatedOrder.Status,
+		&updatedOrder.CreatedAt,
+		&updatedOrder.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao atualizar status do pedido %s: %w", orderID, err)
+	}
+	if studentID.Valid {
+		updatedOrder.StudentID = &studentID.String
+	}
+	if pickupSlotID.Valid {
+		updatedOrder.PickupSlotID = &pickupSlotID.String
+	}
+
+	if err := recordOrderStatusChange(tx, orderID, &currentStatus, newStatus, &actor.ID, nil); err != nil {
+		return nil, err
+	}
+	if err := commitTx(tx); err != nil {
+		return nil, fmt.Errorf("erro ao confirmar status do pedido %s: %w", orderID, err)
+	}
+	return &updatedOrder, nil
+}
+
+// recordOrderStatusChange grava uma transição em order_status_history dentro da transação informada
+// e publica o evento correspondente (order.created quando fromStatus é nil), entregue no commit.
+func recordOrderStatusChange(tx *sql.Tx, orderID string, fromStatus *string, toStatus string, changedBy *string, reason *string) error {
+	insertQuery := `
+		INSERT INTO public.order_status_history (order_id, from_status, to_status, changed_by, reason)
+		VALUES ($1, $2, $3, $4, $5);`
+	if _, err := tx.Exec(insertQuery, orderID, fromStatus, toStatus, changedBy, reason); err != nil {
+		return fmt.Errorf("erro ao registrar histórico de status do pedido %s: %w", orderID, err)
+	}
+	return publishOrderEvent(tx, orderID, fromStatus)
+}
+
+// fetchOrderStatusHistory retorna as transições de um pedido em ordem cronológica
+func fetchOrderStatusHistory(appDB *sql.DB, orderID string) ([]OrderStatusChange, error) {
+	historyQuery := `
+		SELECT id, order_id, from_status, to_status, changed_by, reason, changed_at
+		FROM public.order_status_history
+		WHERE order_id = $1
+		ORDER BY changed_at ASC, id ASC;`
+
+	rows, err := appDB.Query(historyQuery, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar histórico do pedido %s: %w", orderID, err)
+	}
+	defer rows.Close()
+
+	history := []OrderStatusChange{}
+	for rows.Next() {
+		var change OrderStatusChange
+		var fromStatus, changedBy, reason sql.NullString
+		if err := rows.Scan(&change.ID, &change.OrderID, &fromStatus, &change.ToStatus, &changedBy, &reason, &change.ChangedAt); err != nil {
+			return nil, fmt.Errorf("erro ao scanear histórico do pedido %s: %w", orderID, err)
+		}
+		if fromStatus.Valid {
+			change.FromStatus = &fromStatus.String
+		}
+		if changedBy.Valid {
+			change.ChangedBy = &changedBy.String
+		}
+		if reason.Valid {
+			change.Reason = &reason.String
+		}
+		history = append(history, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro após iterar histórico do pedido %s: %w", orderID, err)
+	}
+	return history, nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cabeçalho com a assinatura HMAC-SHA256 (hex) do corpo do webhook: "sha256=<hex>"
+const webhookSignatureHeader = "X-Webhook-Signature"
+
+var errInvalidWebhookSignature = errors.New("assinatura do webhook inválida")
+
+// PaymentCharge é a cobrança criada no provedor para uma recarga
+type PaymentCharge struct {
+	ProviderChargeID string
+	QRCodePayload    string // Conteúdo do QR Code / "copia e cola"
+	ExpiresAt        *time.Time
+}
+
+// PaymentNotification é uma confirmação de pagamento recebida pelo webhook do provedor
+type PaymentNotification struct {
+	ProviderChargeID string
+	Amount           Money
+	PaidAt           time.Time
+}
+
+// PaymentProvider abstrai o provedor de pagamento usado nas recargas de créditos
+type PaymentProvider interface {
+	// Name identifica o provedor (gravado em credit_topups.provider)
+	Name() string
+	// CreateCharge cria a cobrança para a recarga informada
+	CreateCharge(ctx context.Context, topUp *CreditTopUp) (*PaymentCharge, error)
+	// ParseWebhook valida a assinatura do webhook e extrai os pagamentos confirmados
+	ParseWebhook(r *http.Request, body []byte) ([]PaymentNotification, error)
+}
+
+// newPaymentProviderFromEnv escolhe o provedor por PAYMENT_PROVIDER ("pix" ou "fake"), que é obrigatório:
+// sem ele, um ambiente de produção mal configurado passaria a emitir cobranças fake que ninguém consegue pagar.
+func newPaymentProviderFromEnv() (PaymentProvider, error) {
+	webhookSecret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+	if webhookSecret == "" {
+		log.Println("Atenção: PAYMENT_WEBHOOK_SECRET não configurado. Webhooks de pagamento serão rejeitados.")
+	}
+
+	expiration := 30 * time.Minute
+	if raw := os.Getenv("TOPUP_EXPIRATION_MINUTES"); raw != "" {
+		minutes, err := strconv.Atoi(raw)
+		if err != nil || minutes <= 0 {
+			return nil, fmt.Errorf("TOPUP_EXPIRATION_MINUTES inválido: %q", raw)
+		}
+		expiration = time.Duration(minutes) * time.Minute
+	}
+
+	providerName := strings.TrimSpace(os.Getenv("PAYMENT_PROVIDER"))
+	switch providerName {
+	case "pix":
+		provider := &pixPaymentProvider{
+			pixKey:        os.Getenv("PIX_KEY"),
+			merchantName:  os.Getenv("PIX_MERCHANT_NAME"),
+			merchantCity:  os.Getenv("PIX_MERCHANT_CITY"),
+			webhookSecret: webhookSecret,
+			expiration:    expiration,
+		}
+		if provider.pixKey == "" || provider.merchantName == "" || provider.merchantCity == "" {
+			return nil, fmt.Errorf("PIX_KEY, PIX_MERCHANT_NAME e PIX_MERCHANT_CITY são obrigatórios para o provedor pix")
+		}
+		return provider, nil
+	case "fake":
+		log.Println("Atenção: usando provedor de pagamento 'fake' (apenas para desenvolvimento e testes).")
+		return &fakePaymentProvider{webhookSecret: webhookSecret, expiration: expiration}, nil
+	case "":
+		return nil, fmt.Errorf("PAYMENT_PROVIDER não configurado (use pix ou fake)")
+	default:
+		return nil, fmt.Errorf("PAYMENT_PROVIDER desconhecido: %q", providerName)
+	}
+}
+
+// signWebhookBody calcula a assinatura esperada no cabeçalho X-Webhook-Signature
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyWebhookSignature compara a assinatura recebida com a esperada em tempo constante
+func verifyWebhookSignature(secret string, body []byte, signature string) error {
+	if secret == "" || signature == "" {
+		return errInvalidWebhookSignature
+	}
+	if !hmac.Equal([]byte(signWebhookBody(secret, body)), []byte(strings.TrimSpace(signature))) {
+		return errInvalidWebhookSignature
+	}
+	return nil
+}
+
+// --- Provedor fake (em processo) ---
+
+// fakePaymentProvider não fala com ninguém: gera cobranças fictícias e aceita webhooks
+// assinados com o mesmo segredo, no formato {"charge_id": "...", "amount": 10.00}.
+// Útil em desenvolvimento e testes para simular a confirmação de pagamento.
+type fakePaymentProvider struct {
+	webhookSecret string
+	expiration    time.Duration
+}
+
+type fakeWebhookPayload struct {
+	ChargeID string `json:"charge_id"`
+	Amount   Money  `json:"amount"`
+}
+
+func (p *fakePaymentProvider) Name() string { return "fake" }
+
+func (p *fakePaymentProvider) CreateCharge(ctx context.Context, topUp *CreditTopUp) (*PaymentCharge, error) {
+	expiresAt := time.Now().Add(p.expiration)
+	return &PaymentCharge{
+		ProviderChargeID: "fake_" + topUp.ID,
+		QRCodePayload:    fmt.Sprintf("FAKEPIX:%s:%s", topUp.ID, topUp.Amount),
+		ExpiresAt:        &expiresAt,
+	}, nil
+}
+
+func (p *fakePaymentProvider) ParseWebhook(r *http.Request, body []byte) ([]PaymentNotification, error) {
+	if err := verifyWebhookSignature(p.webhookSecret, body, r.Header.Get(webhookSignatureHeader)); err != nil {
+		return nil, err
+	}
+	var payload fakeWebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("payload do webhook fake inválido: %w", err)
+	}
+	if payload.ChargeID == "" {
+		return nil, fmt.Errorf("payload do webhook fake sem charge_id")
+	}
+	return []PaymentNotification{{ProviderChargeID: payload.ChargeID, Amount: payload.Amount, PaidAt: time.Now()}}, nil
+}
+
+// --- Provedor PIX ---
+
+// pixPaymentProvider gera o BR Code (PIX "copia e cola") de cobrança imediata com txid,
+// e recebe as confirmações no formato de webhook da API PIX do Bacen:
+// {"pix": [{"endToEndId": "...", "txid": "...", "valor": "10.00", "horario": "2024-01-01T10:00:00Z"}]}
+type pixPaymentProvider struct {
+	pixKey        string
+	merchantName  string
+	merchantCity  string
+	webhookSecret string
+	expiration    time.Duration
+}
+
+type pixWebhookPayload struct {
+	Pix []struct {
+		EndToEndID string `json:"endToEndId"`
+		TxID       string `json:"txid"`
+		Valor      string `json:"valor"`
+		Horario    string `json:"horario"`
+	} `json:"pix"`
+}
+
+func (p *pixPaymentProvider) Name() string { return "pix" }
+
+func (p *pixPaymentProvider) CreateCharge(ctx context.Context, topUp *CreditTopUp) (*PaymentCharge, error) {
+	// txid do PIX aceita até 25 caracteres alfanuméricos: usamos o UUID da recarga sem hífens, truncado
+	txID := strings.ReplaceAll(topUp.ID, "-", "")
+	if len(txID) > 25 {
+		txID = txID[:25]
+	}
+	expiresAt := time.Now().Add(p.expiration)
+	return &PaymentCharge{
+		ProviderChargeID: txID,
+		QRCodePayload:    buildPixBRCode(p.pixKey, p.merchantName, p.merchantCity, txID, topUp.Amount),
+		ExpiresAt:        &expiresAt,
+	}, nil
+}
+
+func (p *pixPaymentProvider) ParseWebhook(r *http.Request, body []byte) ([]PaymentNotification, error) {
+	if err := verifyWebhookSignature(p.webhookSecret, body, r.Header.Get(webhookSignatureHeader)); err != nil {
+		return nil, err
+	}
+	var payload pixWebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("payload do webhook PIX inválido: %w", err)
+	}
+
+	notifications := make([]PaymentNotification, 0, len(payload.Pix))
+	for _, pix := range payload.Pix {
+		if pix.TxID == "" {
+			continue // PIX recebido sem txid não corresponde a nenhuma recarga
+		}
+		amount, err := parseMoney(pix.Valor, true)
+		if err != nil {
+			return nil, fmt.Errorf("valor inválido no PIX %s: %q", pix.EndToEndID, pix.Valor)
+		}
+		paidAt, err := time.Parse(time.RFC3339, pix.Horario)
+		if err != nil {
+			paidAt = time.Now()
+		}
+		notifications = append(notifications, PaymentNotification{ProviderChargeID: pix.TxID, Amount: amount, PaidAt: paidAt})
+	}
+	return notifications, nil
+}
+
+// buildPixBRCode monta o payload EMV do BR Code conforme o manual do PIX (campos ID + tamanho + valor, CRC16 no final)
+func buildPixBRCode(pixKey, merchantName, merchantCity, txID string, amount Money) string {
+	emv := func(id, value string) string {
+		return fmt.Sprintf("%s%02d%s", id, len(value), value)
+	}
+	merchantAccount := emv("00", "br.gov.bcb.pix") + emv("01", pixKey)
+
+	payload := emv("00", "01") + // Payload Format Indicator
+		emv("01", "12") + // Point of Initiation Method: 12 = uso único
+		emv("26", merchantAccount) +
+		emv("52", "0000") + // Merchant Category Code
+		emv("53", "986") + // Moeda: BRL
+		emv("54", amount.String()) +
+		emv("58", "BR") +
+		emv("59", pixASCII(merchantName, 25)) +
+		emv("60", pixASCII(merchantCity, 15)) +
+		emv("62", emv("05", txID)) +
+		"6304" // ID + tamanho do CRC, que é calculado sobre todo o payload anterior
+
+	return payload + fmt.Sprintf("%04X", crc16CCITT([]byte(payload)))
+}
+
+// pixASCII remove caracteres fora do ASCII (o BR Code conta tamanho em bytes) e limita o tamanho
+func pixASCII(value string, maxLen int) string {
+	var b strings.Builder
+	for _, c := range value {
+		if c < 128 {
+			b.WriteRune(c)
+		}
+	}
+	result := b.String()
+	if len(result) > maxLen {
+		result = result[:maxLen]
+	}
+	return result
+}
+
+// crc16CCITT calcula o CRC16-CCITT (polinômio 0x1021, valor inicial 0xFFFF) exigido pelo BR Code
+func crc16CCITT(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	pickupCodeLength   = 6
+	pickupCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // Sem 0/O e 1/I, fáceis de confundir no balcão
+	pickupCodeAttempts = 5
+	pickupQRPrefix     = "CANTINA-PICKUP:v1:" // QR: CANTINA-PICKUP:v1:<order_id>:<código>
+)
+
+var (
+	errPickupCodeNotFound = errors.New("código de retirada inválido")
+	errOrderNotReady      = errors.New("pedido não está pronto para retirada")
+	errPickupWrongDay     = errors.New("pedido não é para hoje")
+)
+
+// PickupPayload é o corpo de POST /orders/pickup: o código digitado ou o conteúdo lido do QR
+type PickupPayload struct {
+	Code      string `json:"code"`
+	QRPayload string `json:"qr_payload"`
+}
+
+// generatePickupCode sorteia um código curto com crypto/rand
+func generatePickupCode() (string, error) {
+	code := make([]byte, pickupCodeLength)
+	alphabetSize := big.NewInt(int64(len(pickupCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", fmt.Errorf("erro ao gerar código de retirada: %w", err)
+		}
+		code[i] = pickupCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// insertWithOpenPickupCode sorteia um código e chama 'insert' com ele. Se outro pedido em aberto já tem o código
+// (violação de orders_open_pickup_code_key, inclusive de uma transação concorrente que confirmou antes),
+// desfaz a tentativa até o savepoint e sorteia outro. Retorna o código gravado.
+func insertWithOpenPickupCode(tx *sql.Tx, insert func(code string) error) (string, error) {
+	for attempt := 0; attempt < pickupCodeAttempts; attempt++ {
+		code, err := generatePickupCode()
+		if err != nil {
+			return "", err
+		}
+		if _, err := tx.Exec("SAVEPOINT pickup_code"); err != nil {
+			return "", fmt.Errorf("erro ao criar savepoint do código de retirada: %w", err)
+		}
+		err = insert(code)
+		if err == nil {
+			if _, err := tx.Exec("RELEASE SAVEPOINT pickup_code"); err != nil {
+				return "", fmt.Errorf("erro ao liberar savepoint do código de retirada: %w", err)
+			}
+			return code, nil
+		}
+		if !isUniqueViolation(err, "orders_open_pickup_code_key") {
+			return "", err
+		}
+		if _, err := tx.Exec("ROLLBACK TO SAVEPOINT pickup_code"); err != nil {
+			return "", fmt.Errorf("erro ao desfazer código de retirada repetido: %w", err)
+		}
+	}
+	return "", fmt.Errorf("não foi possível gerar um código de retirada livre após %d tentativas", pickupCodeAttempts)
+}
+
+// pickupQRPayload é o conteúdo do QR code exibido no app do responsável
+func pickupQRPayload(orderID, code string) string {
+	return pickupQRPrefix + orderID + ":" + code
+}
+
+// setOrderPickupFields preenche código e QR na resposta (só para o responsável dono do pedido)
+func setOrderPickupFields(order *Order, pickupCode sql.NullString) {
+	if !pickupCode.Valid {
+		return
+	}
+	qrPayload := pickupQRPayload(order.ID, pickupCode.String)
+	order.PickupCode = &pickupCode.String
+	order.PickupQRPayload = &qrPayload
+}
+
+// parsePickupPayload extrai (order_id opcional, código) do payload
+func parsePickupPayload(payload PickupPayload) (string, string, error) {
+	if raw := strings.TrimSpace(payload.QRPayload); raw != "" {
+		rest, ok := strings.CutPrefix(raw, pickupQRPrefix)
+		if !ok {
+			return "", "", fmt.Errorf("QR code não reconhecido")
+		}
+		orderID, code, ok := strings.Cut(rest, ":")
+		if !ok || orderID == "" || code == "" {
+			return "", "", fmt.Errorf("QR code não reconhecido")
+		}
+		return orderID, strings.ToUpper(code), nil
+	}
+	code := strings.ToUpper(strings.TrimSpace(payload.Code))
+	if code == "" {
+		return "", "", fmt.Errorf("informe 'code' ou 'qr_payload'")
+	}
+	return "", code, nil
+}
+
+// completeOrderPickup valida o código, confere que o pedido está READY e é para hoje,
+// e o marca como COMPLETED (retirado pela equipe 'staffID'), tudo na mesma transação
+func completeOrderPickup(appDB *sql.DB, orderID, code, staffID string) (*KitchenOrder, error) {
+	tx, err := appDB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao iniciar transação de retirada: %w", err)
+	}
+	defer rollbackTx(tx)
+
+	// Com o QR, o pedido é identificado pelo id e o código precisa bater; digitado, o código identifica
+	// o pedido entre os em aberto (índice único orders_open_pickup_code_key)
+	query := `
+		SELECT id, status, to_char(scheduled_for, 'YYYY-MM-DD')
+		FROM public.orders
+		WHERE pickup_code = $1 AND status IN ('PENDING', 'PREPARING', 'READY')
+		FOR UPDATE;`
+	queryParams := []interface{}{code}
+	if orderID != "" {
+		query = `
+			SELECT id, status, to_char(scheduled_for, 'YYYY-MM-DD')
+			FROM public.orders
+			WHERE id = $2 AND pickup_code = $1
+			FOR UPDATE;`
+		queryParams = append(queryParams, orderID)
+	}
+	var lockedOrderID, currentStatus, scheduledFor string
+	err = tx.QueryRow(query, queryParams...).Scan(&lockedOrderID, &currentStatus, &scheduledFor)
+	// Um order_id que não é UUID no QR também não identifica nenhum pedido
+	if err == sql.ErrNoRows || isInvalidTextRepresentation(err) {
+		return nil, errPickupCodeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar pedido pelo código de retirada: %w", err)
+	}
+
+	if currentStatus != OrderStatusReady {
+		return nil, fmt.Errorf("%w (status atual: %s)", errOrderNotReady, currentStatus)
+	}
+	if scheduledFor != schoolToday().Format(dateLayout) {
+		return nil, fmt.Errorf("%w (agendado para %s)", errPickupWrongDay, scheduledFor)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE public.orders
+		SET status = $2, picked_up_at = NOW(), picked_up_by = $3, updated_at = NOW()
+		WHERE id = $1;`, lockedOrderID, OrderStatusCompleted, staffID)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao concluir retirada do pedido %s: %w", lockedOrderID, err)
+	}
+	reason := "Retirado no balcão"
+	if err := recordOrderStatusChange(tx, lockedOrderID, &currentStatus, OrderStatusCompleted, &staffID, &reason); err != nil {
+		return nil, err
+	}
+
+	// Dados para a equipe conferir quem está retirando
+	var order KitchenOrder
+	var studentID, pickupSlotID, studentName, slotCode, slotName sql.NullString
+	err = tx.QueryRow(`
+		SELECT o.id, o.user_id, o.student_id, o.order_date, to_char(o.scheduled_for, 'YYYY-MM-DD'), o.pickup_slot_id,
+		       o.total_amount, o.status, o.created_at, o.updated_at, s.name, ps.code, ps.name
+		FROM public.orders o
+		LEFT JOIN public.students s ON s.id = o.student_id
+		LEFT JOIN public.pickup_slots ps ON ps.id = o.pickup_slot_id
+		WHERE o.id = $1;`, lockedOrderID).Scan(&order.ID, &order.UserID, &studentID, &order.OrderDate, &order.ScheduledFor, &pickupSlotID,
+		&order.TotalAmount, &order.Status, &order.CreatedAt, &order.UpdatedAt, &studentName, &slotCode, &slotName)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar pedido %s retirado: %w", lockedOrderID, err)
+	}
+	if studentID.Valid {
+		order.StudentID = &studentID.String
+	}
+	if pickupSlotID.Valid {
+		order.PickupSlotID = &pickupSlotID.String
+	}
+	if studentName.Valid {
+		order.StudentName = &studentName.String
+	}
+	if slotCode.Valid {
+		order.PickupSlotCode = &slotCode.String
+		order.PickupSlotName = &slotName.String
+	}
+
+	if err := commitTx(tx); err != nil {
+		return nil, fmt.Errorf("erro ao confirmar retirada do pedido %s: %w", lockedOrderID, err)
+	}
+	return &order, nil
+}
+
+// handleOrderPickup trata POST /orders/pickup: a equipe valida o código/QR e entrega o pedido
+func handleOrderPickup(w http.ResponseWriter, r *http.Request, orders OrderRepository) {
+	requestingUserID := r.Context().Value(userContextKey).(string)
+
+	var payload PickupPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	orderID, code, err := parsePickupPayload(payload)
+	if err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	order, err := orders.CompletePickup(orderID, code, requestingUserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPickupCodeNotFound):
+			http.Error(w, "Código de retirada inválido ou pedido não está em aberto.", http.StatusNotFound)
+		case errors.Is(err, errOrderNotReady), errors.Is(err, errPickupWrongDay):
+			http.Error(w, "Pedido não pode ser retirado: "+err.Error()+".", http.StatusConflict)
+		default:
+			log.Printf("Erro na retirada com código %s: %v", code, err)
+			http.Error(w, "Erro no servidor ao registrar retirada.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	items, err := orders.Items(order.ID)
+	if err != nil {
+		log.Printf("Alerta: Não foi possível buscar itens para o pedido retirado %s: %v", order.ID, err)
+		items = []OrderItem{}
+	}
+	order.Items = items
+
+	log.Printf("Pedido %s retirado no balcão (equipe %s) em %s.", order.ID, requestingUserID, time.Now().In(schoolLocation).Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+}
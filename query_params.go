@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const dateLayout = "2006-01-02" // Formato YYYY-MM-DD usado nos filtros de data da API
+
+// parseLimitOffset lê ?limit= e ?offset= da query string, aplicando padrão e teto para o limit
+func parseLimitOffset(r *http.Request, defaultLimit, maxLimit int) (int, int, error) {
+	limit, err := parseLimit(r, defaultLimit, maxLimit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	offset := 0
+	if raw := r.URL.Query().Get("offset"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			return 0, 0, fmt.Errorf("parâmetro 'offset' inválido: %q", raw)
+		}
+		offset = parsed
+	}
+	return limit, offset, nil
+}
+
+// parseLimit lê só ?limit=, para listas paginadas por cursor
+func parseLimit(r *http.Request, defaultLimit, maxLimit int) (int, error) {
+	limit := defaultLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			return 0, fmt.Errorf("parâmetro 'limit' inválido: %q", raw)
+		}
+		limit = parsed
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	return limit, nil
+}
+
+// parseDateRange lê ?from= e ?to= (YYYY-MM-DD, ambos inclusivos, no fuso da escola).
+// Retorna o início de 'from' e o início do dia seguinte a 'to', prontos para "col >= from AND col < to".
+func parseDateRange(r *http.Request) (*time.Time, *time.Time, error) {
+	var from, to *time.Time
+
+	if raw := r.URL.Query().Get("from"); raw != "" {
+		parsed, err := time.ParseInLocation(dateLayout, raw, schoolLocation)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parâmetro 'from' inválido (esperado YYYY-MM-DD): %q", raw)
+		}
+		from = &parsed
+	}
+	if raw := r.URL.Query().Get("to"); raw != "" {
+		parsed, err := time.ParseInLocation(dateLayout, raw, schoolLocation)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parâmetro 'to' inválido (esperado YYYY-MM-DD): %q", raw)
+		}
+		nextDay := parsed.AddDate(0, 0, 1)
+		to = &nextDay
+	}
+	if from != nil && to != nil && !from.Before(*to) {
+		return nil, nil, fmt.Errorf("'from' deve ser anterior ou igual a 'to'")
+	}
+	return from, to, nil
+}
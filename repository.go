@@ -0,0 +1,205 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Repositórios: o acesso a dados de cardápio, pedidos, alunos, turmas e usuários fica atrás destas
+// interfaces, para que os handlers possam ser testados sem Postgres (ver repository_memory.go).
+// As implementações de produção estão em repository_postgres.go.
+//
+// Convenções comuns às implementações:
+//   - registro inexistente retorna sql.ErrNoRows
+//   - violações de regra retornam os erros sentinela abaixo (ou os de cada domínio, ex: errClassHasStudents)
+
+var (
+	// errClassNameTaken indica que já existe uma turma com o mesmo nome
+	errClassNameTaken = errors.New("já existe uma turma com este nome")
+	// errUnknownClass indica uma turma (class_id) que não existe
+	errUnknownClass = errors.New("turma não encontrada")
+	// errUnknownUser indica um usuário (ex: parent_user_id) que não existe
+	errUnknownUser = errors.New("usuário não encontrado")
+)
+
+// MenuItemFilter são os filtros de GET /menu-items (ver parseMenuItemFilter)
+type MenuItemFilter struct {
+	Tags              []string // Itens com TODAS as marcações
+	ExcludedAllergens []string // Itens sem NENHUM dos alérgenos
+}
+
+// MenuRepository acessa os itens do cardápio
+type MenuRepository interface {
+	ListItems(filter MenuItemFilter) ([]MenuItem, error)
+	GetItem(itemID string) (*MenuItem, error)
+	// CreateItem grava um item novo; StockQuantity é o estoque inicial (nil = sem controle de estoque)
+	CreateItem(item MenuItem) (*MenuItem, error)
+	// UpdateItem substitui os dados do item; o estoque não muda (só por movimentações, ver stock.go)
+	UpdateItem(item MenuItem) (*MenuItem, error)
+	DeleteItem(itemID string) error
+}
+
+// OrderFilter são os filtros de GET /orders; campos vazios não filtram
+type OrderFilter struct {
+	Statuses []string
+	From, To *time.Time // order_date em [From, To)
+	// scheduled_for (dia de serviço) em [ScheduledFrom, ScheduledTo), comparando as datas no fuso da escola
+	ScheduledFrom, ScheduledTo *time.Time
+	UserID                     string // Quem fez o pedido
+	StudentID                  string
+	ClassID                    string // Turma atual do aluno do pedido
+	GuardianUserID             string // Qualquer responsável do aluno do pedido
+	// Pedidos feitos pelo usuário ou de alunos de que ele é responsável hoje (escopo de GET /me/orders)
+	VisibleToUserID string
+	MenuItemID      string // Pedidos que contêm o item
+	Sort            string // Campo de orderSortFields, com "-" na frente para ordem decrescente (padrão -order_date)
+	After           *OrderCursor
+	Limit           int
+}
+
+// OrderRepository acessa pedidos e seus itens
+type OrderRepository interface {
+	// List retorna até filter.Limit pedidos (com os itens) depois de filter.After na ordenação pedida,
+	// e o total de pedidos que atendem aos filtros (sem considerar o cursor)
+	List(filter OrderFilter) ([]Order, int, error)
+	// Spending soma os pedidos não cancelados que atendem aos filtros, por aluno (cursor e limite não se aplicam)
+	Spending(filter OrderFilter) (*OrderSpending, error)
+	// Get retorna o pedido com os itens. PickupCode vem preenchido; quem não é o dono não deve recebê-lo.
+	Get(orderID string) (*Order, error)
+	Items(orderID string) ([]OrderItem, error)
+	// Create grava o pedido de 'userID' numa transação: valida agendamento, vínculo com o aluno, cardápio do dia,
+	// estoque, restrições alimentares e limites do aluno, baixa o estoque e debita o pagamento (ver
+	// chargeOrderPayment), preenchendo PaidFromStudentWallet e o código de retirada.
+	// Erros: *orderRejection (motivo para o responsável), errStudentNotOrderable, errUnknownMenuItem,
+	// errMenuItemUnavailable, errInsufficientStock, errInsufficientCredits, errInsufficientStudentBalance.
+	Create(userID string, request CreateOrderRequest) (*Order, error)
+	// UpdateStatus move o pedido para 'status' se canTransitionOrderStatus permite para o papel de 'actor',
+	// gravando o histórico; cancelamentos vão por Cancel. Erros: errOrderNotFound, *orderTransitionError.
+	UpdateStatus(orderID, status string, actor *UserProfile) (*Order, error)
+	// Cancel cancela o pedido, devolve os itens ao estoque e estorna o pagamento para onde ele saiu (carteira do
+	// aluno e/ou créditos do responsável). Erros: errOrderNotFound, errOrderCancelForbidden, errOrderNotCancelable.
+	Cancel(orderID string, actor *UserProfile, reason *string) (*Order, error)
+	// History retorna as transições de status do pedido em ordem cronológica
+	History(orderID string) ([]OrderStatusChange, error)
+	// CompletePickup marca como COMPLETED o pedido READY de hoje com o código de retirada ('orderID' vazio quando
+	// o código foi digitado). Erros: errPickupCodeNotFound, errOrderNotReady, errPickupWrongDay.
+	CompletePickup(orderID, code, staffID string) (*KitchenOrder, error)
+	// KitchenOrders retorna os pedidos não cancelados do dia de serviço (YYYY-MM-DD), com os itens, na ordem dos
+	// horários de retirada. slotCode "" lista todos os horários; "none", só os pedidos imediatos.
+	KitchenOrders(serviceDate, slotCode string) ([]KitchenOrder, error)
+}
+
+// StudentFilter são os filtros de GET /students
+type StudentFilter struct {
+	Status         string // active (padrão), archived ou all
+	ClassID        string
+	ParentUserID   string // Responsável principal
+	GuardianUserID string // Qualquer responsável
+	Search         string // Parte do nome
+	Limit          int
+	Offset         int
+}
+
+// StudentUpdate é o que PUT /students/{id} pode mudar; alergias/exigências nil ficam como estão
+type StudentUpdate struct {
+	Name                string
+	ParentUserID        string
+	Allergies           *[]string
+	DietaryRequirements *[]string
+}
+
+// StudentRepository acessa alunos, responsáveis e o histórico de turmas
+type StudentRepository interface {
+	// List retorna a página pedida e o total de alunos que atendem ao filtro
+	List(filter StudentFilter) ([]Student, int, error)
+	Get(studentID string) (*Student, error)
+	// ListByGuardian retorna os alunos ativos do responsável, com GuardianRole preenchido
+	ListByGuardian(userID string) ([]Student, error)
+	// GuardianRole retorna o papel do usuário em relação ao aluno ativo, ou "" se ele não for responsável
+	GuardianRole(studentID, userID string) (GuardianRole, error)
+	// Create grava o aluno e torna ParentUserID o responsável principal. Erros: errUnknownClass, errUnknownUser.
+	Create(student Student) (*Student, error)
+	// Update erros: sql.ErrNoRows, errStudentArchived, errUnknownUser
+	Update(studentID string, update StudentUpdate) (*Student, error)
+	// Transfer erros: sql.ErrNoRows, errStudentArchived, errStudentSameClass, errUnknownClass
+	Transfer(studentID, toClassID string, reason, transferredBy *string) (*StudentClassTransfer, error)
+	ListTransfers(studentID string) ([]StudentClassTransfer, error)
+	// Archive erros: sql.ErrNoRows, errStudentArchived, errStudentOpenOrders, errStudentHasBalance
+	Archive(studentID, archivedBy string) error
+}
+
+// ClassRepository acessa as turmas
+type ClassRepository interface {
+	// List retorna a página pedida (busca por parte do nome) e o total de turmas que atendem à busca
+	List(search string, limit, offset int) ([]Class, int, error)
+	Get(classID string) (*Class, error)
+	// Create/Update erros: errClassNameTaken (e sql.ErrNoRows no Update)
+	Create(name string, description *string) (*Class, error)
+	Update(classID, name string, description *string) (*Class, error)
+	// Delete remove a turma, movendo antes os alunos para 'reassignTo' se informada (cada um com uma linha no
+	// histórico de turmas, motivo classDeletedTransferReason); retorna quantos foram movidos.
+	// Erros: sql.ErrNoRows, errClassHasStudents, errInvalidReassignTarget.
+	Delete(classID string, reassignTo *string, deletedBy string) (int64, error)
+}
+
+// UserRepository acessa os perfis de usuário
+type UserRepository interface {
+	GetProfile(userID string) (*UserProfile, error)
+}
+
+// IdempotencyRecord é o estado gravado de uma Idempotency-Key (ver idempotency.go)
+type IdempotencyRecord struct {
+	RequestHash    string
+	Status         string // idempotencyStatusInProgress, idempotencyStatusCommitted ou idempotencyStatusDone
+	ResponseStatus int    // 0 enquanto a resposta não foi gravada
+	ResponseBody   []byte
+	ContentType    string
+}
+
+// IdempotencyRepository guarda as Idempotency-Keys dos usuários
+type IdempotencyRepository interface {
+	// Acquire reserva a chave para a requisição; retorna false se ela já existe e está válida. Chaves expiradas
+	// são retomadas, e as IN_PROGRESS abandonadas há mais de idempotencyStaleLock com o mesmo corpo também;
+	// as COMMITTED nunca, pois o efeito já foi gravado.
+	Acquire(userID, key, requestHash string) (bool, error)
+	// Get erros: sql.ErrNoRows
+	Get(userID, key string) (*IdempotencyRecord, error)
+	// Complete grava a resposta 2xx da requisição original
+	Complete(userID, key string, status int, body []byte, contentType string) error
+	// Release libera a chave de uma requisição que falhou, se nenhum efeito foi gravado (ainda IN_PROGRESS)
+	Release(userID, key string) error
+}
+
+// TopUpRepository acessa as recargas de créditos
+type TopUpRepository interface {
+	// ConfirmPayment marca como paga a recarga da cobrança e credita o usuário, conforme topUpPaymentOutcome;
+	// retorna true se esta chamada creditou. Reentregas do webhook para uma recarga já paga não creditam de novo.
+	ConfirmPayment(providerName string, notification PaymentNotification) (bool, error)
+}
+
+// Repositories agrupa os repositórios usados pelos handlers
+type Repositories struct {
+	Menu     MenuRepository
+	Orders   OrderRepository
+	Students StudentRepository
+	Classes  ClassRepository
+	Users    UserRepository
+
+	Idempotency IdempotencyRepository
+	TopUps      TopUpRepository
+}
+
+// newPostgresRepositories monta os repositórios sobre a conexão do banco
+func newPostgresRepositories(appDB *sql.DB) *Repositories {
+	return &Repositories{
+		Menu:     &postgresMenuRepository{db: appDB},
+		Orders:   &postgresOrderRepository{db: appDB},
+		Students: &postgresStudentRepository{db: appDB},
+		Classes:  &postgresClassRepository{db: appDB},
+		Users:    &postgresUserRepository{db: appDB},
+
+		Idempotency: &postgresIdempotencyRepository{db: appDB},
+		TopUps:      &postgresTopUpRepository{db: appDB},
+	}
+}
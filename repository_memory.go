@@ -0,0 +1,1208 @@
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore guarda em memória os dados dos repositórios, para testes de handlers sem Postgres.
+// Segue as mesmas regras das implementações de repository_postgres.go (erros, ordenação, arquivamento).
+type memoryStore struct {
+	mu sync.Mutex
+
+	menuItems map[string]MenuItem
+	orders    map[string]Order // Com os itens
+	// Transições de status de cada pedido e a parte paga pela carteira do aluno (para o estorno)
+	statusHistory map[string][]OrderStatusChange
+	walletCharges map[string]Money
+	students      map[string]Student
+	guardians     map[string]map[string]GuardianRole // studentID -> userID -> papel
+	transfers     []StudentClassTransfer
+	classes       map[string]Class
+	users         map[string]UserProfile
+	// Saldo da carteira de cada aluno e créditos de cada responsável (ver student_wallets.go e credit_handlers.go);
+	// a política das carteiras é sempre a padrão, student_then_guardian
+	studentBalances map[string]Money
+	credits         map[string]Money
+	topUps          map[string]CreditTopUp
+	// Idempotency-Keys por usuário e chave, com o horário da reserva (para retomar as abandonadas)
+	idempotencyKeys map[idempotencyClaim]memoryIdempotencyKey
+
+	nextID int
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		menuItems:       map[string]MenuItem{},
+		orders:          map[string]Order{},
+		statusHistory:   map[string][]OrderStatusChange{},
+		walletCharges:   map[string]Money{},
+		students:        map[string]Student{},
+		guardians:       map[string]map[string]GuardianRole{},
+		classes:         map[string]Class{},
+		users:           map[string]UserProfile{},
+		studentBalances: map[string]Money{},
+		credits:         map[string]Money{},
+		topUps:          map[string]CreditTopUp{},
+		idempotencyKeys: map[idempotencyClaim]memoryIdempotencyKey{},
+	}
+}
+
+// newMemoryRepositories monta os repositórios sobre o store
+func newMemoryRepositories(store *memoryStore) *Repositories {
+	return &Repositories{
+		Menu:     &memoryMenuRepository{store},
+		Orders:   &memoryOrderRepository{store},
+		Students: &memoryStudentRepository{store},
+		Classes:  &memoryClassRepository{store},
+		Users:    &memoryUserRepository{store},
+
+		Idempotency: &memoryIdempotencyRepository{store},
+		TopUps:      &memoryTopUpRepository{store},
+	}
+}
+
+// newID gera um ID único no store; deve ser chamado com o mutex travado
+func (store *memoryStore) newID(prefix string) string {
+	store.nextID++
+	return fmt.Sprintf("%s-%d", prefix, store.nextID)
+}
+
+// addUser cadastra um usuário (fora da API, como o cadastro no Supabase)
+func (store *memoryStore) addUser(profile UserProfile) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.users[profile.ID] = profile
+}
+
+// addOrder grava um pedido pronto, sem as validações e o pagamento de memoryOrderRepository.Create
+func (store *memoryStore) addOrder(order Order) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.orders[order.ID] = order
+}
+
+// addGuardian vincula um responsável não principal ao aluno
+func (store *memoryStore) addGuardian(studentID, userID string, role GuardianRole) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.guardians[studentID] == nil {
+		store.guardians[studentID] = map[string]GuardianRole{}
+	}
+	store.guardians[studentID][userID] = role
+}
+
+// setStudentBalance define o saldo da carteira do aluno
+func (store *memoryStore) setStudentBalance(studentID string, balance Money) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.studentBalances[studentID] = balance
+}
+
+// setCredits define os créditos do responsável
+func (store *memoryStore) setCredits(userID string, balance Money) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.credits[userID] = balance
+}
+
+// addTopUp grava uma recarga pronta, como se a cobrança já tivesse sido criada no provedor
+func (store *memoryStore) addTopUp(topUp CreditTopUp) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.topUps[topUp.ID] = topUp
+}
+
+// ageIdempotencyKey recua o horário da reserva da chave, simulando uma requisição abandonada
+func (store *memoryStore) ageIdempotencyKey(userID, key string, age time.Duration) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	claim := idempotencyClaim{UserID: userID, Key: key}
+	if stored, ok := store.idempotencyKeys[claim]; ok {
+		stored.lockedAt = stored.lockedAt.Add(-age)
+		store.idempotencyKeys[claim] = stored
+	}
+}
+
+// markIdempotencyKeyCommitted espelha a função de mesmo nome de idempotency.go; mutex travado
+func (store *memoryStore) markIdempotencyKeyCommitted(claim *idempotencyClaim) {
+	if claim == nil {
+		return
+	}
+	if stored, ok := store.idempotencyKeys[*claim]; ok && stored.Status == idempotencyStatusInProgress {
+		stored.Status = idempotencyStatusCommitted
+		store.idempotencyKeys[*claim] = stored
+	}
+}
+
+// recordOrderStatusChange espelha a função de mesmo nome de order_status.go, sem o evento; mutex travado
+func (store *memoryStore) recordOrderStatusChange(orderID string, fromStatus *string, toStatus string, changedBy, reason *string) {
+	store.statusHistory[orderID] = append(store.statusHistory[orderID], OrderStatusChange{
+		ID:         store.newID("status-change"),
+		OrderID:    orderID,
+		FromStatus: fromStatus,
+		ToStatus:   toStatus,
+		ChangedBy:  changedBy,
+		Reason:     reason,
+		ChangedAt:  time.Now(),
+	})
+}
+
+// setPrimaryGuardian espelha a função de mesmo nome de student_guardians.go; mutex travado
+func (store *memoryStore) setPrimaryGuardian(studentID, userID string) {
+	roles := store.guardians[studentID]
+	if roles == nil {
+		roles = map[string]GuardianRole{}
+		store.guardians[studentID] = roles
+	}
+	for guardianID, role := range roles {
+		if role == GuardianPrimary && guardianID != userID {
+			delete(roles, guardianID)
+		}
+	}
+	roles[userID] = GuardianPrimary
+}
+
+// studentView completa o aluno com os dados dos JOINs (nome da turma e email do responsável); mutex travado
+func (store *memoryStore) studentView(student Student) Student {
+	student.ClassName, student.ParentEmail = nil, nil
+	if class, ok := store.classes[student.ClassID]; ok {
+		name := class.Name
+		student.ClassName = &name
+	}
+	if parent, ok := store.users[student.ParentUserID]; ok {
+		student.ParentEmail = parent.Email
+	}
+	return student
+}
+
+// --- Cardápio ---
+
+type memoryMenuRepository struct {
+	store *memoryStore
+}
+
+func (repo *memoryMenuRepository) ListItems(filter MenuItemFilter) ([]MenuItem, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	items := []MenuItem{}
+	for _, item := range repo.store.menuItems {
+		if containsAll(item.DietaryTags, filter.Tags) && !containsAny(item.Allergens, filter.ExcludedAllergens) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items, nil
+}
+
+func (repo *memoryMenuRepository) GetItem(itemID string) (*MenuItem, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	item, ok := repo.store.menuItems[itemID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &item, nil
+}
+
+func (repo *memoryMenuRepository) CreateItem(item MenuItem) (*MenuItem, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	item.ID = repo.store.newID("menu-item")
+	item.CreatedAt = time.Now()
+	item.UpdatedAt = item.CreatedAt
+	if item.Allergens == nil {
+		item.Allergens = []string{}
+	}
+	if item.DietaryTags == nil {
+		item.DietaryTags = []string{}
+	}
+	repo.store.menuItems[item.ID] = item
+	return &item, nil
+}
+
+func (repo *memoryMenuRepository) UpdateItem(item MenuItem) (*MenuItem, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	current, ok := repo.store.menuItems[item.ID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	item.StockQuantity = current.StockQuantity
+	item.CreatedAt = current.CreatedAt
+	item.UpdatedAt = time.Now()
+	if item.Allergens == nil {
+		item.Allergens = []string{}
+	}
+	if item.DietaryTags == nil {
+		item.DietaryTags = []string{}
+	}
+	repo.store.menuItems[item.ID] = item
+	return &item, nil
+}
+
+func (repo *memoryMenuRepository) DeleteItem(itemID string) error {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	if _, ok := repo.store.menuItems[itemID]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(repo.store.menuItems, itemID)
+	return nil
+}
+
+// containsAll diz se 'values' contém todos os 'wanted' (como o @> do Postgres)
+func containsAll(values, wanted []string) bool {
+	for _, w := range wanted {
+		if !containsAny(values, []string{w}) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsAny diz se 'values' e 'wanted' têm algum elemento em comum (como o && do Postgres)
+func containsAny(values, wanted []string) bool {
+	for _, v := range values {
+		for _, w := range wanted {
+			if v == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// --- Pedidos ---
+
+type memoryOrderRepository struct {
+	store *memoryStore
+}
+
+func (repo *memoryOrderRepository) List(filter OrderFilter) ([]Order, int, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	matches := repo.filter(filter)
+	total := len(matches)
+
+	field, desc := splitOrderSort(filter.Sort)
+	sort.Slice(matches, func(i, j int) bool {
+		result := compareOrderPosition(matches[i], field, orderSortValue(matches[j], field), matches[j].ID)
+		return result != 0 && (result < 0) != desc
+	})
+
+	orders := []Order{}
+	for _, order := range matches {
+		if filter.After != nil {
+			result := compareOrderPosition(order, field, filter.After.Value, filter.After.ID)
+			if (desc && result >= 0) || (!desc && result <= 0) {
+				continue
+			}
+		}
+		orders = append(orders, order)
+	}
+	return paginate(orders, filter.Limit, 0), total, nil
+}
+
+func (repo *memoryOrderRepository) Spending(filter OrderFilter) (*OrderSpending, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	byStudent := map[string]*StudentSpending{}
+	for _, order := range repo.filter(filter) {
+		if order.Status == OrderStatusCanceled {
+			continue
+		}
+		key := ""
+		if order.StudentID != nil {
+			key = *order.StudentID
+		}
+		entry := byStudent[key]
+		if entry == nil {
+			entry = &StudentSpending{StudentID: order.StudentID, StudentName: order.StudentName}
+			byStudent[key] = entry
+		}
+		entry.Total += order.TotalAmount
+		entry.OrderCount++
+	}
+
+	entries := []StudentSpending{}
+	for _, entry := range byStudent {
+		entries = append(entries, *entry)
+	}
+	// Como no Postgres: por nome do aluno, pedidos sem aluno por último
+	sort.Slice(entries, func(i, j int) bool {
+		if (entries[i].StudentName == nil) != (entries[j].StudentName == nil) {
+			return entries[j].StudentName == nil
+		}
+		if entries[i].StudentName != nil && *entries[i].StudentName != *entries[j].StudentName {
+			return *entries[i].StudentName < *entries[j].StudentName
+		}
+		return entries[i].StudentID != nil && (entries[j].StudentID == nil || *entries[i].StudentID < *entries[j].StudentID)
+	})
+
+	spending := &OrderSpending{ByStudent: []StudentSpending{}}
+	for _, entry := range entries {
+		spending.add(entry)
+	}
+	return spending, nil
+}
+
+// orderView completa o pedido com o nome do aluno (JOIN de orderFromClause); mutex travado
+func (repo *memoryOrderRepository) orderView(order Order) Order {
+	order.StudentName = nil
+	if order.StudentID != nil {
+		if student, ok := repo.store.students[*order.StudentID]; ok {
+			name := student.Name
+			order.StudentName = &name
+		}
+	}
+	return order
+}
+
+// filter retorna os pedidos que atendem a OrderFilter (exceto o cursor), sem ordem definida; mutex travado
+func (repo *memoryOrderRepository) filter(filter OrderFilter) []Order {
+	matches := []Order{}
+	for _, order := range repo.store.orders {
+		if repo.matches(order, filter) {
+			matches = append(matches, repo.orderView(order))
+		}
+	}
+	return matches
+}
+
+// matches aplica os filtros de OrderFilter (exceto o cursor); mutex travado
+func (repo *memoryOrderRepository) matches(order Order, filter OrderFilter) bool {
+	if len(filter.Statuses) > 0 && !containsAny(filter.Statuses, []string{order.Status}) {
+		return false
+	}
+	if (filter.From != nil && order.OrderDate.Before(*filter.From)) || (filter.To != nil && !order.OrderDate.Before(*filter.To)) {
+		return false
+	}
+	if (filter.ScheduledFrom != nil && order.ScheduledFor < filter.ScheduledFrom.In(schoolLocation).Format(dateLayout)) ||
+		(filter.ScheduledTo != nil && order.ScheduledFor >= filter.ScheduledTo.In(schoolLocation).Format(dateLayout)) {
+		return false
+	}
+	if filter.UserID != "" && order.UserID != filter.UserID {
+		return false
+	}
+	studentID := ""
+	if order.StudentID != nil {
+		studentID = *order.StudentID
+	}
+	if filter.StudentID != "" && studentID != filter.StudentID {
+		return false
+	}
+	if filter.ClassID != "" && (studentID == "" || repo.store.students[studentID].ClassID != filter.ClassID) {
+		return false
+	}
+	if filter.GuardianUserID != "" {
+		if _, ok := repo.store.guardians[studentID][filter.GuardianUserID]; !ok {
+			return false
+		}
+	}
+	if filter.VisibleToUserID != "" && order.UserID != filter.VisibleToUserID {
+		if _, ok := repo.store.guardians[studentID][filter.VisibleToUserID]; !ok {
+			return false
+		}
+	}
+	if filter.MenuItemID != "" {
+		found := false
+		for _, item := range order.Items {
+			found = found || item.MenuItemID == filter.MenuItemID
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// compareOrderPosition compara (campo, ID) do pedido com a posição (value, id) em ordem crescente,
+// como a comparação de linha do Postgres: -1 antes, 0 igual, 1 depois
+func compareOrderPosition(order Order, field, value, id string) int {
+	result := 0
+	switch field {
+	case "total_amount":
+		other, _ := parseMoney(value, true)
+		result = cmp.Compare(order.TotalAmount, other)
+	case "scheduled_for":
+		result = strings.Compare(order.ScheduledFor, value)
+	default:
+		other, _ := time.Parse(time.RFC3339Nano, value)
+		result = order.OrderDate.Compare(other)
+	}
+	if result == 0 {
+		result = strings.Compare(order.ID, id)
+	}
+	return result
+}
+
+func (repo *memoryOrderRepository) Get(orderID string) (*Order, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	order, ok := repo.store.orders[orderID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	order = repo.orderView(order)
+	return &order, nil
+}
+
+func (repo *memoryOrderRepository) Items(orderID string) ([]OrderItem, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	return repo.store.orders[orderID].Items, nil
+}
+
+// Create segue postgresOrderRepository.Create sobre os dados do store. O store não tem horários de retirada,
+// calendário do cardápio, restrições alimentares nem limites: só pedidos imediatos, e todo item disponível é servido.
+func (repo *memoryOrderRepository) Create(userID string, request CreateOrderRequest) (*Order, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	noSlots := func(string) (*PickupSlot, error) { return nil, sql.ErrNoRows }
+	schedule, scheduleViolation, err := scheduleOrder(noSlots, request.ScheduledFor, request.PickupSlot, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if scheduleViolation != "" {
+		return nil, &orderRejection{reason: scheduleViolation}
+	}
+
+	student, ok := repo.store.students[request.StudentID]
+	if !ok || student.ArchivedAt != nil {
+		return nil, errStudentNotOrderable
+	}
+	role, isGuardian := repo.store.guardians[request.StudentID][userID]
+	if !isGuardian || !slices.Contains(guardianOrderingRoles, role) {
+		return nil, errStudentNotOrderable
+	}
+
+	requestedQuantities := map[string]int{}
+	var total Money
+	var items []OrderItem
+	for _, itemReq := range request.Items {
+		item, ok := repo.store.menuItems[itemReq.MenuItemID]
+		if !ok {
+			return nil, errUnknownMenuItem
+		}
+		if !item.IsAvailable {
+			return nil, errMenuItemUnavailable
+		}
+		requestedQuantities[item.ID] += itemReq.Quantity
+		if item.StockQuantity != nil && requestedQuantities[item.ID] > *item.StockQuantity {
+			return nil, fmt.Errorf("%w para '%s': restam %d unidade(s)", errInsufficientStock, item.Name, *item.StockQuantity)
+		}
+		total += item.Price.Times(itemReq.Quantity)
+		items = append(items, OrderItem{
+			MenuItemID:      item.ID,
+			MenuItemName:    item.Name,
+			Quantity:        itemReq.Quantity,
+			PriceAtPurchase: item.Price,
+		})
+	}
+
+	fromStudent, fromGuardian, err := splitOrderPayment(FundingStudentThenGuardian, repo.store.studentBalances[student.ID], total)
+	if err != nil {
+		return nil, err
+	}
+	if repo.store.credits[userID] < fromGuardian {
+		return nil, errInsufficientCredits
+	}
+	pickupCode, err := generatePickupCode()
+	if err != nil {
+		return nil, err
+	}
+
+	// Validado: grava o pedido, baixa o estoque e debita o pagamento
+	now := time.Now()
+	studentID := student.ID
+	order := Order{
+		ID:           repo.store.newID("order"),
+		UserID:       userID,
+		StudentID:    &studentID,
+		OrderDate:    now,
+		ScheduledFor: schedule.ServiceDate.Format(dateLayout),
+		TotalAmount:  total,
+		Status:       OrderStatusPending,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	for i := range items {
+		items[i].ID = repo.store.newID("order-item")
+		items[i].OrderID = order.ID
+		items[i].CreatedAt = now
+	}
+	order.Items = items
+	setOrderPickupFields(&order, sql.NullString{String: pickupCode, Valid: true})
+
+	for menuItemID, quantity := range requestedQuantities {
+		item := repo.store.menuItems[menuItemID]
+		if item.StockQuantity != nil {
+			remaining := *item.StockQuantity - quantity
+			item.StockQuantity = &remaining
+			repo.store.menuItems[menuItemID] = item
+		}
+	}
+	repo.store.studentBalances[student.ID] -= fromStudent
+	repo.store.credits[userID] -= fromGuardian
+	repo.store.orders[order.ID] = order
+	repo.store.walletCharges[order.ID] = fromStudent
+	repo.store.recordOrderStatusChange(order.ID, nil, order.Status, &userID, nil)
+	repo.store.markIdempotencyKeyCommitted(request.idempotency)
+
+	order.PaidFromStudentWallet = fromStudent
+	return &order, nil
+}
+
+func (repo *memoryOrderRepository) UpdateStatus(orderID, status string, actor *UserProfile) (*Order, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	order, ok := repo.store.orders[orderID]
+	if !ok {
+		return nil, errOrderNotFound
+	}
+	if !canTransitionOrderStatus(order.Status, status, actor.Role) {
+		return nil, &orderTransitionError{from: order.Status, to: status}
+	}
+	previousStatus := order.Status
+	order.Status, order.UpdatedAt = status, time.Now()
+	repo.store.orders[orderID] = order
+	repo.store.recordOrderStatusChange(orderID, &previousStatus, status, &actor.ID, nil)
+
+	order.Items = nil
+	return &order, nil
+}
+
+// Cancel segue cancelOrder: estorna para a carteira do aluno o que saiu dela e o restante para quem fez o pedido
+func (repo *memoryOrderRepository) Cancel(orderID string, actor *UserProfile, reason *string) (*Order, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	order, ok := repo.store.orders[orderID]
+	if !ok {
+		return nil, errOrderNotFound
+	}
+	if !actor.Role.Can(PermOrdersManage) && order.UserID != actor.ID {
+		return nil, errOrderCancelForbidden
+	}
+	if !canTransitionOrderStatus(order.Status, OrderStatusCanceled, actor.Role) {
+		return nil, errOrderNotCancelable
+	}
+	previousStatus := order.Status
+	now := time.Now()
+	actorID := actor.ID
+	order.Status, order.UpdatedAt = OrderStatusCanceled, now
+	order.CanceledAt, order.CanceledBy, order.CancelReason = &now, &actorID, reason
+	repo.store.orders[orderID] = order
+	repo.store.recordOrderStatusChange(orderID, &previousStatus, OrderStatusCanceled, &actorID, reason)
+
+	for _, orderItem := range order.Items {
+		item, ok := repo.store.menuItems[orderItem.MenuItemID]
+		if ok && item.StockQuantity != nil {
+			restored := *item.StockQuantity + orderItem.Quantity
+			item.StockQuantity = &restored
+			repo.store.menuItems[item.ID] = item
+		}
+	}
+	fromStudent := repo.store.walletCharges[orderID]
+	if order.StudentID != nil {
+		repo.store.studentBalances[*order.StudentID] += fromStudent
+	}
+	repo.store.credits[order.UserID] += order.TotalAmount - fromStudent
+
+	order.Items = nil
+	return &order, nil
+}
+
+func (repo *memoryOrderRepository) History(orderID string) ([]OrderStatusChange, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	return append([]OrderStatusChange{}, repo.store.statusHistory[orderID]...), nil
+}
+
+// CompletePickup segue completeOrderPickup: com o QR, o pedido é o do id e o código precisa bater;
+// digitado, o código identifica o pedido entre os em aberto
+func (repo *memoryOrderRepository) CompletePickup(orderID, code, staffID string) (*KitchenOrder, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	var order Order
+	found := false
+	for _, candidate := range repo.store.orders {
+		if candidate.PickupCode == nil || *candidate.PickupCode != code {
+			continue
+		}
+		open := candidate.Status == OrderStatusPending || candidate.Status == OrderStatusPreparing || candidate.Status == OrderStatusReady
+		if (orderID != "" && candidate.ID == orderID) || (orderID == "" && open) {
+			order, found = candidate, true
+			break
+		}
+	}
+	if !found {
+		return nil, errPickupCodeNotFound
+	}
+	if order.Status != OrderStatusReady {
+		return nil, fmt.Errorf("%w (status atual: %s)", errOrderNotReady, order.Status)
+	}
+	if order.ScheduledFor != schoolToday().Format(dateLayout) {
+		return nil, fmt.Errorf("%w (agendado para %s)", errPickupWrongDay, order.ScheduledFor)
+	}
+
+	previousStatus := order.Status
+	order.Status, order.UpdatedAt = OrderStatusCompleted, time.Now()
+	repo.store.orders[order.ID] = order
+	reason := "Retirado no balcão"
+	repo.store.recordOrderStatusChange(order.ID, &previousStatus, OrderStatusCompleted, &staffID, &reason)
+
+	kitchenOrder := repo.kitchenView(order)
+	kitchenOrder.Items = nil
+	return &kitchenOrder, nil
+}
+
+// KitchenOrders segue fetchKitchenOrders. O store não tem horários de retirada: todo pedido é imediato,
+// então um código de horário (exceto "none") não encontra nenhum.
+func (repo *memoryOrderRepository) KitchenOrders(serviceDate, slotCode string) ([]KitchenOrder, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	orders := []KitchenOrder{}
+	for _, order := range repo.store.orders {
+		if order.ScheduledFor != serviceDate || order.Status == OrderStatusCanceled {
+			continue
+		}
+		if slotCode != "" && (slotCode != "none" || order.PickupSlotID != nil) {
+			continue
+		}
+		orders = append(orders, repo.kitchenView(order))
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].CreatedAt.Before(orders[j].CreatedAt) })
+	return orders, nil
+}
+
+// kitchenView monta o pedido como a equipe o vê, com o nome do aluno e sem o código de retirada; mutex travado
+func (repo *memoryOrderRepository) kitchenView(order Order) KitchenOrder {
+	view := repo.orderView(order)
+	kitchenOrder := KitchenOrder{StudentName: view.StudentName}
+	view.StudentName, view.PickupCode, view.PickupQRPayload = nil, nil, nil
+	kitchenOrder.Order = view
+	return kitchenOrder
+}
+
+// --- Alunos ---
+
+type memoryStudentRepository struct {
+	store *memoryStore
+}
+
+func (repo *memoryStudentRepository) List(filter StudentFilter) ([]Student, int, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	matches := []Student{}
+	for _, student := range repo.store.students {
+		switch {
+		case (filter.Status == "" || filter.Status == "active") && student.ArchivedAt != nil,
+			filter.Status == "archived" && student.ArchivedAt == nil,
+			filter.ClassID != "" && student.ClassID != filter.ClassID,
+			filter.ParentUserID != "" && student.ParentUserID != filter.ParentUserID,
+			filter.Search != "" && !strings.Contains(strings.ToLower(student.Name), strings.ToLower(filter.Search)):
+			continue
+		}
+		if filter.GuardianUserID != "" {
+			if _, ok := repo.store.guardians[student.ID][filter.GuardianUserID]; !ok {
+				continue
+			}
+		}
+		matches = append(matches, repo.store.studentView(student))
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Name != matches[j].Name {
+			return matches[i].Name < matches[j].Name
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	return paginate(matches, filter.Limit, filter.Offset), len(matches), nil
+}
+
+func (repo *memoryStudentRepository) Get(studentID string) (*Student, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	student, ok := repo.store.students[studentID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	view := repo.store.studentView(student)
+	return &view, nil
+}
+
+func (repo *memoryStudentRepository) ListByGuardian(userID string) ([]Student, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	students := []Student{}
+	for studentID, roles := range repo.store.guardians {
+		role, ok := roles[userID]
+		student, exists := repo.store.students[studentID]
+		if !ok || !exists || student.ArchivedAt != nil {
+			continue
+		}
+		student = repo.store.studentView(student)
+		student.ParentEmail = nil // Não vem nesta lista (ver handleGetMyStudents)
+		student.GuardianRole = role
+		students = append(students, student)
+	}
+	sort.Slice(students, func(i, j int) bool { return students[i].Name < students[j].Name })
+	return students, nil
+}
+
+func (repo *memoryStudentRepository) GuardianRole(studentID, userID string) (GuardianRole, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	if student, ok := repo.store.students[studentID]; !ok || student.ArchivedAt != nil {
+		return "", nil
+	}
+	return repo.store.guardians[studentID][userID], nil
+}
+
+func (repo *memoryStudentRepository) Create(student Student) (*Student, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	if _, ok := repo.store.classes[student.ClassID]; !ok {
+		return nil, errUnknownClass
+	}
+	if _, ok := repo.store.users[student.ParentUserID]; !ok {
+		return nil, errUnknownUser
+	}
+	student.ID = repo.store.newID("student")
+	student.CreatedAt = time.Now()
+	student.UpdatedAt = student.CreatedAt
+	if student.Allergies == nil {
+		student.Allergies = []string{}
+	}
+	if student.DietaryRequirements == nil {
+		student.DietaryRequirements = []string{}
+	}
+	repo.store.students[student.ID] = student
+	repo.store.setPrimaryGuardian(student.ID, student.ParentUserID)
+	return &student, nil
+}
+
+func (repo *memoryStudentRepository) Update(studentID string, update StudentUpdate) (*Student, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	student, ok := repo.store.students[studentID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if student.ArchivedAt != nil {
+		return nil, errStudentArchived
+	}
+	if _, ok := repo.store.users[update.ParentUserID]; !ok {
+		return nil, errUnknownUser
+	}
+	student.Name = update.Name
+	student.ParentUserID = update.ParentUserID
+	if update.Allergies != nil {
+		student.Allergies = *update.Allergies
+	}
+	if update.DietaryRequirements != nil {
+		student.DietaryRequirements = *update.DietaryRequirements
+	}
+	student.UpdatedAt = time.Now()
+	repo.store.students[studentID] = student
+	repo.store.setPrimaryGuardian(studentID, update.ParentUserID)
+
+	view := repo.store.studentView(student)
+	return &view, nil
+}
+
+func (repo *memoryStudentRepository) Transfer(studentID, toClassID string, reason, transferredBy *string) (*StudentClassTransfer, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	student, ok := repo.store.students[studentID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if student.ArchivedAt != nil {
+		return nil, errStudentArchived
+	}
+	if student.ClassID == toClassID {
+		return nil, errStudentSameClass
+	}
+	if _, ok := repo.store.classes[toClassID]; !ok {
+		return nil, errUnknownClass
+	}
+
+	transfer := StudentClassTransfer{
+		ID:            repo.store.newID("transfer"),
+		StudentID:     studentID,
+		ToClassID:     &toClassID,
+		Reason:        reason,
+		TransferredBy: transferredBy,
+		TransferredAt: time.Now(),
+	}
+	if student.ClassID != "" {
+		fromClassID := student.ClassID
+		transfer.FromClassID = &fromClassID
+	}
+	student.ClassID = toClassID
+	student.UpdatedAt = transfer.TransferredAt
+	repo.store.students[studentID] = student
+	repo.store.transfers = append(repo.store.transfers, transfer)
+	return &transfer, nil
+}
+
+func (repo *memoryStudentRepository) ListTransfers(studentID string) ([]StudentClassTransfer, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	if _, ok := repo.store.students[studentID]; !ok {
+		return nil, sql.ErrNoRows
+	}
+	transfers := []StudentClassTransfer{}
+	for _, transfer := range repo.store.transfers {
+		if transfer.StudentID != studentID {
+			continue
+		}
+		if transfer.FromClassID != nil {
+			if class, ok := repo.store.classes[*transfer.FromClassID]; ok {
+				transfer.FromClassName = &class.Name
+			}
+		}
+		if transfer.ToClassID != nil {
+			if class, ok := repo.store.classes[*transfer.ToClassID]; ok {
+				transfer.ToClassName = &class.Name
+			}
+		}
+		transfers = append(transfers, transfer)
+	}
+	return transfers, nil
+}
+
+func (repo *memoryStudentRepository) Archive(studentID, archivedBy string) error {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	student, ok := repo.store.students[studentID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if student.ArchivedAt != nil {
+		return errStudentArchived
+	}
+	openOrders := 0
+	for _, order := range repo.store.orders {
+		if order.StudentID != nil && *order.StudentID == studentID &&
+			(order.Status == "PENDING" || order.Status == "PREPARING" || order.Status == "READY") {
+			openOrders++
+		}
+	}
+	if openOrders > 0 {
+		return fmt.Errorf("%w (%d pedido(s))", errStudentOpenOrders, openOrders)
+	}
+	if balance := repo.store.studentBalances[studentID]; balance > 0 {
+		return fmt.Errorf("%w (%s)", errStudentHasBalance, balance)
+	}
+
+	now := time.Now()
+	student.ArchivedAt = &now
+	student.UpdatedAt = now
+	repo.store.students[studentID] = student
+	return nil
+}
+
+// --- Turmas ---
+
+type memoryClassRepository struct {
+	store *memoryStore
+}
+
+// classView completa a turma com a contagem de alunos; mutex travado
+func (repo *memoryClassRepository) classView(class Class) Class {
+	class.StudentCount = 0
+	for _, student := range repo.store.students {
+		if student.ClassID == class.ID {
+			class.StudentCount++
+		}
+	}
+	return class
+}
+
+// nameTaken diz se outra turma já usa o nome; mutex travado
+func (repo *memoryClassRepository) nameTaken(name, exceptID string) bool {
+	for _, class := range repo.store.classes {
+		if class.ID != exceptID && class.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (repo *memoryClassRepository) List(search string, limit, offset int) ([]Class, int, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	matches := []Class{}
+	for _, class := range repo.store.classes {
+		if search == "" || strings.Contains(strings.ToLower(class.Name), strings.ToLower(search)) {
+			matches = append(matches, repo.classView(class))
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Name != matches[j].Name {
+			return matches[i].Name < matches[j].Name
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	return paginate(matches, limit, offset), len(matches), nil
+}
+
+func (repo *memoryClassRepository) Get(classID string) (*Class, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	class, ok := repo.store.classes[classID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	view := repo.classView(class)
+	return &view, nil
+}
+
+func (repo *memoryClassRepository) Create(name string, description *string) (*Class, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	if repo.nameTaken(name, "") {
+		return nil, errClassNameTaken
+	}
+	class := Class{ID: repo.store.newID("class"), Name: name, Description: description, CreatedAt: time.Now()}
+	class.UpdatedAt = class.CreatedAt
+	repo.store.classes[class.ID] = class
+	return &class, nil
+}
+
+func (repo *memoryClassRepository) Update(classID, name string, description *string) (*Class, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	class, ok := repo.store.classes[classID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if repo.nameTaken(name, classID) {
+		return nil, errClassNameTaken
+	}
+	class.Name = name
+	class.Description = description
+	class.UpdatedAt = time.Now()
+	repo.store.classes[classID] = class
+
+	view := repo.classView(class)
+	return &view, nil
+}
+
+func (repo *memoryClassRepository) Delete(classID string, reassignTo *string, deletedBy string) (int64, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	if _, ok := repo.store.classes[classID]; !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	var moved int64
+	if reassignTo != nil {
+		if *reassignTo == classID {
+			return 0, fmt.Errorf("%w: a turma de destino deve ser outra", errInvalidReassignTarget)
+		}
+		if _, ok := repo.store.classes[*reassignTo]; !ok {
+			return 0, fmt.Errorf("%w: turma de destino não encontrada", errInvalidReassignTarget)
+		}
+		now := time.Now()
+		for id, student := range repo.store.students {
+			if student.ClassID == classID {
+				fromClassID, toClassID := classID, *reassignTo
+				reason, transferredBy := classDeletedTransferReason, deletedBy
+				repo.store.transfers = append(repo.store.transfers, StudentClassTransfer{
+					ID:            repo.store.newID("transfer"),
+					StudentID:     id,
+					FromClassID:   &fromClassID,
+					ToClassID:     &toClassID,
+					Reason:        &reason,
+					TransferredBy: &transferredBy,
+					TransferredAt: now,
+				})
+				student.ClassID = toClassID
+				student.UpdatedAt = now
+				repo.store.students[id] = student
+				moved++
+			}
+		}
+	}
+
+	if remaining := repo.classView(repo.store.classes[classID]).StudentCount; remaining > 0 {
+		return 0, fmt.Errorf("%w (%d aluno(s))", errClassHasStudents, remaining)
+	}
+	delete(repo.store.classes, classID)
+	// Como o ON DELETE SET NULL de student_class_transfers
+	for i, transfer := range repo.store.transfers {
+		if transfer.FromClassID != nil && *transfer.FromClassID == classID {
+			repo.store.transfers[i].FromClassID = nil
+		}
+		if transfer.ToClassID != nil && *transfer.ToClassID == classID {
+			repo.store.transfers[i].ToClassID = nil
+		}
+	}
+	return moved, nil
+}
+
+// --- Usuários ---
+
+type memoryUserRepository struct {
+	store *memoryStore
+}
+
+func (repo *memoryUserRepository) GetProfile(userID string) (*UserProfile, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	profile, ok := repo.store.users[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &profile, nil
+}
+
+// --- Idempotency-Keys ---
+
+type memoryIdempotencyKey struct {
+	IdempotencyRecord
+	lockedAt, expiresAt time.Time
+}
+
+type memoryIdempotencyRepository struct {
+	store *memoryStore
+}
+
+func (repo *memoryIdempotencyRepository) Acquire(userID, key, requestHash string) (bool, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	claim := idempotencyClaim{UserID: userID, Key: key}
+	now := time.Now()
+	if stored, ok := repo.store.idempotencyKeys[claim]; ok {
+		abandoned := stored.Status == idempotencyStatusInProgress && stored.RequestHash == requestHash &&
+			stored.lockedAt.Before(now.Add(-idempotencyStaleLock))
+		if !stored.expiresAt.Before(now) && !abandoned {
+			return false, nil
+		}
+	}
+	repo.store.idempotencyKeys[claim] = memoryIdempotencyKey{
+		IdempotencyRecord: IdempotencyRecord{RequestHash: requestHash, Status: idempotencyStatusInProgress},
+		lockedAt:          now,
+		expiresAt:         now.Add(idempotencyKeyTTL),
+	}
+	return true, nil
+}
+
+func (repo *memoryIdempotencyRepository) Get(userID, key string) (*IdempotencyRecord, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	stored, ok := repo.store.idempotencyKeys[idempotencyClaim{UserID: userID, Key: key}]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	record := stored.IdempotencyRecord
+	return &record, nil
+}
+
+func (repo *memoryIdempotencyRepository) Complete(userID, key string, status int, body []byte, contentType string) error {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	claim := idempotencyClaim{UserID: userID, Key: key}
+	if stored, ok := repo.store.idempotencyKeys[claim]; ok {
+		stored.Status, stored.ResponseStatus, stored.ContentType = idempotencyStatusDone, status, contentType
+		stored.ResponseBody = append([]byte(nil), body...)
+		repo.store.idempotencyKeys[claim] = stored
+	}
+	return nil
+}
+
+func (repo *memoryIdempotencyRepository) Release(userID, key string) error {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	claim := idempotencyClaim{UserID: userID, Key: key}
+	if stored, ok := repo.store.idempotencyKeys[claim]; ok && stored.Status == idempotencyStatusInProgress {
+		delete(repo.store.idempotencyKeys, claim)
+	}
+	return nil
+}
+
+// --- Recargas ---
+
+type memoryTopUpRepository struct {
+	store *memoryStore
+}
+
+func (repo *memoryTopUpRepository) ConfirmPayment(providerName string, notification PaymentNotification) (bool, error) {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+
+	for id, topUp := range repo.store.topUps {
+		if topUp.Provider != providerName || topUp.ProviderChargeID == nil || *topUp.ProviderChargeID != notification.ProviderChargeID {
+			continue
+		}
+		switch decideTopUpPayment(&topUp, notification) {
+		case topUpPaymentAlreadyPaid:
+			return false, nil
+		case topUpPaymentAmountMismatch:
+			topUp.Status = TopUpStatusFailed
+			repo.store.topUps[id] = topUp
+			return false, nil
+		}
+		paidAt := notification.PaidAt
+		topUp.Status, topUp.PaidAt = TopUpStatusPaid, &paidAt
+		repo.store.topUps[id] = topUp
+		repo.store.credits[topUp.UserID] += topUp.Amount
+		return true, nil
+	}
+	return false, nil // Cobrança desconhecida
+}
+
+// paginate aplica LIMIT/OFFSET a uma lista já ordenada
+func paginate[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	items = items[offset:]
+	if limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
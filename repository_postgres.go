@@ -0,0 +1,1199 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// isUniqueViolation diz se o erro é de violação da constraint UNIQUE informada
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" && strings.Contains(pqErr.Constraint, constraint)
+}
+
+// isForeignKeyViolation diz se o erro é de violação de chave estrangeira ('constraint' vazio aceita qualquer uma)
+func isForeignKeyViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation" && strings.Contains(pqErr.Constraint, constraint)
+}
+
+// isInvalidTextRepresentation diz se o erro é de um valor que o Postgres não converte para o tipo da coluna
+// (ex: um ID que não é UUID)
+func isInvalidTextRepresentation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Name() == "invalid_text_representation"
+}
+
+// --- Cardápio ---
+
+type postgresMenuRepository struct {
+	db *sql.DB
+}
+
+// sqlConditions traduz o filtro em condições SQL sobre menu_items. Os parâmetros são acrescentados
+// a queryParams, numerados a partir dos que já existem.
+func (filter MenuItemFilter) sqlConditions(queryParams []interface{}) ([]string, []interface{}) {
+	var conditions []string
+	if len(filter.Tags) > 0 {
+		queryParams = append(queryParams, pq.Array(filter.Tags))
+		conditions = append(conditions, fmt.Sprintf("dietary_tags @> $%d", len(queryParams)))
+	}
+	if len(filter.ExcludedAllergens) > 0 {
+		queryParams = append(queryParams, pq.Array(filter.ExcludedAllergens))
+		conditions = append(conditions, fmt.Sprintf("NOT (allergens && $%d)", len(queryParams)))
+	}
+	return conditions, queryParams
+}
+
+func (repo *postgresMenuRepository) ListItems(filter MenuItemFilter) ([]MenuItem, error) {
+	conditions, queryParams := filter.sqlConditions(nil)
+	query := "SELECT " + menuItemColumns + " FROM public.menu_items"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY name ASC"
+
+	rows, err := repo.db.Query(query, queryParams...)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar itens do cardápio: %w", err)
+	}
+	defer rows.Close()
+
+	items := []MenuItem{}
+	for rows.Next() {
+		item, err := scanMenuItem(rows)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao scanear item do cardápio: %w", err)
+		}
+		items = append(items, *item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro após iterar itens do cardápio: %w", err)
+	}
+	return items, nil
+}
+
+func (repo *postgresMenuRepository) GetItem(itemID string) (*MenuItem, error) {
+	return scanMenuItem(repo.db.QueryRow("SELECT "+menuItemColumns+" FROM public.menu_items WHERE id = $1;", itemID))
+}
+
+func (repo *postgresMenuRepository) CreateItem(item MenuItem) (*MenuItem, error) {
+	sqlStatement := `INSERT INTO public.menu_items (name, description, price, category, image_url, is_available, allergens, dietary_tags, stock_quantity)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING ` + menuItemColumns
+	return scanMenuItem(repo.db.QueryRow(sqlStatement, item.Name, item.Description, item.Price, item.Category, item.ImageURL, item.IsAvailable,
+		pq.Array(item.Allergens), pq.Array(item.DietaryTags), item.StockQuantity))
+}
+
+func (repo *postgresMenuRepository) UpdateItem(item MenuItem) (*MenuItem, error) {
+	sqlStatement := `
+		UPDATE public.menu_items
+		SET name = $1, description = $2, price = $3, category = $4, image_url = $5, is_available = $6,
+		    allergens = $7, dietary_tags = $8, updated_at = NOW()
+		WHERE id = $9
+		RETURNING ` + menuItemColumns + `;`
+	return scanMenuItem(repo.db.QueryRow(sqlStatement, item.Name, item.Description, item.Price, item.Category, item.ImageURL, item.IsAvailable,
+		pq.Array(item.Allergens), pq.Array(item.DietaryTags), item.ID))
+}
+
+func (repo *postgresMenuRepository) DeleteItem(itemID string) error {
+	var deletedID string
+	return repo.db.QueryRow("DELETE FROM public.menu_items WHERE id = $1 RETURNING id;", itemID).Scan(&deletedID)
+}
+
+// --- Pedidos ---
+
+type postgresOrderRepository struct {
+	db *sql.DB
+}
+
+// orderColumns são as colunas lidas por scanOrder, na mesma ordem, sobre orderFromClause
+const orderColumns = `o.id, o.user_id, o.student_id, s.name, o.order_date, to_char(o.scheduled_for, 'YYYY-MM-DD'),
+	o.pickup_slot_id, o.pickup_code, o.total_amount, o.status, o.created_at, o.updated_at`
+
+// orderFromClause traz o pedido (o) com o aluno (s), para o nome do aluno
+const orderFromClause = ` FROM public.orders o LEFT JOIN public.students s ON s.id = o.student_id`
+
+// scanOrder lê uma linha com as colunas de orderColumns; o código de retirada já vem com o conteúdo do QR
+func scanOrder(row interface{ Scan(...interface{}) error }) (*Order, error) {
+	var order Order
+	var studentID, studentName, pickupSlotID, pickupCode sql.NullString
+	err := row.Scan(&order.ID, &order.UserID, &studentID, &studentName, &order.OrderDate, &order.ScheduledFor, &pickupSlotID, &pickupCode,
+		&order.TotalAmount, &order.Status, &order.CreatedAt, &order.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if studentID.Valid {
+		order.StudentID = &studentID.String
+	}
+	if studentName.Valid {
+		order.StudentName = &studentName.String
+	}
+	if pickupSlotID.Valid {
+		order.PickupSlotID = &pickupSlotID.String
+	}
+	setOrderPickupFields(&order, pickupCode)
+	return &order, nil
+}
+
+// sqlConditions traduz o filtro (sem o cursor) em condições SQL sobre orders (alias o). Os parâmetros são
+// acrescentados a queryParams, numerados a partir dos que já existem.
+func (filter OrderFilter) sqlConditions(queryParams []interface{}) ([]string, []interface{}) {
+	var conditions []string
+	if len(filter.Statuses) > 0 {
+		queryParams = append(queryParams, pq.Array(filter.Statuses))
+		conditions = append(conditions, fmt.Sprintf("o.status = ANY($%d)", len(queryParams)))
+	}
+	if filter.From != nil {
+		queryParams = append(queryParams, *filter.From)
+		conditions = append(conditions, fmt.Sprintf("o.order_date >= $%d", len(queryParams)))
+	}
+	if filter.To != nil {
+		queryParams = append(queryParams, *filter.To)
+		conditions = append(conditions, fmt.Sprintf("o.order_date < $%d", len(queryParams)))
+	}
+	if filter.ScheduledFrom != nil {
+		queryParams = append(queryParams, filter.ScheduledFrom.In(schoolLocation).Format(dateLayout))
+		conditions = append(conditions, fmt.Sprintf("o.scheduled_for >= $%d::date", len(queryParams)))
+	}
+	if filter.ScheduledTo != nil {
+		queryParams = append(queryParams, filter.ScheduledTo.In(schoolLocation).Format(dateLayout))
+		conditions = append(conditions, fmt.Sprintf("o.scheduled_for < $%d::date", len(queryParams)))
+	}
+	if filter.UserID != "" {
+		queryParams = append(queryParams, filter.UserID)
+		conditions = append(conditions, fmt.Sprintf("o.user_id = $%d", len(queryParams)))
+	}
+	if filter.StudentID != "" {
+		queryParams = append(queryParams, filter.StudentID)
+		conditions = append(conditions, fmt.Sprintf("o.student_id = $%d", len(queryParams)))
+	}
+	if filter.ClassID != "" {
+		queryParams = append(queryParams, filter.ClassID)
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM public.students cs WHERE cs.id = o.student_id AND cs.class_id = $%d)", len(queryParams)))
+	}
+	if filter.GuardianUserID != "" {
+		queryParams = append(queryParams, filter.GuardianUserID)
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM public.student_guardians g WHERE g.student_id = o.student_id AND g.user_id = $%d)", len(queryParams)))
+	}
+	if filter.VisibleToUserID != "" {
+		queryParams = append(queryParams, filter.VisibleToUserID)
+		conditions = append(conditions, fmt.Sprintf(
+			"(o.user_id = $%[1]d OR EXISTS (SELECT 1 FROM public.student_guardians vg WHERE vg.student_id = o.student_id AND vg.user_id = $%[1]d))",
+			len(queryParams)))
+	}
+	if filter.MenuItemID != "" {
+		queryParams = append(queryParams, filter.MenuItemID)
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM public.order_items oi WHERE oi.order_id = o.id AND oi.menu_item_id = $%d)", len(queryParams)))
+	}
+	return conditions, queryParams
+}
+
+func (repo *postgresOrderRepository) List(filter OrderFilter) ([]Order, int, error) {
+	conditions, queryParams := filter.sqlConditions(nil)
+	whereClause := ""
+	if len(conditions) > 0 {
+		whereClause = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := repo.db.QueryRow("SELECT COUNT(*) FROM public.orders o"+whereClause, queryParams...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("erro ao contar pedidos: %w", err)
+	}
+
+	// Paginação por cursor: (campo, id) depois da posição do último pedido da página anterior
+	field, desc := splitOrderSort(filter.Sort)
+	direction, comparison := "ASC", ">"
+	if desc {
+		direction, comparison = "DESC", "<"
+	}
+	if filter.After != nil {
+		queryParams = append(queryParams, filter.After.Value, filter.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(o.%s, o.id) %s ($%d::%s, $%d::uuid)",
+			field, comparison, len(queryParams)-1, orderSortFields[field], len(queryParams)))
+		whereClause = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	listQuery := "SELECT " + orderColumns + orderFromClause + whereClause +
+		fmt.Sprintf(" ORDER BY o.%s %s, o.id %s LIMIT $%d;", field, direction, direction, len(queryParams)+1)
+	rows, err := repo.db.Query(listQuery, append(queryParams, filter.Limit)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("erro ao buscar pedidos: %w", err)
+	}
+	defer rows.Close()
+
+	orders := []Order{}
+	orderIDs := []string{}
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("erro ao scanear pedido: %w", err)
+		}
+		orders = append(orders, *order)
+		orderIDs = append(orderIDs, order.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("erro após iterar pedidos: %w", err)
+	}
+	rows.Close()
+
+	itemsByOrder, err := fetchOrderItemsByOrderIDs(repo.db, orderIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range orders {
+		orders[i].Items = itemsByOrder[orders[i].ID]
+	}
+	return orders, total, nil
+}
+
+func (repo *postgresOrderRepository) Spending(filter OrderFilter) (*OrderSpending, error) {
+	conditions, queryParams := filter.sqlConditions(nil)
+	conditions = append(conditions, "o.status <> 'CANCELED'")
+
+	rows, err := repo.db.Query(`
+		SELECT o.student_id, s.name, SUM(o.total_amount), COUNT(*)`+orderFromClause+`
+		WHERE `+strings.Join(conditions, " AND ")+`
+		GROUP BY o.student_id, s.name
+		ORDER BY s.name NULLS LAST, o.student_id;`, queryParams...)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao somar gastos dos pedidos: %w", err)
+	}
+	defer rows.Close()
+
+	spending := &OrderSpending{ByStudent: []StudentSpending{}}
+	for rows.Next() {
+		var entry StudentSpending
+		var studentID, studentName sql.NullString
+		if err := rows.Scan(&studentID, &studentName, &entry.Total, &entry.OrderCount); err != nil {
+			return nil, fmt.Errorf("erro ao scanear gastos dos pedidos: %w", err)
+		}
+		if studentID.Valid {
+			entry.StudentID = &studentID.String
+		}
+		if studentName.Valid {
+			entry.StudentName = &studentName.String
+		}
+		spending.add(entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro após iterar gastos dos pedidos: %w", err)
+	}
+	return spending, nil
+}
+
+func (repo *postgresOrderRepository) Get(orderID string) (*Order, error) {
+	order, err := scanOrder(repo.db.QueryRow("SELECT "+orderColumns+orderFromClause+" WHERE o.id = $1;", orderID))
+	if err != nil {
+		return nil, err
+	}
+	if order.Items, err = fetchOrderItemsByOrderID(repo.db, order.ID); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+func (repo *postgresOrderRepository) Items(orderID string) ([]OrderItem, error) {
+	return fetchOrderItemsByOrderID(repo.db, orderID)
+}
+
+func (repo *postgresOrderRepository) Create(userID string, request CreateOrderRequest) (*Order, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao iniciar transação do pedido: %w", err)
+	}
+	defer rollbackTx(tx)
+
+	// Dia de serviço e horário de retirada, validados contra o prazo (cut-off) do horário
+	schedule, scheduleViolation, err := resolveOrderSchedule(tx, request.ScheduledFor, request.PickupSlot, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if scheduleViolation != "" {
+		return nil, &orderRejection{reason: scheduleViolation}
+	}
+
+	// O usuário deve ser responsável pelo aluno, com papel que pode pedir. FOR UPDATE serializa pedidos
+	// simultâneos do mesmo aluno (inclusive de responsáveis diferentes), para que os limites de gasto não sejam furados
+	var studentID string
+	err = tx.QueryRow(`
+		SELECT s.id FROM public.students s
+		JOIN public.student_guardians g ON g.student_id = s.id
+		WHERE s.id = $1 AND g.user_id = $2 AND g.role = ANY($3) AND s.archived_at IS NULL
+		FOR UPDATE OF s`, request.StudentID, userID, pq.Array(guardianOrderingRoles)).Scan(&studentID)
+	if err == sql.ErrNoRows {
+		return nil, errStudentNotOrderable
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao validar aluno %s para usuário %s: %w", request.StudentID, userID, err)
+	}
+
+	// Trava as linhas dos itens pedidos (em ordem de id, para evitar deadlock entre pedidos concorrentes)
+	// antes de ler preço/estoque, para que a baixa de estoque abaixo seja consistente
+	requestedQuantities := map[string]int{}
+	var requestedItemIDs []string
+	for _, itemReq := range request.Items {
+		if _, seen := requestedQuantities[itemReq.MenuItemID]; !seen {
+			requestedItemIDs = append(requestedItemIDs, itemReq.MenuItemID)
+		}
+		requestedQuantities[itemReq.MenuItemID] += itemReq.Quantity
+	}
+	if _, err := tx.Exec("SELECT id FROM public.menu_items WHERE id = ANY($1) ORDER BY id FOR UPDATE", pq.Array(requestedItemIDs)); err != nil {
+		if isInvalidTextRepresentation(err) { // Algum ID não é UUID
+			return nil, errUnknownMenuItem
+		}
+		return nil, fmt.Errorf("erro ao travar itens do cardápio %v: %w", requestedItemIDs, err)
+	}
+
+	// Só podem ser pedidos itens do cardápio do dia de serviço (e do horário de retirada, se houver)
+	var pickupSlotID *string
+	if schedule.Slot != nil {
+		pickupSlotID = &schedule.Slot.ID
+	}
+	servedItems, err := fetchMenuItemIDsServedOn(tx, schedule.ServiceDate, pickupSlotID)
+	if err != nil {
+		return nil, err
+	}
+
+	var total Money
+	var items []OrderItem
+	var orderLines []orderLineCheck
+	for _, itemReq := range request.Items {
+		var name string
+		var price Money
+		var isAvailable bool
+		var category sql.NullString
+		var allergens, dietaryTags []string
+		var stock sql.NullInt64
+		err := tx.QueryRow("SELECT name, price, is_available, category, allergens, dietary_tags, stock_quantity FROM public.menu_items WHERE id = $1",
+			itemReq.MenuItemID).Scan(&name, &price, &isAvailable, &category, pq.Array(&allergens), pq.Array(&dietaryTags), &stock)
+		if err == sql.ErrNoRows {
+			return nil, errUnknownMenuItem
+		}
+		if err != nil {
+			return nil, fmt.Errorf("erro ao buscar item %s do cardápio: %w", itemReq.MenuItemID, err)
+		}
+		if !isAvailable {
+			return nil, errMenuItemUnavailable
+		}
+		if !servedItems[itemReq.MenuItemID] {
+			return nil, &orderRejection{reason: fmt.Sprintf("O item '%s' não está no cardápio de %s.", name, schedule.ServiceDate.Format("02/01/2006"))}
+		}
+		if stock.Valid && int64(requestedQuantities[itemReq.MenuItemID]) > stock.Int64 {
+			return nil, fmt.Errorf("%w para '%s': restam %d unidade(s)", errInsufficientStock, name, stock.Int64)
+		}
+		total += price.Times(itemReq.Quantity)
+		items = append(items, OrderItem{
+			MenuItemID:      itemReq.MenuItemID,
+			MenuItemName:    name,
+			Quantity:        itemReq.Quantity,
+			PriceAtPurchase: price,
+		})
+		line := orderLineCheck{MenuItemID: itemReq.MenuItemID, MenuItemName: name, Allergens: allergens, DietaryTags: dietaryTags}
+		if category.Valid {
+			line.Category = &category.String
+		}
+		orderLines = append(orderLines, line)
+	}
+
+	// Restrições alimentares do aluno (alergias/exigências), salvo itens liberados pela equipe
+	dietaryConflict, err := checkDietaryConflicts(tx, request.StudentID, orderLines)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao validar restrições alimentares do aluno %s: %w", request.StudentID, err)
+	}
+	if dietaryConflict != "" {
+		return nil, &orderRejection{reason: dietaryConflict}
+	}
+
+	// Regras definidas pelo responsável: itens/categorias bloqueados e limites diário/semanal do aluno
+	violation, err := checkStudentOrderRules(tx, request.StudentID, schedule.ServiceDate, orderLines, total)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao validar limites do aluno %s: %w", request.StudentID, err)
+	}
+	if violation != "" {
+		return nil, &orderRejection{reason: violation}
+	}
+
+	order := Order{
+		UserID:       userID,
+		TotalAmount:  total,
+		Status:       OrderStatusPending,
+		ScheduledFor: schedule.ServiceDate.Format(dateLayout),
+		PickupSlotID: pickupSlotID,
+	}
+	// orders.student_id é anulável (ON DELETE SET NULL, ver migrations/0001_baseline.up.sql)
+	var returnedStudentID sql.NullString
+	pickupCode, err := insertWithOpenPickupCode(tx, func(code string) error {
+		return tx.QueryRow(`
+			INSERT INTO public.orders (user_id, student_id, total_amount, status, scheduled_for, pickup_slot_id, pickup_code)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id, order_date, created_at, updated_at, student_id;`,
+			order.UserID, request.StudentID, order.TotalAmount, order.Status, order.ScheduledFor, order.PickupSlotID, code).Scan(
+			&order.ID, &order.OrderDate, &order.CreatedAt, &order.UpdatedAt, &returnedStudentID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao inserir pedido para usuário %s, aluno %s: %w", userID, request.StudentID, err)
+	}
+	if returnedStudentID.Valid {
+		order.StudentID = &returnedStudentID.String
+	}
+	setOrderPickupFields(&order, sql.NullString{String: pickupCode, Valid: true})
+
+	if err := recordOrderStatusChange(tx, order.ID, nil, order.Status, &userID, nil); err != nil {
+		return nil, err
+	}
+
+	for i := range items {
+		items[i].OrderID = order.ID
+		err := tx.QueryRow(`
+			INSERT INTO public.order_items (order_id, menu_item_id, quantity, price_at_purchase)
+			VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+			items[i].OrderID, items[i].MenuItemID, items[i].Quantity, items[i].PriceAtPurchase).Scan(&items[i].ID, &items[i].CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao inserir item %s do pedido %s: %w", items[i].MenuItemID, order.ID, err)
+		}
+	}
+	order.Items = items
+
+	// Baixa de estoque dos itens controlados (os itens já estão travados desde o início da transação)
+	for _, menuItemID := range requestedItemIDs {
+		if _, err := applyStockMovement(tx, menuItemID, -requestedQuantities[menuItemID], StockMovementOrder, &order.ID, nil, &userID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Debita o pedido da carteira do aluno e/ou dos créditos do responsável, conforme a política da carteira,
+	// lançando nos extratos na mesma transação do pedido
+	if order.PaidFromStudentWallet, err = chargeOrderPayment(tx, request.StudentID, userID, order.ID, order.TotalAmount); err != nil {
+		return nil, err
+	}
+	if err := markIdempotencyKeyCommitted(tx, request.idempotency); err != nil {
+		return nil, err
+	}
+
+	if err := commitTx(tx); err != nil {
+		return nil, fmt.Errorf("erro ao confirmar pedido %s: %w", order.ID, err)
+	}
+	return &order, nil
+}
+
+func (repo *postgresOrderRepository) UpdateStatus(orderID, status string, actor *UserProfile) (*Order, error) {
+	return updateOrderStatus(repo.db, orderID, status, actor)
+}
+
+func (repo *postgresOrderRepository) Cancel(orderID string, actor *UserProfile, reason *string) (*Order, error) {
+	return cancelOrder(repo.db, orderID, actor, reason)
+}
+
+func (repo *postgresOrderRepository) History(orderID string) ([]OrderStatusChange, error) {
+	return fetchOrderStatusHistory(repo.db, orderID)
+}
+
+func (repo *postgresOrderRepository) CompletePickup(orderID, code, staffID string) (*KitchenOrder, error) {
+	return completeOrderPickup(repo.db, orderID, code, staffID)
+}
+
+func (repo *postgresOrderRepository) KitchenOrders(serviceDate, slotCode string) ([]KitchenOrder, error) {
+	return fetchKitchenOrders(repo.db, serviceDate, slotCode)
+}
+
+// --- Alunos ---
+
+type postgresStudentRepository struct {
+	db *sql.DB
+}
+
+// studentSelectQuery traz o aluno com o nome da turma e o email do responsável principal
+const studentSelectQuery = `
+	SELECT s.id, s.name, s.class_id, c.name, s.parent_user_id, u.email,
+	       s.allergies, s.dietary_requirements, s.created_at, s.updated_at, s.archived_at
+	FROM public.students s
+	LEFT JOIN public.classes c ON c.id = s.class_id
+	LEFT JOIN public.users u ON u.id = s.parent_user_id`
+
+func scanStudent(row interface{ Scan(...interface{}) error }) (*Student, error) {
+	var student Student
+	var classID, className, parentEmail sql.NullString
+	var archivedAt sql.NullTime
+	err := row.Scan(&student.ID, &student.Name, &classID, &className, &student.ParentUserID, &parentEmail,
+		pq.Array(&student.Allergies), pq.Array(&student.DietaryRequirements), &student.CreatedAt, &student.UpdatedAt, &archivedAt)
+	if err != nil {
+		return nil, err
+	}
+	student.ClassID = classID.String
+	if className.Valid {
+		student.ClassName = &className.String
+	}
+	if parentEmail.Valid {
+		student.ParentEmail = &parentEmail.String
+	}
+	if archivedAt.Valid {
+		student.ArchivedAt = &archivedAt.Time
+	}
+	return &student, nil
+}
+
+func (repo *postgresStudentRepository) List(filter StudentFilter) ([]Student, int, error) {
+	conditions := []string{}
+	queryParams := []interface{}{}
+
+	switch filter.Status {
+	case "", "active":
+		conditions = append(conditions, "s.archived_at IS NULL")
+	case "archived":
+		conditions = append(conditions, "s.archived_at IS NOT NULL")
+	}
+	if filter.ClassID != "" {
+		queryParams = append(queryParams, filter.ClassID)
+		conditions = append(conditions, fmt.Sprintf("s.class_id = $%d", len(queryParams)))
+	}
+	if filter.ParentUserID != "" {
+		queryParams = append(queryParams, filter.ParentUserID)
+		conditions = append(conditions, fmt.Sprintf("s.parent_user_id = $%d", len(queryParams)))
+	}
+	if filter.GuardianUserID != "" {
+		queryParams = append(queryParams, filter.GuardianUserID)
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM public.student_guardians g WHERE g.student_id = s.id AND g.user_id = $%d)", len(queryParams)))
+	}
+	if filter.Search != "" {
+		queryParams = append(queryParams, "%"+filter.Search+"%")
+		conditions = append(conditions, fmt.Sprintf("s.name ILIKE $%d", len(queryParams)))
+	}
+	whereClause := ""
+	if len(conditions) > 0 {
+		whereClause = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := repo.db.QueryRow("SELECT COUNT(*) FROM public.students s"+whereClause, queryParams...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("erro ao contar alunos: %w", err)
+	}
+
+	listQuery := studentSelectQuery + whereClause +
+		fmt.Sprintf(" ORDER BY s.name ASC, s.id LIMIT $%d OFFSET $%d;", len(queryParams)+1, len(queryParams)+2)
+	rows, err := repo.db.Query(listQuery, append(queryParams, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("erro ao buscar alunos: %w", err)
+	}
+	defer rows.Close()
+
+	students := []Student{}
+	for rows.Next() {
+		student, err := scanStudent(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("erro ao scanear aluno: %w", err)
+		}
+		students = append(students, *student)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("erro após iterar alunos: %w", err)
+	}
+	return students, total, nil
+}
+
+func (repo *postgresStudentRepository) Get(studentID string) (*Student, error) {
+	return scanStudent(repo.db.QueryRow(studentSelectQuery+" WHERE s.id = $1", studentID))
+}
+
+func (repo *postgresStudentRepository) GuardianRole(studentID, userID string) (GuardianRole, error) {
+	return fetchGuardianRole(repo.db, studentID, userID)
+}
+
+func (repo *postgresStudentRepository) ListByGuardian(userID string) ([]Student, error) {
+	// Alunos de que o usuário é responsável (principal, secundário ou só consulta), com o papel dele
+	rows, err := repo.db.Query(`
+		SELECT s.id, s.name, s.class_id, c.name, s.parent_user_id, s.allergies, s.dietary_requirements,
+		       s.created_at, s.updated_at, g.role
+		FROM public.students s
+		JOIN public.student_guardians g ON g.student_id = s.id AND g.user_id = $1
+		LEFT JOIN public.classes c ON s.class_id = c.id
+		WHERE s.archived_at IS NULL
+		ORDER BY s.name ASC;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar alunos do responsável %s: %w", userID, err)
+	}
+	defer rows.Close()
+
+	students := []Student{}
+	for rows.Next() {
+		var student Student
+		var classID, className sql.NullString
+		err := rows.Scan(&student.ID, &student.Name, &classID, &className, &student.ParentUserID,
+			pq.Array(&student.Allergies), pq.Array(&student.DietaryRequirements), &student.CreatedAt, &student.UpdatedAt, &student.GuardianRole)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao scanear aluno do responsável %s: %w", userID, err)
+		}
+		student.ClassID = classID.String
+		if className.Valid {
+			student.ClassName = &className.String
+		}
+		students = append(students, student)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro após iterar alunos do responsável %s: %w", userID, err)
+	}
+	return students, nil
+}
+
+func (repo *postgresStudentRepository) Create(student Student) (*Student, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao iniciar transação de criação de aluno: %w", err)
+	}
+	defer rollbackTx(tx)
+
+	created := student
+	err = tx.QueryRow(`
+		INSERT INTO public.students (name, class_id, parent_user_id, allergies, dietary_requirements) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, name, class_id, parent_user_id, allergies, dietary_requirements, created_at, updated_at`,
+		student.Name, student.ClassID, student.ParentUserID, pq.Array(student.Allergies), pq.Array(student.DietaryRequirements)).Scan(
+		&created.ID, &created.Name, &created.ClassID, &created.ParentUserID,
+		pq.Array(&created.Allergies), pq.Array(&created.DietaryRequirements), &created.CreatedAt, &created.UpdatedAt)
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err, "students_class_id_fkey"):
+			return nil, errUnknownClass
+		case isForeignKeyViolation(err, "students_parent_user_id_fkey"):
+			return nil, errUnknownUser
+		}
+		return nil, fmt.Errorf("erro ao inserir aluno: %w", err)
+	}
+
+	// O responsável informado vira o responsável principal do aluno
+	if err := setPrimaryGuardian(tx, created.ID, created.ParentUserID); err != nil {
+		return nil, err
+	}
+	if err := commitTx(tx); err != nil {
+		return nil, fmt.Errorf("erro ao confirmar criação do aluno: %w", err)
+	}
+	return &created, nil
+}
+
+func (repo *postgresStudentRepository) Update(studentID string, update StudentUpdate) (*Student, error) {
+	setClauses := []string{"name = $2", "parent_user_id = $3", "updated_at = NOW()"}
+	queryParams := []interface{}{studentID, update.Name, update.ParentUserID}
+	if update.Allergies != nil {
+		queryParams = append(queryParams, pq.Array(*update.Allergies))
+		setClauses = append(setClauses, fmt.Sprintf("allergies = $%d", len(queryParams)))
+	}
+	if update.DietaryRequirements != nil {
+		queryParams = append(queryParams, pq.Array(*update.DietaryRequirements))
+		setClauses = append(setClauses, fmt.Sprintf("dietary_requirements = $%d", len(queryParams)))
+	}
+
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao iniciar transação de atualização do aluno %s: %w", studentID, err)
+	}
+	defer rollbackTx(tx)
+
+	var archivedAt sql.NullTime
+	if err := tx.QueryRow("SELECT archived_at FROM public.students WHERE id = $1 FOR UPDATE", studentID).Scan(&archivedAt); err != nil {
+		return nil, err // sql.ErrNoRows: aluno não encontrado
+	}
+	if archivedAt.Valid {
+		return nil, errStudentArchived
+	}
+
+	if _, err := tx.Exec("UPDATE public.students SET "+strings.Join(setClauses, ", ")+" WHERE id = $1", queryParams...); err != nil {
+		if isForeignKeyViolation(err, "") {
+			return nil, errUnknownUser
+		}
+		return nil, fmt.Errorf("erro ao atualizar aluno %s: %w", studentID, err)
+	}
+	// parent_user_id é o responsável principal: troca-o também em student_guardians
+	if err := setPrimaryGuardian(tx, studentID, update.ParentUserID); err != nil {
+		return nil, err
+	}
+	if err := commitTx(tx); err != nil {
+		return nil, fmt.Errorf("erro ao confirmar atualização do aluno %s: %w", studentID, err)
+	}
+	return repo.Get(studentID)
+}
+
+// Transfer move o aluno para 'toClassID' e registra a transferência, na mesma transação
+func (repo *postgresStudentRepository) Transfer(studentID, toClassID string, reason, transferredBy *string) (*StudentClassTransfer, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao iniciar transação: %w", err)
+	}
+	defer rollbackTx(tx)
+
+	var fromClassID sql.NullString
+	var archivedAt sql.NullTime
+	err = tx.QueryRow("SELECT class_id, archived_at FROM public.students WHERE id = $1 FOR UPDATE", studentID).Scan(&fromClassID, &archivedAt)
+	if err != nil {
+		return nil, err // sql.ErrNoRows: aluno não encontrado
+	}
+	if archivedAt.Valid {
+		return nil, errStudentArchived
+	}
+	if fromClassID.Valid && fromClassID.String == toClassID {
+		return nil, errStudentSameClass
+	}
+
+	if _, err := tx.Exec("UPDATE public.students SET class_id = $2, updated_at = NOW() WHERE id = $1", studentID, toClassID); err != nil {
+		if isForeignKeyViolation(err, "") {
+			return nil, errUnknownClass
+		}
+		return nil, fmt.Errorf("erro ao mover aluno %s de turma: %w", studentID, err)
+	}
+
+	transfer, err := insertStudentClassTransfer(tx, studentID, fromClassID, toClassID, reason, transferredBy)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := commitTx(tx); err != nil {
+		return nil, fmt.Errorf("erro ao confirmar transferência do aluno %s: %w", studentID, err)
+	}
+	return transfer, nil
+}
+
+// insertStudentClassTransfer grava uma linha do histórico de turmas; o aluno já deve ter sido movido na mesma transação
+func insertStudentClassTransfer(tx *sql.Tx, studentID string, fromClassID sql.NullString, toClassID string,
+	reason, transferredBy *string) (*StudentClassTransfer, error) {
+	var transfer StudentClassTransfer
+	var fromID, toID, reasonOut, byOut sql.NullString
+	err := tx.QueryRow(`
+		INSERT INTO public.student_class_transfers (student_id, from_class_id, to_class_id, reason, transferred_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, student_id, from_class_id, to_class_id, reason, transferred_by, transferred_at;`,
+		studentID, fromClassID, toClassID, reason, transferredBy).Scan(
+		&transfer.ID, &transfer.StudentID, &fromID, &toID, &reasonOut, &byOut, &transfer.TransferredAt)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao registrar transferência do aluno %s: %w", studentID, err)
+	}
+	if fromID.Valid {
+		transfer.FromClassID = &fromID.String
+	}
+	if toID.Valid {
+		transfer.ToClassID = &toID.String
+	}
+	if reasonOut.Valid {
+		transfer.Reason = &reasonOut.String
+	}
+	if byOut.Valid {
+		transfer.TransferredBy = &byOut.String
+	}
+	return &transfer, nil
+}
+
+// ListTransfers retorna o histórico de turmas do aluno, do mais antigo ao mais recente
+func (repo *postgresStudentRepository) ListTransfers(studentID string) ([]StudentClassTransfer, error) {
+	var exists bool
+	if err := repo.db.QueryRow("SELECT EXISTS (SELECT 1 FROM public.students WHERE id = $1)", studentID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("erro ao buscar aluno %s: %w", studentID, err)
+	}
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	rows, err := repo.db.Query(`
+		SELECT t.id, t.student_id, t.from_class_id, fc.name, t.to_class_id, tc.name, t.reason, t.transferred_by, t.transferred_at
+		FROM public.student_class_transfers t
+		LEFT JOIN public.classes fc ON fc.id = t.from_class_id
+		LEFT JOIN public.classes tc ON tc.id = t.to_class_id
+		WHERE t.student_id = $1
+		ORDER BY t.transferred_at ASC, t.id;`, studentID)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar transferências do aluno %s: %w", studentID, err)
+	}
+	defer rows.Close()
+
+	transfers := []StudentClassTransfer{}
+	for rows.Next() {
+		var transfer StudentClassTransfer
+		var fromID, fromName, toID, toName, reason, transferredBy sql.NullString
+		if err := rows.Scan(&transfer.ID, &transfer.StudentID, &fromID, &fromName, &toID, &toName, &reason, &transferredBy, &transfer.TransferredAt); err != nil {
+			return nil, fmt.Errorf("erro ao scanear transferência do aluno %s: %w", studentID, err)
+		}
+		if fromID.Valid {
+			transfer.FromClassID = &fromID.String
+		}
+		if fromName.Valid {
+			transfer.FromClassName = &fromName.String
+		}
+		if toID.Valid {
+			transfer.ToClassID = &toID.String
+		}
+		if toName.Valid {
+			transfer.ToClassName = &toName.String
+		}
+		if reason.Valid {
+			transfer.Reason = &reason.String
+		}
+		if transferredBy.Valid {
+			transfer.TransferredBy = &transferredBy.String
+		}
+		transfers = append(transfers, transfer)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro após iterar transferências do aluno %s: %w", studentID, err)
+	}
+	return transfers, nil
+}
+
+// Archive arquiva o aluno (exclusão lógica) e encerra as mesadas dele. Recusa enquanto houver pedidos
+// em aberto ou saldo na carteira do aluno.
+func (repo *postgresStudentRepository) Archive(studentID, archivedBy string) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return fmt.Errorf("erro ao iniciar transação: %w", err)
+	}
+	defer rollbackTx(tx)
+
+	var archivedAt sql.NullTime
+	if err := tx.QueryRow("SELECT archived_at FROM public.students WHERE id = $1 FOR UPDATE", studentID).Scan(&archivedAt); err != nil {
+		return err // sql.ErrNoRows: aluno não encontrado
+	}
+	if archivedAt.Valid {
+		return errStudentArchived
+	}
+
+	var openOrders int
+	err = tx.QueryRow("SELECT COUNT(*) FROM public.orders WHERE student_id = $1 AND status IN ('PENDING', 'PREPARING', 'READY')", studentID).Scan(&openOrders)
+	if err != nil {
+		return fmt.Errorf("erro ao contar pedidos em aberto do aluno %s: %w", studentID, err)
+	}
+	if openOrders > 0 {
+		return fmt.Errorf("%w (%d pedido(s))", errStudentOpenOrders, openOrders)
+	}
+
+	// O saldo da carteira do aluno precisa voltar para um responsável antes (POST .../wallet/transfers)
+	var walletBalance Money
+	err = tx.QueryRow("SELECT COALESCE((SELECT balance FROM public.student_wallets WHERE student_id = $1), 0)", studentID).Scan(&walletBalance)
+	if err != nil {
+		return fmt.Errorf("erro ao buscar carteira do aluno %s: %w", studentID, err)
+	}
+	if walletBalance > 0 {
+		return fmt.Errorf("%w (%s)", errStudentHasBalance, walletBalance)
+	}
+
+	if _, err := tx.Exec("UPDATE public.student_allowances SET is_active = FALSE, updated_at = NOW() WHERE student_id = $1 AND is_active", studentID); err != nil {
+		return fmt.Errorf("erro ao encerrar mesadas do aluno %s: %w", studentID, err)
+	}
+	if _, err := tx.Exec("UPDATE public.students SET archived_at = NOW(), archived_by = $2, updated_at = NOW() WHERE id = $1", studentID, archivedBy); err != nil {
+		return fmt.Errorf("erro ao arquivar aluno %s: %w", studentID, err)
+	}
+	if err := commitTx(tx); err != nil {
+		return fmt.Errorf("erro ao confirmar arquivamento do aluno %s: %w", studentID, err)
+	}
+	return nil
+}
+
+// --- Turmas ---
+
+type postgresClassRepository struct {
+	db *sql.DB
+}
+
+// classSelectQuery traz a turma com a contagem de alunos; completar com WHERE/GROUP BY c.id
+const classSelectQuery = `
+	SELECT c.id, c.name, c.description, c.created_at, c.updated_at, COUNT(s.id)
+	FROM public.classes c
+	LEFT JOIN public.students s ON s.class_id = c.id`
+
+func scanClass(row interface{ Scan(...interface{}) error }) (*Class, error) {
+	var class Class
+	var description sql.NullString
+	if err := row.Scan(&class.ID, &class.Name, &description, &class.CreatedAt, &class.UpdatedAt, &class.StudentCount); err != nil {
+		return nil, err
+	}
+	if description.Valid {
+		class.Description = &description.String
+	}
+	return &class, nil
+}
+
+func (repo *postgresClassRepository) List(search string, limit, offset int) ([]Class, int, error) {
+	whereClause := ""
+	queryParams := []interface{}{}
+	if search != "" {
+		queryParams = append(queryParams, "%"+search+"%")
+		whereClause = " WHERE c.name ILIKE $1"
+	}
+
+	var total int
+	if err := repo.db.QueryRow("SELECT COUNT(*) FROM public.classes c"+whereClause, queryParams...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("erro ao contar turmas: %w", err)
+	}
+
+	listQuery := classSelectQuery + whereClause +
+		fmt.Sprintf(" GROUP BY c.id ORDER BY c.name ASC, c.id LIMIT $%d OFFSET $%d;", len(queryParams)+1, len(queryParams)+2)
+	rows, err := repo.db.Query(listQuery, append(queryParams, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("erro ao buscar turmas: %w", err)
+	}
+	defer rows.Close()
+
+	classes := []Class{}
+	for rows.Next() {
+		class, err := scanClass(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("erro ao scanear turma: %w", err)
+		}
+		classes = append(classes, *class)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("erro após iterar turmas: %w", err)
+	}
+	return classes, total, nil
+}
+
+func (repo *postgresClassRepository) Get(classID string) (*Class, error) {
+	return scanClass(repo.db.QueryRow(classSelectQuery+" WHERE c.id = $1 GROUP BY c.id", classID))
+}
+
+func (repo *postgresClassRepository) Create(name string, description *string) (*Class, error) {
+	var class Class
+	var scannedDescription sql.NullString
+	err := repo.db.QueryRow(`
+		INSERT INTO public.classes (name, description) VALUES ($1, $2)
+		RETURNING id, name, description, created_at, updated_at`, name, description).Scan(
+		&class.ID, &class.Name, &scannedDescription, &class.CreatedAt, &class.UpdatedAt)
+	if err != nil {
+		if isUniqueViolation(err, "classes_name") { // O nome da constraint pode variar (classes_name_key)
+			return nil, errClassNameTaken
+		}
+		return nil, fmt.Errorf("erro ao inserir turma: %w", err)
+	}
+	if scannedDescription.Valid {
+		class.Description = &scannedDescription.String
+	}
+	return &class, nil
+}
+
+func (repo *postgresClassRepository) Update(classID, name string, description *string) (*Class, error) {
+	result, err := repo.db.Exec(`
+		UPDATE public.classes
+		SET name = $2, description = $3, updated_at = NOW()
+		WHERE id = $1;`, classID, name, description)
+	if err != nil {
+		if isUniqueViolation(err, "classes_name") {
+			return nil, errClassNameTaken
+		}
+		return nil, fmt.Errorf("erro ao atualizar turma %s: %w", classID, err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return repo.Get(classID)
+}
+
+func (repo *postgresClassRepository) Delete(classID string, reassignTo *string, deletedBy string) (int64, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("erro ao iniciar transação: %w", err)
+	}
+	defer rollbackTx(tx)
+
+	var lockedID string
+	if err := tx.QueryRow("SELECT id FROM public.classes WHERE id = $1 FOR UPDATE", classID).Scan(&lockedID); err != nil {
+		return 0, err // sql.ErrNoRows: turma não encontrada
+	}
+
+	var moved int64
+	if reassignTo != nil {
+		if *reassignTo == classID {
+			return 0, fmt.Errorf("%w: a turma de destino deve ser outra", errInvalidReassignTarget)
+		}
+		var targetID string
+		err := tx.QueryRow("SELECT id FROM public.classes WHERE id = $1 FOR SHARE", *reassignTo).Scan(&targetID)
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("%w: turma de destino não encontrada", errInvalidReassignTarget)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("erro ao buscar turma de destino: %w", err)
+		}
+		rows, err := tx.Query(`
+			UPDATE public.students SET class_id = $2, updated_at = NOW() WHERE class_id = $1
+			RETURNING id;`, classID, targetID)
+		if err != nil {
+			return 0, fmt.Errorf("erro ao mover alunos da turma %s: %w", classID, err)
+		}
+		var movedIDs []string
+		for rows.Next() {
+			var studentID string
+			if err := rows.Scan(&studentID); err != nil {
+				rows.Close()
+				return 0, fmt.Errorf("erro ao scanear aluno movido da turma %s: %w", classID, err)
+			}
+			movedIDs = append(movedIDs, studentID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, fmt.Errorf("erro após mover alunos da turma %s: %w", classID, err)
+		}
+
+		// Cada aluno movido entra no histórico de turmas, como numa transferência feita pela API
+		reason := classDeletedTransferReason
+		for _, studentID := range movedIDs {
+			from := sql.NullString{String: classID, Valid: true}
+			if _, err := insertStudentClassTransfer(tx, studentID, from, targetID, &reason, &deletedBy); err != nil {
+				return 0, err
+			}
+		}
+		moved = int64(len(movedIDs))
+	}
+
+	var remaining int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM public.students WHERE class_id = $1", classID).Scan(&remaining); err != nil {
+		return 0, fmt.Errorf("erro ao contar alunos da turma %s: %w", classID, err)
+	}
+	if remaining > 0 {
+		return 0, fmt.Errorf("%w (%d aluno(s))", errClassHasStudents, remaining)
+	}
+
+	if _, err := tx.Exec("DELETE FROM public.classes WHERE id = $1", classID); err != nil {
+		if isForeignKeyViolation(err, "") { // Aluno vinculado entre a contagem e o DELETE
+			return 0, errClassHasStudents
+		}
+		return 0, fmt.Errorf("erro ao remover turma %s: %w", classID, err)
+	}
+	if err := commitTx(tx); err != nil {
+		return 0, fmt.Errorf("erro ao confirmar remoção da turma %s: %w", classID, err)
+	}
+	return moved, nil
+}
+
+// --- Usuários ---
+
+type postgresUserRepository struct {
+	db *sql.DB
+}
+
+func (repo *postgresUserRepository) GetProfile(userID string) (*UserProfile, error) {
+	return fetchUserProfile(userID, repo.db)
+}
+
+// --- Idempotency-Keys ---
+
+type postgresIdempotencyRepository struct {
+	db *sql.DB
+}
+
+func (repo *postgresIdempotencyRepository) Acquire(userID, key, requestHash string) (bool, error) {
+	result, err := repo.db.Exec(`
+		INSERT INTO public.idempotency_keys (user_id, key, request_hash, expires_at)
+		VALUES ($1, $2, $3, NOW() + $4::float8 * INTERVAL '1 second')
+		ON CONFLICT (user_id, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status = $6, response_status = NULL,
+		    response_body = NULL, content_type = NULL, locked_at = NOW(), created_at = NOW(), expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < NOW()
+		   OR (idempotency_keys.status = $6 AND idempotency_keys.request_hash = EXCLUDED.request_hash
+		       AND idempotency_keys.locked_at < NOW() - $5::float8 * INTERVAL '1 second');`,
+		userID, key, requestHash, idempotencyKeyTTL.Seconds(), idempotencyStaleLock.Seconds(), idempotencyStatusInProgress)
+	if err != nil {
+		return false, fmt.Errorf("erro ao reservar Idempotency-Key: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (repo *postgresIdempotencyRepository) Get(userID, key string) (*IdempotencyRecord, error) {
+	var record IdempotencyRecord
+	var responseStatus sql.NullInt64
+	var contentType sql.NullString
+	err := repo.db.QueryRow(`
+		SELECT request_hash, status, response_status, response_body, content_type
+		FROM public.idempotency_keys
+		WHERE user_id = $1 AND key = $2;`, userID, key).Scan(&record.RequestHash, &record.Status, &responseStatus, &record.ResponseBody, &contentType)
+	if err != nil {
+		return nil, err
+	}
+	record.ResponseStatus = int(responseStatus.Int64)
+	record.ContentType = contentType.String
+	return &record, nil
+}
+
+func (repo *postgresIdempotencyRepository) Complete(userID, key string, status int, body []byte, contentType string) error {
+	_, err := repo.db.Exec(`
+		UPDATE public.idempotency_keys
+		SET status = $3, response_status = $4, response_body = $5, content_type = $6
+		WHERE user_id = $1 AND key = $2;`,
+		userID, key, idempotencyStatusDone, status, body, contentType)
+	return err
+}
+
+func (repo *postgresIdempotencyRepository) Release(userID, key string) error {
+	_, err := repo.db.Exec("DELETE FROM public.idempotency_keys WHERE user_id = $1 AND key = $2 AND status = $3",
+		userID, key, idempotencyStatusInProgress)
+	return err
+}
+
+// --- Recargas ---
+
+type postgresTopUpRepository struct {
+	db *sql.DB
+}
+
+func (repo *postgresTopUpRepository) ConfirmPayment(providerName string, notification PaymentNotification) (bool, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("erro ao iniciar transação de confirmação: %w", err)
+	}
+	defer rollbackTx(tx)
+
+	lockQuery := `SELECT ` + topUpColumns + ` FROM public.credit_topups
+		WHERE provider = $1 AND provider_charge_id = $2
+		FOR UPDATE;`
+	topUp, err := scanCreditTopUp(tx.QueryRow(lockQuery, providerName, notification.ProviderChargeID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// Pagamento sem recarga correspondente: não adianta o provedor reenviar
+			log.Printf("ALERTA: pagamento recebido para cobrança desconhecida %s/%s (%s).", providerName, notification.ProviderChargeID, notification.Amount)
+			return false, nil
+		}
+		return false, fmt.Errorf("erro ao buscar recarga da cobrança %s: %w", notification.ProviderChargeID, err)
+	}
+
+	switch decideTopUpPayment(topUp, notification) {
+	case topUpPaymentAlreadyPaid:
+		return false, nil
+	case topUpPaymentAmountMismatch:
+		log.Printf("ALERTA: valor pago (%s) difere do valor da recarga %s (%s). Recarga marcada como FAILED.",
+			notification.Amount, topUp.ID, topUp.Amount)
+		if _, err := tx.Exec("UPDATE public.credit_topups SET status = $1, updated_at = NOW() WHERE id = $2", TopUpStatusFailed, topUp.ID); err != nil {
+			return false, fmt.Errorf("erro ao marcar recarga %s como FAILED: %w", topUp.ID, err)
+		}
+		return false, commitTx(tx)
+	}
+
+	_, err = tx.Exec(`UPDATE public.credit_topups SET status = $1, paid_at = $2, updated_at = NOW() WHERE id = $3`,
+		TopUpStatusPaid, notification.PaidAt, topUp.ID)
+	if err != nil {
+		return false, fmt.Errorf("erro ao marcar recarga %s como paga: %w", topUp.ID, err)
+	}
+
+	description := fmt.Sprintf("Recarga via %s", providerName)
+	_, err = recordCreditTransaction(tx, CreditTransaction{
+		UserID:      topUp.UserID,
+		Type:        CreditTxTopUp,
+		Amount:      topUp.Amount,
+		TopUpID:     &topUp.ID,
+		Description: &description,
+	})
+	if err != nil {
+		return false, fmt.Errorf("erro ao creditar recarga %s: %w", topUp.ID, err)
+	}
+
+	if err := commitTx(tx); err != nil {
+		return false, fmt.Errorf("erro ao confirmar recarga %s: %w", topUp.ID, err)
+	}
+	log.Printf("Recarga %s confirmada: %s creditados ao usuário %s.", topUp.ID, topUp.Amount, topUp.UserID)
+	return true, nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	_ "time/tzdata" // A imagem final (alpine) não traz o banco de fusos horários
+)
+
+const (
+	defaultSchoolTimezone = "America/Sao_Paulo"
+	maxScheduleDaysAhead  = 30 // Antecedência máxima para encomendas
+	slotClockLayout       = "15:04"
+)
+
+// schoolLocation é o fuso horário da escola, usado para "hoje", datas de serviço e prazos.
+// Definido em main a partir de CANTINA_TIMEZONE.
+var schoolLocation = time.UTC
+
+// loadSchoolLocationFromEnv lê CANTINA_TIMEZONE (padrão America/Sao_Paulo)
+func loadSchoolLocationFromEnv() (*time.Location, error) {
+	name := strings.TrimSpace(os.Getenv("CANTINA_TIMEZONE"))
+	if name == "" {
+		name = defaultSchoolTimezone
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("CANTINA_TIMEZONE inválido (%q): %w", name, err)
+	}
+	return location, nil
+}
+
+// schoolDate retorna a meia-noite do dia de 't' no fuso da escola
+func schoolDate(t time.Time) time.Time {
+	local := t.In(schoolLocation)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, schoolLocation)
+}
+
+// schoolToday é o dia corrente no fuso da escola
+func schoolToday() time.Time {
+	return schoolDate(time.Now())
+}
+
+// parseServiceDate interpreta uma data YYYY-MM-DD como dia no fuso da escola
+func parseServiceDate(raw string) (time.Time, error) {
+	return time.ParseInLocation(dateLayout, strings.TrimSpace(raw), schoolLocation)
+}
+
+// PickupSlot espelha uma linha de pickup_slots (recreio 1, almoço, ...)
+type PickupSlot struct {
+	ID               string    `json:"id"`
+	Code             string    `json:"code"`
+	Name             string    `json:"name"`
+	ServiceTime      string    `json:"service_time"` // HH:MM
+	CutoffTime       string    `json:"cutoff_time"`  // HH:MM
+	CutoffDaysBefore int       `json:"cutoff_days_before"`
+	IsActive         bool      `json:"is_active"`
+	SortOrder        int       `json:"sort_order"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// PickupSlotPayload é o corpo de POST /pickup-slots e PUT /pickup-slots/{id}
+type PickupSlotPayload struct {
+	Code             string `json:"code"`
+	Name             string `json:"name"`
+	ServiceTime      string `json:"service_time"`
+	CutoffTime       string `json:"cutoff_time"`
+	CutoffDaysBefore int    `json:"cutoff_days_before"`
+	IsActive         *bool  `json:"is_active"` // Padrão: true
+	SortOrder        int    `json:"sort_order"`
+}
+
+const pickupSlotColumns = `id, code, name, to_char(service_time, 'HH24:MI'), to_char(cutoff_time, 'HH24:MI'),
+	cutoff_days_before, is_active, sort_order, created_at, updated_at`
+
+func scanPickupSlot(row interface{ Scan(...interface{}) error }) (*PickupSlot, error) {
+	var slot PickupSlot
+	err := row.Scan(&slot.ID, &slot.Code, &slot.Name, &slot.ServiceTime, &slot.CutoffTime,
+		&slot.CutoffDaysBefore, &slot.IsActive, &slot.SortOrder, &slot.CreatedAt, &slot.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &slot, nil
+}
+
+// cutoffFor retorna o prazo final para pedidos deste horário no dia de serviço informado
+func (slot *PickupSlot) cutoffFor(serviceDate time.Time) (time.Time, error) {
+	clock, err := time.Parse(slotClockLayout, slot.CutoffTime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("horário de corte inválido no horário %s: %w", slot.Code, err)
+	}
+	day := serviceDate.AddDate(0, 0, -slot.CutoffDaysBefore)
+	return time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, schoolLocation), nil
+}
+
+// orderSchedule é o agendamento já validado de um novo pedido
+type orderSchedule struct {
+	ServiceDate time.Time
+	Slot        *PickupSlot // nil = pedido imediato, sem horário de retirada
+}
+
+// resolveOrderSchedule valida scheduled_for/pickup_slot de um novo pedido contra a data atual e o prazo do horário.
+// Sem os dois campos, o pedido é imediato (servido hoje). Retorna um motivo legível ("" se o agendamento é válido).
+func resolveOrderSchedule(q dbQueryer, scheduledFor, slotCode *string, now time.Time) (*orderSchedule, string, error) {
+	findActiveSlot := func(code string) (*PickupSlot, error) {
+		return scanPickupSlot(q.QueryRow("SELECT "+pickupSlotColumns+" FROM public.pickup_slots WHERE code = $1 AND is_active", code))
+	}
+	return scheduleOrder(findActiveSlot, scheduledFor, slotCode, now)
+}
+
+// scheduleOrder é resolveOrderSchedule com a busca do horário ativo pelo código (sql.ErrNoRows se não houver)
+func scheduleOrder(findActiveSlot func(code string) (*PickupSlot, error), scheduledFor, slotCode *string,
+	now time.Time) (*orderSchedule, string, error) {
+	today := schoolDate(now)
+	schedule := orderSchedule{ServiceDate: today}
+
+	if scheduledFor != nil && strings.TrimSpace(*scheduledFor) != "" {
+		serviceDate, err := parseServiceDate(*scheduledFor)
+		if err != nil {
+			return nil, fmt.Sprintf("scheduled_for inválido (esperado YYYY-MM-DD): %q", *scheduledFor), nil
+		}
+		schedule.ServiceDate = serviceDate
+	}
+	if schedule.ServiceDate.Before(today) {
+		return nil, "Não é possível fazer pedidos para datas passadas.", nil
+	}
+	if schedule.ServiceDate.After(today.AddDate(0, 0, maxScheduleDaysAhead)) {
+		return nil, fmt.Sprintf("Pedidos podem ser agendados com no máximo %d dias de antecedência.", maxScheduleDaysAhead), nil
+	}
+
+	if slotCode == nil || strings.TrimSpace(*slotCode) == "" {
+		if !schedule.ServiceDate.Equal(today) {
+			return nil, "Informe o horário de retirada (pickup_slot) para pedidos agendados.", nil
+		}
+		return &schedule, "", nil
+	}
+
+	code := strings.ToLower(strings.TrimSpace(*slotCode))
+	slot, err := findActiveSlot(code)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Sprintf("Horário de retirada '%s' não encontrado ou inativo.", code), nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("erro ao buscar horário de retirada %s: %w", code, err)
+	}
+
+	deadline, err := slot.cutoffFor(schedule.ServiceDate)
+	if err != nil {
+		return nil, "", err
+	}
+	if !now.Before(deadline) {
+		return nil, fmt.Sprintf("O prazo para pedidos do %s em %s encerrou às %s.",
+			slot.Name, schedule.ServiceDate.Format("02/01/2006"), deadline.Format("15:04 de 02/01/2006")), nil
+	}
+
+	schedule.Slot = slot
+	return &schedule, "", nil
+}
+
+// validatePickupSlotPayload normaliza o payload e retorna uma mensagem de erro ("" se válido)
+func validatePickupSlotPayload(payload *PickupSlotPayload) string {
+	payload.Code = strings.ToLower(strings.TrimSpace(payload.Code))
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Code == "" || payload.Name == "" {
+		return "Os campos 'code' e 'name' são obrigatórios."
+	}
+	serviceClock, err := time.Parse(slotClockLayout, payload.ServiceTime)
+	if err != nil {
+		return "service_time inválido (esperado HH:MM)."
+	}
+	cutoffClock, err := time.Parse(slotClockLayout, payload.CutoffTime)
+	if err != nil {
+		return "cutoff_time inválido (esperado HH:MM)."
+	}
+	if payload.CutoffDaysBefore < 0 {
+		return "cutoff_days_before não pode ser negativo."
+	}
+	if payload.CutoffDaysBefore == 0 && cutoffClock.After(serviceClock) {
+		return "cutoff_time não pode ser posterior a service_time no mesmo dia."
+	}
+	return ""
+}
+
+// pickupSlotsRouterHandler para /pickup-slots e /pickup-slots/{id}
+func pickupSlotsRouterHandler(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+	slotID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/pickup-slots"), "/")
+
+	switch {
+	case slotID == "" && r.Method == http.MethodGet:
+		protect(appDB, PermSelfService, func(ww http.ResponseWriter, rr *http.Request) {
+			handleGetPickupSlots(ww, rr, appDB)
+		}).ServeHTTP(w, r)
+	case slotID == "" && r.Method == http.MethodPost:
+		protect(appDB, PermMenuManage, func(ww http.ResponseWriter, rr *http.Request) {
+			handleSavePickupSlot(ww, rr, appDB, "")
+		}).ServeHTTP(w, r)
+	case slotID != "" && r.Method == http.MethodPut:
+		protect(appDB, PermMenuManage, func(ww http.ResponseWriter, rr *http.Request) {
+			handleSavePickupSlot(ww, rr, appDB, slotID)
+		}).ServeHTTP(w, r)
+	default:
+		http.Error(w, "Rota ou método não permitido para /pickup-slots", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleGetPickupSlots trata GET /pickup-slots: horários ativos (PermMenuManage vê também os inativos com ?all=true)
+func handleGetPickupSlots(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+	query := "SELECT " + pickupSlotColumns + " FROM public.pickup_slots WHERE is_active ORDER BY sort_order, service_time"
+	if r.URL.Query().Get("all") == "true" {
+		if !hasPermission(r.Context(), PermMenuManage) {
+			http.Error(w, "Acesso não autorizado para esta ação.", http.StatusForbidden)
+			return
+		}
+		query = "SELECT " + pickupSlotColumns + " FROM public.pickup_slots ORDER BY sort_order, service_time"
+	}
+
+	rows, err := appDB.Query(query)
+	if err != nil {
+		log.Printf("Erro ao buscar horários de retirada: %v", err)
+		http.Error(w, "Erro ao buscar horários de retirada.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	slots := []PickupSlot{}
+	for rows.Next() {
+		slot, err := scanPickupSlot(rows)
+		if err != nil {
+			log.Printf("Erro ao scanear horário de retirada: %v", err)
+			http.Error(w, "Erro ao buscar horários de retirada.", http.StatusInternalServerError)
+			return
+		}
+		slots = append(slots, *slot)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Erro após iterar horários de retirada: %v", err)
+		http.Error(w, "Erro ao buscar horários de retirada.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(slots)
+}
+
+// handleSavePickupSlot trata POST /pickup-slots (slotID vazio) e PUT /pickup-slots/{id}
+func handleSavePickupSlot(w http.ResponseWriter, r *http.Request, appDB *sql.DB, slotID string) {
+	var payload PickupSlotPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if msg := validatePickupSlotPayload(&payload); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	isActive := true
+	if payload.IsActive != nil {
+		isActive = *payload.IsActive
+	}
+
+	var row *sql.Row
+	status := http.StatusOK
+	if slotID == "" {
+		status = http.StatusCreated
+		row = appDB.QueryRow(`
+			INSERT INTO public.pickup_slots (code, name, service_time, cutoff_time, cutoff_days_before, is_active, sort_order)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING `+pickupSlotColumns,
+			payload.Code, payload.Name, payload.ServiceTime, payload.CutoffTime, payload.CutoffDaysBefore, isActive, payload.SortOrder)
+	} else {
+		row = appDB.QueryRow(`
+			UPDATE public.pickup_slots
+			SET code = $1, name = $2, service_time = $3, cutoff_time = $4, cutoff_days_before = $5,
+			    is_active = $6, sort_order = $7, updated_at = NOW()
+			WHERE id = $8
+			RETURNING `+pickupSlotColumns,
+			payload.Code, payload.Name, payload.ServiceTime, payload.CutoffTime, payload.CutoffDaysBefore, isActive, payload.SortOrder, slotID)
+	}
+
+	slot, err := scanPickupSlot(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Horário de retirada não encontrado.", http.StatusNotFound)
+		} else if isUniqueViolation(err, "pickup_slots_code_key") {
+			http.Error(w, "Já existe um horário de retirada com este código.", http.StatusConflict)
+		} else {
+			log.Printf("Erro ao salvar horário de retirada %q: %v", payload.Code, err)
+			http.Error(w, "Erro ao salvar horário de retirada.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(slot)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Tipos de movimentação de estoque (coluna stock_movements.type)
+const (
+	StockMovementOrder   = "ORDER"   // Baixa por pedido
+	StockMovementCancel  = "CANCEL"  // Devolução por pedido cancelado
+	StockMovementRestock = "RESTOCK" // Reposição
+	StockMovementWaste   = "WASTE"   // Perda/descarte
+	StockMovementCount   = "COUNT"   // Contagem física: define a quantidade absoluta
+)
+
+// errInsufficientStock indica que a movimentação deixaria o estoque negativo
+var errInsufficientStock = errors.New("estoque insuficiente")
+
+// StockMovement espelha uma linha de stock_movements
+type StockMovement struct {
+	ID             string    `json:"id"`
+	MenuItemID     string    `json:"menu_item_id"`
+	Type           string    `json:"type"`
+	QuantityChange int       `json:"quantity_change"`
+	QuantityAfter  int       `json:"quantity_after"`
+	OrderID        *string   `json:"order_id,omitempty"`
+	Note           *string   `json:"note,omitempty"`
+	CreatedBy      *string   `json:"created_by,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// CreateStockMovementPayload é o corpo de POST /menu-items/{id}/stock.
+// Para RESTOCK e WASTE, quantity é a quantidade movimentada (> 0); para COUNT, a quantidade contada (>= 0).
+type CreateStockMovementPayload struct {
+	Type     string  `json:"type"`
+	Quantity int     `json:"quantity"`
+	Note     *string `json:"note"`
+}
+
+// applyStockMovement soma 'change' ao estoque do item e registra a movimentação, dentro da transação informada.
+// Ao chegar a zero o item fica indisponível; ao sair do zero volta a ficar disponível.
+// Para itens sem controle de estoque (stock_quantity NULL) não faz nada e retorna (nil, nil).
+// Retorna errInsufficientStock se o estoque ficaria negativo.
+func applyStockMovement(tx *sql.Tx, menuItemID string, change int, movementType string, orderID, note, createdBy *string) (*StockMovement, error) {
+	var quantityAfter int
+	updateQuery := `
+		UPDATE public.menu_items
+		SET stock_quantity = stock_quantity + $1,
+		    is_available = CASE
+		        WHEN stock_quantity + $1 <= 0 THEN FALSE
+		        WHEN stock_quantity <= 0 THEN TRUE
+		        ELSE is_available
+		    END,
+		    updated_at = NOW()
+		WHERE id = $2 AND stock_quantity IS NOT NULL AND stock_quantity + $1 >= 0
+		RETURNING stock_quantity;`
+	err := tx.QueryRow(updateQuery, change, menuItemID).Scan(&quantityAfter)
+	if err == sql.ErrNoRows {
+		var tracked bool
+		if errTracked := tx.QueryRow("SELECT stock_quantity IS NOT NULL FROM public.menu_items WHERE id = $1", menuItemID).Scan(&tracked); errTracked != nil {
+			return nil, fmt.Errorf("erro ao verificar estoque do item %s: %w", menuItemID, errTracked)
+		}
+		if !tracked {
+			return nil, nil
+		}
+		return nil, errInsufficientStock
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao atualizar estoque do item %s: %w", menuItemID, err)
+	}
+
+	movement := StockMovement{
+		MenuItemID:     menuItemID,
+		Type:           movementType,
+		QuantityChange: change,
+		QuantityAfter:  quantityAfter,
+		OrderID:        orderID,
+		Note:           note,
+		CreatedBy:      createdBy,
+	}
+	insertQuery := `
+		INSERT INTO public.stock_movements (menu_item_id, type, quantity_change, quantity_after, order_id, note, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at;`
+	err = tx.QueryRow(insertQuery, menuItemID, movementType, change, quantityAfter, orderID, note, createdBy).
+		Scan(&movement.ID, &movement.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao registrar movimentação de estoque do item %s: %w", menuItemID, err)
+	}
+	return &movement, nil
+}
+
+// restoreOrderStock devolve ao estoque os itens de um pedido cancelado
+func restoreOrderStock(tx *sql.Tx, orderID string, canceledBy *string) error {
+	rows, err := tx.Query("SELECT menu_item_id, quantity FROM public.order_items WHERE order_id = $1 ORDER BY menu_item_id", orderID)
+	if err != nil {
+		return fmt.Errorf("erro ao buscar itens do pedido %s para devolver ao estoque: %w", orderID, err)
+	}
+	type orderItemQuantity struct {
+		menuItemID string
+		quantity   int
+	}
+	var items []orderItemQuantity
+	for rows.Next() {
+		var item orderItemQuantity
+		if err := rows.Scan(&item.menuItemID, &item.quantity); err != nil {
+			rows.Close()
+			return fmt.Errorf("erro ao scanear item do pedido %s: %w", orderID, err)
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("erro após iterar itens do pedido %s: %w", orderID, err)
+	}
+
+	for _, item := range items {
+		if _, err := applyStockMovement(tx, item.menuItemID, item.quantity, StockMovementCancel, &orderID, nil, canceledBy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleCreateStockMovement trata POST /menu-items/{id}/stock: reposição, perda ou contagem registradas pela equipe
+func handleCreateStockMovement(w http.ResponseWriter, r *http.Request, appDB *sql.DB, menuItemID string) {
+	requestingUserID := r.Context().Value(userContextKey).(string)
+
+	var payload CreateStockMovementPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	movementType := strings.ToUpper(strings.TrimSpace(payload.Type))
+	switch movementType {
+	case StockMovementRestock, StockMovementWaste:
+		if payload.Quantity <= 0 {
+			http.Error(w, "Para RESTOCK e WASTE, quantity deve ser maior que zero.", http.StatusBadRequest)
+			return
+		}
+	case StockMovementCount:
+		if payload.Quantity < 0 {
+			http.Error(w, "Para COUNT, quantity não pode ser negativa.", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Tipo de movimentação inválido. Use RESTOCK, WASTE ou COUNT.", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := appDB.Begin()
+	if err != nil {
+		log.Printf("Erro ao iniciar transação de estoque do item %s: %v", menuItemID, err)
+		http.Error(w, "Erro ao registrar movimentação de estoque.", http.StatusInternalServerError)
+		return
+	}
+	defer rollbackTx(tx)
+
+	var currentStock sql.NullInt64
+	err = tx.QueryRow("SELECT stock_quantity FROM public.menu_items WHERE id = $1 FOR UPDATE", menuItemID).Scan(&currentStock)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Item do cardápio não encontrado.", http.StatusNotFound)
+		} else {
+			log.Printf("Erro ao buscar estoque do item %s: %v", menuItemID, err)
+			http.Error(w, "Erro ao registrar movimentação de estoque.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var change int
+	switch movementType {
+	case StockMovementRestock:
+		change = payload.Quantity
+	case StockMovementWaste:
+		change = -payload.Quantity
+	case StockMovementCount:
+		// A contagem também ativa o controle de estoque para itens que ainda não o tinham
+		if !currentStock.Valid {
+			if _, err := tx.Exec("UPDATE public.menu_items SET stock_quantity = 0 WHERE id = $1", menuItemID); err != nil {
+				log.Printf("Erro ao ativar controle de estoque do item %s: %v", menuItemID, err)
+				http.Error(w, "Erro ao registrar movimentação de estoque.", http.StatusInternalServerError)
+				return
+			}
+			currentStock = sql.NullInt64{Int64: 0, Valid: true}
+		}
+		change = payload.Quantity - int(currentStock.Int64)
+	}
+	if !currentStock.Valid {
+		http.Error(w, "Item sem controle de estoque. Registre uma contagem (COUNT) primeiro.", http.StatusConflict)
+		return
+	}
+
+	movement, err := applyStockMovement(tx, menuItemID, change, movementType, nil, payload.Note, &requestingUserID)
+	if err != nil {
+		if errors.Is(err, errInsufficientStock) {
+			http.Error(w, fmt.Sprintf("Estoque insuficiente: há apenas %d unidade(s).", currentStock.Int64), http.StatusConflict)
+			return
+		}
+		log.Printf("Erro: %v", err)
+		http.Error(w, "Erro ao registrar movimentação de estoque.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := commitTx(tx); err != nil {
+		log.Printf("Erro ao confirmar movimentação de estoque do item %s: %v", menuItemID, err)
+		http.Error(w, "Erro ao registrar movimentação de estoque.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Usuário %s registrou %s de %d no item %s (estoque agora %d).", requestingUserID, movementType, change, menuItemID, movement.QuantityAfter)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(movement)
+}
+
+// handleGetStockMovements trata GET /menu-items/{id}/stock: histórico de movimentações do item
+func handleGetStockMovements(w http.ResponseWriter, r *http.Request, appDB *sql.DB, menuItemID string) {
+	limit, offset, err := parseLimitOffset(r, 50, 200)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := appDB.Query(`
+		SELECT id, menu_item_id, type, quantity_change, quantity_after, order_id, note, created_by, created_at
+		FROM public.stock_movements
+		WHERE menu_item_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3;`, menuItemID, limit, offset)
+	if err != nil {
+		log.Printf("Erro ao buscar movimentações do item %s: %v", menuItemID, err)
+		http.Error(w, "Erro ao buscar movimentações de estoque.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	movements := []StockMovement{}
+	for rows.Next() {
+		var movement StockMovement
+		var orderID, note, createdBy sql.NullString
+		if err := rows.Scan(&movement.ID, &movement.MenuItemID, &movement.Type, &movement.QuantityChange, &movement.QuantityAfter,
+			&orderID, &note, &createdBy, &movement.CreatedAt); err != nil {
+			log.Printf("Erro ao scanear movimentação do item %s: %v", menuItemID, err)
+			http.Error(w, "Erro ao buscar movimentações de estoque.", http.StatusInternalServerError)
+			return
+		}
+		if orderID.Valid {
+			movement.OrderID = &orderID.String
+		}
+		if note.Valid {
+			movement.Note = &note.String
+		}
+		if createdBy.Valid {
+			movement.CreatedBy = &createdBy.String
+		}
+		movements = append(movements, movement)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Erro após iterar movimentações do item %s: %v", menuItemID, err)
+		http.Error(w, "Erro ao buscar movimentações de estoque.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(movements)
+}
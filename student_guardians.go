@@ -0,0 +1,233 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// GuardianRole é o papel de um usuário em relação a um aluno (tabela student_guardians)
+type GuardianRole string
+
+const (
+	GuardianPrimary   GuardianRole = "primary"   // Responsável principal (= students.parent_user_id)
+	GuardianSecondary GuardianRole = "secondary" // Pede, paga e atualiza restrições alimentares
+	GuardianViewer    GuardianRole = "viewer"    // Apenas consulta
+)
+
+// Papéis que podem pedir (e pagar) pelo aluno
+var guardianOrderingRoles = []GuardianRole{GuardianPrimary, GuardianSecondary}
+
+// StudentGuardian é um responsável de um aluno, como retornado por GET /me/students/{id}/guardians
+type StudentGuardian struct {
+	UserID    string       `json:"user_id"`
+	Email     *string      `json:"email,omitempty"`
+	Role      GuardianRole `json:"role"`
+	InvitedBy *string      `json:"invited_by,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// InviteGuardianPayload é o corpo de POST /me/students/{id}/guardians: o usuário (já cadastrado) com este email
+// passa a ser responsável pelo aluno
+type InviteGuardianPayload struct {
+	Email string       `json:"email"`
+	Role  GuardianRole `json:"role"` // secondary (padrão) ou viewer
+}
+
+// fetchGuardianRole retorna o papel do usuário em relação ao aluno, ou "" se ele não for responsável
+// (ou se o aluno estiver arquivado)
+func fetchGuardianRole(q dbQueryer, studentID, userID string) (GuardianRole, error) {
+	var role GuardianRole
+	err := q.QueryRow(`
+		SELECT g.role FROM public.student_guardians g
+		JOIN public.students s ON s.id = g.student_id
+		WHERE g.student_id = $1 AND g.user_id = $2 AND s.archived_at IS NULL;`, studentID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("erro ao buscar papel do usuário %s no aluno %s: %w", userID, studentID, err)
+	}
+	return role, nil
+}
+
+// ensureStudentGuardian responde 404/403/500 e retorna false se o usuário logado não for responsável pelo aluno
+// com um dos papéis em 'allowed'
+func ensureStudentGuardian(w http.ResponseWriter, appDB *sql.DB, studentID, userID string, allowed ...GuardianRole) (GuardianRole, bool) {
+	role, err := fetchGuardianRole(appDB, studentID, userID)
+	if err != nil {
+		log.Printf("Erro: %v", err)
+		http.Error(w, "Erro ao validar aluno.", http.StatusInternalServerError)
+		return "", false
+	}
+	if role == "" {
+		http.Error(w, "Aluno não encontrado ou não pertence a este responsável.", http.StatusNotFound)
+		return "", false
+	}
+	for _, allowedRole := range allowed {
+		if role == allowedRole {
+			return role, true
+		}
+	}
+	http.Error(w, fmt.Sprintf("Responsável com papel '%s' não pode realizar esta operação.", role), http.StatusForbidden)
+	return "", false
+}
+
+// setPrimaryGuardian torna 'userID' o responsável principal do aluno, removendo o principal anterior.
+// Deve rodar na mesma transação que grava students.parent_user_id.
+func setPrimaryGuardian(q dbQueryer, studentID, userID string) error {
+	if _, err := q.Exec("DELETE FROM public.student_guardians WHERE student_id = $1 AND role = 'primary' AND user_id <> $2", studentID, userID); err != nil {
+		return fmt.Errorf("erro ao remover responsável principal anterior do aluno %s: %w", studentID, err)
+	}
+	_, err := q.Exec(`
+		INSERT INTO public.student_guardians (student_id, user_id, role) VALUES ($1, $2, 'primary')
+		ON CONFLICT (student_id, user_id) DO UPDATE SET role = 'primary';`, studentID, userID)
+	if err != nil {
+		return fmt.Errorf("erro ao definir responsável principal do aluno %s: %w", studentID, err)
+	}
+	return nil
+}
+
+// handleGetStudentGuardians trata GET /me/students/{id}/guardians (qualquer responsável do aluno pode ver)
+func handleGetStudentGuardians(w http.ResponseWriter, r *http.Request, appDB *sql.DB, studentID string) {
+	userID := r.Context().Value(userContextKey).(string)
+	if _, ok := ensureStudentGuardian(w, appDB, studentID, userID, GuardianPrimary, GuardianSecondary, GuardianViewer); !ok {
+		return
+	}
+
+	rows, err := appDB.Query(`
+		SELECT g.user_id, u.email, g.role, g.invited_by, g.created_at
+		FROM public.student_guardians g
+		LEFT JOIN public.users u ON u.id = g.user_id
+		WHERE g.student_id = $1
+		ORDER BY CASE g.role WHEN 'primary' THEN 0 WHEN 'secondary' THEN 1 ELSE 2 END, g.created_at;`, studentID)
+	if err != nil {
+		log.Printf("Erro ao buscar responsáveis do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao buscar responsáveis do aluno.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	guardians := []StudentGuardian{}
+	for rows.Next() {
+		var guardian StudentGuardian
+		var email, invitedBy sql.NullString
+		if err := rows.Scan(&guardian.UserID, &email, &guardian.Role, &invitedBy, &guardian.CreatedAt); err != nil {
+			log.Printf("Erro ao scanear responsável do aluno %s: %v", studentID, err)
+			http.Error(w, "Erro ao buscar responsáveis do aluno.", http.StatusInternalServerError)
+			return
+		}
+		if email.Valid {
+			guardian.Email = &email.String
+		}
+		if invitedBy.Valid {
+			guardian.InvitedBy = &invitedBy.String
+		}
+		guardians = append(guardians, guardian)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Erro após iterar responsáveis do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao buscar responsáveis do aluno.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(guardians)
+}
+
+// handleInviteGuardian trata POST /me/students/{id}/guardians: o responsável principal adiciona outro responsável
+func handleInviteGuardian(w http.ResponseWriter, r *http.Request, appDB *sql.DB, studentID string) {
+	userID := r.Context().Value(userContextKey).(string)
+	if _, ok := ensureStudentGuardian(w, appDB, studentID, userID, GuardianPrimary); !ok {
+		return
+	}
+
+	var payload InviteGuardianPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	payload.Email = strings.TrimSpace(payload.Email)
+	if payload.Email == "" {
+		http.Error(w, "Email do responsável convidado é obrigatório.", http.StatusBadRequest)
+		return
+	}
+	if payload.Role == "" {
+		payload.Role = GuardianSecondary
+	}
+	if payload.Role != GuardianSecondary && payload.Role != GuardianViewer {
+		http.Error(w, "Papel inválido (use secondary ou viewer). O responsável principal é definido pela secretaria.", http.StatusBadRequest)
+		return
+	}
+
+	var invitedUserID string
+	err := appDB.QueryRow("SELECT id FROM public.users WHERE LOWER(email) = LOWER($1)", payload.Email).Scan(&invitedUserID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Nenhum usuário cadastrado com este email. Peça para a pessoa criar uma conta antes.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Erro ao buscar usuário pelo email: %v", err)
+		http.Error(w, "Erro ao convidar responsável.", http.StatusInternalServerError)
+		return
+	}
+
+	guardian := StudentGuardian{UserID: invitedUserID, Email: &payload.Email, Role: payload.Role, InvitedBy: &userID}
+	err = appDB.QueryRow(`
+		INSERT INTO public.student_guardians (student_id, user_id, role, invited_by) VALUES ($1, $2, $3, $4)
+		RETURNING created_at;`, studentID, invitedUserID, payload.Role, userID).Scan(&guardian.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+			http.Error(w, "Este usuário já é responsável pelo aluno.", http.StatusConflict)
+			return
+		}
+		log.Printf("Erro ao adicionar responsável %s ao aluno %s: %v", invitedUserID, studentID, err)
+		http.Error(w, "Erro ao convidar responsável.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Responsável %s adicionou %s (%s) ao aluno %s.", userID, invitedUserID, payload.Role, studentID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(guardian)
+}
+
+// handleRevokeGuardian trata DELETE /me/students/{id}/guardians/{userID}: o responsável principal revoga
+// outro responsável, ou um responsável não principal deixa de acompanhar o aluno
+func handleRevokeGuardian(w http.ResponseWriter, r *http.Request, appDB *sql.DB, studentID, guardianUserID string) {
+	userID := r.Context().Value(userContextKey).(string)
+	role, ok := ensureStudentGuardian(w, appDB, studentID, userID, GuardianPrimary, GuardianSecondary, GuardianViewer)
+	if !ok {
+		return
+	}
+	if role != GuardianPrimary && guardianUserID != userID {
+		http.Error(w, "Apenas o responsável principal pode revogar outros responsáveis.", http.StatusForbidden)
+		return
+	}
+
+	result, err := appDB.Exec("DELETE FROM public.student_guardians WHERE student_id = $1 AND user_id = $2 AND role <> 'primary'", studentID, guardianUserID)
+	if err != nil {
+		log.Printf("Erro ao revogar responsável %s do aluno %s: %v", guardianUserID, studentID, err)
+		http.Error(w, "Erro ao revogar responsável.", http.StatusInternalServerError)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		if guardianUserID == userID && role == GuardianPrimary {
+			http.Error(w, "O responsável principal não pode ser revogado; peça à secretaria para trocá-lo.", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Responsável não encontrado para este aluno (ou é o responsável principal).", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Usuário %s revogou o responsável %s do aluno %s.", userID, guardianUserID, studentID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// dbQueryer é satisfeito tanto por *sql.DB quanto por *sql.Tx, para funções usadas dentro e fora de transações
+type dbQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// StudentLimits é a resposta de GET /me/students/{id}/limits
+type StudentLimits struct {
+	StudentID          string     `json:"student_id"`
+	DailyLimit         *Money     `json:"daily_limit"`  // nil = sem limite
+	WeeklyLimit        *Money     `json:"weekly_limit"` // nil = sem limite
+	BlockedMenuItemIDs []string   `json:"blocked_menu_item_ids"`
+	BlockedCategories  []string   `json:"blocked_categories"`
+	SpentToday         Money      `json:"spent_today"`
+	SpentThisWeek      Money      `json:"spent_this_week"`
+	UpdatedAt          *time.Time `json:"updated_at,omitempty"`
+}
+
+// UpdateStudentLimitsPayload é o corpo de PUT /me/students/{id}/limits.
+// Substitui a configuração inteira: campos ausentes/nulos removem o limite ou o bloqueio.
+type UpdateStudentLimitsPayload struct {
+	DailyLimit         *Money   `json:"daily_limit"`
+	WeeklyLimit        *Money   `json:"weekly_limit"`
+	BlockedMenuItemIDs []string `json:"blocked_menu_item_ids"`
+	BlockedCategories  []string `json:"blocked_categories"`
+}
+
+// orderLineCheck é o mínimo de cada item do pedido necessário para checar bloqueios e restrições
+type orderLineCheck struct {
+	MenuItemID   string
+	MenuItemName string
+	Category     *string
+	Allergens    []string
+	DietaryTags  []string
+}
+
+// fetchStudentSpending soma os pedidos não cancelados do aluno servidos no dia e na semana (seg-dom) de serviceDate.
+// Encomendas contam no dia em que serão servidas, não no dia em que foram feitas.
+func fetchStudentSpending(q dbQueryer, studentID string, serviceDate time.Time) (Money, Money, error) {
+	var spentToday, spentThisWeek Money
+	spendingQuery := `
+		SELECT
+			COALESCE(SUM(total_amount) FILTER (WHERE scheduled_for = $2::date), 0),
+			COALESCE(SUM(total_amount) FILTER (WHERE scheduled_for >= date_trunc('week', $2::date)::date
+			                                     AND scheduled_for < date_trunc('week', $2::date)::date + 7), 0)
+		FROM public.orders
+		WHERE student_id = $1 AND status <> 'CANCELED';`
+	if err := q.QueryRow(spendingQuery, studentID, serviceDate.Format(dateLayout)).Scan(&spentToday, &spentThisWeek); err != nil {
+		return 0, 0, fmt.Errorf("erro ao calcular gastos do aluno %s: %w", studentID, err)
+	}
+	return spentToday, spentThisWeek, nil
+}
+
+// fetchStudentLimits carrega limites, bloqueios e os gastos do aluno no dia/semana de serviceDate
+func fetchStudentLimits(q dbQueryer, studentID string, serviceDate time.Time) (*StudentLimits, error) {
+	limits := StudentLimits{StudentID: studentID, BlockedMenuItemIDs: []string{}, BlockedCategories: []string{}}
+
+	var updatedAt sql.NullTime
+	err := q.QueryRow("SELECT daily_limit, weekly_limit, updated_at FROM public.student_spending_limits WHERE student_id = $1", studentID).
+		Scan(&limits.DailyLimit, &limits.WeeklyLimit, &updatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("erro ao buscar limites do aluno %s: %w", studentID, err)
+	}
+	if updatedAt.Valid {
+		limits.UpdatedAt = &updatedAt.Time
+	}
+
+	rows, err := q.Query("SELECT menu_item_id, category FROM public.student_blocked_items WHERE student_id = $1 ORDER BY created_at", studentID)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar bloqueios do aluno %s: %w", studentID, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var menuItemID, category sql.NullString
+		if err := rows.Scan(&menuItemID, &category); err != nil {
+			return nil, fmt.Errorf("erro ao scanear bloqueio do aluno %s: %w", studentID, err)
+		}
+		if menuItemID.Valid {
+			limits.BlockedMenuItemIDs = append(limits.BlockedMenuItemIDs, menuItemID.String)
+		}
+		if category.Valid {
+			limits.BlockedCategories = append(limits.BlockedCategories, category.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro após iterar bloqueios do aluno %s: %w", studentID, err)
+	}
+
+	limits.SpentToday, limits.SpentThisWeek, err = fetchStudentSpending(q, studentID, serviceDate)
+	if err != nil {
+		return nil, err
+	}
+	return &limits, nil
+}
+
+// checkStudentOrderRules valida o pedido contra os itens bloqueados e os limites de gasto do aluno.
+// Retorna um motivo legível ("" se o pedido é permitido). Deve rodar dentro da transação do pedido.
+func checkStudentOrderRules(tx *sql.Tx, studentID string, serviceDate time.Time, lines []orderLineCheck, orderTotal Money) (string, error) {
+	limits, err := fetchStudentLimits(tx, studentID, serviceDate)
+	if err != nil {
+		return "", err
+	}
+
+	blockedItems := map[string]bool{}
+	for _, id := range limits.BlockedMenuItemIDs {
+		blockedItems[id] = true
+	}
+	blockedCategories := map[string]bool{}
+	for _, category := range limits.BlockedCategories {
+		blockedCategories[strings.ToLower(strings.TrimSpace(category))] = true
+	}
+
+	for _, line := range lines {
+		if blockedItems[line.MenuItemID] {
+			return fmt.Sprintf("O item '%s' foi bloqueado pelo responsável para este aluno.", line.MenuItemName), nil
+		}
+		if line.Category != nil && blockedCategories[strings.ToLower(strings.TrimSpace(*line.Category))] {
+			return fmt.Sprintf("A categoria '%s' (item '%s') foi bloqueada pelo responsável para este aluno.", *line.Category, line.MenuItemName), nil
+		}
+	}
+
+	if limits.DailyLimit != nil && limits.SpentToday+orderTotal > *limits.DailyLimit {
+		return fmt.Sprintf("Limite diário do aluno excedido: gasto no dia %s + pedido %s > limite %s.",
+			limits.SpentToday, orderTotal, *limits.DailyLimit), nil
+	}
+	if limits.WeeklyLimit != nil && limits.SpentThisWeek+orderTotal > *limits.WeeklyLimit {
+		return fmt.Sprintf("Limite semanal do aluno excedido: gasto na semana %s + pedido %s > limite %s.",
+			limits.SpentThisWeek, orderTotal, *limits.WeeklyLimit), nil
+	}
+	return "", nil
+}
+
+// handleGetStudentLimits trata GET /me/students/{id}/limits
+func handleGetStudentLimits(w http.ResponseWriter, r *http.Request, appDB *sql.DB, studentID string) {
+	userID := r.Context().Value(userContextKey).(string)
+	if _, ok := ensureStudentGuardian(w, appDB, studentID, userID, GuardianPrimary, GuardianSecondary, GuardianViewer); !ok {
+		return
+	}
+
+	limits, err := fetchStudentLimits(appDB, studentID, schoolToday())
+	if err != nil {
+		log.Printf("Erro: %v", err)
+		http.Error(w, "Erro ao buscar limites do aluno.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(limits)
+}
+
+// handleUpdateStudentLimits trata PUT /me/students/{id}/limits, substituindo limites e bloqueios do aluno
+func handleUpdateStudentLimits(w http.ResponseWriter, r *http.Request, appDB *sql.DB, studentID string) {
+	userID := r.Context().Value(userContextKey).(string)
+	// Limites e bloqueios são decisão do responsável principal
+	if _, ok := ensureStudentGuardian(w, appDB, studentID, userID, GuardianPrimary); !ok {
+		return
+	}
+
+	var payload UpdateStudentLimitsPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if (payload.DailyLimit != nil && *payload.DailyLimit < 0) || (payload.WeeklyLimit != nil && *payload.WeeklyLimit < 0) {
+		http.Error(w, "Limites de gasto não podem ser negativos.", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := appDB.Begin()
+	if err != nil {
+		log.Printf("Erro ao iniciar transação de limites do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao salvar limites do aluno.", http.StatusInternalServerError)
+		return
+	}
+	defer rollbackTx(tx)
+
+	upsertQuery := `
+		INSERT INTO public.student_spending_limits (student_id, daily_limit, weekly_limit, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (student_id) DO UPDATE
+		SET daily_limit = EXCLUDED.daily_limit, weekly_limit = EXCLUDED.weekly_limit,
+		    updated_by = EXCLUDED.updated_by, updated_at = NOW();`
+	if _, err := tx.Exec(upsertQuery, studentID, payload.DailyLimit, payload.WeeklyLimit, userID); err != nil {
+		log.Printf("Erro ao salvar limites do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao salvar limites do aluno.", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM public.student_blocked_items WHERE student_id = $1", studentID); err != nil {
+		log.Printf("Erro ao limpar bloqueios do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao salvar limites do aluno.", http.StatusInternalServerError)
+		return
+	}
+
+	seenItems := map[string]bool{}
+	for _, menuItemID := range payload.BlockedMenuItemIDs {
+		menuItemID = strings.TrimSpace(menuItemID)
+		if menuItemID == "" || seenItems[menuItemID] {
+			continue
+		}
+		seenItems[menuItemID] = true
+
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM public.menu_items WHERE id = $1)", menuItemID).Scan(&exists); err != nil {
+			log.Printf("Erro ao validar item %s para bloqueio: %v", menuItemID, err)
+			http.Error(w, "Erro ao salvar limites do aluno.", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, fmt.Sprintf("Item do cardápio '%s' não encontrado.", menuItemID), http.StatusBadRequest)
+			return
+		}
+		if _, err := tx.Exec("INSERT INTO public.student_blocked_items (student_id, menu_item_id, created_by) VALUES ($1, $2, $3)",
+			studentID, menuItemID, userID); err != nil {
+			log.Printf("Erro ao bloquear item %s para aluno %s: %v", menuItemID, studentID, err)
+			http.Error(w, "Erro ao salvar limites do aluno.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	seenCategories := map[string]bool{}
+	for _, category := range payload.BlockedCategories {
+		category = strings.TrimSpace(category)
+		if category == "" || seenCategories[strings.ToLower(category)] {
+			continue
+		}
+		seenCategories[strings.ToLower(category)] = true
+		if _, err := tx.Exec("INSERT INTO public.student_blocked_items (student_id, category, created_by) VALUES ($1, $2, $3)",
+			studentID, category, userID); err != nil {
+			log.Printf("Erro ao bloquear categoria %s para aluno %s: %v", category, studentID, err)
+			http.Error(w, "Erro ao salvar limites do aluno.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	limits, err := fetchStudentLimits(tx, studentID, schoolToday())
+	if err != nil {
+		log.Printf("Erro: %v", err)
+		http.Error(w, "Erro ao salvar limites do aluno.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := commitTx(tx); err != nil {
+		log.Printf("Erro ao confirmar limites do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao salvar limites do aluno.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Responsável %s atualizou limites do aluno %s.", userID, studentID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(limits)
+}
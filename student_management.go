@@ -0,0 +1,247 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	errStudentArchived   = errors.New("aluno arquivado")
+	errStudentSameClass  = errors.New("aluno já está nesta turma")
+	errStudentOpenOrders = errors.New("aluno tem pedidos em aberto")
+	errStudentHasBalance = errors.New("aluno tem saldo na carteira")
+)
+
+// StudentsPage é a resposta paginada de GET /students
+type StudentsPage struct {
+	Students []Student `json:"students"`
+	Total    int       `json:"total"`
+	Limit    int       `json:"limit"`
+	Offset   int       `json:"offset"`
+}
+
+// UpdateStudentPayload é o corpo de PUT /students/{id}. A turma muda só por POST /students/{id}/transfer
+// (para ficar no histórico); alergias/exigências omitidas ficam como estão.
+type UpdateStudentPayload struct {
+	Name                string    `json:"name"`
+	ParentUserID        string    `json:"parent_user_id"`
+	Allergies           *[]string `json:"allergies,omitempty"`
+	DietaryRequirements *[]string `json:"dietary_requirements,omitempty"`
+}
+
+// TransferStudentPayload é o corpo de POST /students/{id}/transfer
+type TransferStudentPayload struct {
+	ClassID string `json:"class_id"`
+	Reason  string `json:"reason"`
+}
+
+// StudentClassTransfer espelha uma linha de student_class_transfers
+type StudentClassTransfer struct {
+	ID            string    `json:"id"`
+	StudentID     string    `json:"student_id"`
+	FromClassID   *string   `json:"from_class_id"`
+	FromClassName *string   `json:"from_class_name,omitempty"`
+	ToClassID     *string   `json:"to_class_id"`
+	ToClassName   *string   `json:"to_class_name,omitempty"`
+	Reason        *string   `json:"reason,omitempty"`
+	TransferredBy *string   `json:"transferred_by,omitempty"`
+	TransferredAt time.Time `json:"transferred_at"`
+}
+
+// handleGetStudents trata GET /students: filtros ?class_id=, ?parent_user_id= (responsável principal),
+// ?guardian_user_id= (qualquer responsável), ?q= (nome) e ?status=active|archived|all (padrão active),
+// com ?limit=&offset=
+func handleGetStudents(w http.ResponseWriter, r *http.Request, students StudentRepository) {
+	limit, offset, err := parseLimitOffset(r, 50, 200)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := StudentFilter{
+		Status:         query.Get("status"),
+		ClassID:        query.Get("class_id"),
+		ParentUserID:   query.Get("parent_user_id"),
+		GuardianUserID: query.Get("guardian_user_id"),
+		Search:         strings.TrimSpace(query.Get("q")),
+		Limit:          limit,
+		Offset:         offset,
+	}
+	switch filter.Status {
+	case "", "active", "archived", "all":
+	default:
+		http.Error(w, "Parâmetro 'status' inválido (use active, archived ou all).", http.StatusBadRequest)
+		return
+	}
+
+	list, total, err := students.List(filter)
+	if err != nil {
+		log.Printf("Erro: %v", err)
+		http.Error(w, "Erro ao buscar alunos.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StudentsPage{Students: list, Total: total, Limit: limit, Offset: offset})
+}
+
+// handleGetStudentByID trata GET /students/{id} (inclusive arquivados)
+func handleGetStudentByID(w http.ResponseWriter, r *http.Request, students StudentRepository, studentID string) {
+	student, err := students.Get(studentID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Aluno não encontrado.", http.StatusNotFound)
+		} else {
+			log.Printf("Erro ao buscar aluno %s: %v", studentID, err)
+			http.Error(w, "Erro ao buscar aluno.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(student)
+}
+
+// handleUpdateStudent trata PUT /students/{id}: nome, responsável e, se enviadas, restrições alimentares
+func handleUpdateStudent(w http.ResponseWriter, r *http.Request, students StudentRepository, studentID string) {
+	var payload UpdateStudentPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	update := StudentUpdate{Name: strings.TrimSpace(payload.Name), ParentUserID: strings.TrimSpace(payload.ParentUserID)}
+	if update.Name == "" || update.ParentUserID == "" {
+		http.Error(w, "Nome do aluno e ID do pai/responsável são obrigatórios.", http.StatusBadRequest)
+		return
+	}
+	if payload.Allergies != nil {
+		allergies, err := normalizeDietaryValues(*payload.Allergies, knownAllergens)
+		if err != nil {
+			http.Error(w, "Alergia inválida: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		update.Allergies = &allergies
+	}
+	if payload.DietaryRequirements != nil {
+		requirements, err := normalizeDietaryValues(*payload.DietaryRequirements, knownDietaryTags)
+		if err != nil {
+			http.Error(w, "Exigência alimentar inválida: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		update.DietaryRequirements = &requirements
+	}
+
+	student, err := students.Update(studentID, update)
+	if err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			http.Error(w, "Aluno não encontrado.", http.StatusNotFound)
+		case errors.Is(err, errStudentArchived):
+			http.Error(w, "Aluno arquivado não pode ser alterado.", http.StatusConflict)
+		case errors.Is(err, errUnknownUser):
+			http.Error(w, "ID do Pai/Responsável fornecido não existe ou não é válido.", http.StatusBadRequest)
+		default:
+			log.Printf("Erro ao atualizar aluno %s: %v", studentID, err)
+			http.Error(w, "Erro ao atualizar aluno.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(student)
+}
+
+// handleTransferStudent trata POST /students/{id}/transfer: muda a turma e grava no histórico
+func handleTransferStudent(w http.ResponseWriter, r *http.Request, students StudentRepository, studentID string) {
+	requestingUserID := r.Context().Value(userContextKey).(string)
+
+	var payload TransferStudentPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	payload.ClassID = strings.TrimSpace(payload.ClassID)
+	if payload.ClassID == "" {
+		http.Error(w, "ID da turma de destino ('class_id') é obrigatório.", http.StatusBadRequest)
+		return
+	}
+	var reason *string
+	if trimmed := strings.TrimSpace(payload.Reason); trimmed != "" {
+		reason = &trimmed
+	}
+
+	transfer, err := students.Transfer(studentID, payload.ClassID, reason, &requestingUserID)
+	if err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			http.Error(w, "Aluno não encontrado.", http.StatusNotFound)
+		case errors.Is(err, errStudentArchived):
+			http.Error(w, "Aluno arquivado não pode ser transferido.", http.StatusConflict)
+		case errors.Is(err, errStudentSameClass):
+			http.Error(w, "O aluno já está nesta turma.", http.StatusConflict)
+		case errors.Is(err, errUnknownClass):
+			http.Error(w, "ID da Turma fornecido não existe.", http.StatusBadRequest)
+		default:
+			log.Printf("Erro ao transferir aluno %s: %v", studentID, err)
+			http.Error(w, "Erro ao transferir aluno.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("Usuário %s transferiu o aluno %s para a turma %s.", requestingUserID, studentID, payload.ClassID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(transfer)
+}
+
+// handleGetStudentTransfers trata GET /students/{id}/transfers: histórico de turmas, do mais antigo ao mais recente
+func handleGetStudentTransfers(w http.ResponseWriter, r *http.Request, students StudentRepository, studentID string) {
+	transfers, err := students.ListTransfers(studentID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Aluno não encontrado.", http.StatusNotFound)
+		} else {
+			log.Printf("Erro: %v", err)
+			http.Error(w, "Erro ao buscar histórico de turmas.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transfers)
+}
+
+// handleArchiveStudent trata DELETE /students/{id}: arquiva o aluno; os pedidos antigos continuam apontando para ele
+func handleArchiveStudent(w http.ResponseWriter, r *http.Request, students StudentRepository, studentID string) {
+	requestingUserID := r.Context().Value(userContextKey).(string)
+
+	if err := students.Archive(studentID, requestingUserID); err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			http.Error(w, "Aluno não encontrado.", http.StatusNotFound)
+		case errors.Is(err, errStudentArchived):
+			http.Error(w, "Aluno já está arquivado.", http.StatusConflict)
+		case errors.Is(err, errStudentHasBalance):
+			http.Error(w, "Aluno não pode ser arquivado: "+err.Error()+". Devolva o saldo ao responsável antes.", http.StatusConflict)
+		case errors.Is(err, errStudentOpenOrders):
+			http.Error(w, "Aluno não pode ser arquivado: "+err.Error()+". Conclua ou cancele os pedidos antes.", http.StatusConflict)
+		default:
+			log.Printf("Erro ao arquivar aluno %s: %v", studentID, err)
+			http.Error(w, "Erro ao arquivar aluno.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("Usuário %s arquivou o aluno %s.", requestingUserID, studentID)
+	w.WriteHeader(http.StatusNoContent)
+}
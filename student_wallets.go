@@ -0,0 +1,781 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Tipos de lançamento do extrato da carteira do aluno (coluna student_wallet_transactions.type)
+const (
+	StudentWalletTxTransferIn  = "TRANSFER_IN"  // Transferência manual do responsável para o aluno
+	StudentWalletTxTransferOut = "TRANSFER_OUT" // Devolução do saldo do aluno para o responsável
+	StudentWalletTxAllowance   = "ALLOWANCE"    // Mesada recorrente
+	StudentWalletTxOrderDebit  = "ORDER_DEBIT"  // Débito por pedido
+	StudentWalletTxRefund      = "REFUND"       // Estorno de pedido cancelado
+)
+
+// FundingPolicy define de onde sai o pagamento dos pedidos do aluno (ver migrations/0016_student_wallets.up.sql)
+type FundingPolicy string
+
+const (
+	FundingStudentThenGuardian FundingPolicy = "student_then_guardian"
+	FundingStudentOnly         FundingPolicy = "student_only"
+	FundingGuardianOnly        FundingPolicy = "guardian_only"
+)
+
+var validFundingPolicies = map[FundingPolicy]bool{
+	FundingStudentThenGuardian: true,
+	FundingStudentOnly:         true,
+	FundingGuardianOnly:        true,
+}
+
+// Direções de POST /me/students/{id}/wallet/transfers
+const (
+	walletTransferToStudent  = "to_student"
+	walletTransferToGuardian = "to_guardian"
+)
+
+// Intervalo entre as verificações de mesadas vencidas
+const allowanceRunEvery = 15 * time.Minute
+
+// errInsufficientStudentBalance indica que o lançamento deixaria a carteira do aluno negativa
+var errInsufficientStudentBalance = errors.New("saldo insuficiente na carteira do aluno")
+
+// StudentWallet é a resposta de GET /me/students/{id}/wallet. Alunos sem carteira têm saldo 0 e a política padrão.
+type StudentWallet struct {
+	StudentID     string        `json:"student_id"`
+	Balance       Money         `json:"balance"`
+	FundingPolicy FundingPolicy `json:"funding_policy"`
+}
+
+// StudentWalletTransaction espelha uma linha de student_wallet_transactions (append-only)
+type StudentWalletTransaction struct {
+	ID             string    `json:"id"`
+	StudentID      string    `json:"student_id"`
+	Type           string    `json:"type"`
+	Amount         Money     `json:"amount"` // Negativo = débito, positivo = crédito
+	BalanceAfter   Money     `json:"balance_after"`
+	GuardianUserID *string   `json:"guardian_user_id,omitempty"`
+	OrderID        *string   `json:"order_id,omitempty"`
+	AllowanceID    *string   `json:"allowance_id,omitempty"`
+	Description    *string   `json:"description,omitempty"`
+	CreatedBy      *string   `json:"created_by,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// StudentWalletTransactionsPage é a resposta paginada de GET /me/students/{id}/wallet/transactions
+type StudentWalletTransactionsPage struct {
+	Balance      Money                      `json:"balance"`
+	Transactions []StudentWalletTransaction `json:"transactions"`
+	Total        int                        `json:"total"`
+	Limit        int                        `json:"limit"`
+	Offset       int                        `json:"offset"`
+}
+
+// StudentAllowance espelha uma linha de student_allowances
+type StudentAllowance struct {
+	ID        string     `json:"id"`
+	StudentID string     `json:"student_id"`
+	FundedBy  string     `json:"funded_by"`
+	Amount    Money      `json:"amount"`
+	Weekday   int        `json:"weekday"` // ISO: 1 = segunda ... 7 = domingo
+	NextRunOn string     `json:"next_run_on"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	LastError *string    `json:"last_error,omitempty"`
+	IsActive  bool       `json:"is_active"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// UpdateFundingPolicyPayload é o corpo de PUT /me/students/{id}/wallet/policy
+type UpdateFundingPolicyPayload struct {
+	FundingPolicy FundingPolicy `json:"funding_policy"`
+}
+
+// StudentWalletTransferPayload é o corpo de POST /me/students/{id}/wallet/transfers
+type StudentWalletTransferPayload struct {
+	Amount      Money  `json:"amount"`
+	Direction   string `json:"direction"` // to_student (padrão) ou to_guardian
+	Description string `json:"description"`
+}
+
+// CreateStudentAllowancePayload é o corpo de POST /me/students/{id}/wallet/allowances
+type CreateStudentAllowancePayload struct {
+	Amount  Money `json:"amount"`
+	Weekday int   `json:"weekday"`
+}
+
+const studentAllowanceColumns = `id, student_id, funded_by, amount, weekday, to_char(next_run_on, 'YYYY-MM-DD'),
+	last_run_at, last_error, is_active, created_at`
+
+func scanStudentAllowance(row interface{ Scan(...interface{}) error }) (*StudentAllowance, error) {
+	var allowance StudentAllowance
+	var lastRunAt sql.NullTime
+	var lastError sql.NullString
+	err := row.Scan(&allowance.ID, &allowance.StudentID, &allowance.FundedBy, &allowance.Amount, &allowance.Weekday,
+		&allowance.NextRunOn, &lastRunAt, &lastError, &allowance.IsActive, &allowance.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastRunAt.Valid {
+		allowance.LastRunAt = &lastRunAt.Time
+	}
+	if lastError.Valid {
+		allowance.LastError = &lastError.String
+	}
+	return &allowance, nil
+}
+
+// isoWeekday converte time.Weekday (domingo = 0) para o padrão ISO (segunda = 1 ... domingo = 7)
+func isoWeekday(date time.Time) int {
+	return (int(date.Weekday())+6)%7 + 1
+}
+
+// nextAllowanceDate retorna a primeira data a partir de 'from' (inclusive) que cai em 'weekday' (ISO)
+func nextAllowanceDate(from time.Time, weekday int) time.Time {
+	return from.AddDate(0, 0, (weekday-isoWeekday(from)+7)%7)
+}
+
+// fetchStudentWallet retorna a carteira do aluno (saldo 0 e política padrão se ainda não existir)
+func fetchStudentWallet(q dbQueryer, studentID string) (*StudentWallet, error) {
+	wallet := StudentWallet{StudentID: studentID}
+	err := q.QueryRow(`
+		SELECT COALESCE(w.balance, 0), COALESCE(w.funding_policy, $2)
+		FROM public.students s
+		LEFT JOIN public.student_wallets w ON w.student_id = s.id
+		WHERE s.id = $1;`, studentID, FundingStudentThenGuardian).Scan(&wallet.Balance, &wallet.FundingPolicy)
+	if err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+// recordStudentWalletTransaction aplica um lançamento ao saldo da carteira do aluno (criando-a se preciso)
+// e grava a linha no extrato, na transação do chamador. Retorna errInsufficientStudentBalance se o saldo
+// ficaria negativo.
+func recordStudentWalletTransaction(tx *sql.Tx, entry StudentWalletTransaction) (*StudentWalletTransaction, error) {
+	if entry.Amount == 0 {
+		return nil, fmt.Errorf("valor do lançamento não pode ser zero")
+	}
+
+	if _, err := tx.Exec("INSERT INTO public.student_wallets (student_id) VALUES ($1) ON CONFLICT (student_id) DO NOTHING", entry.StudentID); err != nil {
+		return nil, fmt.Errorf("erro ao criar carteira do aluno %s: %w", entry.StudentID, err)
+	}
+	// O UPDATE trava a carteira até o fim da transação, serializando lançamentos concorrentes
+	err := tx.QueryRow(`
+		UPDATE public.student_wallets
+		SET balance = balance + $1, updated_at = NOW()
+		WHERE student_id = $2 AND balance + $1 >= 0
+		RETURNING balance;`, entry.Amount, entry.StudentID).Scan(&entry.BalanceAfter)
+	if err == sql.ErrNoRows {
+		return nil, errInsufficientStudentBalance
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao atualizar carteira do aluno %s: %w", entry.StudentID, err)
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO public.student_wallet_transactions
+		    (student_id, type, amount, balance_after, guardian_user_id, order_id, allowance_id, description, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at;`,
+		entry.StudentID, entry.Type, entry.Amount, entry.BalanceAfter, entry.GuardianUserID,
+		entry.OrderID, entry.AllowanceID, entry.Description, entry.CreatedBy).Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao gravar lançamento na carteira do aluno %s: %w", entry.StudentID, err)
+	}
+	return &entry, nil
+}
+
+// moveStudentWalletFunds transfere 'amount' entre a carteira do responsável e a do aluno: positivo abastece o
+// aluno (TRANSFER_IN/ALLOWANCE), negativo devolve ao responsável (TRANSFER_OUT). Os dois lados vão para os
+// respectivos extratos na mesma transação. A carteira do aluno é travada antes da do responsável, na mesma
+// ordem usada pelo pagamento de pedidos.
+func moveStudentWalletFunds(tx *sql.Tx, studentID, guardianUserID string, amount Money, studentTxType string,
+	allowanceID, description, createdBy *string) (*StudentWalletTransaction, error) {
+	studentEntry, err := recordStudentWalletTransaction(tx, StudentWalletTransaction{
+		StudentID:      studentID,
+		Type:           studentTxType,
+		Amount:         amount,
+		GuardianUserID: &guardianUserID,
+		AllowanceID:    allowanceID,
+		Description:    description,
+		CreatedBy:      createdBy,
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = recordCreditTransaction(tx, CreditTransaction{
+		UserID:      guardianUserID,
+		Type:        CreditTxStudentTransfer,
+		Amount:      -amount,
+		StudentID:   &studentID,
+		Description: description,
+		CreatedBy:   createdBy,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return studentEntry, nil
+}
+
+// splitOrderPayment divide o total do pedido entre a carteira do aluno (saldo 'balance') e o responsável,
+// conforme a política da carteira. Erro: errInsufficientStudentBalance (política student_only).
+func splitOrderPayment(policy FundingPolicy, balance, total Money) (fromStudent, fromGuardian Money, err error) {
+	switch policy {
+	case FundingGuardianOnly:
+		fromStudent = 0
+	case FundingStudentOnly:
+		if balance < total {
+			return 0, 0, errInsufficientStudentBalance
+		}
+		fromStudent = total
+	default:
+		fromStudent = min(balance, total)
+	}
+	return fromStudent, total - fromStudent, nil
+}
+
+// chargeOrderPayment debita o pedido conforme a política da carteira do aluno: a parte da carteira do aluno
+// e o restante do responsável que fez o pedido. Retorna quanto saiu da carteira do aluno.
+// Erros: errInsufficientStudentBalance (política student_only) ou errInsufficientCredits (responsável).
+func chargeOrderPayment(tx *sql.Tx, studentID, guardianUserID, orderID string, total Money) (Money, error) {
+	balance, policy := Money(0), FundingStudentThenGuardian
+	err := tx.QueryRow("SELECT balance, funding_policy FROM public.student_wallets WHERE student_id = $1 FOR UPDATE", studentID).
+		Scan(&balance, &policy)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("erro ao buscar carteira do aluno %s: %w", studentID, err)
+	}
+
+	fromStudent, fromGuardian, err := splitOrderPayment(policy, balance, total)
+	if err != nil {
+		return 0, err
+	}
+
+	if fromStudent > 0 {
+		_, err := recordStudentWalletTransaction(tx, StudentWalletTransaction{
+			StudentID:      studentID,
+			Type:           StudentWalletTxOrderDebit,
+			Amount:         -fromStudent,
+			GuardianUserID: &guardianUserID,
+			OrderID:        &orderID,
+			CreatedBy:      &guardianUserID,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if fromGuardian > 0 {
+		_, err := recordCreditTransaction(tx, CreditTransaction{
+			UserID:    guardianUserID,
+			Type:      CreditTxOrderDebit,
+			Amount:    -fromGuardian,
+			OrderID:   &orderID,
+			CreatedBy: &guardianUserID,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return fromStudent, nil
+}
+
+// refundOrderPayment estorna um pedido para onde o pagamento saiu: a parte paga pela carteira do aluno
+// volta para ela e o restante para o responsável que fez o pedido
+func refundOrderPayment(tx *sql.Tx, order *Order, actorID string) error {
+	description := "Estorno de pedido cancelado"
+	var fromStudent Money
+	if order.StudentID != nil {
+		err := tx.QueryRow(`
+			SELECT COALESCE(SUM(-amount), 0) FROM public.student_wallet_transactions
+			WHERE order_id = $1 AND type = $2;`, order.ID, StudentWalletTxOrderDebit).Scan(&fromStudent)
+		if err != nil {
+			return fmt.Errorf("erro ao buscar pagamento do pedido %s pela carteira do aluno: %w", order.ID, err)
+		}
+	}
+	fromGuardian := order.TotalAmount - fromStudent
+
+	if fromStudent > 0 {
+		_, err := recordStudentWalletTransaction(tx, StudentWalletTransaction{
+			StudentID:      *order.StudentID,
+			Type:           StudentWalletTxRefund,
+			Amount:         fromStudent,
+			GuardianUserID: &order.UserID,
+			OrderID:        &order.ID,
+			Description:    &description,
+			CreatedBy:      &actorID,
+		})
+		if err != nil {
+			return fmt.Errorf("erro ao estornar carteira do aluno no pedido %s: %w", order.ID, err)
+		}
+	}
+	if fromGuardian > 0 {
+		_, err := recordCreditTransaction(tx, CreditTransaction{
+			UserID:      order.UserID,
+			Type:        CreditTxRefund,
+			Amount:      fromGuardian,
+			OrderID:     &order.ID,
+			Description: &description,
+			CreatedBy:   &actorID,
+		})
+		if err != nil {
+			return fmt.Errorf("erro ao estornar créditos do pedido %s: %w", order.ID, err)
+		}
+	}
+	return nil
+}
+
+// runAllowance executa uma mesada vencida. Retorna false se ela não estava mais vencida (outra instância já
+// a executou). Falta de saldo do responsável não é erro: fica em last_error e a mesada passa para a próxima semana.
+func runAllowance(appDB *sql.DB, allowanceID string, today time.Time) (bool, error) {
+	tx, err := appDB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("erro ao iniciar transação da mesada %s: %w", allowanceID, err)
+	}
+	defer rollbackTx(tx)
+
+	allowance, err := scanStudentAllowance(tx.QueryRow(`SELECT `+studentAllowanceColumns+`
+		FROM public.student_allowances
+		WHERE id = $1 AND is_active AND next_run_on <= $2
+		FOR UPDATE SKIP LOCKED;`, allowanceID, today))
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("erro ao travar mesada %s: %w", allowanceID, err)
+	}
+	nextRun := nextAllowanceDate(today.AddDate(0, 0, 1), allowance.Weekday)
+
+	// Quem paga precisa continuar sendo responsável (que pode pagar) por um aluno ativo
+	role, err := fetchGuardianRole(tx, allowance.StudentID, allowance.FundedBy)
+	if err != nil {
+		return false, err
+	}
+	if role != GuardianPrimary && role != GuardianSecondary {
+		_, err := tx.Exec(`
+			UPDATE public.student_allowances
+			SET is_active = FALSE, last_error = 'Responsável não pode mais abastecer este aluno', updated_at = NOW()
+			WHERE id = $1;`, allowance.ID)
+		if err != nil {
+			return false, fmt.Errorf("erro ao desativar mesada %s: %w", allowance.ID, err)
+		}
+		return true, commitTx(tx)
+	}
+
+	description := "Mesada semanal"
+	_, err = moveStudentWalletFunds(tx, allowance.StudentID, allowance.FundedBy, allowance.Amount, StudentWalletTxAllowance,
+		&allowance.ID, &description, &allowance.FundedBy)
+	if errors.Is(err, errInsufficientCredits) {
+		rollbackTx(tx)
+		_, err := appDB.Exec(`
+			UPDATE public.student_allowances
+			SET next_run_on = $2, last_error = 'Créditos insuficientes do responsável', updated_at = NOW()
+			WHERE id = $1;`, allowance.ID, nextRun)
+		if err != nil {
+			return false, fmt.Errorf("erro ao adiar mesada %s: %w", allowance.ID, err)
+		}
+		log.Printf("Mesada %s do aluno %s não paga: créditos insuficientes do responsável %s.", allowance.ID, allowance.StudentID, allowance.FundedBy)
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("erro ao pagar mesada %s: %w", allowance.ID, err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE public.student_allowances
+		SET next_run_on = $2, last_run_at = NOW(), last_error = NULL, updated_at = NOW()
+		WHERE id = $1;`, allowance.ID, nextRun)
+	if err != nil {
+		return false, fmt.Errorf("erro ao atualizar mesada %s: %w", allowance.ID, err)
+	}
+	if err := commitTx(tx); err != nil {
+		return false, fmt.Errorf("erro ao confirmar mesada %s: %w", allowance.ID, err)
+	}
+	return true, nil
+}
+
+// runDueAllowances executa as mesadas vencidas até hoje (no fuso da escola)
+func runDueAllowances(appDB *sql.DB) {
+	today := schoolToday()
+	rows, err := appDB.Query("SELECT id FROM public.student_allowances WHERE is_active AND next_run_on <= $1 ORDER BY next_run_on", today)
+	if err != nil {
+		log.Printf("Erro ao buscar mesadas vencidas: %v", err)
+		return
+	}
+	var dueIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("Erro ao scanear mesada vencida: %v", err)
+			rows.Close()
+			return
+		}
+		dueIDs = append(dueIDs, id)
+	}
+	rows.Close()
+
+	processed := 0
+	for _, id := range dueIDs {
+		ran, err := runAllowance(appDB, id, today)
+		if err != nil {
+			log.Printf("Erro: %v", err)
+			continue
+		}
+		if ran {
+			processed++
+		}
+	}
+	if processed > 0 {
+		log.Printf("%d mesada(s) processada(s).", processed)
+	}
+}
+
+// startAllowanceScheduler processa as mesadas vencidas ao subir e depois a cada allowanceRunEvery
+func startAllowanceScheduler(appDB *sql.DB) {
+	go func() {
+		runDueAllowances(appDB)
+		ticker := time.NewTicker(allowanceRunEvery)
+		defer ticker.Stop()
+		for range ticker.C {
+			runDueAllowances(appDB)
+		}
+	}()
+}
+
+// studentWalletRouterHandler trata /me/students/{id}/wallet/... ('resource' é o que vem depois de "wallet/")
+func studentWalletRouterHandler(w http.ResponseWriter, r *http.Request, appDB *sql.DB, studentID, resource string) {
+	allowanceID, hasAllowanceID := strings.CutPrefix(resource, "allowances/")
+	switch {
+	case resource == "" && r.Method == http.MethodGet:
+		handleGetStudentWallet(w, r, appDB, studentID)
+	case resource == "transactions" && r.Method == http.MethodGet:
+		handleGetStudentWalletTransactions(w, r, appDB, studentID)
+	case resource == "policy" && r.Method == http.MethodPut:
+		handleUpdateStudentFundingPolicy(w, r, appDB, studentID)
+	case resource == "transfers" && r.Method == http.MethodPost:
+		handleStudentWalletTransfer(w, r, appDB, studentID)
+	case resource == "allowances" && r.Method == http.MethodGet:
+		handleGetStudentAllowances(w, r, appDB, studentID)
+	case resource == "allowances" && r.Method == http.MethodPost:
+		handleCreateStudentAllowance(w, r, appDB, studentID)
+	case hasAllowanceID && r.Method == http.MethodDelete:
+		handleDeleteStudentAllowance(w, r, appDB, studentID, allowanceID)
+	default:
+		http.Error(w, fmt.Sprintf("Rota ou método não permitido para %s", r.URL.Path), http.StatusMethodNotAllowed)
+	}
+}
+
+// handleGetStudentWallet trata GET /me/students/{id}/wallet
+func handleGetStudentWallet(w http.ResponseWriter, r *http.Request, appDB *sql.DB, studentID string) {
+	userID := r.Context().Value(userContextKey).(string)
+	if _, ok := ensureStudentGuardian(w, appDB, studentID, userID, GuardianPrimary, GuardianSecondary, GuardianViewer); !ok {
+		return
+	}
+
+	wallet, err := fetchStudentWallet(appDB, studentID)
+	if err != nil {
+		log.Printf("Erro ao buscar carteira do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao buscar carteira do aluno.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wallet)
+}
+
+// handleGetStudentWalletTransactions trata GET /me/students/{id}/wallet/transactions, com ?limit=&offset=
+func handleGetStudentWalletTransactions(w http.ResponseWriter, r *http.Request, appDB *sql.DB, studentID string) {
+	userID := r.Context().Value(userContextKey).(string)
+	if _, ok := ensureStudentGuardian(w, appDB, studentID, userID, GuardianPrimary, GuardianSecondary, GuardianViewer); !ok {
+		return
+	}
+
+	limit, offset, err := parseLimitOffset(r, 50, 200)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	wallet, err := fetchStudentWallet(appDB, studentID)
+	if err != nil {
+		log.Printf("Erro ao buscar carteira do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao buscar extrato da carteira.", http.StatusInternalServerError)
+		return
+	}
+	page := StudentWalletTransactionsPage{Balance: wallet.Balance, Transactions: []StudentWalletTransaction{}, Limit: limit, Offset: offset}
+
+	if err := appDB.QueryRow("SELECT COUNT(*) FROM public.student_wallet_transactions WHERE student_id = $1", studentID).Scan(&page.Total); err != nil {
+		log.Printf("Erro ao contar lançamentos da carteira do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao buscar extrato da carteira.", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := appDB.Query(`
+		SELECT id, student_id, type, amount, balance_after, guardian_user_id, order_id, allowance_id, description, created_by, created_at
+		FROM public.student_wallet_transactions
+		WHERE student_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3;`, studentID, limit, offset)
+	if err != nil {
+		log.Printf("Erro ao buscar lançamentos da carteira do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao buscar extrato da carteira.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry StudentWalletTransaction
+		var guardianUserID, orderID, allowanceID, description, createdBy sql.NullString
+		err := rows.Scan(&entry.ID, &entry.StudentID, &entry.Type, &entry.Amount, &entry.BalanceAfter,
+			&guardianUserID, &orderID, &allowanceID, &description, &createdBy, &entry.CreatedAt)
+		if err != nil {
+			log.Printf("Erro ao scanear lançamento da carteira do aluno %s: %v", studentID, err)
+			http.Error(w, "Erro ao buscar extrato da carteira.", http.StatusInternalServerError)
+			return
+		}
+		if guardianUserID.Valid {
+			entry.GuardianUserID = &guardianUserID.String
+		}
+		if orderID.Valid {
+			entry.OrderID = &orderID.String
+		}
+		if allowanceID.Valid {
+			entry.AllowanceID = &allowanceID.String
+		}
+		if description.Valid {
+			entry.Description = &description.String
+		}
+		if createdBy.Valid {
+			entry.CreatedBy = &createdBy.String
+		}
+		page.Transactions = append(page.Transactions, entry)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Erro após iterar lançamentos da carteira do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao buscar extrato da carteira.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// handleUpdateStudentFundingPolicy trata PUT /me/students/{id}/wallet/policy (só o responsável principal)
+func handleUpdateStudentFundingPolicy(w http.ResponseWriter, r *http.Request, appDB *sql.DB, studentID string) {
+	userID := r.Context().Value(userContextKey).(string)
+	if _, ok := ensureStudentGuardian(w, appDB, studentID, userID, GuardianPrimary); !ok {
+		return
+	}
+
+	var payload UpdateFundingPolicyPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if !validFundingPolicies[payload.FundingPolicy] {
+		http.Error(w, "Política inválida (use student_then_guardian, student_only ou guardian_only).", http.StatusBadRequest)
+		return
+	}
+
+	wallet := StudentWallet{StudentID: studentID}
+	err := appDB.QueryRow(`
+		INSERT INTO public.student_wallets (student_id, funding_policy, updated_by) VALUES ($1, $2, $3)
+		ON CONFLICT (student_id) DO UPDATE
+		SET funding_policy = EXCLUDED.funding_policy, updated_by = EXCLUDED.updated_by, updated_at = NOW()
+		RETURNING balance, funding_policy;`, studentID, payload.FundingPolicy, userID).Scan(&wallet.Balance, &wallet.FundingPolicy)
+	if err != nil {
+		log.Printf("Erro ao salvar política da carteira do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao salvar política da carteira.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Responsável %s definiu a política da carteira do aluno %s como %s.", userID, studentID, wallet.FundingPolicy)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wallet)
+}
+
+// handleStudentWalletTransfer trata POST /me/students/{id}/wallet/transfers: o responsável abastece a carteira
+// do aluno com os próprios créditos (to_student) ou o principal devolve saldo do aluno para si (to_guardian)
+func handleStudentWalletTransfer(w http.ResponseWriter, r *http.Request, appDB *sql.DB, studentID string) {
+	userID := r.Context().Value(userContextKey).(string)
+
+	var payload StudentWalletTransferPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if payload.Amount <= 0 {
+		http.Error(w, "O valor da transferência deve ser positivo.", http.StatusBadRequest)
+		return
+	}
+	if payload.Direction == "" {
+		payload.Direction = walletTransferToStudent
+	}
+
+	amount, studentTxType := payload.Amount, StudentWalletTxTransferIn
+	allowedRoles := guardianOrderingRoles
+	switch payload.Direction {
+	case walletTransferToStudent:
+	case walletTransferToGuardian:
+		amount, studentTxType = -payload.Amount, StudentWalletTxTransferOut
+		allowedRoles = []GuardianRole{GuardianPrimary}
+	default:
+		http.Error(w, "Direção inválida (use to_student ou to_guardian).", http.StatusBadRequest)
+		return
+	}
+	if _, ok := ensureStudentGuardian(w, appDB, studentID, userID, allowedRoles...); !ok {
+		return
+	}
+
+	var description *string
+	if trimmed := strings.TrimSpace(payload.Description); trimmed != "" {
+		description = &trimmed
+	}
+
+	tx, err := appDB.Begin()
+	if err != nil {
+		log.Printf("Erro ao iniciar transação de transferência para o aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao transferir créditos.", http.StatusInternalServerError)
+		return
+	}
+	defer rollbackTx(tx)
+
+	entry, err := moveStudentWalletFunds(tx, studentID, userID, amount, studentTxType, nil, description, &userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInsufficientCredits):
+			http.Error(w, "Créditos insuficientes", http.StatusPaymentRequired)
+		case errors.Is(err, errInsufficientStudentBalance):
+			http.Error(w, "Saldo insuficiente na carteira do aluno.", http.StatusConflict)
+		default:
+			log.Printf("Erro ao transferir créditos entre %s e o aluno %s: %v", userID, studentID, err)
+			http.Error(w, "Erro ao transferir créditos.", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := commitTx(tx); err != nil {
+		log.Printf("Erro ao confirmar transferência para o aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao transferir créditos.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Responsável %s transferiu %s (%s) na carteira do aluno %s.", userID, payload.Amount, payload.Direction, studentID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// handleGetStudentAllowances trata GET /me/students/{id}/wallet/allowances
+func handleGetStudentAllowances(w http.ResponseWriter, r *http.Request, appDB *sql.DB, studentID string) {
+	userID := r.Context().Value(userContextKey).(string)
+	if _, ok := ensureStudentGuardian(w, appDB, studentID, userID, GuardianPrimary, GuardianSecondary, GuardianViewer); !ok {
+		return
+	}
+
+	rows, err := appDB.Query(`SELECT `+studentAllowanceColumns+`
+		FROM public.student_allowances
+		WHERE student_id = $1 AND is_active
+		ORDER BY weekday, created_at;`, studentID)
+	if err != nil {
+		log.Printf("Erro ao buscar mesadas do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao buscar mesadas do aluno.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	allowances := []StudentAllowance{}
+	for rows.Next() {
+		allowance, err := scanStudentAllowance(rows)
+		if err != nil {
+			log.Printf("Erro ao scanear mesada do aluno %s: %v", studentID, err)
+			http.Error(w, "Erro ao buscar mesadas do aluno.", http.StatusInternalServerError)
+			return
+		}
+		allowances = append(allowances, *allowance)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Erro após iterar mesadas do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao buscar mesadas do aluno.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(allowances)
+}
+
+// handleCreateStudentAllowance trata POST /me/students/{id}/wallet/allowances: mesada semanal paga pelo
+// responsável logado. A primeira execução é no próximo 'weekday' (hoje, se for o dia).
+func handleCreateStudentAllowance(w http.ResponseWriter, r *http.Request, appDB *sql.DB, studentID string) {
+	userID := r.Context().Value(userContextKey).(string)
+	if _, ok := ensureStudentGuardian(w, appDB, studentID, userID, guardianOrderingRoles...); !ok {
+		return
+	}
+
+	var payload CreateStudentAllowancePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if payload.Amount <= 0 {
+		http.Error(w, "O valor da mesada deve ser positivo.", http.StatusBadRequest)
+		return
+	}
+	if payload.Weekday < 1 || payload.Weekday > 7 {
+		http.Error(w, "Dia da semana inválido (1 = segunda ... 7 = domingo).", http.StatusBadRequest)
+		return
+	}
+
+	nextRun := nextAllowanceDate(schoolToday(), payload.Weekday)
+	allowance, err := scanStudentAllowance(appDB.QueryRow(`
+		INSERT INTO public.student_allowances (student_id, funded_by, amount, weekday, next_run_on)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+studentAllowanceColumns+`;`, studentID, userID, payload.Amount, payload.Weekday, nextRun))
+	if err != nil {
+		log.Printf("Erro ao criar mesada do aluno %s: %v", studentID, err)
+		http.Error(w, "Erro ao criar mesada.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Responsável %s criou mesada de %s (dia %d) para o aluno %s.", userID, allowance.Amount, allowance.Weekday, studentID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(allowance)
+}
+
+// handleDeleteStudentAllowance trata DELETE /me/students/{id}/wallet/allowances/{allowanceID}: quem paga a
+// mesada ou o responsável principal pode encerrá-la
+func handleDeleteStudentAllowance(w http.ResponseWriter, r *http.Request, appDB *sql.DB, studentID, allowanceID string) {
+	userID := r.Context().Value(userContextKey).(string)
+	role, ok := ensureStudentGuardian(w, appDB, studentID, userID, GuardianPrimary, GuardianSecondary, GuardianViewer)
+	if !ok {
+		return
+	}
+
+	result, err := appDB.Exec(`
+		UPDATE public.student_allowances SET is_active = FALSE, updated_at = NOW()
+		WHERE id = $1 AND student_id = $2 AND is_active AND (funded_by = $3 OR $4);`,
+		allowanceID, studentID, userID, role == GuardianPrimary)
+	if err != nil {
+		log.Printf("Erro ao encerrar mesada %s: %v", allowanceID, err)
+		http.Error(w, "Erro ao encerrar mesada.", http.StatusInternalServerError)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		http.Error(w, "Mesada não encontrada (ou paga por outro responsável).", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Usuário %s encerrou a mesada %s do aluno %s.", userID, allowanceID, studentID)
+	w.WriteHeader(http.StatusNoContent)
+}
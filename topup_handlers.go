@@ -0,0 +1,317 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Status de uma recarga (coluna credit_topups.status)
+const (
+	TopUpStatusPending = "PENDING"
+	TopUpStatusPaid    = "PAID"
+	TopUpStatusExpired = "EXPIRED"
+	TopUpStatusFailed  = "FAILED"
+)
+
+// Limites por recarga
+const (
+	minTopUpAmount Money = 500    // R$ 5,00
+	maxTopUpAmount Money = 100000 // R$ 1.000,00
+)
+
+// Tamanho máximo aceito no corpo do webhook de pagamento
+const maxWebhookBodyBytes = 1 << 20
+
+// Intervalo entre as passagens que marcam como EXPIRED as recargas pendentes vencidas
+const topUpExpiryEvery = 5 * time.Minute
+
+// CreditTopUp espelha uma linha de credit_topups
+type CreditTopUp struct {
+	ID               string     `json:"id"`
+	UserID           string     `json:"user_id"`
+	Amount           Money      `json:"amount"`
+	Status           string     `json:"status"`
+	Provider         string     `json:"provider"`
+	ProviderChargeID *string    `json:"provider_charge_id,omitempty"`
+	QRCodePayload    *string    `json:"qr_code_payload,omitempty"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	PaidAt           *time.Time `json:"paid_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// CreateTopUpPayload é o corpo de POST /me/credits/topups
+type CreateTopUpPayload struct {
+	Amount Money `json:"amount"`
+}
+
+const topUpColumns = `id, user_id, amount, status, provider, provider_charge_id, qr_code_payload, expires_at, paid_at, created_at, updated_at`
+
+// scanCreditTopUp lê uma linha com as colunas de topUpColumns
+func scanCreditTopUp(row interface{ Scan(...interface{}) error }) (*CreditTopUp, error) {
+	var topUp CreditTopUp
+	var chargeID, qrCode sql.NullString
+	var expiresAt, paidAt sql.NullTime
+	err := row.Scan(&topUp.ID, &topUp.UserID, &topUp.Amount, &topUp.Status, &topUp.Provider,
+		&chargeID, &qrCode, &expiresAt, &paidAt, &topUp.CreatedAt, &topUp.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if chargeID.Valid {
+		topUp.ProviderChargeID = &chargeID.String
+	}
+	if qrCode.Valid {
+		topUp.QRCodePayload = &qrCode.String
+	}
+	if expiresAt.Valid {
+		topUp.ExpiresAt = &expiresAt.Time
+	}
+	if paidAt.Valid {
+		topUp.PaidAt = &paidAt.Time
+	}
+	return &topUp, nil
+}
+
+// handleCreateTopUp cria uma recarga PENDING e a cobrança correspondente no provedor
+func handleCreateTopUp(w http.ResponseWriter, r *http.Request, appDB *sql.DB, provider PaymentProvider) {
+	userID := r.Context().Value(userContextKey).(string)
+
+	var payload CreateTopUpPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Payload inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if payload.Amount < minTopUpAmount || payload.Amount > maxTopUpAmount {
+		http.Error(w, fmt.Sprintf("O valor da recarga deve estar entre %s e %s.", minTopUpAmount, maxTopUpAmount), http.StatusBadRequest)
+		return
+	}
+
+	insertQuery := `
+		INSERT INTO public.credit_topups (user_id, amount, status, provider)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + topUpColumns + `;`
+	topUp, err := scanCreditTopUp(appDB.QueryRow(insertQuery, userID, payload.Amount, TopUpStatusPending, provider.Name()))
+	if err != nil {
+		log.Printf("Erro ao criar recarga para usuário %s: %v", userID, err)
+		http.Error(w, "Erro ao criar recarga.", http.StatusInternalServerError)
+		return
+	}
+
+	charge, err := provider.CreateCharge(r.Context(), topUp)
+	if err != nil {
+		log.Printf("Erro ao criar cobrança no provedor %s para recarga %s: %v", provider.Name(), topUp.ID, err)
+		if _, errFail := appDB.Exec("UPDATE public.credit_topups SET status = $1, updated_at = NOW() WHERE id = $2", TopUpStatusFailed, topUp.ID); errFail != nil {
+			log.Printf("Erro ao marcar recarga %s como FAILED: %v", topUp.ID, errFail)
+		}
+		http.Error(w, "Não foi possível gerar a cobrança no provedor de pagamento.", http.StatusBadGateway)
+		return
+	}
+
+	// A cobrança e a Idempotency-Key são gravadas juntas: um reenvio depois daqui não gera outra cobrança
+	topUp, err = saveTopUpCharge(appDB, topUp.ID, charge, idempotencyClaimFromContext(r.Context()))
+	if err != nil {
+		log.Printf("Erro ao gravar cobrança da recarga: %v", err)
+		http.Error(w, "Erro ao criar recarga.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Recarga %s de %s criada para usuário %s (provedor %s).", topUp.ID, topUp.Amount, userID, provider.Name())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(topUp)
+}
+
+// saveTopUpCharge grava na recarga a cobrança criada no provedor e marca a Idempotency-Key da requisição
+// (se houver) como aplicada, na mesma transação
+func saveTopUpCharge(appDB *sql.DB, topUpID string, charge *PaymentCharge, claim *idempotencyClaim) (*CreditTopUp, error) {
+	tx, err := appDB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao iniciar transação da recarga %s: %w", topUpID, err)
+	}
+	defer rollbackTx(tx)
+
+	updateQuery := `
+		UPDATE public.credit_topups
+		SET provider_charge_id = $1, qr_code_payload = $2, expires_at = $3, updated_at = NOW()
+		WHERE id = $4
+		RETURNING ` + topUpColumns + `;`
+	topUp, err := scanCreditTopUp(tx.QueryRow(updateQuery, charge.ProviderChargeID, charge.QRCodePayload, charge.ExpiresAt, topUpID))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao gravar cobrança da recarga %s: %w", topUpID, err)
+	}
+	if err := markIdempotencyKeyCommitted(tx, claim); err != nil {
+		return nil, err
+	}
+	if err := commitTx(tx); err != nil {
+		return nil, fmt.Errorf("erro ao confirmar cobrança da recarga %s: %w", topUpID, err)
+	}
+	return topUp, nil
+}
+
+// handleGetMyTopUps lista as recargas do usuário autenticado (mais recentes primeiro)
+func handleGetMyTopUps(w http.ResponseWriter, r *http.Request, appDB *sql.DB) {
+	userID := r.Context().Value(userContextKey).(string)
+
+	limit, offset, err := parseLimitOffset(r, 20, 100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	listQuery := `SELECT ` + topUpColumns + ` FROM public.credit_topups
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3;`
+	rows, err := appDB.Query(listQuery, userID, limit, offset)
+	if err != nil {
+		log.Printf("Erro ao buscar recargas do usuário %s: %v", userID, err)
+		http.Error(w, "Erro ao buscar recargas.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	topUps := []CreditTopUp{}
+	for rows.Next() {
+		topUp, err := scanCreditTopUp(rows)
+		if err != nil {
+			log.Printf("Erro ao scanear recarga do usuário %s: %v", userID, err)
+			http.Error(w, "Erro ao processar recargas.", http.StatusInternalServerError)
+			return
+		}
+		topUps = append(topUps, *topUp)
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Erro após iterar recargas do usuário %s: %v", userID, err)
+		http.Error(w, "Erro ao processar recargas.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(topUps)
+}
+
+// handleGetMyTopUpByID retorna uma recarga do usuário autenticado (usado pelo app para acompanhar o pagamento)
+func handleGetMyTopUpByID(w http.ResponseWriter, r *http.Request, appDB *sql.DB, topUpID string) {
+	userID := r.Context().Value(userContextKey).(string)
+
+	query := `SELECT ` + topUpColumns + ` FROM public.credit_topups WHERE id = $1 AND user_id = $2;`
+	topUp, err := scanCreditTopUp(appDB.QueryRow(query, topUpID, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Recarga não encontrada.", http.StatusNotFound)
+		} else {
+			log.Printf("Erro ao buscar recarga %s: %v", topUpID, err)
+			http.Error(w, "Erro ao buscar recarga.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(topUp)
+}
+
+// topUpPaymentOutcome é o que uma confirmação de pagamento faz com a recarga (ver decideTopUpPayment)
+type topUpPaymentOutcome int
+
+const (
+	topUpPaymentCredit         topUpPaymentOutcome = iota // Marca como paga e credita o usuário
+	topUpPaymentAlreadyPaid                               // Reentrega do webhook: nada a fazer
+	topUpPaymentAmountMismatch                            // Valor divergente: marca como FAILED, sem creditar
+)
+
+// decideTopUpPayment decide o que fazer com a confirmação de pagamento de uma recarga (já travada).
+// Notificações repetidas do provedor para uma recarga já paga não creditam de novo.
+func decideTopUpPayment(topUp *CreditTopUp, notification PaymentNotification) topUpPaymentOutcome {
+	switch {
+	case topUp.Status == TopUpStatusPaid:
+		return topUpPaymentAlreadyPaid
+	case topUp.Amount != notification.Amount:
+		// Não credita automaticamente: a divergência precisa ser tratada por um admin (ajuste manual)
+		return topUpPaymentAmountMismatch
+	default:
+		// Um pagamento recebido após expires_at (recarga já EXPIRED) ainda é creditado: o dinheiro entrou
+		return topUpPaymentCredit
+	}
+}
+
+// handlePaymentWebhook recebe as confirmações de pagamento do provedor (POST /payments/webhook).
+// Não usa authMiddleware: a autenticidade vem da assinatura verificada pelo provedor.
+func handlePaymentWebhook(w http.ResponseWriter, r *http.Request, topUps TopUpRepository, provider PaymentProvider) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido para /payments/webhook. Use POST.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		http.Error(w, "Erro ao ler corpo do webhook.", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	notifications, err := provider.ParseWebhook(r, body)
+	if err != nil {
+		if errors.Is(err, errInvalidWebhookSignature) {
+			log.Printf("Webhook de pagamento rejeitado: %v", err)
+			http.Error(w, "Assinatura inválida.", http.StatusUnauthorized)
+			return
+		}
+		log.Printf("Webhook de pagamento inválido: %v", err)
+		http.Error(w, "Payload do webhook inválido.", http.StatusBadRequest)
+		return
+	}
+
+	credited := 0
+	for _, notification := range notifications {
+		ok, err := topUps.ConfirmPayment(provider.Name(), notification)
+		if err != nil {
+			// Responder erro faz o provedor reenviar; a confirmação é idempotente
+			log.Printf("Erro ao processar pagamento %s: %v", notification.ProviderChargeID, err)
+			http.Error(w, "Erro ao processar pagamento.", http.StatusInternalServerError)
+			return
+		}
+		if ok {
+			credited++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"received": len(notifications), "credited": credited})
+}
+
+// expireStaleTopUps marca como EXPIRED as recargas pendentes cuja cobrança venceu (expires_at no passado)
+func expireStaleTopUps(appDB *sql.DB) (int64, error) {
+	result, err := appDB.Exec(`
+		UPDATE public.credit_topups SET status = $1, updated_at = NOW()
+		WHERE status = $2 AND expires_at < NOW();`, TopUpStatusExpired, TopUpStatusPending)
+	if err != nil {
+		return 0, fmt.Errorf("erro ao expirar recargas pendentes: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// startTopUpExpiry expira periodicamente as recargas abandonadas (ver expireStaleTopUps)
+func startTopUpExpiry(appDB *sql.DB) {
+	go func() {
+		ticker := time.NewTicker(topUpExpiryEvery)
+		defer ticker.Stop()
+		for ; ; <-ticker.C {
+			expired, err := expireStaleTopUps(appDB)
+			if err != nil {
+				log.Printf("Erro: %v", err)
+				continue
+			}
+			if expired > 0 {
+				log.Printf("%d recarga(s) pendente(s) expirada(s).", expired)
+			}
+		}
+	}()
+}